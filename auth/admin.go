@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// createApiKeyRequest is the body of an api key creation request
+type createApiKeyRequest struct {
+	Name    string `json:"name"`
+	Role    string `json:"role"`
+	UserIds []int  `json:"user_ids,omitempty"`
+}
+
+// createApiKeyResponse returns the raw key, which is never shown again
+type createApiKeyResponse struct {
+	Id  int64  `json:"id"`
+	Key string `json:"key"`
+}
+
+// GenerateApiKey returns a new random api key
+func GenerateApiKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateApiKey handles POST requests issuing a new api key
+func (a *Authenticator) CreateApiKey(w http.ResponseWriter, r *http.Request) {
+	var req createApiKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "Missing api key name", http.StatusBadRequest)
+		return
+	}
+	if _, err := ParseRole(req.Role); err != nil {
+		http.Error(w, "Invalid role. Must be 'reader', 'writer' or 'admin'", http.StatusBadRequest)
+		return
+	}
+	for _, id := range req.UserIds {
+		if id < 1 {
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+	}
+	// A key restricted to some users only issues keys restricted to them as well
+	if p, ok := FromContext(r.Context()); ok && p.Scoped() {
+		if len(req.UserIds) == 0 || !allAccessible(p, req.UserIds) {
+			http.Error(w, "Api key user ids must be a subset of your own", http.StatusForbidden)
+			return
+		}
+	}
+
+	key, err := GenerateApiKey()
+	if err != nil {
+		http.Error(w, "Failed to generate api key", http.StatusInternalServerError)
+		return
+	}
+	id, err := a.ApiKeys.InsertApiKey(r.Context(), req.Name, HashApiKey(key), req.Role, req.UserIds)
+	if err != nil {
+		http.Error(w, "Failed to store api key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createApiKeyResponse{Id: id, Key: key})
+}
+
+// allAccessible reports whether the principal may see every user
+func allAccessible(p *Principal, userIds []int) bool {
+	for _, id := range userIds {
+		if !p.CanAccessUser(id) {
+			return false
+		}
+	}
+	return true
+}
+
+// RevokeApiKey handles DELETE requests revoking an api key by id
+func (a *Authenticator) RevokeApiKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid api key id", http.StatusBadRequest)
+		return
+	}
+
+	if err := a.ApiKeys.RevokeApiKey(r.Context(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Api key not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to revoke api key", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"transaction-management-system/database"
+)
+
+// Role grants a set of permissions
+type Role string
+
+const (
+	RoleReader Role = "reader"
+	RoleWriter Role = "writer"
+	RoleAdmin  Role = "admin"
+)
+
+// Permission guards a group of endpoints
+type Permission string
+
+const (
+	PermRead   Permission = "read"   // query and stream transactions
+	PermIngest Permission = "ingest" // submit transactions
+	PermAdmin  Permission = "admin"  // manage credentials
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleReader: {PermRead},
+	RoleWriter: {PermRead, PermIngest},
+	RoleAdmin:  {PermRead, PermIngest, PermAdmin},
+}
+
+var (
+	ErrUnauthenticated = errors.New("missing or invalid credentials")
+	ErrForbidden       = errors.New("insufficient permissions")
+)
+
+// ParseRole validates a role name
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if _, ok := rolePermissions[role]; !ok {
+		return "", errors.New("invalid role. Must be 'reader', 'writer' or 'admin'")
+	}
+	return role, nil
+}
+
+// Principal is the authenticated caller
+type Principal struct {
+	Subject string
+	Role    Role
+	UserIds []int // empty means access to all users
+}
+
+// Can reports whether the principal's role grants the permission
+func (p *Principal) Can(perm Permission) bool {
+	return slices.Contains(rolePermissions[p.Role], perm)
+}
+
+// Scoped reports whether the principal is restricted to specific users
+func (p *Principal) Scoped() bool {
+	return len(p.UserIds) > 0
+}
+
+// CanAccessUser reports whether the principal may see the user's data
+func (p *Principal) CanAccessUser(userId int) bool {
+	return !p.Scoped() || slices.Contains(p.UserIds, userId)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the principal
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal stored by the middleware
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(*Principal)
+	return p, ok
+}
+
+// ApiKeyStore looks up hashed api keys
+type ApiKeyStore interface {
+	GetApiKey(ctx context.Context, keyHash string) (*database.ApiKey, error)
+	InsertApiKey(ctx context.Context, name, keyHash, role string, userIds []int) (int64, error)
+	RevokeApiKey(ctx context.Context, id int64) error
+}
+
+// Authenticator validates api keys and JWTs
+type Authenticator struct {
+	Keys    *KeySet
+	ApiKeys ApiKeyStore
+}
+
+func NewAuthenticator(keys *KeySet, apiKeys ApiKeyStore) *Authenticator {
+	return &Authenticator{
+		Keys:    keys,
+		ApiKeys: apiKeys,
+	}
+}
+
+// HashApiKey returns the hex encoded SHA-256 hash stored for an api key
+func HashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate resolves the request credentials to a principal.
+// Api keys are read from X-API-Key and JWTs from the Authorization bearer token.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	return a.AuthenticateCredentials(r.Context(), r.Header.Get("X-API-Key"), r.Header.Get("Authorization"))
+}
+
+// QueryToken wraps the handler so a JWT is also read from the access_token query parameter,
+// for WebSocket routes only as browsers can't set their headers. Elsewhere it would end up
+// in access logs and proxies.
+func QueryToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("access_token")
+		if token != "" && !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			r = r.Clone(r.Context())
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next(w, r)
+	}
+}
+
+// AuthenticateCredentials resolves an api key, or else the JWT of an Authorization
+// bearer token, to a principal. It serves callers without an HTTP request, like gRPC.
+func (a *Authenticator) AuthenticateCredentials(ctx context.Context, apiKey, authorization string) (*Principal, error) {
+	if apiKey != "" {
+		return a.authenticateApiKey(ctx, apiKey)
+	}
+
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || token == "" {
+		return nil, ErrUnauthenticated
+	}
+	return a.authenticateJWT(token)
+}
+
+func (a *Authenticator) authenticateApiKey(ctx context.Context, key string) (*Principal, error) {
+	if a.ApiKeys == nil {
+		return nil, ErrUnauthenticated
+	}
+	apiKey, err := a.ApiKeys.GetApiKey(ctx, HashApiKey(key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUnauthenticated
+	}
+	if err != nil {
+		// The key may be valid, the caller retries instead of dropping it
+		return nil, fmt.Errorf("failed to look up api key: %w", err)
+	}
+	role, err := ParseRole(apiKey.Role)
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+	return &Principal{
+		Subject: "api_key:" + apiKey.Name,
+		Role:    role,
+		UserIds: apiKey.UserIds,
+	}, nil
+}
+
+// Require wraps the handler so it only runs for callers holding the permission
+func (a *Authenticator) Require(perm Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := a.Authenticate(r)
+		if err != nil && !errors.Is(err, ErrUnauthenticated) {
+			slog.ErrorContext(r.Context(), "Failed to authenticate", "error", err)
+			http.Error(w, "Failed to authenticate", http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="transactions"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !p.Can(perm) {
+			slog.WarnContext(r.Context(), "Access denied", "subject", p.Subject, "role", p.Role, "method", r.Method, "path", r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(NewContext(r.Context(), p)))
+	}
+}
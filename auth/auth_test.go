@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"transaction-management-system/database"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryApiKeys is an in-memory ApiKeyStore
+type memoryApiKeys map[string]*database.ApiKey
+
+func (m memoryApiKeys) GetApiKey(ctx context.Context, keyHash string) (*database.ApiKey, error) {
+	if key, ok := m[keyHash]; ok {
+		return key, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m memoryApiKeys) InsertApiKey(ctx context.Context, name, keyHash, role string, userIds []int) (int64, error) {
+	m[keyHash] = &database.ApiKey{Id: int64(len(m) + 1), Name: name, Role: role, UserIds: userIds}
+	return int64(len(m)), nil
+}
+
+func (m memoryApiKeys) RevokeApiKey(ctx context.Context, id int64) error {
+	for hash, key := range m {
+		if key.Id == id {
+			delete(m, hash)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func signHS256(t *testing.T, kid string, secret []byte, claims Claims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(secret)
+	require.NoError(t, err)
+	return signed
+}
+
+func validClaims(role Role) Claims {
+	return Claims{
+		Role: string(role),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "tester",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+}
+
+// unavailableApiKeys fails every lookup like an unreachable database
+type unavailableApiKeys struct{ memoryApiKeys }
+
+func (unavailableApiKeys) GetApiKey(ctx context.Context, keyHash string) (*database.ApiKey, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestPrincipal(t *testing.T) {
+	t.Run("role permissions", func(t *testing.T) {
+		reader := &Principal{Role: RoleReader}
+		require.True(t, reader.Can(PermRead))
+		require.False(t, reader.Can(PermIngest))
+
+		writer := &Principal{Role: RoleWriter}
+		require.True(t, writer.Can(PermIngest))
+		require.False(t, writer.Can(PermAdmin))
+
+		admin := &Principal{Role: RoleAdmin}
+		require.True(t, admin.Can(PermAdmin))
+	})
+
+	t.Run("user scope", func(t *testing.T) {
+		require.True(t, (&Principal{}).CanAccessUser(42))
+
+		scoped := &Principal{UserIds: []int{1, 2}}
+		require.True(t, scoped.Scoped())
+		require.True(t, scoped.CanAccessUser(2))
+		require.False(t, scoped.CanAccessUser(3))
+	})
+}
+
+func TestKeySet(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	ks := NewKeySet()
+	ks.AddHMAC("hs", secret)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ks.AddRSA("rs", &rsaKey.PublicKey)
+
+	t.Run("successful HS256", func(t *testing.T) {
+		claims, err := ks.Parse(signHS256(t, "hs", secret, validClaims(RoleReader)))
+		require.NoError(t, err)
+		require.Equal(t, "reader", claims.Role)
+	})
+
+	t.Run("successful RS256", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims(RoleAdmin))
+		token.Header["kid"] = "rs"
+		signed, err := token.SignedString(rsaKey)
+		require.NoError(t, err)
+
+		claims, err := ks.Parse(signed)
+		require.NoError(t, err)
+		require.Equal(t, "admin", claims.Role)
+	})
+
+	t.Run("failed unknown key id", func(t *testing.T) {
+		_, err := ks.Parse(signHS256(t, "other", secret, validClaims(RoleReader)))
+		require.Error(t, err)
+	})
+
+	t.Run("failed wrong secret", func(t *testing.T) {
+		_, err := ks.Parse(signHS256(t, "hs", []byte("wrong"), validClaims(RoleReader)))
+		require.Error(t, err)
+	})
+
+	t.Run("failed missing expiration", func(t *testing.T) {
+		claims := validClaims(RoleReader)
+		claims.ExpiresAt = nil
+		_, err := ks.Parse(signHS256(t, "hs", secret, claims))
+		require.Error(t, err)
+	})
+
+	t.Run("failed issuer mismatch", func(t *testing.T) {
+		ks.Issuer = "casino"
+		defer func() { ks.Issuer = "" }()
+
+		_, err := ks.Parse(signHS256(t, "hs", secret, validClaims(RoleReader)))
+		require.Error(t, err)
+	})
+}
+
+func TestRequire(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	ks := NewKeySet()
+	ks.AddHMAC("", secret)
+	keys := memoryApiKeys{HashApiKey("reader-key"): {Id: 1, Name: "dashboard", Role: "reader", UserIds: []int{7}}}
+	a := NewAuthenticator(ks, keys)
+
+	var got *Principal
+	handler := a.Require(PermRead, func(w http.ResponseWriter, r *http.Request) {
+		got, _ = FromContext(r.Context())
+	})
+
+	t.Run("failed missing credentials", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest("GET", "/transactions", nil))
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("failed unknown api key", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/transactions", nil)
+		req.Header.Set("X-API-Key", "unknown")
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("failed api key lookup", func(t *testing.T) {
+		failing := NewAuthenticator(ks, unavailableApiKeys{memoryApiKeys{}})
+		req := httptest.NewRequest("GET", "/transactions", nil)
+		req.Header.Set("X-API-Key", "reader-key")
+		rr := httptest.NewRecorder()
+		failing.Require(PermRead, func(w http.ResponseWriter, r *http.Request) {})(rr, req)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+	})
+
+	t.Run("successful api key", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/transactions", nil)
+		req.Header.Set("X-API-Key", "reader-key")
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, []int{7}, got.UserIds)
+	})
+
+	t.Run("successful jwt", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/transactions", nil)
+		req.Header.Set("Authorization", "Bearer "+signHS256(t, "", secret, validClaims(RoleWriter)))
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, RoleWriter, got.Role)
+	})
+
+	t.Run("failed query token outside QueryToken", func(t *testing.T) {
+		token := signHS256(t, "", secret, validClaims(RoleReader))
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest("GET", "/transactions?access_token="+token, nil))
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		rr = httptest.NewRecorder()
+		QueryToken(handler)(rr, httptest.NewRequest("GET", "/transactions/ws?access_token="+token, nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("failed insufficient role", func(t *testing.T) {
+		adminOnly := a.Require(PermAdmin, func(w http.ResponseWriter, r *http.Request) {})
+		req := httptest.NewRequest("POST", "/admin/api-keys", nil)
+		req.Header.Set("X-API-Key", "reader-key")
+		rr := httptest.NewRecorder()
+		adminOnly(rr, req)
+		require.Equal(t, http.StatusForbidden, rr.Code)
+	})
+}
+
+func TestApiKeyAdmin(t *testing.T) {
+	keys := memoryApiKeys{
+		HashApiKey("admin-key"):  {Id: 1, Name: "root", Role: "admin"},
+		HashApiKey("scoped-key"): {Id: 2, Name: "operator", Role: "admin", UserIds: []int{7, 8}},
+	}
+	a := NewAuthenticator(NewKeySet(), keys)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /admin/api-keys", a.Require(PermAdmin, a.CreateApiKey))
+	mux.HandleFunc("DELETE /admin/api-keys/{id}", a.Require(PermAdmin, a.RevokeApiKey))
+
+	t.Run("failed invalid role", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/admin/api-keys", strings.NewReader(`{"name":"x","role":"owner"}`))
+		req.Header.Set("X-API-Key", "admin-key")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("successful create and revoke", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/admin/api-keys", strings.NewReader(`{"name":"game","role":"writer"}`))
+		req.Header.Set("X-API-Key", "admin-key")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusCreated, rr.Code)
+		require.Len(t, keys, 3)
+
+		req = httptest.NewRequest("DELETE", "/admin/api-keys/3", nil)
+		req.Header.Set("X-API-Key", "admin-key")
+		rr = httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusNoContent, rr.Code)
+		require.Len(t, keys, 2)
+	})
+
+	t.Run("scoped admin creates keys within its users", func(t *testing.T) {
+		for body, code := range map[string]int{
+			`{"name":"all","role":"reader"}`:                  http.StatusForbidden,
+			`{"name":"other","role":"reader","user_ids":[9]}`: http.StatusForbidden,
+			`{"name":"own","role":"reader","user_ids":[8]}`:   http.StatusCreated,
+		} {
+			req := httptest.NewRequest("POST", "/admin/api-keys", strings.NewReader(body))
+			req.Header.Set("X-API-Key", "scoped-key")
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+			require.Equal(t, code, rr.Code, body)
+		}
+	})
+
+	t.Run("failed revoke unknown key", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/admin/api-keys/99", nil)
+		req.Header.Set("X-API-Key", "admin-key")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeySet holds the local keys trusted to sign JWTs, indexed by key id
+type KeySet struct {
+	Issuer   string // required "iss" claim, if set
+	Audience string // required "aud" claim, if set
+	hmac     map[string][]byte
+	rsa      map[string]*rsa.PublicKey
+}
+
+// keySetFile is the on-disk format of a key set
+type keySetFile struct {
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+	Keys     []struct {
+		Kid       string `json:"kid"`
+		Alg       string `json:"alg"`        // "HS256" or "RS256"
+		Secret    string `json:"secret"`     // base64 encoded HS256 secret
+		PublicKey string `json:"public_key"` // PEM encoded RS256 public key
+	} `json:"keys"`
+}
+
+// Claims are the JWT claims understood by the API
+type Claims struct {
+	Role    string `json:"role"`
+	UserIds []int  `json:"user_ids,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func NewKeySet() *KeySet {
+	return &KeySet{
+		hmac: make(map[string][]byte),
+		rsa:  make(map[string]*rsa.PublicKey),
+	}
+}
+
+// LoadKeySet reads a JSON key set file
+func LoadKeySet(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key set: %w", err)
+	}
+
+	var file keySetFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse key set: %w", err)
+	}
+
+	ks := NewKeySet()
+	ks.Issuer = file.Issuer
+	ks.Audience = file.Audience
+	for _, key := range file.Keys {
+		switch key.Alg {
+		case "HS256":
+			secret, err := base64.StdEncoding.DecodeString(key.Secret)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode secret of key %q: %w", key.Kid, err)
+			}
+			ks.AddHMAC(key.Kid, secret)
+		case "RS256":
+			pub, err := jwt.ParseRSAPublicKeyFromPEM([]byte(key.PublicKey))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse public key %q: %w", key.Kid, err)
+			}
+			ks.AddRSA(key.Kid, pub)
+		default:
+			return nil, fmt.Errorf("unsupported algorithm %q for key %q", key.Alg, key.Kid)
+		}
+	}
+	return ks, nil
+}
+
+// AddHMAC trusts an HS256 secret under the key id
+func (ks *KeySet) AddHMAC(kid string, secret []byte) {
+	ks.hmac[kid] = secret
+}
+
+// AddRSA trusts an RS256 public key under the key id
+func (ks *KeySet) AddRSA(kid string, key *rsa.PublicKey) {
+	ks.rsa[kid] = key
+}
+
+// keyFunc selects the verification key by the token's algorithm and "kid" header
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	switch token.Method.Alg() {
+	case "HS256":
+		if key, ok := ks.hmac[kid]; ok {
+			return key, nil
+		}
+	case "RS256":
+		if key, ok := ks.rsa[kid]; ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// Parse validates the token signature and registered claims
+func (ks *KeySet) Parse(token string) (*Claims, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "RS256"}),
+		jwt.WithExpirationRequired(),
+	}
+	if ks.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(ks.Issuer))
+	}
+	if ks.Audience != "" {
+		opts = append(opts, jwt.WithAudience(ks.Audience))
+	}
+
+	var claims Claims
+	if _, err := jwt.ParseWithClaims(token, &claims, ks.keyFunc, opts...); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func (a *Authenticator) authenticateJWT(token string) (*Principal, error) {
+	if a.Keys == nil {
+		return nil, ErrUnauthenticated
+	}
+	claims, err := a.Keys.Parse(token)
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+	role, err := ParseRole(claims.Role)
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+	return &Principal{
+		Subject: "jwt:" + claims.Subject,
+		Role:    role,
+		UserIds: claims.UserIds,
+	}, nil
+}
@@ -0,0 +1,182 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrOpen reports a call rejected by an open breaker
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State of a breaker
+type State int
+
+const (
+	// Closed lets every call through
+	Closed State = iota
+	// Open rejects calls until the open timeout elapsed
+	Open
+	// HalfOpen lets a single trial call through, its outcome closes or reopens the breaker
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Config tunes when a breaker opens and how long it stays open
+type Config struct {
+	// Consecutive failures opening the breaker
+	FailureThreshold int
+	// Time the breaker stays open before a trial call
+	OpenTimeout time.Duration
+}
+
+// DefaultConfig returns settings riding out short database hiccups
+func DefaultConfig() Config {
+	return Config{
+		FailureThreshold: 5,
+		OpenTimeout:      10 * time.Second,
+	}
+}
+
+// Stats is a snapshot of a breaker for health checks and metrics
+type Stats struct {
+	State State
+	// Failures since the last success
+	ConsecutiveFailures int
+	// Times the breaker opened since it was created
+	Opens uint64
+}
+
+// Breaker stops calls to a failing dependency after consecutive failures
+// and probes it with a single trial call once the open timeout elapsed
+type Breaker struct {
+	name string
+	cfg  Config
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	opens    uint64
+	openedAt time.Time
+	trial    bool // a half-open trial is in flight
+	now      func() time.Time
+}
+
+// New returns a closed breaker, the name is used in logs
+func New(name string, cfg Config) *Breaker {
+	if cfg.FailureThreshold < 1 {
+		cfg.FailureThreshold = DefaultConfig().FailureThreshold
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = DefaultConfig().OpenTimeout
+	}
+	return &Breaker{name: name, cfg: cfg, now: time.Now}
+}
+
+// Allow reports whether a call may proceed. Once the open timeout elapsed
+// the breaker turns half-open and allows a single trial, whose outcome must
+// be reported with Success or Failure.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Closed:
+		return true
+	case Open:
+		if b.now().Sub(b.openedAt) < b.cfg.OpenTimeout {
+			return false
+		}
+		b.setState(HalfOpen)
+		fallthrough
+	default: // HalfOpen
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	}
+}
+
+// Success records a successful call, closing the breaker
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.trial = false
+	if b.state != Closed {
+		b.setState(Closed)
+	}
+}
+
+// Failure records a failed call, opening the breaker after a failed trial or
+// once the failure threshold is reached
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.trial = false
+	if b.state == HalfOpen || (b.state == Closed && b.failures >= b.cfg.FailureThreshold) {
+		b.openedAt = b.now()
+		b.opens++
+		b.setState(Open)
+	}
+}
+
+// State returns the current state, without turning an expired open breaker half-open
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Stats returns a snapshot of the breaker
+func (b *Breaker) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return Stats{State: b.state, ConsecutiveFailures: b.failures, Opens: b.opens}
+}
+
+// Wait blocks until an open breaker's timeout elapsed, so a trial call may be allowed,
+// or until ctx is done
+func (b *Breaker) Wait(ctx context.Context) error {
+	b.mu.Lock()
+	var wait time.Duration
+	if b.state == Open {
+		wait = b.cfg.OpenTimeout - b.now().Sub(b.openedAt)
+	}
+	b.mu.Unlock()
+
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// setState changes the state, the caller holds b.mu
+func (b *Breaker) setState(state State) {
+	slog.Warn("Circuit breaker state changed", "breaker", b.name, "from", b.state, "to", state)
+	b.state = state
+}
@@ -0,0 +1,109 @@
+package breaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBreaker(cfg Config) (*Breaker, *time.Time) {
+	now := time.Now()
+	b := New("test", cfg)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestBreaker(t *testing.T) {
+	cfg := Config{FailureThreshold: 3, OpenTimeout: 10 * time.Second}
+
+	t.Run("opens after consecutive failures", func(t *testing.T) {
+		b, _ := newTestBreaker(cfg)
+
+		b.Failure()
+		b.Failure()
+		b.Success()
+		b.Failure()
+		b.Failure()
+		require.Equal(t, Closed, b.State())
+		require.True(t, b.Allow())
+
+		b.Failure()
+		require.Equal(t, Open, b.State())
+		require.False(t, b.Allow())
+		require.Equal(t, Stats{State: Open, ConsecutiveFailures: 3, Opens: 1}, b.Stats())
+	})
+
+	t.Run("successful trial closes", func(t *testing.T) {
+		b, now := newTestBreaker(cfg)
+		for i := 0; i < 3; i++ {
+			b.Failure()
+		}
+
+		*now = now.Add(10 * time.Second)
+		require.True(t, b.Allow())
+		require.Equal(t, HalfOpen, b.State())
+		// A single trial at a time
+		require.False(t, b.Allow())
+
+		b.Success()
+		require.Equal(t, Closed, b.State())
+		require.True(t, b.Allow())
+		require.True(t, b.Allow())
+	})
+
+	t.Run("failed trial reopens", func(t *testing.T) {
+		b, now := newTestBreaker(cfg)
+		for i := 0; i < 3; i++ {
+			b.Failure()
+		}
+
+		*now = now.Add(10 * time.Second)
+		require.True(t, b.Allow())
+		b.Failure()
+		require.Equal(t, Open, b.State())
+		require.Equal(t, uint64(2), b.Stats().Opens)
+
+		// The open timeout restarts
+		*now = now.Add(5 * time.Second)
+		require.False(t, b.Allow())
+	})
+
+	t.Run("defaults fill an empty config", func(t *testing.T) {
+		b := New("test", Config{})
+		require.Equal(t, DefaultConfig(), b.cfg)
+	})
+}
+
+func TestWait(t *testing.T) {
+	t.Run("closed breaker doesn't wait", func(t *testing.T) {
+		b := New("test", Config{FailureThreshold: 1, OpenTimeout: time.Hour})
+		require.NoError(t, b.Wait(t.Context()))
+	})
+
+	t.Run("waits for the open timeout", func(t *testing.T) {
+		b := New("test", Config{FailureThreshold: 1, OpenTimeout: 50 * time.Millisecond})
+		b.Failure()
+
+		start := time.Now()
+		require.NoError(t, b.Wait(t.Context()))
+		require.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+		require.True(t, b.Allow())
+	})
+
+	t.Run("cancelled wait", func(t *testing.T) {
+		b := New("test", Config{FailureThreshold: 1, OpenTimeout: time.Hour})
+		b.Failure()
+
+		ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, b.Wait(ctx), context.DeadlineExceeded)
+	})
+}
+
+func TestStateString(t *testing.T) {
+	require.Equal(t, "closed", Closed.String())
+	require.Equal(t, "open", Open.String())
+	require.Equal(t, "half-open", HalfOpen.String())
+}
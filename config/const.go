@@ -8,6 +8,7 @@ const (
 	WRONG_AMQP_URI         = "amqp://wrongUri"
 	WRONG_QUEUE_NAME       = "amq.queueName"
 	USER_ID                = 999
+	LIMITS_USER_ID         = 998
 	TRANSACTION_TYPE       = "bet"
 	WRONG_TRANSACTION_TYPE = "wrongType"
 	AMOUNT                 = 0.5
@@ -0,0 +1,17 @@
+package config
+
+import (
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+// LoadEnv loads the .env file at ENV_PATH (.env by default) into the environment
+// and returns its path. Variables already set in the environment win.
+func LoadEnv() (string, error) {
+	envPath := os.Getenv("ENV_PATH")
+	if envPath == "" {
+		envPath = ".env"
+	}
+	return envPath, godotenv.Load(envPath)
+}
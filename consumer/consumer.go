@@ -2,28 +2,50 @@ package consumer
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"log"
-	"sync"
-	"transaction-management-system/config"
+	"log/slog"
+	"time"
+	"transaction-management-system/breaker"
 	"transaction-management-system/database"
+	"transaction-management-system/fraud"
+	"transaction-management-system/lifecycle"
+	"transaction-management-system/limits"
+	"transaction-management-system/logging"
+	"transaction-management-system/message"
+	"transaction-management-system/outbox"
 	"transaction-management-system/rabbitmq"
+	"transaction-management-system/tracing"
 	"transaction-management-system/transaction"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// Attempts to store a message before it is requeued
+	insertAttempts = 3
+	// Wait before the first retry, doubled after each attempt
+	retryBackoff = 200 * time.Millisecond
 )
 
 type Consumer struct {
 	RabbitMQ *rabbitmq.RabbitMQ
 	Db       *database.Database
-}
+	// Broker receives every stored transaction for live streaming (optional)
+	Broker *transaction.Broker
+	// Breaker pauses consuming while the database fails, a nil Breaker disables it
+	Breaker *breaker.Breaker
+	// Limits flags bets breaking the players' limits, a nil Limits disables it.
+	// Bets from the queue already took place, so they are stored either way.
+	Limits *limits.Engine
+	// Fraud evaluates every stored transaction against the fraud rules, a nil Fraud disables it
+	Fraud *fraud.Detector
 
-func NewConsumer(amqpURI, queueName string) (*Consumer, error) {
-	rmq, err := rabbitmq.GetInstance(amqpURI, queueName)
-	if err != nil {
-		return nil, err
-	}
+	stopper lifecycle.Stopper
+}
 
-	db, err := database.GetDB(config.DB_SCHEMA)
+// NewConsumer connects to RabbitMQ and stores messages in db, which stays owned by the caller
+func NewConsumer(amqpURI string, topology rabbitmq.Topology, db *database.Database) (*Consumer, error) {
+	rmq, err := rabbitmq.Connect(amqpURI, topology)
 	if err != nil {
 		return nil, err
 	}
@@ -31,52 +53,298 @@ func NewConsumer(amqpURI, queueName string) (*Consumer, error) {
 	return &Consumer{
 		RabbitMQ: rmq,
 		Db:       db,
+		Breaker:  breaker.New("database", breaker.DefaultConfig()),
 	}, nil
 }
 
-func (c *Consumer) Consume(ctx context.Context, wg *sync.WaitGroup, queueName string) {
-	defer wg.Done()
+// Consume stores the queue's transactions until Shutdown or until ctx is done.
+// A message is acked once stored, permanent failures are dead-lettered and
+// retryable ones are retried with backoff before the message is requeued.
+// While the breaker is open the consumer is cancelled, so messages wait in the queue.
+// After a lost connection it consumes again once RabbitMQ reconnected.
+// It returns an error when consuming can't start or the broker closes the consumer.
+func (c *Consumer) Consume(ctx context.Context, queueName string) error {
+	defer c.stopper.Stopped()
 	defer c.Close()
 
+	// Intake stops on Shutdown, the message being handled keeps ctx
+	intake, stopIntake := context.WithCancel(ctx)
+	defer stopIntake()
+	go func() {
+		select {
+		case <-c.stopper.Stopping():
+			stopIntake()
+		case <-intake.Done():
+		}
+	}()
+
 	msgs, err := c.RabbitMQ.Consume(queueName)
 	if err != nil {
-		log.Printf("Failed to start consumer: %v\n", err)
-		return
+		slog.ErrorContext(ctx, "Failed to start consumer", "queue", queueName, "error", err)
+		return err
 	}
 
-	fmt.Println("Consumer started. Waiting for messages...")
+	slog.InfoContext(ctx, "Consumer started, waiting for messages", "queue", queueName)
 	for {
 		select {
-		case <-ctx.Done():
-			return
-		case msg := <-msgs:
-			// Unmarshal body as transaction
-			var tr transaction.Transaction
-			err := json.Unmarshal(msg.Body, &tr)
-			if err != nil {
-				log.Printf("Error decoding transaction: %s\n", err)
+		case <-intake.Done():
+			if ctx.Err() == nil {
+				// Shutdown: hand the prefetched messages back before the connection closes
+				if err := c.requeue(ctx, queueName, msgs); err != nil {
+					slog.WarnContext(ctx, "Failed to cancel consumer", "queue", queueName, "error", err)
+				}
+			}
+			return nil
+		case msg, ok := <-msgs:
+			if !ok {
+				slog.WarnContext(ctx, "Consumer channel closed, waiting for RabbitMQ", "queue", queueName)
+				msgs, err = c.resume(intake, queueName)
+				if err != nil {
+					if intake.Err() != nil {
+						return nil
+					}
+					slog.ErrorContext(ctx, "Failed to resume consumer", "queue", queueName, "error", err)
+					return fmt.Errorf("consumer of %s closed by the broker: %w", queueName, err)
+				}
 				continue
 			}
+			c.handle(ctx, msg)
 
-			// Insert transaction into database
-			log.Printf(" [x] Received: %s\n", tr)
-			if err := c.Db.InsertTransaction(tr.UserId, tr.TransactionType, tr.Amount, tr.Timestamp); err != nil {
-				log.Printf(" WARN: Message has not been processed successfully")
-				msg.Nack(false, true)
-				continue
+			if c.Breaker != nil && c.Breaker.State() == breaker.Open {
+				msgs, err = c.pause(intake, queueName, msgs)
+				if err != nil {
+					if intake.Err() != nil {
+						return nil
+					}
+					slog.ErrorContext(ctx, "Failed to resume consumer", "queue", queueName, "error", err)
+					return err
+				}
 			}
-			log.Printf(" [x] Inserted: %s\n", tr)
 		}
 	}
 }
 
-func (c *Consumer) Close() error {
-	if err := c.RabbitMQ.Close(); err != nil {
+// Shutdown stops taking messages and waits until the message being handled is
+// settled and Consume returned, or until ctx is done
+func (c *Consumer) Shutdown(ctx context.Context) error {
+	return c.stopper.Stop(ctx)
+}
+
+// pause cancels the consumer until the breaker allows a trial, then consumes again.
+// Deliveries already in flight are requeued.
+func (c *Consumer) pause(ctx context.Context, queueName string, msgs <-chan amqp.Delivery) (<-chan amqp.Delivery, error) {
+	slog.WarnContext(ctx, "Database unavailable, consumer paused", "queue", queueName)
+	if err := c.requeue(ctx, queueName, msgs); err != nil {
+		return nil, err
+	}
+
+	if err := c.Breaker.Wait(ctx); err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Consumer resumed, probing the database", "queue", queueName)
+	return c.RabbitMQ.Consume(queueName)
+}
+
+// resume consumes again once the channel is open, after the connection was lost.
+// The broker requeued the unacked deliveries of the closed channel.
+func (c *Consumer) resume(ctx context.Context, queueName string) (<-chan amqp.Delivery, error) {
+	if err := c.RabbitMQ.Wait(ctx); err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Consumer resumed", "queue", queueName)
+	return c.RabbitMQ.Consume(queueName)
+}
+
+// requeue cancels the consumer and requeues the deliveries it still holds
+func (c *Consumer) requeue(ctx context.Context, queueName string, msgs <-chan amqp.Delivery) error {
+	if err := c.RabbitMQ.Cancel(queueName); err != nil {
 		return err
 	}
-	if err := c.Db.Close(); err != nil {
+	for msg := range msgs {
+		settle(messageContext(ctx, msg), msg.Nack(false, true))
+	}
+	return nil
+}
+
+// handle stores one delivery and settles it. Logs and database calls carry
+// the message's correlation id and run in a span continuing the publisher's trace.
+func (c *Consumer) handle(ctx context.Context, msg amqp.Delivery) {
+	ctx, span := rabbitmq.StartProcess(messageContext(ctx, msg), msg)
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	var tr transaction.Transaction
+
+	// Decode and validate the versioned message in the format of its content type
+	tr, err = message.DecodeContentType(msg.ContentType, msg.Body)
+	if err != nil {
+		// Redelivering can't fix the body
+		slog.WarnContext(ctx, "Invalid message, dead-lettering", "error", err)
+		settle(ctx, msg.Nack(false, false))
+		return
+	}
+
+	slog.DebugContext(ctx, "Received transaction", "transaction", tr, "redelivered", msg.Redelivered)
+	relayed := outbox.Relayed(msg.MessageId)
+	if relayed != (tr.Id != 0) {
+		// Only the outbox relay publishes stored transactions, with their id
+		err = fmt.Errorf("%w: transaction id %d with message id %q", message.ErrInvalid, tr.Id, msg.MessageId)
+		slog.WarnContext(ctx, "Invalid message, dead-lettering", "error", err)
+		settle(ctx, msg.Nack(false, false))
+		return
+	}
+	if relayed {
+		// Submitted through the API, stored together with its outbox event. The relay
+		// publishes the event again when it crashed before marking it sent.
+		var first bool
+		first, err = c.receive(ctx, tr.Id)
+		if err != nil {
+			requeue := !database.IsPermanent(err)
+			slog.WarnContext(ctx, "Message has not been processed successfully", "error", err, "requeue", requeue)
+			settle(ctx, msg.Nack(false, requeue))
+			return
+		}
+		if !first {
+			slog.InfoContext(ctx, "Relayed transaction received before, skipping", "transaction", tr)
+			settle(ctx, msg.Ack(false))
+			return
+		}
+		slog.InfoContext(ctx, "Transaction already stored", "transaction", tr)
+	} else {
+		// Evaluate against the usage before the bet is stored
+		violations := c.checkLimits(ctx, tr)
+
+		// Insert transaction into database
+		var id int64
+		id, err = c.insert(ctx, tr)
+		if err != nil {
+			// Dead-letter what will fail again, requeue the rest
+			requeue := !database.IsPermanent(err)
+			slog.WarnContext(ctx, "Message has not been processed successfully", "error", err, "requeue", requeue)
+			settle(ctx, msg.Nack(false, requeue))
+			return
+		}
+		tr.Id = id
+		slog.InfoContext(ctx, "Inserted transaction", "transaction", tr)
+		c.flag(ctx, tr, violations)
+	}
+	settle(ctx, msg.Ack(false))
+	c.detect(ctx, tr)
+
+	// Notify live subscribers
+	if c.Broker != nil {
+		c.Broker.Publish(tr)
+	}
+}
+
+// insert stores the transaction, retrying with backoff unless the error is permanent
+func (c *Consumer) insert(ctx context.Context, tr transaction.Transaction) (int64, error) {
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		if c.Breaker != nil && !c.Breaker.Allow() {
+			return 0, breaker.ErrOpen
+		}
+		id, err := c.Db.InsertTransaction(ctx, tr.UserId, tr.TransactionType, tr.Amount, tr.Timestamp)
+		c.record(ctx, err)
+		if err == nil || database.IsPermanent(err) || attempt == insertAttempts {
+			return id, err
+		}
+		slog.WarnContext(ctx, "Insert failed, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return 0, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// receive records the receipt of a relayed transaction, false when it was received before
+func (c *Consumer) receive(ctx context.Context, transactionId int64) (bool, error) {
+	if c.Breaker != nil && !c.Breaker.Allow() {
+		return false, breaker.ErrOpen
+	}
+	first, err := c.Db.ReceiveOutbox(ctx, transactionId)
+	c.record(ctx, err)
+	return first, err
+}
+
+// checkLimits returns the violations of a bet, none when the limits can't be read
+func (c *Consumer) checkLimits(ctx context.Context, tr transaction.Transaction) []limits.Violation {
+	if c.Limits == nil || tr.TransactionType != transaction.BET {
+		return nil
+	}
+	violations, err := c.Limits.Evaluate(ctx, []limits.Bet{{UserId: tr.UserId, Amount: tr.Amount}})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to check limits", "error", err)
+		return nil
+	}
+	return violations[0]
+}
+
+// flag records the violations of a stored bet
+func (c *Consumer) flag(ctx context.Context, tr transaction.Transaction, violations []limits.Violation) {
+	if len(violations) == 0 {
+		return
+	}
+	slog.WarnContext(ctx, "Bet breaks the player's limits", "transaction", tr, "violation", violations[0].String())
+	bet := limits.Bet{UserId: tr.UserId, Amount: tr.Amount}
+	if err := c.Limits.Record(ctx, bet, tr.Id, limits.ActionFlagged, violations); err != nil {
+		slog.ErrorContext(ctx, "Failed to record limit violations", "error", err)
+	}
+}
+
+// detect runs the fraud rules on a stored transaction, API-originated ones included
+func (c *Consumer) detect(ctx context.Context, tr transaction.Transaction) {
+	if c.Fraud == nil {
+		return
+	}
+	alerts, err := c.Fraud.Observe(ctx, fraud.Transaction{
+		Id:     tr.Id,
+		UserId: tr.UserId,
+		Type:   tr.TransactionType,
+		Amount: tr.Amount,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to run fraud rules", "error", err)
+		return
+	}
+	for _, a := range alerts {
+		slog.WarnContext(ctx, "Fraud alert", "rule", a.Rule, "detail", a.Detail, "transaction", tr)
+	}
+}
+
+// record reports the outcome of a database call to the breaker. A permanent error
+// means the database answered, an error after ctx is done is the shutdown's.
+func (c *Consumer) record(ctx context.Context, err error) {
+	switch {
+	case c.Breaker == nil || ctx.Err() != nil:
+	case err == nil || database.IsPermanent(err):
+		c.Breaker.Success()
+	default:
+		c.Breaker.Failure()
+	}
+}
+
+// messageContext returns ctx with the message's correlation id, or a new one
+// for messages published without
+func messageContext(ctx context.Context, msg amqp.Delivery) context.Context {
+	return logging.Ensure(ctx, msg.CorrelationId)
+}
+
+// settle logs a failed ack or nack, the broker redelivers the message when the channel closes
+func settle(ctx context.Context, err error) {
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to settle message", "error", err)
+	}
+}
+
+// Close closes the RabbitMQ connection, the database is closed by its owner
+func (c *Consumer) Close() error {
+	if err := c.RabbitMQ.Close(); err != nil {
 		return err
 	}
-	log.Println("Consumer closed succesfully")
+	slog.Info("Consumer closed")
 	return nil
 }
@@ -3,46 +3,52 @@ package consumer
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"log"
+	"log/slog"
 	"os"
-	"sync"
 	"testing"
 	"time"
+	"transaction-management-system/breaker"
 	test "transaction-management-system/config"
 	"transaction-management-system/database"
+	"transaction-management-system/logging"
+	"transaction-management-system/message"
+	"transaction-management-system/rabbitmq"
 	"transaction-management-system/transaction"
 
+	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/stretchr/testify/require"
 )
 
+// openDB opens a handle on the test schema, closed when the test ends
+func openDB(t *testing.T) *database.Database {
+	t.Helper()
+	test.LoadEnv()
+	cfg, err := database.ConfigFromEnv(test.DB_SCHEMA)
+	require.NoError(t, err)
+	db, err := database.Open(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
 func TestNewConsumer(t *testing.T) {
 	t.Run("failed new consumer - wrong amqp uri", func(t *testing.T) {
-		_, err := NewConsumer(test.WRONG_AMQP_URI, test.QUEUE_NAME)
+		_, err := NewConsumer(test.WRONG_AMQP_URI, rabbitmq.DefaultTopology(test.QUEUE_NAME), nil)
 
 		require.Error(t, err)
 		require.ErrorContains(t, err, "failed to connect to RabbitMQ")
 	})
 	t.Run("failed new consumer - wrong queue name", func(t *testing.T) {
-		_, err := NewConsumer(test.AMQP_URI, test.WRONG_QUEUE_NAME)
+		_, err := NewConsumer(test.AMQP_URI, rabbitmq.DefaultTopology(test.WRONG_QUEUE_NAME), nil)
 
 		require.Error(t, err)
 		require.ErrorContains(t, err, "failed to declare a queue")
 	})
 
-	t.Run("failed new consumer - db", func(t *testing.T) {
-		os.Setenv("ENV_PATH", "")
-
-		_, err := NewConsumer(test.AMQP_URI, test.QUEUE_NAME)
-
-		require.Error(t, err)
-		require.ErrorContains(t, err, "failed to load .env file")
-
-		os.Setenv("ENV_PATH", "../.env")
-		database.ResetInstance()
-	})
-
 	t.Run("succesfully created new consumer", func(t *testing.T) {
-		c, err := NewConsumer(test.AMQP_URI, test.QUEUE_NAME)
+		c, err := NewConsumer(test.AMQP_URI, rabbitmq.DefaultTopology(test.QUEUE_NAME), openDB(t))
 
 		require.Nil(t, err)
 		require.NotNil(t, c)
@@ -51,35 +57,36 @@ func TestNewConsumer(t *testing.T) {
 
 func TestConsume(t *testing.T) {
 
-	var wg sync.WaitGroup
 	var buf bytes.Buffer
-	log.SetOutput(&buf)
+	prev := slog.Default()
+	slog.SetDefault(logging.New(logging.Config{Level: slog.LevelDebug, Format: logging.FormatText}, &buf))
 	defer func() {
-		log.SetOutput(os.Stderr) // Reset log output when test is done
+		// Reset the loggers when test is done, SetDefault redirected the log package
+		slog.SetDefault(prev)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
 	}()
 
 	t.Run("failed consuming", func(t *testing.T) {
-		c, _ := NewConsumer(test.AMQP_URI, test.QUEUE_NAME)
+		c, _ := NewConsumer(test.AMQP_URI, rabbitmq.DefaultTopology(test.QUEUE_NAME), openDB(t))
 		defer c.Close()
 
 		// Close RabbitMQ before consuming
 		c.RabbitMQ.Close()
 
-		wg.Add(1)
-		go c.Consume(t.Context(), &wg, test.QUEUE_NAME)
-
-		wg.Wait()
+		err := c.Consume(t.Context(), test.QUEUE_NAME)
+		require.Error(t, err)
 
 		logOutput := buf.String()
-		require.Contains(t, logOutput, "Failed to start consumer:",
+		require.Contains(t, logOutput, "Failed to start consumer",
 			"Expected error log not found in:\n%s", logOutput)
 	})
 
 	t.Run("failed message processing", func(t *testing.T) {
-		c, _ := NewConsumer(test.AMQP_URI, test.QUEUE_NAME)
+		c, _ := NewConsumer(test.AMQP_URI, rabbitmq.DefaultTopology(test.QUEUE_NAME), openDB(t))
 		defer c.Close()
 
-		c.RabbitMQ.Publish(test.QUEUE_NAME, transaction.NewTransaction())
+		c.RabbitMQ.Publish(t.Context(), test.QUEUE_NAME, transaction.NewTransaction())
 
 		ctx, close := context.WithTimeout(t.Context(), 1*time.Second)
 		defer close()
@@ -87,10 +94,7 @@ func TestConsume(t *testing.T) {
 		// Close DB before consuming
 		c.Db.Close()
 
-		wg.Add(1)
-		go c.Consume(ctx, &wg, test.QUEUE_NAME)
-
-		wg.Wait()
+		require.NoError(t, c.Consume(ctx, test.QUEUE_NAME))
 
 		logOutput := buf.String()
 		require.Contains(t, logOutput, "Message has not been processed successfully",
@@ -98,32 +102,175 @@ func TestConsume(t *testing.T) {
 	})
 
 	t.Run("successfully consumed", func(t *testing.T) {
-		c, _ := NewConsumer(test.AMQP_URI, test.QUEUE_NAME)
+		c, _ := NewConsumer(test.AMQP_URI, rabbitmq.DefaultTopology(test.QUEUE_NAME), openDB(t))
 		t.Log(c.Db)
 		defer c.Close()
 
-		c.RabbitMQ.Publish(test.QUEUE_NAME, transaction.NewTransaction())
+		published := logging.WithCorrelationID(t.Context(), "consumer-test")
+		c.RabbitMQ.Publish(published, test.QUEUE_NAME, transaction.NewTransaction())
 
 		ctx, close := context.WithTimeout(t.Context(), 1*time.Second)
 		defer close()
 
-		wg.Add(1)
-		go c.Consume(ctx, &wg, test.QUEUE_NAME)
-
-		wg.Wait()
+		require.NoError(t, c.Consume(ctx, test.QUEUE_NAME))
 
 		logOutput := buf.String()
 		require.Contains(t, logOutput, "Received",
 			"Expected error log not found in:\n%s", logOutput)
 		require.Contains(t, logOutput, "Inserted",
 			"Expected error log not found in:\n%s", logOutput)
+		// The publisher's correlation id travels with the message
+		require.Contains(t, logOutput, "correlation_id=consumer-test",
+			"Expected correlation id not found in:\n%s", logOutput)
 	})
 
 }
 
+func TestShutdown(t *testing.T) {
+	c, _ := NewConsumer(test.AMQP_URI, rabbitmq.DefaultTopology(test.QUEUE_NAME), openDB(t))
+	c.RabbitMQ.Publish(t.Context(), test.QUEUE_NAME, transaction.NewTransaction())
+
+	consumed := make(chan error, 1)
+	go func() { consumed <- c.Consume(t.Context(), test.QUEUE_NAME) }()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, c.Shutdown(ctx))
+	require.NoError(t, <-consumed)
+}
+
+// acknowledger records how a delivery was settled
+type acknowledger struct {
+	acked, nacked, requeued bool
+}
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = true
+	return nil
+}
+
+func (a *acknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.nacked, a.requeued = true, requeue
+	return nil
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func TestHandle(t *testing.T) {
+	t.Run("undecodable message is dead-lettered", func(t *testing.T) {
+		ack := &acknowledger{}
+		c := &Consumer{}
+		c.handle(t.Context(), amqp.Delivery{Acknowledger: ack, Body: []byte("not json")})
+
+		require.False(t, ack.acked)
+		require.True(t, ack.nacked)
+		require.False(t, ack.requeued)
+	})
+	t.Run("open breaker requeues without storing", func(t *testing.T) {
+		ack := &acknowledger{}
+		c := &Consumer{Breaker: breaker.New("database", breaker.Config{FailureThreshold: 1, OpenTimeout: time.Minute})}
+		c.Breaker.Failure()
+		body, err := json.Marshal(transaction.NewTransaction())
+		require.NoError(t, err)
+		c.handle(t.Context(), amqp.Delivery{Acknowledger: ack, Body: body})
+
+		require.True(t, ack.nacked)
+		require.True(t, ack.requeued)
+	})
+	t.Run("unsupported content type is dead-lettered", func(t *testing.T) {
+		ack := &acknowledger{}
+		c := &Consumer{}
+		body, err := json.Marshal(transaction.NewTransaction())
+		require.NoError(t, err)
+		c.handle(t.Context(), amqp.Delivery{Acknowledger: ack, ContentType: "application/xml", Body: body})
+
+		require.True(t, ack.nacked)
+		require.False(t, ack.requeued)
+	})
+	t.Run("protobuf message is decoded", func(t *testing.T) {
+		ack := &acknowledger{}
+		c := &Consumer{Breaker: breaker.New("database", breaker.Config{FailureThreshold: 1, OpenTimeout: time.Minute})}
+		c.Breaker.Failure()
+		body, err := message.Protobuf.Encode(transaction.NewTransaction())
+		require.NoError(t, err)
+		c.handle(t.Context(), amqp.Delivery{Acknowledger: ack, ContentType: message.ContentTypeProtobuf, Body: body})
+
+		// Past decoding, held back by the breaker
+		require.True(t, ack.nacked)
+		require.True(t, ack.requeued)
+	})
+	t.Run("transaction id outside the outbox is dead-lettered", func(t *testing.T) {
+		ack := &acknowledger{}
+		c := &Consumer{}
+		tr := transaction.NewTransaction()
+		tr.Id = 7
+		body, err := message.Encode(tr)
+		require.NoError(t, err)
+		c.handle(t.Context(), amqp.Delivery{Acknowledger: ack, Body: body})
+
+		require.True(t, ack.nacked)
+		require.False(t, ack.requeued)
+	})
+	t.Run("relayed message waits for the breaker", func(t *testing.T) {
+		ack := &acknowledger{}
+		c := &Consumer{Breaker: breaker.New("database", breaker.Config{FailureThreshold: 1, OpenTimeout: time.Minute})}
+		c.Breaker.Failure()
+		tr := transaction.NewTransaction()
+		tr.Id = 7
+		body, err := message.Encode(tr)
+		require.NoError(t, err)
+		c.handle(t.Context(), amqp.Delivery{Acknowledger: ack, MessageId: "outbox-7", Body: body})
+
+		require.True(t, ack.nacked)
+		require.True(t, ack.requeued)
+	})
+	t.Run("relayed message is handled once", func(t *testing.T) {
+		broker := transaction.NewBroker()
+		sub := broker.Subscribe(transaction.Filter{}, 2)
+		defer broker.Unsubscribe(sub)
+		c := &Consumer{Db: openDB(t), Broker: broker}
+		tr := transaction.NewTransaction()
+		tr.Id = time.Now().UnixNano()
+		body, err := message.Encode(tr)
+		require.NoError(t, err)
+
+		// Published again by a relay that crashed before marking it sent
+		for range 2 {
+			ack := &acknowledger{}
+			c.handle(t.Context(), amqp.Delivery{Acknowledger: ack, MessageId: "outbox-1", Body: body})
+			require.True(t, ack.acked)
+		}
+		require.Len(t, sub.Transactions(), 1)
+	})
+	t.Run("stored message is acked", func(t *testing.T) {
+		ack := &acknowledger{}
+		c := &Consumer{Db: openDB(t)}
+		body, err := json.Marshal(transaction.NewTransaction())
+		require.NoError(t, err)
+		c.handle(t.Context(), amqp.Delivery{Acknowledger: ack, Body: body})
+
+		require.True(t, ack.acked)
+		require.False(t, ack.nacked)
+	})
+	t.Run("permanent failure is dead-lettered", func(t *testing.T) {
+		ack := &acknowledger{}
+		c := &Consumer{Db: openDB(t)}
+		tr := transaction.NewTransaction()
+		tr.TransactionType = test.WRONG_TRANSACTION_TYPE
+		body, err := json.Marshal(tr)
+		require.NoError(t, err)
+		c.handle(t.Context(), amqp.Delivery{Acknowledger: ack, Body: body})
+
+		require.True(t, ack.nacked)
+		require.False(t, ack.requeued)
+	})
+}
+
 func TestClose(t *testing.T) {
 	t.Run("failed closing", func(t *testing.T) {
-		c, _ := NewConsumer(test.AMQP_URI, test.QUEUE_NAME)
+		c, _ := NewConsumer(test.AMQP_URI, rabbitmq.DefaultTopology(test.QUEUE_NAME), openDB(t))
 		c.Close()
 		err := c.Close()
 
@@ -131,9 +278,17 @@ func TestClose(t *testing.T) {
 		require.ErrorContains(t, err, "channel/connection is not open")
 	})
 	t.Run("succesfully closed", func(t *testing.T) {
-		c, _ := NewConsumer(test.AMQP_URI, test.QUEUE_NAME)
+		c, _ := NewConsumer(test.AMQP_URI, rabbitmq.DefaultTopology(test.QUEUE_NAME), openDB(t))
 		err := c.Close()
 
 		require.Nil(t, err)
 	})
+	t.Run("database stays open for its owner", func(t *testing.T) {
+		db := openDB(t)
+		c, _ := NewConsumer(test.AMQP_URI, rabbitmq.DefaultTopology(test.QUEUE_NAME), db)
+		require.NoError(t, c.Close())
+
+		_, _, err := db.GetBalance(t.Context(), test.USER_ID)
+		require.NoError(t, err)
+	})
 }
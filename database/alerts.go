@@ -0,0 +1,210 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Alert is a transaction that matched a fraud detection rule
+type Alert struct {
+	Id            int64
+	UserId        int
+	TransactionId int64
+	Rule          string
+	Detail        string
+	CreatedAt     time.Time
+	// AcknowledgedAt is nil until an operator acknowledges the alert
+	AcknowledgedAt *time.Time
+	AcknowledgedBy string
+}
+
+// AlertFilter selects alerts, nil fields match every alert
+type AlertFilter struct {
+	UserId       *int
+	Rule         *string
+	Acknowledged *bool
+}
+
+// RecentTransaction is a stored transaction with how long ago it was stored,
+// measured on the database clock
+type RecentTransaction struct {
+	Id              int64
+	UserId          int
+	TransactionType string
+	Amount          float64
+	Age             time.Duration
+}
+
+// prepareAlerts prepares the statements of the alerts table and of the fraud rules' history
+func (db *Database) prepareAlerts(schema string) error {
+	stmts := []struct {
+		stmt  **sql.Stmt
+		name  string
+		query string
+	}{
+		{&db.insertAlertPrepStmt, "insert alert", `
+			INSERT INTO %s.alerts (user_id, transaction_id, rule, detail)
+			VALUES (?, ?, ?, ?)
+		`},
+		{&db.getAlertsPrepStmt, "get alerts", `
+			SELECT id, user_id, transaction_id, rule, detail, created_at, acknowledged_at, acknowledged_by
+			FROM %s.alerts
+			WHERE (? IS NULL OR user_id = ?)
+			AND (? IS NULL OR rule = ?)
+			AND (? IS NULL OR (acknowledged_at IS NOT NULL) = ?)
+			ORDER BY id DESC
+			LIMIT ?
+		`},
+		{&db.acknowledgeAlertPrepStmt, "acknowledge alert", `
+			UPDATE %s.alerts
+			SET acknowledged_at = CURRENT_TIMESTAMP, acknowledged_by = ?
+			WHERE id = ? AND acknowledged_at IS NULL
+		`},
+		{&db.getRecentTransactionsPrepStmt, "get recent transactions", `
+			SELECT id, user_id, transaction_type, amount, TIMESTAMPDIFF(MICROSECOND, created_at, CURRENT_TIMESTAMP(6))
+			FROM %s.transactions
+			WHERE user_id = ? AND created_at >= CURRENT_TIMESTAMP - INTERVAL ? MICROSECOND AND id < ?
+			ORDER BY created_at DESC, id DESC
+			LIMIT ?
+		`},
+	}
+	for _, s := range stmts {
+		stmt, err := db.conn.Prepare(fmt.Sprintf(s.query, schema))
+		if err != nil {
+			return fmt.Errorf("failed to prepare %s statement: %w", s.name, err)
+		}
+		*s.stmt = stmt
+	}
+	return nil
+}
+
+// alertStmts returns the statements of prepareAlerts for Close
+func (db *Database) alertStmts() []*sql.Stmt {
+	return []*sql.Stmt{
+		db.insertAlertPrepStmt,
+		db.getAlertsPrepStmt,
+		db.acknowledgeAlertPrepStmt,
+		db.getRecentTransactionsPrepStmt,
+	}
+}
+
+// InsertAlerts stores the alerts, all of them or none
+func (db *Database) InsertAlerts(ctx context.Context, alerts []Alert) (err error) {
+	ctx, cancel := db.writeContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "insert alerts")
+	defer func() { done(err) }()
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", classify(ctx, err))
+	}
+	defer tx.Rollback()
+
+	insert := tx.StmtContext(ctx, db.insertAlertPrepStmt)
+	for _, a := range alerts {
+		if _, err := insert.ExecContext(ctx, a.UserId, a.TransactionId, a.Rule, a.Detail); err != nil {
+			return fmt.Errorf("failed to insert alert: %w", classify(ctx, err))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", classify(ctx, err))
+	}
+	return nil
+}
+
+// GetAlerts returns the latest alerts matching the filter, newest first.
+// It reads the primary, so an alert just acknowledged isn't listed as open.
+func (db *Database) GetAlerts(ctx context.Context, filter AlertFilter, limit int) ([]Alert, error) {
+	userIdVal, ruleVal := filterArgs(filter.UserId, filter.Rule)
+	var acknowledgedVal interface{}
+	if filter.Acknowledged != nil {
+		acknowledgedVal = *filter.Acknowledged
+	}
+
+	ctx, cancel := db.readContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "get alerts")
+	rows, err := db.getAlertsPrepStmt.QueryContext(ctx, userIdVal, userIdVal, ruleVal, ruleVal, acknowledgedVal, acknowledgedVal, limit)
+	done(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alerts: %w", classify(ctx, err))
+	}
+	defer rows.Close()
+
+	var alerts []Alert
+	for rows.Next() {
+		var a Alert
+		var acknowledgedAt sql.NullTime
+		var acknowledgedBy sql.NullString
+		if err := rows.Scan(&a.Id, &a.UserId, &a.TransactionId, &a.Rule, &a.Detail, &a.CreatedAt, &acknowledgedAt, &acknowledgedBy); err != nil {
+			return nil, fmt.Errorf("failed to scan alert: %w", err)
+		}
+		if acknowledgedAt.Valid {
+			a.AcknowledgedAt = &acknowledgedAt.Time
+		}
+		a.AcknowledgedBy = acknowledgedBy.String
+		alerts = append(alerts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query alerts: %w", classify(ctx, err))
+	}
+	return alerts, nil
+}
+
+// AcknowledgeAlert marks the alert as handled by the subject acknowledgedBy.
+// It returns sql.ErrNoRows when no open alert has the id.
+func (db *Database) AcknowledgeAlert(ctx context.Context, id int64, acknowledgedBy string) error {
+	ctx, cancel := db.writeContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "acknowledge alert")
+	result, err := db.acknowledgeAlertPrepStmt.ExecContext(ctx, acknowledgedBy, id)
+	done(err)
+	if err != nil {
+		return fmt.Errorf("failed to acknowledge alert: %w", classify(ctx, err))
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to acknowledge alert: %w", err)
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetRecentTransactions returns up to limit of the user's transactions stored within the window
+// with an id below beforeId, newest first. It reads the primary, so none stored is missed.
+func (db *Database) GetRecentTransactions(ctx context.Context, userId int, window time.Duration, beforeId int64, limit int) ([]RecentTransaction, error) {
+	ctx, cancel := db.readContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "get recent transactions")
+	rows, err := db.getRecentTransactionsPrepStmt.QueryContext(ctx, userId, window.Microseconds(), beforeId, limit)
+	done(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recent transactions: %w", classify(ctx, err))
+	}
+	defer rows.Close()
+
+	var transactions []RecentTransaction
+	for rows.Next() {
+		var t RecentTransaction
+		var age int64
+		if err := rows.Scan(&t.Id, &t.UserId, &t.TransactionType, &t.Amount, &age); err != nil {
+			return nil, fmt.Errorf("failed to scan recent transaction: %w", err)
+		}
+		t.Age = time.Duration(age) * time.Microsecond
+		transactions = append(transactions, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query recent transactions: %w", classify(ctx, err))
+	}
+	return transactions, nil
+}
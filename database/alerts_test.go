@@ -0,0 +1,81 @@
+package database
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+	test "transaction-management-system/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestAlerts tests storing, listing and acknowledging fraud alerts
+func TestAlerts(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	userId := test.USER_ID
+	rule := "large_win"
+	open, acknowledged := false, true
+
+	t.Run("successful insert and get alerts", func(t *testing.T) {
+		err := db.InsertAlerts(t.Context(), []Alert{
+			{UserId: userId, TransactionId: 1, Rule: "velocity", Detail: "31 bets within 1m0s, more than 30"},
+			{UserId: userId, TransactionId: 2, Rule: rule, Detail: "win of 500.00 is more than 50 times the stake of 2.00"},
+		})
+		require.NoError(t, err)
+
+		alerts, err := db.GetAlerts(t.Context(), AlertFilter{UserId: &userId, Rule: &rule, Acknowledged: &open}, 1)
+		require.NoError(t, err)
+		require.Len(t, alerts, 1)
+		require.Equal(t, rule, alerts[0].Rule)
+		require.Equal(t, int64(2), alerts[0].TransactionId)
+		require.Nil(t, alerts[0].AcknowledgedAt)
+	})
+
+	t.Run("successful acknowledge alert", func(t *testing.T) {
+		alerts, err := db.GetAlerts(t.Context(), AlertFilter{UserId: &userId, Acknowledged: &open}, 1)
+		require.NoError(t, err)
+		require.Len(t, alerts, 1)
+		id := alerts[0].Id
+
+		require.NoError(t, db.AcknowledgeAlert(t.Context(), id, "tester"))
+		require.ErrorIs(t, db.AcknowledgeAlert(t.Context(), id, "tester"), sql.ErrNoRows)
+
+		alerts, err = db.GetAlerts(t.Context(), AlertFilter{UserId: &userId, Acknowledged: &acknowledged}, 1)
+		require.NoError(t, err)
+		require.Equal(t, id, alerts[0].Id)
+		require.NotNil(t, alerts[0].AcknowledgedAt)
+		require.Equal(t, "tester", alerts[0].AcknowledgedBy)
+	})
+
+	t.Run("failed acknowledge missing alert", func(t *testing.T) {
+		require.ErrorIs(t, db.AcknowledgeAlert(t.Context(), -1, "tester"), sql.ErrNoRows)
+	})
+
+	t.Run("successful get recent transactions", func(t *testing.T) {
+		// Stored now, whatever the transaction time
+		id, err := db.InsertTransaction(t.Context(), userId, "bet", 4, time.Now().Add(-24*time.Hour))
+		require.NoError(t, err)
+
+		recent, err := db.GetRecentTransactions(t.Context(), userId, time.Minute, id+1, 10)
+		require.NoError(t, err)
+		require.NotEmpty(t, recent)
+		require.Equal(t, id, recent[0].Id)
+		require.Equal(t, "bet", recent[0].TransactionType)
+		require.Less(t, recent[0].Age, time.Minute)
+
+		recent, err = db.GetRecentTransactions(t.Context(), userId, time.Minute, id, 10)
+		require.NoError(t, err)
+		for _, r := range recent {
+			require.Less(t, r.Id, id)
+		}
+	})
+
+	t.Run("failed get alerts on closed database", func(t *testing.T) {
+		closed := openDB(t)
+		closed.Close()
+		_, err := closed.GetAlerts(t.Context(), AlertFilter{}, 1)
+		require.ErrorContains(t, err, "failed to query alerts")
+	})
+}
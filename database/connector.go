@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"transaction-management-system/secrets"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// newConnector returns a connector for the connection string. MYSQL_TLS_* files
+// enable TLS, and MYSQL_USER / MYSQL_PASSWORD (or their _FILE variants)
+// override the credentials. Credentials are read again for every new
+// connection, so rotated secrets apply once pooled connections are recycled.
+func newConnector(connectionString string) (driver.Connector, error) {
+	tlsFiles := secrets.TLSFilesFromEnv("MYSQL")
+	if tlsFiles.Enabled() {
+		tlsConfig, err := secrets.LoadTLSConfig(tlsFiles)
+		if err != nil {
+			return nil, err
+		}
+		if err := mysql.RegisterTLSConfig(tlsConfigName(tlsFiles), tlsConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg, err := mysql.ParseDSN(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	if tlsFiles.Enabled() && cfg.TLSConfig == "" {
+		cfg.TLSConfig = tlsConfigName(tlsFiles)
+	}
+
+	user, password := secrets.FromEnv("MYSQL_USER"), secrets.FromEnv("MYSQL_PASSWORD")
+	if user != nil || password != nil {
+		err := cfg.Apply(mysql.BeforeConnect(func(ctx context.Context, c *mysql.Config) error {
+			return applyCredentials(c, user, password)
+		}))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return mysql.NewConnector(cfg)
+}
+
+// tlsConfigName returns the name the TLS config of the files is registered under with
+// the mysql driver. The registry is global, so handles with other files don't replace it.
+func tlsConfigName(files secrets.TLSFiles) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%q", files)))
+	return "casino-" + hex.EncodeToString(sum[:8])
+}
+
+// applyCredentials sets the current user and password on the connection config
+func applyCredentials(c *mysql.Config, user, password secrets.Secret) error {
+	if user != nil {
+		value, err := user.Get()
+		if err != nil {
+			return fmt.Errorf("mysql user: %w", err)
+		}
+		c.User = value
+	}
+	if password != nil {
+		value, err := password.Get()
+		if err != nil {
+			return fmt.Errorf("mysql password: %w", err)
+		}
+		c.Passwd = value
+	}
+	return nil
+}
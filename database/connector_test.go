@@ -0,0 +1,55 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"transaction-management-system/secrets"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConnector(t *testing.T) {
+	t.Run("invalid connection string", func(t *testing.T) {
+		_, err := newConnector(":@invalid")
+		require.Error(t, err)
+	})
+
+	t.Run("missing TLS files", func(t *testing.T) {
+		t.Setenv("MYSQL_TLS_CA", filepath.Join(t.TempDir(), "missing.pem"))
+
+		_, err := newConnector("user:password@tcp(127.0.0.1:3306)/casino")
+		require.ErrorContains(t, err, "failed to read CA bundle")
+	})
+
+	t.Run("credentials from files", func(t *testing.T) {
+		t.Setenv("MYSQL_PASSWORD_FILE", filepath.Join(t.TempDir(), "password"))
+
+		_, err := newConnector("user@tcp(127.0.0.1:3306)/casino")
+		require.NoError(t, err)
+	})
+}
+
+func TestTLSConfigName(t *testing.T) {
+	files := secrets.TLSFiles{CAFile: "/etc/mysql/ca.pem"}
+	require.Equal(t, tlsConfigName(files), tlsConfigName(files))
+
+	other := secrets.TLSFiles{CAFile: "/etc/mysql/ca.pem", ServerName: "replica"}
+	require.NotEqual(t, tlsConfigName(files), tlsConfigName(other))
+}
+
+func TestApplyCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(path, []byte("first\n"), 0o600))
+	password := secrets.NewFile(path)
+
+	cfg := mysql.NewConfig()
+	require.NoError(t, applyCredentials(cfg, secrets.Static("casino"), password))
+	require.Equal(t, "casino", cfg.User)
+	require.Equal(t, "first", cfg.Passwd)
+
+	require.NoError(t, os.Remove(path))
+	err := applyCredentials(cfg, nil, password)
+	require.ErrorContains(t, err, "mysql password")
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	// ErrRetryable marks failures that may succeed when the operation is retried,
+	// e.g. deadlocks, lock wait timeouts and lost connections
+	ErrRetryable = errors.New("retryable database error")
+	// ErrPermanent marks failures that will fail again with the same input,
+	// e.g. data truncation and constraint violations
+	ErrPermanent = errors.New("permanent database error")
+)
+
+// MySQL server error numbers, see https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+var (
+	retryableErrorNumbers = map[uint16]bool{
+		1040: true, // too many connections
+		1053: true, // server shutdown in progress
+		1205: true, // lock wait timeout exceeded
+		1213: true, // deadlock found
+		1317: true, // query execution was interrupted
+		3572: true, // NOWAIT lock couldn't be acquired
+	}
+	permanentErrorNumbers = map[uint16]bool{
+		1048: true, // column cannot be null
+		1062: true, // duplicate entry
+		1264: true, // out of range value
+		1265: true, // data truncated
+		1366: true, // incorrect value
+		1406: true, // data too long
+		1451: true, // row is referenced by a foreign key
+		1452: true, // foreign key constraint fails
+		3819: true, // check constraint violated
+	}
+)
+
+// classifiedError wraps a database error with its kind, ErrRetryable,
+// ErrPermanent or the context error, keeping the original message
+type classifiedError struct {
+	err  error
+	kind error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// classify wraps err of an operation run with ctx with its kind. Errors that
+// can't be classified, like a cancelled context or a closed database, are
+// returned unchanged.
+func classify(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+		// Drivers may report an interrupted operation with their own error
+		err = &classifiedError{err: err, kind: ctxErr}
+	}
+	if kind := kindOf(err); kind != nil {
+		return &classifiedError{err: err, kind: kind}
+	}
+	return err
+}
+
+func kindOf(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch {
+		case retryableErrorNumbers[mysqlErr.Number]:
+			return ErrRetryable
+		case permanentErrorNumbers[mysqlErr.Number]:
+			return ErrPermanent
+		}
+		return nil
+	}
+
+	// Lost connections and the per-operation timeout
+	var netErr net.Error
+	switch {
+	case errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, mysql.ErrInvalidConn),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr):
+		return ErrRetryable
+	}
+	return nil
+}
+
+// IsRetryable reports whether err may go away when the operation is retried
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrRetryable)
+}
+
+// IsPermanent reports whether err will happen again with the same input
+func IsPermanent(err error) bool {
+	return errors.Is(err, ErrPermanent)
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+	"transaction-management-system/tracing"
+	"transaction-management-system/tracing/tracingtest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+		permanent bool
+	}{
+		{"deadlock", &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}, true, false},
+		{"lock wait timeout", &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}, true, false},
+		{"connection lost", mysql.ErrInvalidConn, true, false},
+		{"bad connection", driver.ErrBadConn, true, false},
+		{"timeout", context.DeadlineExceeded, true, false},
+		{"data truncated", &mysql.MySQLError{Number: 1265, Message: "Data truncated"}, false, true},
+		{"duplicate entry", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}, false, true},
+		{"unknown server error", &mysql.MySQLError{Number: 1146, Message: "Table doesn't exist"}, false, false},
+		{"cancelled", context.Canceled, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := fmt.Errorf("failed to insert transaction: %w", classify(t.Context(), tt.err))
+
+			require.Equal(t, tt.retryable, IsRetryable(err))
+			require.Equal(t, tt.permanent, IsPermanent(err))
+			// The cause stays reachable and its message unchanged
+			require.ErrorIs(t, err, tt.err)
+			require.Equal(t, "failed to insert transaction: "+tt.err.Error(), err.Error())
+		})
+	}
+
+	require.NoError(t, classify(t.Context(), nil))
+}
+
+// mockWriter returns a database with a mocked insert transaction statement
+func mockWriter(t *testing.T, timeouts Timeouts) (*Database, sqlmock.Sqlmock) {
+	t.Helper()
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	mock.ExpectPrepare("INSERT INTO")
+	stmt, err := conn.Prepare(fmt.Sprintf("INSERT INTO %s.transactions (user_id, transaction_type, amount, timestamp) VALUES (?, ?, ?, ?)", mockSchema))
+	require.NoError(t, err)
+
+	return &Database{conn: conn, insertTransactionPrepStmt: stmt, timeouts: timeouts}, mock
+}
+
+func TestInsertTransactionErrors(t *testing.T) {
+	t.Run("hung write times out as retryable", func(t *testing.T) {
+		db, mock := mockWriter(t, Timeouts{Write: 50 * time.Millisecond})
+		mock.ExpectExec("INSERT INTO").WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		start := time.Now()
+		_, err := db.InsertTransaction(t.Context(), 1, "bet", 1, time.Now())
+		require.Error(t, err)
+		require.Less(t, time.Since(start), time.Second)
+		require.True(t, IsRetryable(err), err.Error())
+	})
+
+	t.Run("cancelled context interrupts the write", func(t *testing.T) {
+		db, mock := mockWriter(t, Timeouts{})
+		mock.ExpectExec("INSERT INTO").WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		ctx, cancel := context.WithCancel(t.Context())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		_, err := db.InsertTransaction(ctx, 1, "bet", 1, time.Now())
+		require.ErrorIs(t, err, context.Canceled)
+		require.False(t, IsRetryable(err))
+	})
+
+	t.Run("deadlock is retryable", func(t *testing.T) {
+		db, mock := mockWriter(t, Timeouts{})
+		mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1213, Message: "Deadlock found"})
+
+		_, err := db.InsertTransaction(t.Context(), 1, "bet", 1, time.Now())
+		require.True(t, IsRetryable(err))
+		require.False(t, IsPermanent(err))
+	})
+
+	t.Run("constraint violation is permanent", func(t *testing.T) {
+		db, mock := mockWriter(t, Timeouts{})
+		mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row"})
+
+		_, err := db.InsertTransaction(t.Context(), 1, "bet", 1, time.Now())
+		require.True(t, IsPermanent(err))
+		var mysqlErr *mysql.MySQLError
+		require.True(t, errors.As(err, &mysqlErr))
+		require.Equal(t, uint16(1452), mysqlErr.Number)
+	})
+}
+
+func TestInsertTransactionSpan(t *testing.T) {
+	exporter, restore := tracingtest.Setup()
+	defer restore()
+
+	db, mock := mockWriter(t, Timeouts{})
+	mock.ExpectExec("INSERT INTO").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO").WillReturnError(&mysql.MySQLError{Number: 1213, Message: "Deadlock found"})
+
+	ctx, parent := tracing.Start(t.Context(), "consume")
+	_, err := db.InsertTransaction(ctx, 1, "bet", 1, time.Now())
+	require.NoError(t, err)
+	_, err = db.InsertTransaction(ctx, 1, "bet", 1, time.Now())
+	require.Error(t, err)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 3)
+	for _, span := range spans[:2] {
+		require.Equal(t, "insert transaction", span.Name)
+		require.Equal(t, trace.SpanKindClient, span.SpanKind)
+		require.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+	}
+	require.Equal(t, codes.Unset, spans[0].Status.Code)
+	require.Equal(t, codes.Error, spans[1].Status.Code)
+}
+
+func TestTimeoutsWithDefaults(t *testing.T) {
+	require.Equal(t, Timeouts{Read: DefaultReadTimeout, Write: DefaultWriteTimeout}, Timeouts{}.withDefaults())
+	require.Equal(t, Timeouts{Read: time.Second, Write: DefaultWriteTimeout}, Timeouts{Read: time.Second}.withDefaults())
+}
@@ -0,0 +1,491 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// PlayerLimit is a player's loss or wager limit over a rolling period
+type PlayerLimit struct {
+	UserId int
+	Kind   string // "loss" or "wager"
+	Period string // "daily", "weekly" or "monthly"
+	// Amount is the limit in force, nil for none
+	Amount *float64
+	// PendingFrom is when a pending increase or removal takes effect, nil without one.
+	// PendingAmount is the limit it sets, nil for a removal.
+	PendingAmount *float64
+	PendingFrom   *time.Time
+}
+
+// LimitChange records a requested change of a player's limit
+type LimitChange struct {
+	Id        int64
+	UserId    int
+	Kind      string
+	Period    string
+	OldAmount *float64 // nil when there was no limit
+	NewAmount *float64 // nil for a removal
+	// RequestedBy is the subject of the credential that asked for the change
+	RequestedBy string
+	RequestedAt time.Time
+	EffectiveAt time.Time
+}
+
+// SelfExclusion is a period in which the player can't bet
+type SelfExclusion struct {
+	Id          int64
+	UserId      int
+	StartsAt    time.Time
+	EndsAt      time.Time
+	Reason      string
+	RequestedBy string
+}
+
+// Usage sums a player's bets and wins over a period
+type Usage struct {
+	Wagered float64
+	Won     float64
+}
+
+// LimitViolation is a bet that broke a limit or a self-exclusion
+type LimitViolation struct {
+	Id     int64
+	UserId int
+	// TransactionId is the stored bet, nil for a rejected one
+	TransactionId *int64
+	Amount        float64
+	Reason        string // "self_exclusion", "loss_limit" or "wager_limit"
+	Period        string // period of the limit, empty for a self-exclusion
+	Detail        string
+	Action        string // "rejected" or "flagged"
+	CreatedAt     time.Time
+}
+
+// prepareLimits prepares the statements of the responsible gambling tables
+func (db *Database) prepareLimits(schema string) error {
+	stmts := []struct {
+		stmt  **sql.Stmt
+		name  string
+		query string
+	}{
+		{&db.getPlayerLimitsPrepStmt, "get player limits", `
+			SELECT user_id, kind, period, amount, pending_amount, pending_from
+			FROM %s.player_limits
+			WHERE user_id = ?
+			ORDER BY kind, period
+		`},
+		{&db.lockPlayerLimitPrepStmt, "lock player limit", `
+			SELECT user_id, kind, period, amount, pending_amount, pending_from
+			FROM %s.player_limits
+			WHERE user_id = ? AND kind = ? AND period = ?
+			FOR UPDATE
+		`},
+		{&db.lockPlayerLimitsPrepStmt, "lock player limits", `
+			SELECT user_id
+			FROM %s.player_limits
+			WHERE user_id = ?
+			FOR UPDATE
+		`},
+		{&db.upsertPlayerLimitPrepStmt, "upsert player limit", `
+			INSERT INTO %s.player_limits (user_id, kind, period, amount, pending_amount, pending_from)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE amount = VALUES(amount), pending_amount = VALUES(pending_amount), pending_from = VALUES(pending_from)
+		`},
+		{&db.insertLimitChangePrepStmt, "insert limit change", `
+			INSERT INTO %s.limit_changes (user_id, kind, period, old_amount, new_amount, requested_by, requested_at, effective_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`},
+		{&db.getLimitChangesPrepStmt, "get limit changes", `
+			SELECT id, user_id, kind, period, old_amount, new_amount, requested_by, requested_at, effective_at
+			FROM %s.limit_changes
+			WHERE user_id = ?
+			ORDER BY id DESC
+			LIMIT ?
+		`},
+		{&db.insertSelfExclusionPrepStmt, "insert self exclusion", `
+			INSERT INTO %s.self_exclusions (user_id, starts_at, ends_at, reason, requested_by)
+			VALUES (?, ?, ?, ?, ?)
+		`},
+		{&db.getSelfExclusionsPrepStmt, "get self exclusions", `
+			SELECT id, user_id, starts_at, ends_at, reason, requested_by
+			FROM %s.self_exclusions
+			WHERE user_id = ? AND ends_at > ?
+			ORDER BY ends_at DESC
+		`},
+		{&db.getUsagePrepStmt, "get usage", `
+			SELECT COALESCE(SUM(CASE WHEN transaction_type = 'bet' THEN amount END), 0),
+				COALESCE(SUM(CASE WHEN transaction_type = 'win' THEN amount END), 0)
+			FROM %s.transactions
+			WHERE user_id = ? AND created_at >= CURRENT_TIMESTAMP - INTERVAL ? SECOND
+		`},
+		{&db.insertLimitViolationPrepStmt, "insert limit violation", `
+			INSERT INTO %s.limit_violations (user_id, transaction_id, amount, reason, period, detail, action)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`},
+		{&db.getLimitViolationsPrepStmt, "get limit violations", `
+			SELECT id, user_id, transaction_id, amount, reason, period, detail, action, created_at
+			FROM %s.limit_violations
+			WHERE user_id = ?
+			ORDER BY id DESC
+			LIMIT ?
+		`},
+	}
+	for _, s := range stmts {
+		stmt, err := db.conn.Prepare(fmt.Sprintf(s.query, schema))
+		if err != nil {
+			return fmt.Errorf("failed to prepare %s statement: %w", s.name, err)
+		}
+		*s.stmt = stmt
+	}
+	return nil
+}
+
+// limitStmts returns the statements of prepareLimits for Close
+func (db *Database) limitStmts() []*sql.Stmt {
+	return []*sql.Stmt{
+		db.getPlayerLimitsPrepStmt,
+		db.lockPlayerLimitPrepStmt,
+		db.lockPlayerLimitsPrepStmt,
+		db.upsertPlayerLimitPrepStmt,
+		db.insertLimitChangePrepStmt,
+		db.getLimitChangesPrepStmt,
+		db.insertSelfExclusionPrepStmt,
+		db.getSelfExclusionsPrepStmt,
+		db.getUsagePrepStmt,
+		db.insertLimitViolationPrepStmt,
+		db.getLimitViolationsPrepStmt,
+	}
+}
+
+// GetPlayerLimits returns the player's limits as stored, pending changes that are due included
+func (db *Database) GetPlayerLimits(ctx context.Context, userId int) ([]PlayerLimit, error) {
+	ctx, cancel := db.readContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "get player limits")
+	limits, err := queryPlayerLimits(ctx, db.getPlayerLimitsPrepStmt, userId)
+	done(err)
+	return limits, err
+}
+
+func queryPlayerLimits(ctx context.Context, stmt *sql.Stmt, userId int) ([]PlayerLimit, error) {
+	rows, err := stmt.QueryContext(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query player limits: %w", classify(ctx, err))
+	}
+	defer rows.Close()
+
+	var limits []PlayerLimit
+	for rows.Next() {
+		l, err := scanPlayerLimit(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan player limit: %w", err)
+		}
+		limits = append(limits, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query player limits: %w", classify(ctx, err))
+	}
+	return limits, nil
+}
+
+// UpdatePlayerLimit changes a player's limit in one SQL transaction. update gets the current
+// limit, locked against concurrent changes and with nil amounts when the player has none,
+// and returns the new limit with the change to record. Errors of update are returned as is.
+func (db *Database) UpdatePlayerLimit(ctx context.Context, userId int, kind, period string, update func(current PlayerLimit) (PlayerLimit, LimitChange, error)) (limit PlayerLimit, err error) {
+	ctx, cancel := db.writeContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "update player limit")
+	defer func() { done(err) }()
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return PlayerLimit{}, fmt.Errorf("failed to begin transaction: %w", classify(ctx, err))
+	}
+	defer tx.Rollback()
+
+	current, err := scanPlayerLimit(tx.StmtContext(ctx, db.lockPlayerLimitPrepStmt).QueryRowContext(ctx, userId, kind, period))
+	if err == sql.ErrNoRows {
+		current, err = PlayerLimit{UserId: userId, Kind: kind, Period: period}, nil
+	}
+	if err != nil {
+		return PlayerLimit{}, fmt.Errorf("failed to lock player limit: %w", classify(ctx, err))
+	}
+
+	limit, change, err := update(current)
+	if err != nil {
+		return PlayerLimit{}, err
+	}
+
+	_, err = tx.StmtContext(ctx, db.upsertPlayerLimitPrepStmt).ExecContext(ctx,
+		userId, kind, period, nullFloat(limit.Amount), nullFloat(limit.PendingAmount), nullTime(limit.PendingFrom))
+	if err != nil {
+		return PlayerLimit{}, fmt.Errorf("failed to store player limit: %w", classify(ctx, err))
+	}
+	_, err = tx.StmtContext(ctx, db.insertLimitChangePrepStmt).ExecContext(ctx,
+		userId, kind, period, nullFloat(change.OldAmount), nullFloat(change.NewAmount), change.RequestedBy, change.RequestedAt, change.EffectiveAt)
+	if err != nil {
+		return PlayerLimit{}, fmt.Errorf("failed to insert limit change: %w", classify(ctx, err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return PlayerLimit{}, fmt.Errorf("failed to commit transaction: %w", classify(ctx, err))
+	}
+	return limit, nil
+}
+
+// GetLimitChanges returns the player's latest limit changes, newest first
+func (db *Database) GetLimitChanges(ctx context.Context, userId int, limit int) ([]LimitChange, error) {
+	ctx, cancel := db.readContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "get limit changes")
+	rows, err := db.getLimitChangesPrepStmt.QueryContext(ctx, userId, limit)
+	done(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query limit changes: %w", classify(ctx, err))
+	}
+	defer rows.Close()
+
+	var changes []LimitChange
+	for rows.Next() {
+		var c LimitChange
+		var oldAmount, newAmount sql.NullFloat64
+		if err := rows.Scan(&c.Id, &c.UserId, &c.Kind, &c.Period, &oldAmount, &newAmount, &c.RequestedBy, &c.RequestedAt, &c.EffectiveAt); err != nil {
+			return nil, fmt.Errorf("failed to scan limit change: %w", err)
+		}
+		c.OldAmount, c.NewAmount = floatPtr(oldAmount), floatPtr(newAmount)
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query limit changes: %w", classify(ctx, err))
+	}
+	return changes, nil
+}
+
+// InsertSelfExclusion stores a self-exclusion period and returns its id
+func (db *Database) InsertSelfExclusion(ctx context.Context, e SelfExclusion) (int64, error) {
+	var reason interface{}
+	if e.Reason != "" {
+		reason = e.Reason
+	}
+
+	ctx, cancel := db.writeContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "insert self exclusion")
+	result, err := db.insertSelfExclusionPrepStmt.ExecContext(ctx, e.UserId, e.StartsAt, e.EndsAt, reason, e.RequestedBy)
+	done(err)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert self exclusion: %w", classify(ctx, err))
+	}
+	return result.LastInsertId()
+}
+
+// GetSelfExclusions returns the player's self-exclusions ending after the given time, latest end first
+func (db *Database) GetSelfExclusions(ctx context.Context, userId int, endsAfter time.Time) ([]SelfExclusion, error) {
+	ctx, cancel := db.readContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "get self exclusions")
+	exclusions, err := querySelfExclusions(ctx, db.getSelfExclusionsPrepStmt, userId, endsAfter)
+	done(err)
+	return exclusions, err
+}
+
+func querySelfExclusions(ctx context.Context, stmt *sql.Stmt, userId int, endsAfter time.Time) ([]SelfExclusion, error) {
+	rows, err := stmt.QueryContext(ctx, userId, endsAfter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query self exclusions: %w", classify(ctx, err))
+	}
+	defer rows.Close()
+
+	var exclusions []SelfExclusion
+	for rows.Next() {
+		var e SelfExclusion
+		var reason sql.NullString
+		if err := rows.Scan(&e.Id, &e.UserId, &e.StartsAt, &e.EndsAt, &reason, &e.RequestedBy); err != nil {
+			return nil, fmt.Errorf("failed to scan self exclusion: %w", err)
+		}
+		e.Reason = reason.String
+		exclusions = append(exclusions, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query self exclusions: %w", classify(ctx, err))
+	}
+	return exclusions, nil
+}
+
+// GetUsage sums the player's bets and wins stored within the window ending now. Windows are
+// measured in storage time on the database clock, whatever timestamp the transactions carry.
+// It always reads the primary, limits must count the bets a replica hasn't applied yet.
+func (db *Database) GetUsage(ctx context.Context, userId int, window time.Duration) (Usage, error) {
+	ctx, cancel := db.readContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "get usage")
+	u, err := queryUsage(ctx, db.getUsagePrepStmt, userId, window)
+	done(err)
+	return u, err
+}
+
+func queryUsage(ctx context.Context, stmt *sql.Stmt, userId int, window time.Duration) (Usage, error) {
+	var u Usage
+	if err := stmt.QueryRowContext(ctx, userId, int64(window/time.Second)).Scan(&u.Wagered, &u.Won); err != nil {
+		return Usage{}, fmt.Errorf("failed to query usage: %w", classify(ctx, err))
+	}
+	return u, nil
+}
+
+// LockedPlayers reads the limits, self-exclusions and usage of the players of CreateTransactions
+// in its SQL transaction, their limits locked until the transactions are stored
+type LockedPlayers struct {
+	db *Database
+	tx *sql.Tx
+}
+
+// lockPlayers locks the limits of the transactions' players, in ascending user id order
+// so concurrent batches can't deadlock. Players without limits have nothing to lock.
+func (db *Database) lockPlayers(ctx context.Context, tx *sql.Tx, transactions []NewTransaction) (*LockedPlayers, error) {
+	userIds := make([]int, 0, len(transactions))
+	for _, t := range transactions {
+		userIds = append(userIds, t.UserId)
+	}
+	slices.Sort(userIds)
+
+	lock := tx.StmtContext(ctx, db.lockPlayerLimitsPrepStmt)
+	for _, userId := range slices.Compact(userIds) {
+		rows, err := lock.QueryContext(ctx, userId)
+		if err == nil {
+			err = rows.Close()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock player limits: %w", classify(ctx, err))
+		}
+	}
+	return &LockedPlayers{db: db, tx: tx}, nil
+}
+
+// GetPlayerLimits returns the player's limits like Database.GetPlayerLimits
+func (p *LockedPlayers) GetPlayerLimits(ctx context.Context, userId int) ([]PlayerLimit, error) {
+	return queryPlayerLimits(ctx, p.tx.StmtContext(ctx, p.db.getPlayerLimitsPrepStmt), userId)
+}
+
+// GetSelfExclusions returns the player's self-exclusions like Database.GetSelfExclusions
+func (p *LockedPlayers) GetSelfExclusions(ctx context.Context, userId int, endsAfter time.Time) ([]SelfExclusion, error) {
+	return querySelfExclusions(ctx, p.tx.StmtContext(ctx, p.db.getSelfExclusionsPrepStmt), userId, endsAfter)
+}
+
+// GetUsage sums the player's bets and wins like Database.GetUsage, counting those committed
+// while the limits were locked by another submission
+func (p *LockedPlayers) GetUsage(ctx context.Context, userId int, window time.Duration) (Usage, error) {
+	return queryUsage(ctx, p.tx.StmtContext(ctx, p.db.getUsagePrepStmt), userId, window)
+}
+
+// InsertLimitViolations stores the violations, all of them or none
+func (db *Database) InsertLimitViolations(ctx context.Context, violations []LimitViolation) (err error) {
+	ctx, cancel := db.writeContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "insert limit violations")
+	defer func() { done(err) }()
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", classify(ctx, err))
+	}
+	defer tx.Rollback()
+
+	insert := tx.StmtContext(ctx, db.insertLimitViolationPrepStmt)
+	for _, v := range violations {
+		var transactionId, period interface{}
+		if v.TransactionId != nil {
+			transactionId = *v.TransactionId
+		}
+		if v.Period != "" {
+			period = v.Period
+		}
+		if _, err := insert.ExecContext(ctx, v.UserId, transactionId, v.Amount, v.Reason, period, v.Detail, v.Action); err != nil {
+			return fmt.Errorf("failed to insert limit violation: %w", classify(ctx, err))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", classify(ctx, err))
+	}
+	return nil
+}
+
+// GetLimitViolations returns the player's latest limit violations, newest first
+func (db *Database) GetLimitViolations(ctx context.Context, userId int, limit int) ([]LimitViolation, error) {
+	ctx, cancel := db.readContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "get limit violations")
+	rows, err := db.getLimitViolationsPrepStmt.QueryContext(ctx, userId, limit)
+	done(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query limit violations: %w", classify(ctx, err))
+	}
+	defer rows.Close()
+
+	var violations []LimitViolation
+	for rows.Next() {
+		var v LimitViolation
+		var transactionId sql.NullInt64
+		var period sql.NullString
+		if err := rows.Scan(&v.Id, &v.UserId, &transactionId, &v.Amount, &v.Reason, &period, &v.Detail, &v.Action, &v.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan limit violation: %w", err)
+		}
+		if transactionId.Valid {
+			v.TransactionId = &transactionId.Int64
+		}
+		v.Period = period.String
+		violations = append(violations, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query limit violations: %w", classify(ctx, err))
+	}
+	return violations, nil
+}
+
+// scanPlayerLimit reads a player limit from a row of *sql.Rows or *sql.Row
+func scanPlayerLimit(row interface{ Scan(dest ...any) error }) (PlayerLimit, error) {
+	var l PlayerLimit
+	var amount, pendingAmount sql.NullFloat64
+	var pendingFrom sql.NullTime
+	if err := row.Scan(&l.UserId, &l.Kind, &l.Period, &amount, &pendingAmount, &pendingFrom); err != nil {
+		return PlayerLimit{}, err
+	}
+	l.Amount, l.PendingAmount = floatPtr(amount), floatPtr(pendingAmount)
+	if pendingFrom.Valid {
+		l.PendingFrom = &pendingFrom.Time
+	}
+	return l, nil
+}
+
+func floatPtr(f sql.NullFloat64) *float64 {
+	if !f.Valid {
+		return nil
+	}
+	return &f.Float64
+}
+
+func nullFloat(f *float64) interface{} {
+	if f == nil {
+		return nil
+	}
+	return *f
+}
+
+func nullTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return *t
+}
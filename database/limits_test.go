@@ -0,0 +1,147 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	test "transaction-management-system/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestPlayerLimits tests storing limits, their history and self-exclusions
+func TestPlayerLimits(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	userId := test.LIMITS_USER_ID
+	now := time.Now().UTC().Truncate(time.Second)
+
+	t.Run("successful update player limit", func(t *testing.T) {
+		amount := 100.0
+		pendingFrom := now.Add(time.Hour)
+		limit, err := db.UpdatePlayerLimit(t.Context(), userId, "loss", "daily", func(current PlayerLimit) (PlayerLimit, LimitChange, error) {
+			require.Equal(t, userId, current.UserId)
+			current.Amount, current.PendingAmount, current.PendingFrom = &amount, nil, &pendingFrom
+			return current, LimitChange{RequestedBy: "tester", RequestedAt: now, EffectiveAt: now, NewAmount: &amount}, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, amount, *limit.Amount)
+
+		limits, err := db.GetPlayerLimits(t.Context(), userId)
+		require.NoError(t, err)
+		require.NotEmpty(t, limits)
+		require.Equal(t, amount, *limits[0].Amount)
+		require.Nil(t, limits[0].PendingAmount)
+		require.True(t, pendingFrom.Equal(*limits[0].PendingFrom))
+
+		changes, err := db.GetLimitChanges(t.Context(), userId, 1)
+		require.NoError(t, err)
+		require.Len(t, changes, 1)
+		require.Equal(t, "tester", changes[0].RequestedBy)
+		require.Equal(t, amount, *changes[0].NewAmount)
+	})
+
+	t.Run("failed update player limit keeps the limit", func(t *testing.T) {
+		before, err := db.GetLimitChanges(t.Context(), userId, 1)
+		require.NoError(t, err)
+
+		refused := errors.New("refused")
+		_, err = db.UpdatePlayerLimit(t.Context(), userId, "loss", "daily", func(current PlayerLimit) (PlayerLimit, LimitChange, error) {
+			return PlayerLimit{}, LimitChange{}, refused
+		})
+		require.ErrorIs(t, err, refused)
+
+		after, err := db.GetLimitChanges(t.Context(), userId, 1)
+		require.NoError(t, err)
+		require.Equal(t, before, after)
+	})
+
+	t.Run("successful self exclusion", func(t *testing.T) {
+		id, err := db.InsertSelfExclusion(t.Context(), SelfExclusion{UserId: userId, StartsAt: now, EndsAt: now.Add(time.Hour), RequestedBy: "tester"})
+		require.NoError(t, err)
+
+		exclusions, err := db.GetSelfExclusions(t.Context(), userId, now)
+		require.NoError(t, err)
+		var found bool
+		for _, e := range exclusions {
+			found = found || e.Id == id
+		}
+		require.True(t, found)
+
+		exclusions, err = db.GetSelfExclusions(t.Context(), userId, now.Add(2*time.Hour))
+		require.NoError(t, err)
+		for _, e := range exclusions {
+			require.NotEqual(t, id, e.Id)
+		}
+	})
+
+	t.Run("successful usage", func(t *testing.T) {
+		before, err := db.GetUsage(t.Context(), userId, time.Hour)
+		require.NoError(t, err)
+
+		// Counted when stored, however backdated
+		_, err = db.InsertTransaction(t.Context(), userId, "bet", 2.5, time.Now().Add(-48*time.Hour))
+		require.NoError(t, err)
+
+		after, err := db.GetUsage(t.Context(), userId, time.Hour)
+		require.NoError(t, err)
+		require.InDelta(t, before.Wagered+2.5, after.Wagered, 0.001)
+		require.Equal(t, before.Won, after.Won)
+	})
+
+	t.Run("failed check stores none", func(t *testing.T) {
+		before, err := db.GetUsage(t.Context(), userId, time.Hour)
+		require.NoError(t, err)
+
+		refused := errors.New("refused")
+		_, err = db.CreateTransactions(t.Context(), []NewTransaction{{UserId: userId, TransactionType: "bet", Amount: 3, Timestamp: time.Now()}},
+			func(ctx context.Context, players *LockedPlayers) error {
+				limits, err := players.GetPlayerLimits(ctx, userId)
+				require.NoError(t, err)
+				require.NotEmpty(t, limits)
+				usage, err := players.GetUsage(ctx, userId, time.Hour)
+				require.NoError(t, err)
+				require.Equal(t, before, usage)
+				return refused
+			}, func(i int, id int64) ([]byte, error) {
+				return []byte("{}"), nil
+			})
+		require.ErrorIs(t, err, refused)
+
+		after, err := db.GetUsage(t.Context(), userId, time.Hour)
+		require.NoError(t, err)
+		require.Equal(t, before, after)
+	})
+
+	t.Run("successful limit violations", func(t *testing.T) {
+		transactionId := int64(1)
+		err := db.InsertLimitViolations(t.Context(), []LimitViolation{
+			{UserId: userId, Amount: 5, Reason: "self_exclusion", Detail: "self-excluded", Action: "rejected"},
+			{UserId: userId, TransactionId: &transactionId, Amount: 5, Reason: "loss_limit", Period: "daily", Detail: "daily loss limit", Action: "flagged"},
+		})
+		require.NoError(t, err)
+
+		violations, err := db.GetLimitViolations(t.Context(), userId, 2)
+		require.NoError(t, err)
+		require.Len(t, violations, 2)
+		require.Equal(t, "flagged", violations[0].Action)
+		require.Equal(t, transactionId, *violations[0].TransactionId)
+		require.Equal(t, "daily", violations[0].Period)
+		require.Nil(t, violations[1].TransactionId)
+		require.Empty(t, violations[1].Period)
+	})
+
+	t.Run("failed invalid violation stores none", func(t *testing.T) {
+		err := db.InsertLimitViolations(t.Context(), []LimitViolation{
+			{UserId: userId, Amount: 5, Reason: "loss_limit", Period: "daily", Detail: "kept", Action: "rejected"},
+			{UserId: userId, Amount: 5, Reason: "deposit_limit", Detail: "invalid", Action: "rejected"},
+		})
+		require.Error(t, err)
+
+		violations, err := db.GetLimitViolations(t.Context(), userId, 1)
+		require.NoError(t, err)
+		require.NotEqual(t, "kept", violations[0].Detail)
+	})
+}
@@ -0,0 +1,16 @@
+-- Add the fraud alerts table to a database created before it existed
+USE casino;
+
+-- Create the fraud alerts table: transactions matching a detection rule, kept until acknowledged
+CREATE TABLE IF NOT EXISTS alerts (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    transaction_id INT NOT NULL,
+    rule VARCHAR(32) NOT NULL,
+    detail VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    acknowledged_at TIMESTAMP NULL,
+    acknowledged_by VARCHAR(255) NULL,
+    INDEX (acknowledged_at, id),
+    INDEX (user_id, id)
+);
@@ -9,6 +9,105 @@ CREATE TABLE IF NOT EXISTS transactions (
     transaction_type ENUM('bet', 'win') NOT NULL,
     amount DECIMAL(15, 2) NOT NULL,
     timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     INDEX (user_id),
-    INDEX (timestamp)
-);
\ No newline at end of file
+    INDEX (timestamp),
+    INDEX (user_id, created_at),
+    INDEX (created_at)
+);
+
+-- Create the api keys table (only SHA-256 hashes of the keys are stored)
+CREATE TABLE IF NOT EXISTS api_keys (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    role ENUM('reader', 'writer', 'admin') NOT NULL,
+    user_ids VARCHAR(1024) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP NULL
+);
+
+
+-- Create the outbox table holding events written with API-originated transactions
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    correlation_id VARCHAR(64) NULL,
+    trace_parent VARCHAR(55) NULL,
+    payload JSON NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP NULL,
+    INDEX (sent_at, id)
+);
+
+-- Create the receipts of relayed transactions the consumer handled, so events the relay
+-- publishes again after a crash are skipped
+CREATE TABLE IF NOT EXISTS outbox_receipts (
+    transaction_id BIGINT PRIMARY KEY,
+    received_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (received_at)
+);
+
+-- Create the responsible gambling tables: a player's current loss and wager limits with a pending
+-- increase or removal, the audit trail of every change, self-exclusion periods, and the bets
+-- rejected or flagged for breaking them
+CREATE TABLE IF NOT EXISTS player_limits (
+    user_id INT NOT NULL,
+    kind ENUM('loss', 'wager') NOT NULL,
+    period ENUM('daily', 'weekly', 'monthly') NOT NULL,
+    amount DECIMAL(15, 2) NULL,
+    pending_amount DECIMAL(15, 2) NULL,
+    pending_from TIMESTAMP NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, kind, period)
+);
+
+CREATE TABLE IF NOT EXISTS limit_changes (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    kind ENUM('loss', 'wager') NOT NULL,
+    period ENUM('daily', 'weekly', 'monthly') NOT NULL,
+    old_amount DECIMAL(15, 2) NULL,
+    new_amount DECIMAL(15, 2) NULL,
+    requested_by VARCHAR(255) NOT NULL,
+    requested_at TIMESTAMP NOT NULL,
+    effective_at TIMESTAMP NOT NULL,
+    INDEX (user_id, id)
+);
+
+CREATE TABLE IF NOT EXISTS self_exclusions (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    starts_at DATETIME NOT NULL,
+    ends_at DATETIME NOT NULL,
+    reason VARCHAR(255) NULL,
+    requested_by VARCHAR(255) NOT NULL,
+    INDEX (user_id, ends_at)
+);
+
+CREATE TABLE IF NOT EXISTS limit_violations (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    transaction_id INT NULL,
+    amount DECIMAL(15, 2) NOT NULL,
+    reason ENUM('self_exclusion', 'loss_limit', 'wager_limit') NOT NULL,
+    period ENUM('daily', 'weekly', 'monthly') NULL,
+    detail VARCHAR(255) NOT NULL,
+    action ENUM('rejected', 'flagged') NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (user_id, id)
+);
+
+-- Create the fraud alerts table: transactions matching a detection rule, kept until acknowledged
+CREATE TABLE IF NOT EXISTS alerts (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    transaction_id INT NOT NULL,
+    rule VARCHAR(32) NOT NULL,
+    detail VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    acknowledged_at TIMESTAMP NULL,
+    acknowledged_by VARCHAR(255) NULL,
+    INDEX (acknowledged_at, id),
+    INDEX (user_id, id)
+);
@@ -0,0 +1,52 @@
+-- Add the responsible gambling tables to a database created before they existed
+USE casino;
+
+-- Create the responsible gambling tables: a player's current loss and wager limits with a pending
+-- increase or removal, the audit trail of every change, self-exclusion periods, and the bets
+-- rejected or flagged for breaking them
+CREATE TABLE IF NOT EXISTS player_limits (
+    user_id INT NOT NULL,
+    kind ENUM('loss', 'wager') NOT NULL,
+    period ENUM('daily', 'weekly', 'monthly') NOT NULL,
+    amount DECIMAL(15, 2) NULL,
+    pending_amount DECIMAL(15, 2) NULL,
+    pending_from TIMESTAMP NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, kind, period)
+);
+
+CREATE TABLE IF NOT EXISTS limit_changes (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    kind ENUM('loss', 'wager') NOT NULL,
+    period ENUM('daily', 'weekly', 'monthly') NOT NULL,
+    old_amount DECIMAL(15, 2) NULL,
+    new_amount DECIMAL(15, 2) NULL,
+    requested_by VARCHAR(255) NOT NULL,
+    requested_at TIMESTAMP NOT NULL,
+    effective_at TIMESTAMP NOT NULL,
+    INDEX (user_id, id)
+);
+
+CREATE TABLE IF NOT EXISTS self_exclusions (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    starts_at DATETIME NOT NULL,
+    ends_at DATETIME NOT NULL,
+    reason VARCHAR(255) NULL,
+    requested_by VARCHAR(255) NOT NULL,
+    INDEX (user_id, ends_at)
+);
+
+CREATE TABLE IF NOT EXISTS limit_violations (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    transaction_id INT NULL,
+    amount DECIMAL(15, 2) NOT NULL,
+    reason ENUM('self_exclusion', 'loss_limit', 'wager_limit') NOT NULL,
+    period ENUM('daily', 'weekly', 'monthly') NULL,
+    detail VARCHAR(255) NOT NULL,
+    action ENUM('rejected', 'flagged') NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (user_id, id)
+);
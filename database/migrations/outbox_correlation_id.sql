@@ -0,0 +1,3 @@
+-- Add the correlation id to an outbox table created before it existed
+USE casino;
+ALTER TABLE outbox ADD COLUMN correlation_id VARCHAR(64) NULL AFTER user_id;
@@ -0,0 +1,10 @@
+-- Add the receipts of relayed transactions to a database created before they existed
+USE casino;
+
+-- Create the receipts of relayed transactions the consumer handled, so events the relay
+-- publishes again after a crash are skipped
+CREATE TABLE IF NOT EXISTS outbox_receipts (
+    transaction_id BIGINT PRIMARY KEY,
+    received_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (received_at)
+);
@@ -0,0 +1,3 @@
+-- Add the trace context to an outbox table created before it existed
+USE casino;
+ALTER TABLE outbox ADD COLUMN trace_parent VARCHAR(55) NULL AFTER correlation_id;
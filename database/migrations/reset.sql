@@ -1,2 +1,6 @@
 USE casino;
-TRUNCATE TABLE transactions;
\ No newline at end of file
+TRUNCATE TABLE transactions;
+TRUNCATE TABLE outbox;
+TRUNCATE TABLE outbox_receipts;
+TRUNCATE TABLE limit_violations;
+TRUNCATE TABLE alerts;
\ No newline at end of file
@@ -0,0 +1,4 @@
+-- Add the storage time to a transactions table created before it existed, set to the transaction time for the stored ones
+USE casino;
+ALTER TABLE transactions ADD COLUMN created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP AFTER timestamp, ADD INDEX (user_id, created_at), ADD INDEX (created_at);
+UPDATE transactions SET created_at = timestamp;
@@ -0,0 +1,229 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Interval between replica health checks
+	replicaCheckInterval = 5 * time.Second
+	// Timeout of a single replica health check
+	replicaCheckTimeout = 2 * time.Second
+)
+
+type primaryKey struct{}
+
+// WithPrimary marks reads made with ctx as needing the primary, for
+// read-your-writes paths where replication lag would lose rows
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func primaryOnly(ctx context.Context) bool {
+	v, _ := ctx.Value(primaryKey{}).(bool)
+	return v
+}
+
+// replica is a read-only pool serving queries that tolerate replication lag.
+// Its statements are prepared once it is first reachable.
+type replica struct {
+	index  int // position in Config.Replicas, logged instead of the DSN and its credentials
+	schema string
+	conn   *sql.DB
+
+	mu                      sync.Mutex
+	getTransactionsPrepStmt *sql.Stmt
+	getBalancePrepStmt      *sql.Stmt
+
+	healthy atomic.Bool
+}
+
+// prepare prepares the replica's statements if they aren't yet
+func (r *replica) prepare(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.getTransactionsPrepStmt != nil {
+		return nil
+	}
+
+	getTransactions, err := r.conn.PrepareContext(ctx, fmt.Sprintf(getTransactionsQuery, r.schema))
+	if err != nil {
+		return fmt.Errorf("failed to prepare get transactions statement: %w", err)
+	}
+	getBalance, err := r.conn.PrepareContext(ctx, fmt.Sprintf(getBalanceQuery, r.schema))
+	if err != nil {
+		getTransactions.Close()
+		return fmt.Errorf("failed to prepare get balance statement: %w", err)
+	}
+	r.getTransactionsPrepStmt, r.getBalancePrepStmt = getTransactions, getBalance
+	return nil
+}
+
+// check pings the replica and prepares its statements, updating its health
+func (r *replica) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, replicaCheckTimeout)
+	defer cancel()
+
+	err := r.conn.PingContext(ctx)
+	if err == nil {
+		err = r.prepare(ctx)
+	}
+	healthy := err == nil
+	if r.healthy.Swap(healthy) != healthy {
+		if healthy {
+			slog.Info("Replica is healthy", "replica", r.index)
+		} else {
+			slog.Warn("Replica is unhealthy", "replica", r.index, "error", err)
+		}
+	}
+}
+
+// markUnhealthy takes the replica out of rotation until the next successful check
+func (r *replica) markUnhealthy(ctx context.Context, err error) {
+	if r.healthy.Swap(false) {
+		slog.WarnContext(ctx, "Replica failed, reading from the primary", "replica", r.index, "error", err)
+	}
+}
+
+func (r *replica) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, stmt := range []*sql.Stmt{r.getTransactionsPrepStmt, r.getBalancePrepStmt} {
+		if stmt == nil {
+			continue
+		}
+		if err := stmt.Close(); err != nil {
+			return err
+		}
+	}
+	return r.conn.Close()
+}
+
+// openReplicas opens a pool per replica DSN. Unreachable replicas don't fail
+// the startup, they join the rotation once a health check succeeds.
+func (db *Database) openReplicas(dsns []string, schema string) error {
+	for i, dsn := range dsns {
+		connector, err := newConnector(dsn)
+		if err != nil {
+			return fmt.Errorf("failed to open replica %d: %w", i, err)
+		}
+		conn := sql.OpenDB(connector)
+		conn.SetMaxOpenConns(25)
+		conn.SetMaxIdleConns(25)
+		conn.SetConnMaxLifetime(5 * time.Minute)
+
+		db.replicas = append(db.replicas, &replica{index: i, schema: schema, conn: conn})
+	}
+	if len(db.replicas) == 0 {
+		return nil
+	}
+
+	db.checkReplicas(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	db.stopHealthChecks = cancel
+	db.healthChecksDone = make(chan struct{})
+	go db.runHealthChecks(ctx)
+	return nil
+}
+
+func (db *Database) checkReplicas(ctx context.Context) {
+	for _, r := range db.replicas {
+		r.check(ctx)
+	}
+}
+
+// runHealthChecks checks the replicas periodically until ctx is cancelled
+func (db *Database) runHealthChecks(ctx context.Context) {
+	defer close(db.healthChecksDone)
+
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			db.checkReplicas(ctx)
+		}
+	}
+}
+
+// pickReplica returns the next healthy replica in round robin,
+// nil when there is none or ctx needs the primary
+func (db *Database) pickReplica(ctx context.Context) *replica {
+	n := len(db.replicas)
+	if n == 0 || primaryOnly(ctx) {
+		return nil
+	}
+	start := db.nextReplica.Add(1)
+	for i := 0; i < n; i++ {
+		r := db.replicas[(start+uint64(i))%uint64(n)]
+		if r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// HealthyReplicas returns how many replicas currently serve reads
+func (db *Database) HealthyReplicas() int {
+	healthy := 0
+	for _, r := range db.replicas {
+		if r.healthy.Load() {
+			healthy++
+		}
+	}
+	return healthy
+}
+
+// queryRead runs a lag tolerant query on a healthy replica, falling back to the primary
+func (db *Database) queryRead(ctx context.Context, stmt func(*replica) *sql.Stmt, primary *sql.Stmt, args ...any) (*sql.Rows, error) {
+	if r := db.pickReplica(ctx); r != nil {
+		rows, err := stmt(r).QueryContext(ctx, args...)
+		if err == nil {
+			return rows, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		r.markUnhealthy(ctx, err)
+	}
+	return primary.QueryContext(ctx, args...)
+}
+
+// scanRead scans a single row from a healthy replica, falling back to the primary
+func (db *Database) scanRead(ctx context.Context, stmt func(*replica) *sql.Stmt, primary *sql.Stmt, args []any, dest ...any) error {
+	if r := db.pickReplica(ctx); r != nil {
+		err := stmt(r).QueryRowContext(ctx, args...).Scan(dest...)
+		if err == nil || errors.Is(err, sql.ErrNoRows) || ctx.Err() != nil {
+			return err
+		}
+		r.markUnhealthy(ctx, err)
+	}
+	return primary.QueryRowContext(ctx, args...).Scan(dest...)
+}
+
+// closeReplicas stops the health checks and closes the replica pools
+func (db *Database) closeReplicas() error {
+	if db.stopHealthChecks != nil {
+		db.stopHealthChecks()
+		<-db.healthChecksDone
+	}
+	var errs []error
+	for _, r := range db.replicas {
+		if err := r.close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
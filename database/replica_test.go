@@ -0,0 +1,153 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+const mockSchema = "casino"
+
+var transactionColumns = []string{"id", "user_id", "transaction_type", "amount", "timestamp"}
+
+// mockPool returns a mocked replica pool answering its first health check ping
+func mockPool(t *testing.T, pingable bool) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	conn, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	mock.MatchExpectationsInOrder(false)
+	t.Cleanup(func() { conn.Close() })
+
+	if pingable {
+		mock.ExpectPing()
+	} else {
+		mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	}
+	return conn, mock
+}
+
+// mockDatabase returns a database whose primary and replica pools are mocked
+func mockDatabase(t *testing.T, replicaUp bool) (*Database, sqlmock.Sqlmock, sqlmock.Sqlmock) {
+	t.Helper()
+
+	primaryConn, primary, err := sqlmock.New()
+	require.NoError(t, err)
+	primary.MatchExpectationsInOrder(false)
+	t.Cleanup(func() { primaryConn.Close() })
+
+	primary.ExpectPrepare("SELECT id, user_id")
+	primary.ExpectPrepare("SELECT COALESCE")
+	getTransactions, err := primaryConn.Prepare(fmt.Sprintf(getTransactionsQuery, mockSchema))
+	require.NoError(t, err)
+	getBalance, err := primaryConn.Prepare(fmt.Sprintf(getBalanceQuery, mockSchema))
+	require.NoError(t, err)
+
+	replicaConn, replicaMock := mockPool(t, replicaUp)
+	if replicaUp {
+		replicaMock.ExpectPrepare("SELECT id, user_id")
+		replicaMock.ExpectPrepare("SELECT COALESCE")
+	}
+
+	db := &Database{
+		conn:                    primaryConn,
+		getTransactionsPrepStmt: getTransactions,
+		getBalancePrepStmt:      getBalance,
+		replicas:                []*replica{{index: 0, schema: mockSchema, conn: replicaConn}},
+	}
+	db.checkReplicas(t.Context())
+	return db, primary, replicaMock
+}
+
+func TestReplicaRouting(t *testing.T) {
+	t.Run("reads go to a healthy replica", func(t *testing.T) {
+		db, primary, replica := mockDatabase(t, true)
+		require.Equal(t, 1, db.HealthyReplicas())
+
+		replica.ExpectQuery("SELECT id, user_id").WillReturnRows(sqlmock.NewRows(transactionColumns))
+		replica.ExpectQuery("SELECT COALESCE").WillReturnRows(sqlmock.NewRows([]string{"balance", "id"}).AddRow(12.5, 7))
+
+		rows, err := db.GetTransactions(t.Context(), nil, nil, 10)
+		require.NoError(t, err)
+		rows.Close()
+
+		balance, lastId, err := db.GetBalance(t.Context(), 1)
+		require.NoError(t, err)
+		require.Equal(t, 12.5, balance)
+		require.Equal(t, int64(7), lastId)
+
+		require.NoError(t, replica.ExpectationsWereMet())
+		require.NoError(t, primary.ExpectationsWereMet())
+	})
+
+	t.Run("failing replica falls back to the primary", func(t *testing.T) {
+		db, primary, replica := mockDatabase(t, true)
+
+		replica.ExpectQuery("SELECT id, user_id").WillReturnError(errors.New("connection lost"))
+		primary.ExpectQuery("SELECT id, user_id").WillReturnRows(sqlmock.NewRows(transactionColumns))
+
+		rows, err := db.GetTransactions(t.Context(), nil, nil, 10)
+		require.NoError(t, err)
+		rows.Close()
+
+		// Out of rotation until the next successful check
+		require.Equal(t, 0, db.HealthyReplicas())
+		require.NoError(t, replica.ExpectationsWereMet())
+		require.NoError(t, primary.ExpectationsWereMet())
+	})
+
+	t.Run("unreachable replica is skipped until it recovers", func(t *testing.T) {
+		db, primary, replica := mockDatabase(t, false)
+		require.Equal(t, 0, db.HealthyReplicas())
+
+		primary.ExpectQuery("SELECT COALESCE").WillReturnRows(sqlmock.NewRows([]string{"balance", "id"}).AddRow(1.0, 1))
+		_, _, err := db.GetBalance(t.Context(), 1)
+		require.NoError(t, err)
+
+		replica.ExpectPing()
+		replica.ExpectPrepare("SELECT id, user_id")
+		replica.ExpectPrepare("SELECT COALESCE")
+		db.checkReplicas(t.Context())
+		require.Equal(t, 1, db.HealthyReplicas())
+
+		require.NoError(t, replica.ExpectationsWereMet())
+		require.NoError(t, primary.ExpectationsWereMet())
+	})
+
+	t.Run("read-your-writes reads the primary", func(t *testing.T) {
+		db, primary, replica := mockDatabase(t, true)
+
+		primary.ExpectQuery("SELECT COALESCE").WillReturnRows(sqlmock.NewRows([]string{"balance", "id"}).AddRow(3.0, 9))
+
+		_, lastId, err := db.GetBalance(WithPrimary(t.Context()), 1)
+		require.NoError(t, err)
+		require.Equal(t, int64(9), lastId)
+
+		require.NoError(t, replica.ExpectationsWereMet())
+		require.NoError(t, primary.ExpectationsWereMet())
+	})
+}
+
+func TestPickReplica(t *testing.T) {
+	db := &Database{}
+	require.Nil(t, db.pickReplica(t.Context()))
+
+	for i := 0; i < 3; i++ {
+		db.replicas = append(db.replicas, &replica{index: i})
+	}
+	db.replicas[0].healthy.Store(true)
+	db.replicas[2].healthy.Store(true)
+
+	seen := map[int]int{}
+	for i := 0; i < 10; i++ {
+		seen[db.pickReplica(t.Context()).index]++
+	}
+	require.Zero(t, seen[1])
+	require.NotZero(t, seen[0])
+	require.NotZero(t, seen[2])
+
+	require.Nil(t, db.pickReplica(WithPrimary(t.Context())))
+}
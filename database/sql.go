@@ -3,108 +3,676 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
-	"sync"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+	"transaction-management-system/logging"
+	"transaction-management-system/tracing"
 
-	_ "github.com/go-sql-driver/mysql"
-	"github.com/joho/godotenv"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// DB is a singleton struct that holds the database connection and prepared statements.
+// Database holds a connection pool to one schema and its prepared statements
 type Database struct {
-	conn                      *sql.DB
-	insertTransactionPrepStmt *sql.Stmt
-	getTransactionsPrepStmt   *sql.Stmt
+	conn                         *sql.DB
+	insertTransactionPrepStmt    *sql.Stmt
+	getTransactionsPrepStmt      *sql.Stmt
+	getTransactionsSincePrepStmt *sql.Stmt
+	getBalancePrepStmt           *sql.Stmt
+	getApiKeyPrepStmt            *sql.Stmt
+	insertApiKeyPrepStmt         *sql.Stmt
+	revokeApiKeyPrepStmt         *sql.Stmt
+	insertOutboxPrepStmt         *sql.Stmt
+	getPendingOutboxPrepStmt     *sql.Stmt
+	markOutboxSentPrepStmt       *sql.Stmt
+	deleteSentOutboxPrepStmt     *sql.Stmt
+	insertOutboxReceiptPrepStmt  *sql.Stmt
+	deleteOutboxReceiptsPrepStmt *sql.Stmt
+
+	// Responsible gambling limits, see limits.go
+	getPlayerLimitsPrepStmt      *sql.Stmt
+	lockPlayerLimitPrepStmt      *sql.Stmt
+	lockPlayerLimitsPrepStmt     *sql.Stmt
+	upsertPlayerLimitPrepStmt    *sql.Stmt
+	insertLimitChangePrepStmt    *sql.Stmt
+	getLimitChangesPrepStmt      *sql.Stmt
+	insertSelfExclusionPrepStmt  *sql.Stmt
+	getSelfExclusionsPrepStmt    *sql.Stmt
+	getUsagePrepStmt             *sql.Stmt
+	insertLimitViolationPrepStmt *sql.Stmt
+	getLimitViolationsPrepStmt   *sql.Stmt
+
+	// Fraud alerts and history, see alerts.go
+	insertAlertPrepStmt           *sql.Stmt
+	getAlertsPrepStmt             *sql.Stmt
+	acknowledgeAlertPrepStmt      *sql.Stmt
+	getRecentTransactionsPrepStmt *sql.Stmt
+
+	timeouts Timeouts
+
+	// Read replicas, see replica.go
+	replicas         []*replica
+	nextReplica      atomic.Uint64
+	stopHealthChecks context.CancelFunc
+	healthChecksDone chan struct{}
 }
 
-var (
-	instance *Database
-	once     sync.Once
+// OutboxMessage is an event waiting to be published
+type OutboxMessage struct {
+	Id     int64
+	UserId int
+	// CorrelationId of the request that created the event, empty for older rows
+	CorrelationId string
+	// TraceParent continues the request's trace when the event is published, empty for older rows
+	TraceParent string
+	Payload     []byte
+}
+
+// ApiKey is a stored API key credential
+type ApiKey struct {
+	Id      int64
+	Name    string
+	Role    string
+	UserIds []int // empty means access to all users
+}
+
+// Config selects the database to open
+type Config struct {
+	// ConnectionString is a go-sql-driver DSN, see newConnector for credentials and TLS
+	ConnectionString string
+	// Schema qualifies every table, so one server can host several schemas
+	Schema string
+	// Replicas are DSNs of read replicas for queries that tolerate replication lag
+	Replicas []string
+	// Timeouts bound each operation, zero values use the defaults
+	Timeouts Timeouts
+}
+
+// Default per-operation timeouts
+const (
+	DefaultReadTimeout  = 5 * time.Second
+	DefaultWriteTimeout = 5 * time.Second
 )
 
-// GetDB returns a singleton MySQL database connection
-func GetDB(schema string) (*Database, error) {
-	var initError error
+// Timeouts bound single database operations on top of the caller's context.
+// Queries returning *sql.Rows are bound by the caller's context only, since
+// cancelling it would abort reading the rows.
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+}
 
-	once.Do(func() {
-		envPath := os.Getenv("ENV_PATH")
-		if envPath == "" {
-			envPath = ".env" // default
+func (t Timeouts) withDefaults() Timeouts {
+	if t.Read <= 0 {
+		t.Read = DefaultReadTimeout
+	}
+	if t.Write <= 0 {
+		t.Write = DefaultWriteTimeout
+	}
+	return t
+}
+
+// Queries shared by the primary and the replicas
+const (
+	getTransactionsQuery = `
+		SELECT id, user_id, transaction_type, amount, timestamp 
+		FROM %s.transactions 
+		WHERE (? IS NULL OR user_id = ?)
+		AND (? IS NULL OR transaction_type = ?)
+		ORDER BY timestamp DESC
+		LIMIT ?
+	`
+	// Wins minus bets, with the last accounted id
+	getBalanceQuery = `
+		SELECT COALESCE(SUM(CASE WHEN transaction_type = 'win' THEN amount ELSE -amount END), 0), COALESCE(MAX(id), 0)
+		FROM %s.transactions
+		WHERE user_id = ?
+	`
+)
+
+// ConfigFromEnv returns the config of MYSQL_CONNECTION_URL for the schema. The environment
+// is read as is, loading a .env file is up to the caller (see config.LoadEnv).
+func ConfigFromEnv(schema string) (Config, error) {
+	if os.Getenv("MYSQL_CONNECTION_URL") == "" {
+		return Config{}, errors.New("MYSQL_CONNECTION_URL is not set")
+	}
+
+	// Mysql connection string and the comma separated replicas
+	cfg := Config{
+		ConnectionString: os.Getenv("MYSQL_CONNECTION_URL"),
+		Schema:           schema,
+	}
+	for _, dsn := range strings.Split(os.Getenv("MYSQL_REPLICA_URLS"), ",") {
+		if dsn = strings.TrimSpace(dsn); dsn != "" {
+			cfg.Replicas = append(cfg.Replicas, dsn)
 		}
+	}
 
-		// Load .env file
-		err := godotenv.Load(envPath)
-		if err != nil {
-			initError = fmt.Errorf("failed to load .env file")
-			return
+	// Optional per-operation timeouts, e.g. MYSQL_WRITE_TIMEOUT=3s
+	for name, timeout := range map[string]*time.Duration{
+		"MYSQL_READ_TIMEOUT":  &cfg.Timeouts.Read,
+		"MYSQL_WRITE_TIMEOUT": &cfg.Timeouts.Write,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
 		}
-		// Load mysql connection stirng from .env
-		connectionString := os.Getenv("MYSQL_CONNECTION_URL")
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return Config{}, fmt.Errorf("invalid %s %q, expected a positive duration like 5s", name, value)
+		}
+		*timeout = d
+	}
+	return cfg, nil
+}
 
-		// Connect to MySQL database
-		conn, err := sql.Open("mysql", connectionString)
-		if err != nil {
-			initError = fmt.Errorf("failed to open database: %w", err)
-			return
+// Open connects to the database and prepares the statements. Every call
+// returns an independent handle, the caller owns it and must Close it.
+func Open(cfg Config) (*Database, error) {
+	schema := cfg.Schema
+	timeouts := cfg.Timeouts.withDefaults()
+
+	// Connect to MySQL database
+	connector, err := newConnector(cfg.ConnectionString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	conn := sql.OpenDB(connector)
+
+	// Test the connection
+	ctx, cancel := context.WithTimeout(context.Background(), timeouts.Read)
+	defer cancel()
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	// Configure connection pool settings
+	conn.SetMaxOpenConns(25)
+	conn.SetMaxIdleConns(25)
+	conn.SetConnMaxLifetime(5 * time.Minute)
+
+	db := &Database{conn: conn, timeouts: timeouts}
+
+	// Prepare the transaction insert statement
+	db.insertTransactionPrepStmt, err = conn.Prepare(fmt.Sprintf("INSERT INTO %s.transactions (user_id, transaction_type, amount, timestamp) VALUES (?, ?, ?, ?)", schema))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare insert transaction statement: %w", err)
+	}
+	// Prepate get transactions statement
+	db.getTransactionsPrepStmt, err = conn.Prepare(fmt.Sprintf(getTransactionsQuery, schema))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare get transactions statement: %w", err)
+	}
+	// Prepare get transactions since id statement (used for stream resumption)
+	db.getTransactionsSincePrepStmt, err = conn.Prepare(fmt.Sprintf(`
+		SELECT id, user_id, transaction_type, amount, timestamp
+		FROM %[1]s.transactions
+		WHERE created_at >= (SELECT created_at FROM %[1]s.transactions WHERE id = ?) - INTERVAL ? SECOND
+		AND id <> ?
+		AND (? IS NULL OR user_id = ?)
+		AND (? IS NULL OR transaction_type = ?)
+		ORDER BY created_at ASC, id ASC
+		LIMIT ?
+	`, schema))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare get transactions since statement: %w", err)
+	}
+
+	// Prepare get balance statement (wins minus bets, with the last accounted id)
+	db.getBalancePrepStmt, err = conn.Prepare(fmt.Sprintf(getBalanceQuery, schema))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare get balance statement: %w", err)
+	}
+
+	// Prepare api key statements
+	db.getApiKeyPrepStmt, err = conn.Prepare(fmt.Sprintf(`
+		SELECT id, name, role, user_ids
+		FROM %s.api_keys
+		WHERE key_hash = ? AND revoked_at IS NULL
+	`, schema))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare get api key statement: %w", err)
+	}
+	db.insertApiKeyPrepStmt, err = conn.Prepare(fmt.Sprintf("INSERT INTO %s.api_keys (name, key_hash, role, user_ids) VALUES (?, ?, ?, ?)", schema))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare insert api key statement: %w", err)
+	}
+	db.revokeApiKeyPrepStmt, err = conn.Prepare(fmt.Sprintf("UPDATE %s.api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL", schema))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare revoke api key statement: %w", err)
+	}
+
+	// Prepare outbox statements
+	db.insertOutboxPrepStmt, err = conn.Prepare(fmt.Sprintf("INSERT INTO %s.outbox (user_id, correlation_id, trace_parent, payload) VALUES (?, ?, ?, ?)", schema))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare insert outbox statement: %w", err)
+	}
+	db.getPendingOutboxPrepStmt, err = conn.Prepare(fmt.Sprintf(`
+		SELECT id, user_id, correlation_id, trace_parent, payload
+		FROM %s.outbox
+		WHERE sent_at IS NULL
+		ORDER BY id ASC
+		LIMIT ?
+	`, schema))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare get pending outbox statement: %w", err)
+	}
+	db.markOutboxSentPrepStmt, err = conn.Prepare(fmt.Sprintf("UPDATE %s.outbox SET sent_at = CURRENT_TIMESTAMP WHERE id = ?", schema))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare mark outbox sent statement: %w", err)
+	}
+	db.deleteSentOutboxPrepStmt, err = conn.Prepare(fmt.Sprintf("DELETE FROM %s.outbox WHERE sent_at IS NOT NULL AND sent_at < ?", schema))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare delete sent outbox statement: %w", err)
+	}
+	db.insertOutboxReceiptPrepStmt, err = conn.Prepare(fmt.Sprintf("INSERT IGNORE INTO %s.outbox_receipts (transaction_id) VALUES (?)", schema))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare insert outbox receipt statement: %w", err)
+	}
+	db.deleteOutboxReceiptsPrepStmt, err = conn.Prepare(fmt.Sprintf("DELETE FROM %s.outbox_receipts WHERE received_at < ?", schema))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare delete outbox receipts statement: %w", err)
+	}
+
+	if err := db.prepareLimits(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := db.prepareAlerts(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Replicas serve the lag tolerant reads
+	if err := db.openReplicas(cfg.Replicas, schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// InsertTransaction inserts a new transaction record and returns its id.
+// Errors are classified, see IsRetryable and IsPermanent.
+func (db *Database) InsertTransaction(ctx context.Context, userId int, transactionType string, amount float64, timestamp time.Time) (int64, error) {
+	ctx, cancel := db.writeContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "insert transaction")
+	result, err := db.insertTransactionPrepStmt.ExecContext(ctx,
+		userId,
+		transactionType,
+		amount,
+		timestamp,
+	)
+	done(err)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert transaction: %w", classify(ctx, err))
+	}
+	return result.LastInsertId()
+}
+
+// GetTransactions returns the latest transactions matching the filters, from a replica when one is healthy
+func (db *Database) GetTransactions(ctx context.Context, userId *int, transactionType *string, limit int) (*sql.Rows, error) {
+	userIdVal, typeVal := filterArgs(userId, transactionType)
+
+	ctx, done := startOp(ctx, "get transactions")
+	rows, err := db.queryRead(ctx, func(r *replica) *sql.Stmt { return r.getTransactionsPrepStmt }, db.getTransactionsPrepStmt,
+		userIdVal, userIdVal, typeVal, typeVal, limit)
+	done(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %w", classify(ctx, err))
+	}
+	return rows, nil
+}
+
+// GetTransactionsSince returns the transactions stored since lastId, starting overlap earlier,
+// in storage order and without lastId itself. Ids don't follow storage order across consumers
+// and the outbox, the overlap takes back the ones stored around lastId.
+// It always reads the primary, resuming a stream must not skip rows a replica hasn't applied yet.
+func (db *Database) GetTransactionsSince(ctx context.Context, lastId int64, overlap time.Duration, userId *int, transactionType *string, limit int) (*sql.Rows, error) {
+	userIdVal, typeVal := filterArgs(userId, transactionType)
+
+	ctx, done := startOp(ctx, "get transactions since")
+	rows, err := db.getTransactionsSincePrepStmt.QueryContext(ctx, lastId, int64(overlap/time.Second), lastId, userIdVal, userIdVal, typeVal, typeVal, limit)
+	done(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %w", classify(ctx, err))
+	}
+	return rows, nil
+}
+
+// GetBalance returns the user's balance and the id of the last transaction it includes,
+// from a replica when one is healthy
+func (db *Database) GetBalance(ctx context.Context, userId int) (float64, int64, error) {
+	ctx, cancel := db.readContext(ctx)
+	defer cancel()
+
+	var balance float64
+	var lastId int64
+	ctx, done := startOp(ctx, "get balance")
+	err := db.scanRead(ctx, func(r *replica) *sql.Stmt { return r.getBalancePrepStmt }, db.getBalancePrepStmt,
+		[]any{userId}, &balance, &lastId)
+	done(err)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to query balance: %w", classify(ctx, err))
+	}
+	return balance, lastId, nil
+}
+
+// GetApiKey returns the active api key with the given hash, or sql.ErrNoRows
+func (db *Database) GetApiKey(ctx context.Context, keyHash string) (*ApiKey, error) {
+	ctx, cancel := db.readContext(ctx)
+	defer cancel()
+
+	var key ApiKey
+	var userIds sql.NullString
+	ctx, done := startOp(ctx, "get api key")
+	err := db.getApiKeyPrepStmt.QueryRowContext(ctx, keyHash).Scan(&key.Id, &key.Name, &key.Role, &userIds)
+	done(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api key: %w", classify(ctx, err))
+	}
+	if userIds.Valid && userIds.String != "" {
+		for _, id := range strings.Split(userIds.String, ",") {
+			userId, err := strconv.Atoi(id)
+			if err != nil {
+				return nil, fmt.Errorf("invalid user id %q in api key %d", id, key.Id)
+			}
+			key.UserIds = append(key.UserIds, userId)
 		}
+	}
+	return &key, nil
+}
+
+// InsertApiKey stores a new api key hash and returns its id
+func (db *Database) InsertApiKey(ctx context.Context, name, keyHash, role string, userIds []int) (int64, error) {
+	var userIdsVal interface{}
+	if len(userIds) > 0 {
+		ids := make([]string, len(userIds))
+		for i, id := range userIds {
+			ids[i] = strconv.Itoa(id)
+		}
+		userIdsVal = strings.Join(ids, ",")
+	}
+
+	ctx, cancel := db.writeContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "insert api key")
+	result, err := db.insertApiKeyPrepStmt.ExecContext(ctx, name, keyHash, role, userIdsVal)
+	done(err)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert api key: %w", classify(ctx, err))
+	}
+	return result.LastInsertId()
+}
+
+// RevokeApiKey marks the api key as revoked, returning sql.ErrNoRows if no active key matched
+func (db *Database) RevokeApiKey(ctx context.Context, id int64) error {
+	ctx, cancel := db.writeContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "revoke api key")
+	result, err := db.revokeApiKeyPrepStmt.ExecContext(ctx, id)
+	done(err)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", classify(ctx, err))
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// NewTransaction is a transaction to store with CreateTransactions
+type NewTransaction struct {
+	UserId          int
+	TransactionType string
+	Amount          float64
+	Timestamp       time.Time
+}
+
+// CreateTransaction inserts a transaction and its outbox event in one SQL transaction.
+// The payload is built from the new transaction id, so the event can't be lost or
+// published without the transaction being stored. The write timeout bounds the whole transaction.
+// The correlation id and trace context of ctx are stored with the event, so the relay publishes them.
+func (db *Database) CreateTransaction(ctx context.Context, userId int, transactionType string, amount float64, timestamp time.Time, payload func(id int64) ([]byte, error)) (int64, error) {
+	ids, err := db.CreateTransactions(ctx, []NewTransaction{{
+		UserId:          userId,
+		TransactionType: transactionType,
+		Amount:          amount,
+		Timestamp:       timestamp,
+	}}, nil, func(_ int, id int64) ([]byte, error) {
+		return payload(id)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+// CreateTransactions inserts the transactions and their outbox events like CreateTransaction,
+// all of them or none in one SQL transaction. payload builds the event of the i-th transaction.
+// Events are written in order, so the relay publishes a user's transactions in order.
+//
+// A non-nil check runs first in the same SQL transaction, with the limits of the transactions'
+// players locked until it ends, so the usage it reads can't change before the transactions are
+// stored. Submissions of the same player are serialised this way. Errors of check are returned as is.
+func (db *Database) CreateTransactions(ctx context.Context, transactions []NewTransaction, check func(ctx context.Context, players *LockedPlayers) error, payload func(i int, id int64) ([]byte, error)) (ids []int64, err error) {
+	ctx, cancel := db.writeContext(ctx)
+	defer cancel()
 
-		// Test the connection
-		err = conn.Ping()
+	ctx, done := startOp(ctx, "create transactions")
+	defer func() { done(err) }()
+
+	// Read committed, so the usage read after waiting for a lock counts the bets stored meanwhile
+	tx, err := db.conn.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", classify(ctx, err))
+	}
+	defer tx.Rollback()
+
+	if check != nil {
+		players, err := db.lockPlayers(ctx, tx, transactions)
 		if err != nil {
-			initError = fmt.Errorf("failed to ping database: %w", err)
-			return
+			return nil, err
+		}
+		if err := check(ctx, players); err != nil {
+			return nil, err
 		}
+	}
 
-		// Configure connection pool settings
-		conn.SetMaxOpenConns(25)
-		conn.SetMaxIdleConns(25)
-		conn.SetConnMaxLifetime(5 * time.Minute)
+	var correlationId, traceParent interface{}
+	if cid := logging.CorrelationID(ctx); cid != "" {
+		correlationId = cid
+	}
+	if tp := tracing.TraceParent(ctx); tp != "" {
+		traceParent = tp
+	}
+	insertTransaction := tx.StmtContext(ctx, db.insertTransactionPrepStmt)
+	insertOutbox := tx.StmtContext(ctx, db.insertOutboxPrepStmt)
 
-		// Prepare the transaction insert statement
-		insertTransactionPrepStmt, err := conn.Prepare(fmt.Sprintf("INSERT INTO %s.transactions (user_id, transaction_type, amount, timestamp) VALUES (?, ?, ?, ?)", schema))
+	ids = make([]int64, 0, len(transactions))
+	for i, t := range transactions {
+		result, err := insertTransaction.ExecContext(ctx, t.UserId, t.TransactionType, t.Amount, t.Timestamp)
 		if err != nil {
-			initError = fmt.Errorf("failed to prepare insert transaction statement: %w", err)
-			return
+			return nil, fmt.Errorf("failed to insert transaction: %w", classify(ctx, err))
 		}
-		// Prepate get transactions statement
-		getTransactionsPrepStmt, err := conn.Prepare(fmt.Sprintf(`
-			SELECT user_id, transaction_type, amount, timestamp 
-			FROM %s.transactions 
-			WHERE (? IS NULL OR user_id = ?)
-			AND (? IS NULL OR transaction_type = ?)
-			ORDER BY timestamp DESC
-			LIMIT ?
-		`, schema))
+		id, err := result.LastInsertId()
 		if err != nil {
-			initError = fmt.Errorf("failed to prepare get transactions statement: %w", err)
-			return
+			return nil, fmt.Errorf("failed to insert transaction: %w", err)
 		}
 
-		instance = &Database{
-			conn:                      conn,
-			insertTransactionPrepStmt: insertTransactionPrepStmt,
-			getTransactionsPrepStmt:   getTransactionsPrepStmt,
+		body, err := payload(i, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build outbox payload: %w", err)
 		}
-	})
+		if _, err := insertOutbox.ExecContext(ctx, t.UserId, correlationId, traceParent, body); err != nil {
+			return nil, fmt.Errorf("failed to insert outbox message: %w", classify(ctx, err))
+		}
+		ids = append(ids, id)
+	}
 
-	return instance, initError
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", classify(ctx, err))
+	}
+	return ids, nil
 }
 
-// InsertTransaction inserts a new transaction record
-func (db *Database) InsertTransaction(userId int, transactionType string, amount float64, timestamp time.Time) error {
-	_, err := db.insertTransactionPrepStmt.Exec(
-		userId,
-		transactionType,
-		amount,
-		timestamp,
+// GetPendingOutbox returns up to limit unsent outbox messages in insertion order
+func (db *Database) GetPendingOutbox(ctx context.Context, limit int) ([]OutboxMessage, error) {
+	ctx, cancel := db.readContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "get pending outbox")
+	rows, err := db.getPendingOutboxPrepStmt.QueryContext(ctx, limit)
+	done(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query outbox: %w", classify(ctx, err))
+	}
+	defer rows.Close()
+
+	var messages []OutboxMessage
+	for rows.Next() {
+		var m OutboxMessage
+		var correlationId, traceParent sql.NullString
+		if err := rows.Scan(&m.Id, &m.UserId, &correlationId, &traceParent, &m.Payload); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox message: %w", err)
+		}
+		m.CorrelationId = correlationId.String
+		m.TraceParent = traceParent.String
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query outbox: %w", classify(ctx, err))
+	}
+	return messages, nil
+}
+
+// MarkOutboxSent records that the outbox message was published
+func (db *Database) MarkOutboxSent(ctx context.Context, id int64) error {
+	ctx, cancel := db.writeContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "mark outbox sent")
+	_, err := db.markOutboxSentPrepStmt.ExecContext(ctx, id)
+	done(err)
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox message sent: %w", classify(ctx, err))
+	}
+	return nil
+}
+
+// DeleteSentOutbox removes outbox messages sent before the given time
+func (db *Database) DeleteSentOutbox(ctx context.Context, sentBefore time.Time) (int64, error) {
+	ctx, cancel := db.writeContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "delete sent outbox")
+	result, err := db.deleteSentOutboxPrepStmt.ExecContext(ctx, sentBefore)
+	done(err)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete sent outbox messages: %w", classify(ctx, err))
+	}
+	return result.RowsAffected()
+}
+
+// ReceiveOutbox records that the consumer handled the relayed transaction. It reports
+// false when the transaction was received before, a republished event to skip.
+func (db *Database) ReceiveOutbox(ctx context.Context, transactionId int64) (bool, error) {
+	ctx, cancel := db.writeContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "receive outbox")
+	result, err := db.insertOutboxReceiptPrepStmt.ExecContext(ctx, transactionId)
+	done(err)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert outbox receipt: %w", classify(ctx, err))
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to insert outbox receipt: %w", err)
+	}
+	return affected == 1, nil
+}
+
+// DeleteOutboxReceipts removes the receipts of transactions received before the given time
+func (db *Database) DeleteOutboxReceipts(ctx context.Context, receivedBefore time.Time) (int64, error) {
+	ctx, cancel := db.writeContext(ctx)
+	defer cancel()
+
+	ctx, done := startOp(ctx, "delete outbox receipts")
+	result, err := db.deleteOutboxReceiptsPrepStmt.ExecContext(ctx, receivedBefore)
+	done(err)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete outbox receipts: %w", classify(ctx, err))
+	}
+	return result.RowsAffected()
+}
+
+// startOp starts the span of a database operation. The returned done ends it
+// and logs the operation at debug level with the correlation id of ctx.
+func startOp(ctx context.Context, op string) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemNameMySQL, semconv.DBOperationName(op)),
 	)
-	return err
+	return ctx, func(err error) {
+		tracing.End(span, err)
+
+		attrs := []any{"op", op, "duration", time.Since(start)}
+		if err != nil {
+			attrs = append(attrs, "error", err)
+		}
+		slog.DebugContext(ctx, "Database operation", attrs...)
+	}
 }
 
-func (db *Database) GetTransactions(ctx context.Context, userId *int, transactionType *string, limit int) (*sql.Rows, error) {
+// readContext bounds a single read, including scanning its rows, by the read timeout
+func (db *Database) readContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, db.timeouts.Read)
+}
+
+// writeContext bounds a write by the write timeout
+func (db *Database) writeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, db.timeouts.Write)
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// filterArgs converts optional filters to NULL-able query arguments
+func filterArgs(userId *int, transactionType *string) (interface{}, interface{}) {
 	var userIdVal, typeVal interface{}
 	if userId != nil {
 		userIdVal = *userId
@@ -112,37 +680,49 @@ func (db *Database) GetTransactions(ctx context.Context, userId *int, transactio
 	if transactionType != nil {
 		typeVal = *transactionType
 	}
-
-	rows, err := db.getTransactionsPrepStmt.QueryContext(ctx, userIdVal, userIdVal, typeVal, typeVal, limit)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query transactions: %w", err)
-	}
-	return rows, nil
+	return userIdVal, typeVal
 }
 
-// Close the database connection
+// Close closes the prepared statements and the connection pool.
+// Statements that were never prepared are skipped, so a partially opened handle can be closed too.
 func (db *Database) Close() error {
-	if err := db.insertTransactionPrepStmt.Close(); err != nil {
-		log.Printf("Failed to close insert prepared statement")
+	if err := db.closeReplicas(); err != nil {
+		slog.Error("Failed to close replicas", "error", err)
 		return err
 	}
 
-	if err := db.getTransactionsPrepStmt.Close(); err != nil {
-		log.Printf("Failed to close insert prepared statement")
-		return err
+	stmts := []*sql.Stmt{
+		db.insertTransactionPrepStmt,
+		db.getTransactionsPrepStmt,
+		db.getTransactionsSincePrepStmt,
+		db.getBalancePrepStmt,
+		db.getApiKeyPrepStmt,
+		db.insertApiKeyPrepStmt,
+		db.revokeApiKeyPrepStmt,
+		db.insertOutboxPrepStmt,
+		db.getPendingOutboxPrepStmt,
+		db.markOutboxSentPrepStmt,
+		db.deleteSentOutboxPrepStmt,
+		db.insertOutboxReceiptPrepStmt,
+		db.deleteOutboxReceiptsPrepStmt,
+	}
+	stmts = append(stmts, db.limitStmts()...)
+	stmts = append(stmts, db.alertStmts()...)
+	for _, stmt := range stmts {
+		if stmt == nil {
+			continue
+		}
+		if err := stmt.Close(); err != nil {
+			slog.Error("Failed to close prepared statement", "error", err)
+			return err
+		}
 	}
 
 	if err := db.conn.Close(); err != nil {
-		log.Printf("Failed to close database")
+		slog.Error("Failed to close database", "error", err)
 		return err
 	}
 
-	ResetInstance()
-	log.Println("Database closed succesfully")
+	slog.Info("Database closed")
 	return nil
 }
-
-func ResetInstance() {
-	instance = nil
-	once = sync.Once{}
-}
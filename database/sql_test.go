@@ -1,117 +1,113 @@
 package database
 
 import (
-	"os"
+	"fmt"
+	"path/filepath"
 	"testing"
 	"time"
 	test "transaction-management-system/config"
+	"transaction-management-system/logging"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func TestDBInitializationFailure(t *testing.T) {
-	// Backup current state
-	originalDir, err := os.Getwd()
-	if err != nil {
-		t.Fatalf("Failed to get current directory: %v", err)
-	}
-	defer os.Chdir(originalDir)
-
-	// Create and move to a temp directory without .env
-	tempDir := t.TempDir()
-	err = os.Chdir(tempDir)
-	if err != nil {
-		t.Fatalf("Failed to change directory: %v", err)
-	}
-
-	t.Run("succesfull no finding.env file", func(t *testing.T) {
-		_, err := GetDB(test.DB_SCHEMA)
-		require.Error(t, err)
-		require.ErrorContains(t, err, "failed to load .env file")
-
+func TestConfigFromEnv(t *testing.T) {
+	t.Run("failed without connection url", func(t *testing.T) {
+		t.Setenv("MYSQL_CONNECTION_URL", "")
+		_, err := ConfigFromEnv(test.DB_SCHEMA)
+		require.ErrorContains(t, err, "MYSQL_CONNECTION_URL is not set")
 	})
-}
 
-// TestGetDBSingleton tests the singleton behavior
-func TestGetDBSingleton(t *testing.T) {
-	t.Run("failed ENV_PATH ", func(t *testing.T) {
-		ResetInstance()
+	t.Run("successful without .env file", func(t *testing.T) {
+		t.Setenv("ENV_PATH", filepath.Join(t.TempDir(), ".env"))
+		t.Setenv("MYSQL_CONNECTION_URL", "root:12345@tcp(127.0.0.1:3306)/casino?parseTime=true")
+		t.Setenv("MYSQL_REPLICA_URLS", " replica1 ,,replica2")
+		t.Setenv("MYSQL_WRITE_TIMEOUT", "3s")
 
-		os.Setenv("ENV_PATH", "")
-		_, err := GetDB(test.DB_SCHEMA)
-
-		require.Error(t, err)
-		require.ErrorContains(t, err, "failed to load .env file")
+		cfg, err := ConfigFromEnv(test.DB_SCHEMA)
+		require.NoError(t, err)
+		require.Equal(t, []string{"replica1", "replica2"}, cfg.Replicas)
+		require.Equal(t, 3*time.Second, cfg.Timeouts.Write)
+	})
 
-		os.Setenv("ENV_PATH", "../.env")
+	t.Run("failed invalid timeout", func(t *testing.T) {
+		t.Setenv("MYSQL_CONNECTION_URL", "root:12345@tcp(127.0.0.1:3306)/casino?parseTime=true")
+		t.Setenv("MYSQL_READ_TIMEOUT", "-1s")
+		_, err := ConfigFromEnv(test.DB_SCHEMA)
+		require.ErrorContains(t, err, "invalid MYSQL_READ_TIMEOUT")
 	})
+}
 
-	t.Run("failed invalid mysql connection string", func(t *testing.T) {
-		ResetInstance()
+// openDB opens a handle on the test schema
+func openDB(t *testing.T) *Database {
+	t.Helper()
+	test.LoadEnv()
+	cfg, err := ConfigFromEnv(test.DB_SCHEMA)
+	require.NoError(t, err)
+	db, err := Open(cfg)
+	require.NoError(t, err)
+	return db
+}
 
-		os.Setenv("MYSQL_CONNECTION_URL", ":@invalid")
-		_, err := GetDB(test.DB_SCHEMA)
+// TestOpen tests opening independent database handles
+func TestOpen(t *testing.T) {
+	t.Run("failed invalid mysql connection string", func(t *testing.T) {
+		_, err := Open(Config{ConnectionString: ":@invalid", Schema: test.DB_SCHEMA})
 
 		require.Error(t, err)
 		require.ErrorContains(t, err, "failed to open database")
-
-		os.Unsetenv("MYSQL_CONNECTION_URL")
 	})
 
 	t.Run("failed ping", func(t *testing.T) {
-		ResetInstance()
-
-		os.Setenv("MYSQL_CONNECTION_URL", "root:12345@tcp(127.0.0.1:3306)/casino?parseTime=true")
-		_, err := GetDB(test.DB_SCHEMA)
+		_, err := Open(Config{ConnectionString: "root:12345@tcp(127.0.0.1:3306)/casino?parseTime=true", Schema: test.DB_SCHEMA})
 
 		require.Error(t, err)
 		require.ErrorContains(t, err, "failed to ping database")
-
-		os.Unsetenv("MYSQL_CONNECTION_URL")
 	})
 
 	t.Run("failed prepad statement ", func(t *testing.T) {
-		ResetInstance()
+		test.LoadEnv()
+		cfg, err := ConfigFromEnv(test.WRONG_DB_SCHEMA)
+		require.NoError(t, err)
 
-		_, err := GetDB(test.WRONG_DB_SCHEMA)
+		_, err = Open(cfg)
 		require.Error(t, err)
 		require.ErrorContains(t, err, "failed to prepare insert transaction statement")
 	})
 
-	t.Run("succesful singleton pattern", func(t *testing.T) {
-		ResetInstance()
+	t.Run("succesful independent handles", func(t *testing.T) {
+		db1 := openDB(t)
+		db2 := openDB(t)
+		defer db2.Close()
 
-		// First call should succeed
-		db1, err := GetDB(test.DB_SCHEMA)
-		require.NoError(t, err)
-		require.NotNil(t, db1)
+		// Each call returns its own handle
+		assert.NotSame(t, db1, db2)
 
-		// Second call should return the same instance
-		db2, err := GetDB(test.DB_SCHEMA)
+		// Closing one leaves the other usable
+		require.NoError(t, db1.Close())
+		_, _, err := db2.GetBalance(t.Context(), test.USER_ID)
 		require.NoError(t, err)
-		require.NotNil(t, db2)
-
-		// Verify it's the same instance
-		assert.Equal(t, db1, db2)
 	})
 }
 
 // TestInsertTransaction tests the InsertTransaction method
 func TestInsertTransaction(t *testing.T) {
 
-	db, _ := GetDB(test.DB_SCHEMA)
+	db := openDB(t)
 	defer db.Close()
 
 	t.Run("successful insert with valid data", func(t *testing.T) {
-		err := db.InsertTransaction(test.USER_ID, test.TRANSACTION_TYPE, test.AMOUNT, time.Now())
+		id, err := db.InsertTransaction(t.Context(), test.USER_ID, test.TRANSACTION_TYPE, test.AMOUNT, time.Now())
 		require.NoError(t, err)
+		require.Greater(t, id, int64(0))
 	})
 
 	t.Run("failed insert with invalid transaction type", func(t *testing.T) {
-		err := db.InsertTransaction(test.USER_ID, test.WRONG_TRANSACTION_TYPE, test.AMOUNT, time.Now())
+		_, err := db.InsertTransaction(t.Context(), test.USER_ID, test.WRONG_TRANSACTION_TYPE, test.AMOUNT, time.Now())
 		require.Error(t, err)
 		require.ErrorContains(t, err, "Data truncated for column 'transaction_type'")
+		require.True(t, IsPermanent(err), "truncation can't succeed on retry")
 	})
 
 }
@@ -123,7 +119,7 @@ func TestGetTransactions(t *testing.T) {
 	transactionType := test.TRANSACTION_TYPE
 
 	t.Run("successful get transaction", func(t *testing.T) {
-		db, _ := GetDB(test.DB_SCHEMA)
+		db := openDB(t)
 		defer db.Close()
 
 		rows, err := db.GetTransactions(t.Context(), &userId, &transactionType, 1)
@@ -133,8 +129,33 @@ func TestGetTransactions(t *testing.T) {
 		assert.Equal(t, rows.Next(), true)
 	})
 
+	t.Run("successful get transactions since id", func(t *testing.T) {
+		db := openDB(t)
+		defer db.Close()
+
+		first, err := db.InsertTransaction(t.Context(), userId, transactionType, test.AMOUNT, time.Now())
+		require.NoError(t, err)
+		second, err := db.InsertTransaction(t.Context(), userId, transactionType, test.AMOUNT, time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+
+		// The overlap takes back the first, stored just before whatever the transaction times
+		rows, err := db.GetTransactionsSince(t.Context(), second, time.Minute, &userId, &transactionType, 1000)
+		require.NoError(t, err)
+		defer rows.Close()
+
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			var rest [4]any
+			require.NoError(t, rows.Scan(&id, &rest[0], &rest[1], &rest[2], &rest[3]))
+			ids = append(ids, id)
+		}
+		require.Contains(t, ids, first)
+		require.NotContains(t, ids, second)
+	})
+
 	t.Run("failed get transaction", func(t *testing.T) {
-		db, _ := GetDB(test.DB_SCHEMA)
+		db := openDB(t)
 		db.Close()
 
 		_, err := db.GetTransactions(t.Context(), &userId, &transactionType, 1)
@@ -144,13 +165,116 @@ func TestGetTransactions(t *testing.T) {
 
 }
 
+// TestOutbox tests the transactional outbox methods
+func TestOutbox(t *testing.T) {
+	t.Run("successful create transaction with outbox message", func(t *testing.T) {
+		db := openDB(t)
+		defer db.Close()
+
+		ctx := logging.WithCorrelationID(t.Context(), "outbox-test")
+		id, err := db.CreateTransaction(ctx, test.USER_ID, test.TRANSACTION_TYPE, test.AMOUNT, time.Now(), func(id int64) ([]byte, error) {
+			return []byte(fmt.Sprintf(`{"id": %d}`, id)), nil
+		})
+		require.NoError(t, err)
+
+		messages, err := db.GetPendingOutbox(t.Context(), 1000)
+		require.NoError(t, err)
+		require.NotEmpty(t, messages)
+		last := messages[len(messages)-1]
+		require.Contains(t, string(last.Payload), fmt.Sprint(id))
+		require.Equal(t, "outbox-test", last.CorrelationId)
+
+		require.NoError(t, db.MarkOutboxSent(t.Context(), last.Id))
+		_, err = db.DeleteSentOutbox(t.Context(), time.Now().Add(time.Minute))
+		require.NoError(t, err)
+	})
+
+	t.Run("failed create transaction rolls back", func(t *testing.T) {
+		db := openDB(t)
+		defer db.Close()
+
+		_, err := db.CreateTransaction(t.Context(), test.USER_ID, test.WRONG_TRANSACTION_TYPE, test.AMOUNT, time.Now(), func(id int64) ([]byte, error) {
+			return []byte("{}"), nil
+		})
+		require.Error(t, err)
+		require.ErrorContains(t, err, "failed to insert transaction")
+	})
+
+	t.Run("successful create transactions in order", func(t *testing.T) {
+		db := openDB(t)
+		defer db.Close()
+
+		batch := []NewTransaction{
+			{UserId: test.USER_ID, TransactionType: test.TRANSACTION_TYPE, Amount: test.AMOUNT, Timestamp: time.Now()},
+			{UserId: test.USER_ID, TransactionType: test.TRANSACTION_TYPE, Amount: test.AMOUNT, Timestamp: time.Now()},
+		}
+		ids, err := db.CreateTransactions(t.Context(), batch, nil, func(i int, id int64) ([]byte, error) {
+			return []byte(fmt.Sprintf(`{"index": %d, "id": %d}`, i, id)), nil
+		})
+		require.NoError(t, err)
+		require.Len(t, ids, 2)
+		require.Less(t, ids[0], ids[1])
+	})
+
+	t.Run("failed create transactions stores none", func(t *testing.T) {
+		db := openDB(t)
+		defer db.Close()
+
+		batch := []NewTransaction{
+			{UserId: test.USER_ID, TransactionType: test.TRANSACTION_TYPE, Amount: test.AMOUNT, Timestamp: time.Now()},
+			{UserId: test.USER_ID, TransactionType: test.WRONG_TRANSACTION_TYPE, Amount: test.AMOUNT, Timestamp: time.Now()},
+		}
+		before, err := db.InsertTransaction(t.Context(), test.USER_ID, test.TRANSACTION_TYPE, test.AMOUNT, time.Now())
+		require.NoError(t, err)
+
+		var first int64
+		_, err = db.CreateTransactions(t.Context(), batch, nil, func(i int, id int64) ([]byte, error) {
+			if i == 0 {
+				first = id
+			}
+			return []byte("{}"), nil
+		})
+		require.ErrorContains(t, err, "failed to insert transaction")
+
+		after, err := db.GetTransactionsSince(t.Context(), before, 0, nil, nil, 10)
+		require.NoError(t, err)
+		defer after.Close()
+		for after.Next() {
+			var id int64
+			var rest [4]any
+			require.NoError(t, after.Scan(&id, &rest[0], &rest[1], &rest[2], &rest[3]))
+			require.NotEqual(t, first, id)
+		}
+	})
+}
+
+// TestGetBalance tests the GetBalance method
+func TestGetBalance(t *testing.T) {
+	t.Run("successful get balance", func(t *testing.T) {
+		db := openDB(t)
+		defer db.Close()
+
+		_, lastId, err := db.GetBalance(t.Context(), test.USER_ID)
+		require.NoError(t, err)
+		require.Greater(t, lastId, int64(0))
+	})
+
+	t.Run("failed get balance", func(t *testing.T) {
+		db := openDB(t)
+		db.Close()
+
+		_, _, err := db.GetBalance(t.Context(), test.USER_ID)
+		require.Error(t, err)
+		require.ErrorContains(t, err, "failed to query balance")
+	})
+}
+
 func TestClose(t *testing.T) {
 
 	t.Run("successfully closed", func(t *testing.T) {
-		db, _ := GetDB(test.DB_SCHEMA)
+		db := openDB(t)
 		err := db.Close()
 
-		require.Nil(t, instance)
 		require.Nil(t, err)
 	})
 
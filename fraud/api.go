@@ -0,0 +1,128 @@
+package fraud
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+	"transaction-management-system/auth"
+	"transaction-management-system/database"
+)
+
+const (
+	// Alerts returned without a limit parameter, and at most
+	defaultAlertLimit = 100
+	maxAlertLimit     = 1000
+)
+
+// Alert is an alert as served by the api
+type Alert struct {
+	Id             int64      `json:"id"`
+	UserId         int        `json:"user_id"`
+	TransactionId  int64      `json:"transaction_id"`
+	Rule           string     `json:"rule"`
+	Detail         string     `json:"detail"`
+	CreatedAt      time.Time  `json:"created_at"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy string     `json:"acknowledged_by,omitempty"`
+}
+
+// GetAlerts handles GET requests for the latest alerts, newest first. Scoped credentials
+// must filter by one of their users.
+func (d *Detector) GetAlerts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var filter database.AlertFilter
+
+	if uid := query.Get("user_id"); uid != "" {
+		userId, err := strconv.Atoi(uid)
+		if err != nil {
+			http.Error(w, "Invalid user id conversion", http.StatusBadRequest)
+			return
+		}
+		filter.UserId = &userId
+	}
+	if rule := query.Get("rule"); rule != "" {
+		if !slices.Contains(Rules, rule) {
+			http.Error(w, "Invalid rule. Must be 'velocity', 'large_win', 'win_without_bet' or 'structuring'", http.StatusBadRequest)
+			return
+		}
+		filter.Rule = &rule
+	}
+	if ack := query.Get("acknowledged"); ack != "" {
+		acknowledged, err := strconv.ParseBool(ack)
+		if err != nil {
+			http.Error(w, "Invalid acknowledged parameter", http.StatusBadRequest)
+			return
+		}
+		filter.Acknowledged = &acknowledged
+	}
+	limit := defaultAlertLimit
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxAlertLimit {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	if principal, ok := auth.FromContext(r.Context()); ok && principal.Scoped() {
+		if filter.UserId == nil || !principal.CanAccessUser(*filter.UserId) {
+			http.Error(w, "Access to user denied", http.StatusForbidden)
+			return
+		}
+	}
+
+	rows, err := d.Store.GetAlerts(r.Context(), filter, limit)
+	if err != nil {
+		http.Error(w, "Failed to retrieve alerts", http.StatusInternalServerError)
+		return
+	}
+	alerts := make([]Alert, len(rows))
+	for i, a := range rows {
+		alerts[i] = Alert{
+			Id:             a.Id,
+			UserId:         a.UserId,
+			TransactionId:  a.TransactionId,
+			Rule:           a.Rule,
+			Detail:         a.Detail,
+			CreatedAt:      a.CreatedAt,
+			AcknowledgedAt: a.AcknowledgedAt,
+			AcknowledgedBy: a.AcknowledgedBy,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alerts)
+}
+
+// AcknowledgeAlert handles POST requests marking an alert as handled
+func (d *Detector) AcknowledgeAlert(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		http.Error(w, "Invalid alert id", http.StatusBadRequest)
+		return
+	}
+
+	// The alert's user isn't known before it is read, so only unscoped credentials acknowledge
+	acknowledgedBy := "anonymous"
+	if principal, ok := auth.FromContext(r.Context()); ok {
+		if principal.Scoped() {
+			http.Error(w, "Scoped credentials can't acknowledge alerts", http.StatusForbidden)
+			return
+		}
+		acknowledgedBy = principal.Subject
+	}
+
+	if err := d.Store.AcknowledgeAlert(r.Context(), id, acknowledgedBy); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Alert not found or already acknowledged", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to acknowledge alert", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
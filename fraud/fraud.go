@@ -0,0 +1,394 @@
+// Package fraud flags suspicious patterns in the transaction stream as it is consumed:
+// bet velocity spikes, wins much larger than their stake, wins without a bet before them
+// and bets kept just under a threshold. Rules look back over sliding windows of each
+// user's recent transactions, kept in memory and seeded from the database when a user is
+// first seen, and matches are stored as alerts.
+package fraud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"transaction-management-system/config"
+	"transaction-management-system/database"
+)
+
+// Rule names, as stored in the alerts table
+const (
+	RuleVelocity      = "velocity"
+	RuleLargeWin      = "large_win"
+	RuleWinWithoutBet = "win_without_bet"
+	RuleStructuring   = "structuring"
+)
+
+// Rules lists the rule names
+var Rules = []string{RuleVelocity, RuleLargeWin, RuleWinWithoutBet, RuleStructuring}
+
+// VelocityRule flags more than MaxBets bets of a user within Window
+type VelocityRule struct {
+	Enabled bool            `json:"enabled"`
+	Window  config.Duration `json:"window"`
+	MaxBets int             `json:"max_bets"`
+}
+
+// LargeWinRule flags a win of at least MinAmount and more than Ratio times its stake,
+// the user's last bet within Window
+type LargeWinRule struct {
+	Enabled   bool            `json:"enabled"`
+	Window    config.Duration `json:"window"`
+	Ratio     float64         `json:"ratio"`
+	MinAmount float64         `json:"min_amount"`
+}
+
+// WinWithoutBetRule flags a win with no bet of the user within Window before it
+type WinWithoutBetRule struct {
+	Enabled bool            `json:"enabled"`
+	Window  config.Duration `json:"window"`
+}
+
+// StructuringRule flags MinBets bets of a user within Window that are under Threshold
+// by at most Margin, a share of the threshold
+type StructuringRule struct {
+	Enabled   bool            `json:"enabled"`
+	Window    config.Duration `json:"window"`
+	Threshold float64         `json:"threshold"`
+	Margin    float64         `json:"margin"`
+	MinBets   int             `json:"min_bets"`
+}
+
+// Config holds the rules, a rule left out of a JSON file keeps its defaults
+type Config struct {
+	Velocity      VelocityRule      `json:"velocity"`
+	LargeWin      LargeWinRule      `json:"large_win"`
+	WinWithoutBet WinWithoutBetRule `json:"win_without_bet"`
+	Structuring   StructuringRule   `json:"structuring"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Velocity:      VelocityRule{Enabled: true, Window: config.Duration(time.Minute), MaxBets: 30},
+		LargeWin:      LargeWinRule{Enabled: true, Window: config.Duration(10 * time.Minute), Ratio: 50, MinAmount: 100},
+		WinWithoutBet: WinWithoutBetRule{Enabled: true, Window: config.Duration(10 * time.Minute)},
+		Structuring:   StructuringRule{Enabled: true, Window: config.Duration(time.Hour), Threshold: 1000, Margin: 0.1, MinBets: 5},
+	}
+}
+
+// LoadConfig reads rules from a JSON file, falling back to the defaults for missing fields
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read fraud rules: %w", err)
+	}
+	cfg := DefaultConfig()
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("failed to parse fraud rules: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Validate checks the enabled rules can match
+func (cfg Config) Validate() error {
+	var errs []error
+	check := func(rule string, enabled bool, window config.Duration, ok bool, expected string) {
+		if !enabled {
+			return
+		}
+		if window <= 0 {
+			errs = append(errs, fmt.Errorf("invalid %s rule: window must be positive", rule))
+		}
+		if !ok {
+			errs = append(errs, fmt.Errorf("invalid %s rule: %s", rule, expected))
+		}
+	}
+	check(RuleVelocity, cfg.Velocity.Enabled, cfg.Velocity.Window, cfg.Velocity.MaxBets > 0,
+		"max_bets must be positive")
+	check(RuleLargeWin, cfg.LargeWin.Enabled, cfg.LargeWin.Window, cfg.LargeWin.Ratio > 0 && cfg.LargeWin.MinAmount >= 0,
+		"ratio must be positive and min_amount not negative")
+	check(RuleWinWithoutBet, cfg.WinWithoutBet.Enabled, cfg.WinWithoutBet.Window, true, "")
+	check(RuleStructuring, cfg.Structuring.Enabled, cfg.Structuring.Window,
+		cfg.Structuring.Threshold > 0 && cfg.Structuring.Margin > 0 && cfg.Structuring.Margin < 1 && cfg.Structuring.MinBets > 0,
+		"threshold and min_bets must be positive and margin between 0 and 1")
+	return errors.Join(errs...)
+}
+
+// window returns the longest window of the enabled rules, how long transactions are kept
+func (cfg Config) window() time.Duration {
+	var longest config.Duration
+	if cfg.Velocity.Enabled {
+		longest = max(longest, cfg.Velocity.Window)
+	}
+	if cfg.LargeWin.Enabled {
+		longest = max(longest, cfg.LargeWin.Window)
+	}
+	if cfg.WinWithoutBet.Enabled {
+		longest = max(longest, cfg.WinWithoutBet.Window)
+	}
+	if cfg.Structuring.Enabled {
+		longest = max(longest, cfg.Structuring.Window)
+	}
+	return time.Duration(longest)
+}
+
+// Store keeps the alerts and reads the users' recent transactions
+type Store interface {
+	InsertAlerts(ctx context.Context, alerts []database.Alert) error
+	GetAlerts(ctx context.Context, filter database.AlertFilter, limit int) ([]database.Alert, error)
+	AcknowledgeAlert(ctx context.Context, id int64, acknowledgedBy string) error
+	GetRecentTransactions(ctx context.Context, userId int, window time.Duration, beforeId int64, limit int) ([]database.RecentTransaction, error)
+}
+
+// Transaction is a stored transaction fed to the detector
+type Transaction struct {
+	Id     int64
+	UserId int
+	Type   string // "bet" or "win"
+	Amount float64
+}
+
+// Most transactions kept per user, bounding memory when a user floods the stream
+const maxEvents = 1000
+
+// Detector evaluates the rules over each user's recent transactions.
+// Windows are measured in the time transactions are observed, right after they are stored,
+// so the timestamp a client sets can't move a transaction out of a window. A user's history
+// is seeded from the stored transactions when first seen, so after a restart, or with other
+// consumers storing some of the user's transactions, the rules still see the earlier ones.
+// Later transactions of other consumers are only seen once the user's history is seeded again.
+type Detector struct {
+	Store  Store
+	Config Config
+
+	mu        sync.Mutex
+	users     map[int]*history
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// history is a user's transactions within the longest window
+type history struct {
+	events   []event
+	alerted  map[string]time.Time // time of the last alert per rule
+	lastSeen time.Time
+}
+
+// event is a transaction in a user's history, at the time it was observed or, for
+// the transactions the history was seeded with, stored
+type event struct {
+	Transaction
+	at time.Time
+}
+
+func newHistory() *history {
+	return &history{alerted: make(map[string]time.Time)}
+}
+
+func NewDetector(store Store, cfg Config) *Detector {
+	return &Detector{
+		Store:     store,
+		Config:    cfg,
+		users:     make(map[int]*history),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Observe evaluates the rules for a stored transaction and stores the alerts it raises.
+// A transaction observed before, e.g. redelivered, is skipped while in the user's history.
+func (d *Detector) Observe(ctx context.Context, t Transaction) ([]database.Alert, error) {
+	if err := d.seed(ctx, t); err != nil {
+		return nil, err
+	}
+	alerts := d.evaluate(t)
+	if len(alerts) == 0 {
+		return nil, nil
+	}
+	if err := d.Store.InsertAlerts(ctx, alerts); err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
+
+// seed loads the history of a user seen for the first time: the transactions stored within
+// the longest window before t, newest ones first when there are more than maxEvents
+func (d *Detector) seed(ctx context.Context, t Transaction) error {
+	d.mu.Lock()
+	_, known := d.users[t.UserId]
+	now := d.now()
+	d.mu.Unlock()
+	window := d.Config.window()
+	if known || window == 0 {
+		return nil
+	}
+
+	stored, err := d.Store.GetRecentTransactions(ctx, t.UserId, window, t.Id, maxEvents)
+	if err != nil {
+		return fmt.Errorf("failed to load the history of user %d: %w", t.UserId, err)
+	}
+	// Ages are measured on the database clock, the events are placed on ours
+	h := newHistory()
+	for i := len(stored) - 1; i >= 0; i-- {
+		s := stored[i]
+		h.add(event{Transaction{Id: s.Id, UserId: s.UserId, Type: s.TransactionType, Amount: s.Amount}, now.Add(-s.Age)})
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.users[t.UserId]; !ok {
+		h.lastSeen = now
+		d.users[t.UserId] = h
+	}
+	return nil
+}
+
+// evaluate adds the transaction to the user's history and returns the alerts it raises
+func (d *Detector) evaluate(t Transaction) []database.Alert {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	d.sweep(now)
+	h, ok := d.users[t.UserId]
+	if !ok {
+		h = newHistory()
+		d.users[t.UserId] = h
+	}
+	h.lastSeen = now
+	h.prune(now.Add(-d.Config.window()))
+	if h.has(t.Id) {
+		return nil
+	}
+	e := event{t, now}
+
+	var alerts []database.Alert
+	alert := func(rule, format string, args ...any) {
+		alerts = append(alerts, database.Alert{
+			UserId:        t.UserId,
+			TransactionId: t.Id,
+			Rule:          rule,
+			Detail:        fmt.Sprintf(format, args...),
+		})
+	}
+
+	switch t.Type {
+	case "bet":
+		h.add(e)
+		if r := d.Config.Velocity; r.Enabled {
+			window := time.Duration(r.Window)
+			bets := h.count(now, window, func(event) bool { return true })
+			if bets > r.MaxBets && h.rearm(RuleVelocity, now, window) {
+				alert(RuleVelocity, "%d bets within %s, more than %d", bets, window, r.MaxBets)
+			}
+		}
+		if r := d.Config.Structuring; r.Enabled && underThreshold(r, t.Amount) {
+			window := time.Duration(r.Window)
+			bets := h.count(now, window, func(e event) bool { return underThreshold(r, e.Amount) })
+			if bets >= r.MinBets && h.rearm(RuleStructuring, now, window) {
+				alert(RuleStructuring, "%d bets within %s just under %.2f", bets, window, r.Threshold)
+			}
+		}
+	case "win":
+		if r := d.Config.WinWithoutBet; r.Enabled {
+			window := time.Duration(r.Window)
+			if _, ok := h.lastBet(now, window); !ok {
+				alert(RuleWinWithoutBet, "win of %.2f without a bet within %s", t.Amount, window)
+			}
+		}
+		if r := d.Config.LargeWin; r.Enabled && t.Amount >= r.MinAmount {
+			if stake, ok := h.lastBet(now, time.Duration(r.Window)); ok && t.Amount > r.Ratio*stake.Amount {
+				alert(RuleLargeWin, "win of %.2f is more than %g times the stake of %.2f", t.Amount, r.Ratio, stake.Amount)
+			}
+		}
+		h.add(e)
+	}
+	return alerts
+}
+
+func underThreshold(r StructuringRule, amount float64) bool {
+	return amount < r.Threshold && amount >= r.Threshold*(1-r.Margin)
+}
+
+// sweep drops the users without transactions for the longest window, d.mu must be held
+func (d *Detector) sweep(now time.Time) {
+	window := d.Config.window()
+	if now.Sub(d.lastSweep) < window {
+		return
+	}
+	d.lastSweep = now
+	for userId, h := range d.users {
+		if now.Sub(h.lastSeen) >= window {
+			delete(d.users, userId)
+		}
+	}
+}
+
+// prune drops the transactions before cutoff
+func (h *history) prune(cutoff time.Time) {
+	kept := h.events[:0]
+	for _, e := range h.events {
+		if !e.at.Before(cutoff) {
+			kept = append(kept, e)
+		}
+	}
+	h.events = kept
+}
+
+func (h *history) add(e event) {
+	if len(h.events) == maxEvents {
+		h.events = append(h.events[:0], h.events[1:]...)
+	}
+	h.events = append(h.events, e)
+}
+
+// has reports whether the transaction is in the history
+func (h *history) has(id int64) bool {
+	for _, e := range h.events {
+		if e.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// within reports whether e is in the window ending at at
+func within(e event, at time.Time, window time.Duration) bool {
+	return !e.at.After(at) && at.Sub(e.at) < window
+}
+
+// count returns the bets in the window ending at at that match
+func (h *history) count(at time.Time, window time.Duration, match func(event) bool) int {
+	n := 0
+	for _, e := range h.events {
+		if e.Type == "bet" && within(e, at, window) && match(e) {
+			n++
+		}
+	}
+	return n
+}
+
+// lastBet returns the latest bet in the window ending at at
+func (h *history) lastBet(at time.Time, window time.Duration) (event, bool) {
+	var last event
+	found := false
+	for _, e := range h.events {
+		if e.Type == "bet" && within(e, at, window) && (!found || !e.at.Before(last.at)) {
+			last, found = e, true
+		}
+	}
+	return last, found
+}
+
+// rearm reports whether the rule may alert again, once per window, so a burst raises one alert
+func (h *history) rearm(rule string, at time.Time, window time.Duration) bool {
+	if last, ok := h.alerted[rule]; ok && at.Sub(last) < window && !at.Before(last) {
+		return false
+	}
+	h.alerted[rule] = at
+	return true
+}
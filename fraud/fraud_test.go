@@ -0,0 +1,331 @@
+package fraud
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"transaction-management-system/auth"
+	"transaction-management-system/config"
+	"transaction-management-system/database"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memoryAlerts is an in-memory Store, with the stored transactions set by the tests
+type memoryAlerts struct {
+	alerts []database.Alert
+	filter database.AlertFilter
+	stored []database.RecentTransaction // newest first
+	err    error
+}
+
+func (m *memoryAlerts) InsertAlerts(ctx context.Context, alerts []database.Alert) error {
+	if m.err != nil {
+		return m.err
+	}
+	for _, a := range alerts {
+		a.Id = int64(len(m.alerts) + 1)
+		m.alerts = append(m.alerts, a)
+	}
+	return nil
+}
+
+func (m *memoryAlerts) GetAlerts(ctx context.Context, filter database.AlertFilter, limit int) ([]database.Alert, error) {
+	m.filter = filter
+	return m.alerts, m.err
+}
+
+func (m *memoryAlerts) AcknowledgeAlert(ctx context.Context, id int64, acknowledgedBy string) error {
+	for i := range m.alerts {
+		if m.alerts[i].Id == id && m.alerts[i].AcknowledgedAt == nil {
+			now := time.Now()
+			m.alerts[i].AcknowledgedAt, m.alerts[i].AcknowledgedBy = &now, acknowledgedBy
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *memoryAlerts) GetRecentTransactions(ctx context.Context, userId int, window time.Duration, beforeId int64, limit int) ([]database.RecentTransaction, error) {
+	var transactions []database.RecentTransaction
+	for _, t := range m.stored {
+		if t.UserId == userId && t.Age <= window && t.Id < beforeId && len(transactions) < limit {
+			transactions = append(transactions, t)
+		}
+	}
+	return transactions, m.err
+}
+
+var start = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// observed is a transaction observed at an offset from start
+type observed struct {
+	Transaction
+	at time.Duration
+}
+
+// observe feeds transactions to the detector at their time and returns the rules of the raised alerts.
+// Ids follow the transactions' order, from 1.
+func observe(t *testing.T, d *Detector, transactions ...observed) []string {
+	t.Helper()
+	var rules []string
+	for i, tr := range transactions {
+		tr.Id = int64(i + 1)
+		if tr.UserId == 0 {
+			tr.UserId = 1
+		}
+		now := start.Add(tr.at)
+		d.now = func() time.Time { return now }
+		alerts, err := d.Observe(context.Background(), tr.Transaction)
+		require.NoError(t, err)
+		for _, a := range alerts {
+			rules = append(rules, a.Rule)
+		}
+	}
+	return rules
+}
+
+func bet(at time.Duration, amount float64) observed {
+	return observed{Transaction{Type: "bet", Amount: amount}, at}
+}
+
+func win(at time.Duration, amount float64) observed {
+	return observed{Transaction{Type: "win", Amount: amount}, at}
+}
+
+// only returns the default config with just the named rule enabled
+func only(rule string) Config {
+	cfg := DefaultConfig()
+	cfg.Velocity.Enabled = rule == RuleVelocity
+	cfg.LargeWin.Enabled = rule == RuleLargeWin
+	cfg.WinWithoutBet.Enabled = rule == RuleWinWithoutBet
+	cfg.Structuring.Enabled = rule == RuleStructuring
+	return cfg
+}
+
+func TestLoadConfig(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "rules.json")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	t.Run("successful partial override", func(t *testing.T) {
+		cfg, err := LoadConfig(write(t, `{"velocity": {"window": "30s"}, "structuring": {"enabled": false}}`))
+		require.NoError(t, err)
+		require.Equal(t, config.Duration(30*time.Second), cfg.Velocity.Window)
+		require.Equal(t, 30, cfg.Velocity.MaxBets)
+		require.False(t, cfg.Structuring.Enabled)
+		require.True(t, cfg.LargeWin.Enabled)
+	})
+
+	t.Run("failed invalid duration", func(t *testing.T) {
+		_, err := LoadConfig(write(t, `{"velocity": {"window": "soon"}}`))
+		require.ErrorContains(t, err, "failed to parse fraud rules")
+	})
+
+	t.Run("failed invalid rule", func(t *testing.T) {
+		_, err := LoadConfig(write(t, `{"structuring": {"margin": 1.5}, "velocity": {"max_bets": 0}}`))
+		require.ErrorContains(t, err, "invalid velocity rule")
+		require.ErrorContains(t, err, "invalid structuring rule")
+	})
+
+	t.Run("successful disabled rule isn't checked", func(t *testing.T) {
+		_, err := LoadConfig(write(t, `{"velocity": {"enabled": false, "max_bets": 0}}`))
+		require.NoError(t, err)
+	})
+
+	t.Run("failed missing file", func(t *testing.T) {
+		_, err := LoadConfig(filepath.Join(t.TempDir(), "missing.json"))
+		require.ErrorContains(t, err, "failed to read fraud rules")
+	})
+}
+
+func TestVelocity(t *testing.T) {
+	cfg := only(RuleVelocity)
+	cfg.Velocity.MaxBets = 3
+
+	t.Run("successful spread out bets", func(t *testing.T) {
+		d := NewDetector(&memoryAlerts{}, cfg)
+		require.Empty(t, observe(t, d, bet(0, 1), bet(30*time.Second, 1), bet(61*time.Second, 1), bet(90*time.Second, 1)))
+	})
+
+	t.Run("failed spike raises one alert per window", func(t *testing.T) {
+		store := &memoryAlerts{}
+		d := NewDetector(store, cfg)
+		rules := observe(t, d, bet(0, 1), bet(time.Second, 1), bet(2*time.Second, 1), bet(3*time.Second, 1), bet(4*time.Second, 1),
+			bet(2*time.Minute, 1), bet(2*time.Minute+time.Second, 1), bet(2*time.Minute+2*time.Second, 1), bet(2*time.Minute+3*time.Second, 1))
+		require.Equal(t, []string{RuleVelocity, RuleVelocity}, rules)
+		require.Equal(t, int64(4), store.alerts[0].TransactionId)
+		require.Equal(t, "4 bets within 1m0s, more than 3", store.alerts[0].Detail)
+	})
+
+	t.Run("successful users counted apart", func(t *testing.T) {
+		d := NewDetector(&memoryAlerts{}, cfg)
+		var transactions []observed
+		for user := 1; user <= 4; user++ {
+			tr := bet(time.Duration(user)*time.Second, 1)
+			tr.UserId = user
+			transactions = append(transactions, tr, tr, tr)
+		}
+		require.Empty(t, observe(t, d, transactions...))
+	})
+}
+
+func TestWins(t *testing.T) {
+	t.Run("failed win without bet", func(t *testing.T) {
+		d := NewDetector(&memoryAlerts{}, only(RuleWinWithoutBet))
+		require.Equal(t, []string{RuleWinWithoutBet}, observe(t, d, bet(0, 1), win(11*time.Minute, 2)))
+	})
+
+	t.Run("successful win after bet", func(t *testing.T) {
+		d := NewDetector(&memoryAlerts{}, only(RuleWinWithoutBet))
+		require.Empty(t, observe(t, d, bet(0, 1), win(time.Minute, 2)))
+	})
+
+	t.Run("failed large win", func(t *testing.T) {
+		store := &memoryAlerts{}
+		d := NewDetector(store, only(RuleLargeWin))
+		require.Equal(t, []string{RuleLargeWin}, observe(t, d, bet(0, 100), bet(time.Minute, 2), win(2*time.Minute, 500)))
+		require.Equal(t, "win of 500.00 is more than 50 times the stake of 2.00", store.alerts[0].Detail)
+	})
+
+	t.Run("successful large win under minimum amount", func(t *testing.T) {
+		d := NewDetector(&memoryAlerts{}, only(RuleLargeWin))
+		require.Empty(t, observe(t, d, bet(0, 0.5), win(time.Minute, 99)))
+	})
+
+	t.Run("successful win within ratio", func(t *testing.T) {
+		d := NewDetector(&memoryAlerts{}, only(RuleLargeWin))
+		require.Empty(t, observe(t, d, bet(0, 10), win(time.Minute, 500)))
+	})
+}
+
+func TestStructuring(t *testing.T) {
+	d := NewDetector(&memoryAlerts{}, only(RuleStructuring))
+	rules := observe(t, d,
+		bet(0, 950), bet(time.Minute, 999.99), bet(2*time.Minute, 500), bet(3*time.Minute, 1000),
+		bet(4*time.Minute, 900), bet(5*time.Minute, 960), bet(6*time.Minute, 990), bet(7*time.Minute, 970))
+	require.Equal(t, []string{RuleStructuring}, rules)
+}
+
+func TestObserve(t *testing.T) {
+	t.Run("failed store error", func(t *testing.T) {
+		d := NewDetector(&memoryAlerts{err: errors.New("connection refused")}, only(RuleWinWithoutBet))
+		_, err := d.Observe(context.Background(), Transaction{Id: 1, UserId: 1, Type: "win", Amount: 1})
+		require.ErrorContains(t, err, "failed to load the history of user 1")
+		require.Empty(t, d.users)
+	})
+
+	t.Run("successful idle users swept", func(t *testing.T) {
+		d := NewDetector(&memoryAlerts{}, DefaultConfig())
+		d.lastSweep = start
+		observe(t, d, bet(0, 1))
+		require.Len(t, d.users, 1)
+
+		other := bet(2*time.Hour, 1)
+		other.UserId = 2
+		observe(t, d, other)
+		require.Len(t, d.users, 1)
+	})
+
+	t.Run("successful history seeded from the stored transactions", func(t *testing.T) {
+		store := &memoryAlerts{stored: []database.RecentTransaction{
+			{Id: 3, UserId: 1, TransactionType: "win", Amount: 300, Age: time.Minute},
+			{Id: 2, UserId: 1, TransactionType: "bet", Amount: 2, Age: 2 * time.Minute},
+			{Id: 1, UserId: 1, TransactionType: "bet", Amount: 2, Age: 2 * time.Hour},
+		}}
+		d := NewDetector(store, DefaultConfig())
+		d.now = func() time.Time { return start }
+
+		// The stake stored before the restart counts, the transactions out of the window don't
+		alerts, err := d.Observe(context.Background(), Transaction{Id: 4, UserId: 1, Type: "win", Amount: 500})
+		require.NoError(t, err)
+		require.Len(t, alerts, 1)
+		require.Equal(t, RuleLargeWin, alerts[0].Rule)
+		require.Len(t, d.users[1].events, 3)
+	})
+
+	t.Run("successful seen transaction skipped", func(t *testing.T) {
+		store := &memoryAlerts{}
+		d := NewDetector(store, only(RuleWinWithoutBet))
+		d.now = func() time.Time { return start }
+		tr := Transaction{Id: 7, UserId: 1, Type: "win", Amount: 5}
+
+		alerts, err := d.Observe(context.Background(), tr)
+		require.NoError(t, err)
+		require.Len(t, alerts, 1)
+
+		alerts, err = d.Observe(context.Background(), tr)
+		require.NoError(t, err)
+		require.Empty(t, alerts)
+		require.Len(t, store.alerts, 1)
+	})
+}
+
+func TestAlertsApi(t *testing.T) {
+	store := &memoryAlerts{}
+	d := NewDetector(store, DefaultConfig())
+	observe(t, d, win(0, 5))
+
+	serve := func(handler http.HandlerFunc, req *http.Request, principal *auth.Principal) *httptest.ResponseRecorder {
+		if principal != nil {
+			req = req.WithContext(auth.NewContext(req.Context(), principal))
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+	admin := &auth.Principal{Subject: "key:1", Role: auth.RoleAdmin}
+	scoped := &auth.Principal{Subject: "key:2", Role: auth.RoleAdmin, UserIds: []int{1}}
+
+	t.Run("successful get alerts", func(t *testing.T) {
+		rr := serve(d.GetAlerts, httptest.NewRequest("GET", "/alerts?rule=win_without_bet&acknowledged=false", nil), admin)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Contains(t, rr.Body.String(), `"rule":"win_without_bet"`)
+		require.Equal(t, RuleWinWithoutBet, *store.filter.Rule)
+		require.False(t, *store.filter.Acknowledged)
+		require.Nil(t, store.filter.UserId)
+	})
+
+	t.Run("failed scoped credential without user", func(t *testing.T) {
+		rr := serve(d.GetAlerts, httptest.NewRequest("GET", "/alerts", nil), scoped)
+		require.Equal(t, http.StatusForbidden, rr.Code)
+
+		rr = serve(d.GetAlerts, httptest.NewRequest("GET", "/alerts?user_id=1", nil), scoped)
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("failed invalid parameters", func(t *testing.T) {
+		for _, query := range []string{"rule=other", "acknowledged=maybe", "limit=1001", "user_id=abc"} {
+			rr := serve(d.GetAlerts, httptest.NewRequest("GET", "/alerts?"+query, nil), admin)
+			require.Equal(t, http.StatusBadRequest, rr.Code, query)
+		}
+	})
+
+	acknowledge := func(id string, principal *auth.Principal) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/alerts/"+id+"/acknowledge", strings.NewReader(""))
+		req.SetPathValue("id", id)
+		return serve(d.AcknowledgeAlert, req, principal)
+	}
+
+	t.Run("failed scoped credential acknowledging", func(t *testing.T) {
+		require.Equal(t, http.StatusForbidden, acknowledge("1", scoped).Code)
+	})
+
+	t.Run("successful acknowledge", func(t *testing.T) {
+		require.Equal(t, http.StatusNoContent, acknowledge("1", admin).Code)
+		require.Equal(t, "key:1", store.alerts[0].AcknowledgedBy)
+	})
+
+	t.Run("failed acknowledge twice", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, acknowledge("1", admin).Code)
+	})
+}
@@ -0,0 +1,189 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds a stage's shutdown when the config doesn't set one
+const DefaultTimeout = 10 * time.Second
+
+// Stage is a component the supervisor runs and stops as one step of the shutdown
+type Stage struct {
+	Name string
+	// Run works until its context is cancelled or Shutdown makes it return.
+	// An error stops the supervisor. Stages without Run are only stopped.
+	Run func(ctx context.Context) error
+	// Shutdown stops the component gracefully before the deadline of ctx.
+	// Without Shutdown, stopping cancels the context of Run.
+	Shutdown func(ctx context.Context) error
+}
+
+// Config bounds the shutdown of each stage
+type Config struct {
+	// Timeout applies to stages missing from Timeouts
+	Timeout  time.Duration
+	Timeouts map[string]time.Duration
+}
+
+// DefaultConfig gives every stage DefaultTimeout
+func DefaultConfig() Config {
+	return Config{Timeout: DefaultTimeout, Timeouts: map[string]time.Duration{}}
+}
+
+// ConfigFromEnv reads SHUTDOWN_TIMEOUT for every stage and SHUTDOWN_<STAGE>_TIMEOUT
+// for the named stages, e.g. SHUTDOWN_CONSUMER_TIMEOUT=30s
+func ConfigFromEnv(stages ...string) (Config, error) {
+	cfg := DefaultConfig()
+	timeout, err := durationFromEnv("SHUTDOWN_TIMEOUT")
+	if err != nil {
+		return Config{}, err
+	}
+	if timeout > 0 {
+		cfg.Timeout = timeout
+	}
+	for _, stage := range stages {
+		timeout, err := durationFromEnv("SHUTDOWN_" + strings.ToUpper(stage) + "_TIMEOUT")
+		if err != nil {
+			return Config{}, err
+		}
+		if timeout > 0 {
+			cfg.Timeouts[stage] = timeout
+		}
+	}
+	return cfg, nil
+}
+
+func durationFromEnv(name string) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q, expected a positive duration like 10s", name, value)
+	}
+	return d, nil
+}
+
+func (c Config) timeout(stage string) time.Duration {
+	if timeout, ok := c.Timeouts[stage]; ok {
+		return timeout
+	}
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return DefaultTimeout
+}
+
+// Supervisor starts stages and stops them in the order they were added, so
+// a stage is stopped before the stages it depends on
+type Supervisor struct {
+	cfg    Config
+	stages []*stage
+}
+
+type stage struct {
+	Stage
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func New(cfg Config) *Supervisor {
+	return &Supervisor{cfg: cfg}
+}
+
+// Add appends a stage to the shutdown order
+func (s *Supervisor) Add(st Stage) {
+	s.stages = append(s.stages, &stage{Stage: st})
+}
+
+// Run starts the stages in reverse order and waits until ctx is done or a stage
+// fails, then stops every stage in order within its timeout. It returns the
+// failures of the stages and of their shutdown.
+func (s *Supervisor) Run(ctx context.Context) error {
+	failed := make(chan error, len(s.stages))
+	for i := len(s.stages) - 1; i >= 0; i-- {
+		st := s.stages[i]
+		if st.Run == nil {
+			continue
+		}
+		// Stages are stopped by the supervisor, not by ctx
+		runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		st.cancel, st.done = cancel, make(chan struct{})
+		go func() {
+			defer close(st.done)
+			if err := st.Run(runCtx); err != nil && !errors.Is(err, context.Canceled) {
+				failed <- fmt.Errorf("%s: %w", st.Name, err)
+			}
+		}()
+	}
+
+	var errs []error
+	select {
+	case <-ctx.Done():
+		slog.Info("Shutting down")
+	case err := <-failed:
+		slog.Error("Stage failed, shutting down", "error", err)
+		errs = append(errs, err)
+	}
+
+	for _, st := range s.stages {
+		if err := s.stop(st); err != nil {
+			slog.Error("Stage did not stop cleanly", "stage", st.Name, "error", err)
+			errs = append(errs, err)
+		}
+	}
+
+	// Failures while stopping
+	for len(failed) > 0 {
+		errs = append(errs, <-failed)
+	}
+	return errors.Join(errs...)
+}
+
+// stop shuts the stage down and waits until its Run returned, both within the stage's timeout
+func (s *Supervisor) stop(st *stage) error {
+	timeout := s.cfg.timeout(st.Name)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	slog.Info("Stopping", "stage", st.Name, "timeout", timeout)
+
+	var err error
+	if st.Shutdown != nil {
+		// Shutdown may ignore ctx, the deadline holds anyway
+		result := make(chan error, 1)
+		go func() { result <- st.Shutdown(ctx) }()
+		select {
+		case err = <-result:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	if st.done != nil {
+		// Cuts off whatever a graceful shutdown didn't finish
+		st.cancel()
+		select {
+		case <-st.done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("%s did not stop within %s", st.Name, timeout)
+		}
+		return fmt.Errorf("failed to stop %s: %w", st.Name, err)
+	}
+	slog.Info("Stopped", "stage", st.Name, "duration", time.Since(start))
+	return nil
+}
@@ -0,0 +1,157 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recorder records the order stages were stopped in
+type recorder struct {
+	mu      sync.Mutex
+	stopped []string
+}
+
+func (r *recorder) add(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = append(r.stopped, name)
+}
+
+// loop runs until its context is cancelled and records when it returned
+func (r *recorder) loop(name string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		<-ctx.Done()
+		r.add(name)
+		return ctx.Err()
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		t.Setenv("SHUTDOWN_TIMEOUT", "")
+		t.Setenv("SHUTDOWN_CONSUMER_TIMEOUT", "")
+		cfg, err := ConfigFromEnv("consumer")
+		require.NoError(t, err)
+		require.Equal(t, DefaultConfig(), cfg)
+		require.Equal(t, DefaultTimeout, cfg.timeout("consumer"))
+	})
+
+	t.Run("successful stage timeouts", func(t *testing.T) {
+		t.Setenv("SHUTDOWN_TIMEOUT", "3s")
+		t.Setenv("SHUTDOWN_CONSUMER_TIMEOUT", "30s")
+		cfg, err := ConfigFromEnv("consumer", "http")
+		require.NoError(t, err)
+		require.Equal(t, 30*time.Second, cfg.timeout("consumer"))
+		require.Equal(t, 3*time.Second, cfg.timeout("http"))
+	})
+
+	t.Run("failed invalid timeout", func(t *testing.T) {
+		t.Setenv("SHUTDOWN_CONSUMER_TIMEOUT", "soon")
+		_, err := ConfigFromEnv("consumer")
+		require.ErrorContains(t, err, "invalid SHUTDOWN_CONSUMER_TIMEOUT")
+
+		t.Setenv("SHUTDOWN_CONSUMER_TIMEOUT", "")
+		t.Setenv("SHUTDOWN_TIMEOUT", "-1s")
+		_, err = ConfigFromEnv("consumer")
+		require.ErrorContains(t, err, "invalid SHUTDOWN_TIMEOUT")
+	})
+}
+
+func TestRun(t *testing.T) {
+	t.Run("stages stop in order", func(t *testing.T) {
+		rec := &recorder{}
+		var stopper Stopper
+		s := New(DefaultConfig())
+		s.Add(Stage{Name: "intake", Run: rec.loop("intake")})
+		s.Add(Stage{
+			Name: "consumer",
+			Run: func(ctx context.Context) error {
+				defer stopper.Stopped()
+				<-stopper.Stopping()
+				rec.add("consumer")
+				return nil
+			},
+			Shutdown: stopper.Stop,
+		})
+		s.Add(Stage{Name: "database", Shutdown: func(ctx context.Context) error {
+			rec.add("database")
+			return nil
+		}})
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		require.NoError(t, s.Run(ctx))
+		require.Equal(t, []string{"intake", "consumer", "database"}, rec.stopped)
+	})
+
+	t.Run("failed stage stops the others", func(t *testing.T) {
+		rec := &recorder{}
+		s := New(DefaultConfig())
+		s.Add(Stage{Name: "intake", Run: rec.loop("intake")})
+		s.Add(Stage{Name: "consumer", Run: func(ctx context.Context) error {
+			return errors.New("consumer channel closed")
+		}})
+
+		err := s.Run(t.Context())
+		require.ErrorContains(t, err, "consumer: consumer channel closed")
+		require.Equal(t, []string{"intake"}, rec.stopped)
+	})
+
+	t.Run("stage past its deadline doesn't hold up the next", func(t *testing.T) {
+		rec := &recorder{}
+		s := New(Config{Timeout: time.Second, Timeouts: map[string]time.Duration{"http": 20 * time.Millisecond}})
+		s.Add(Stage{Name: "http", Shutdown: func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		}})
+		s.Add(Stage{Name: "database", Shutdown: func(ctx context.Context) error {
+			rec.add("database")
+			return nil
+		}})
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		start := time.Now()
+		err := s.Run(ctx)
+		require.ErrorContains(t, err, "http did not stop within 20ms")
+		require.Less(t, time.Since(start), time.Second)
+		require.Equal(t, []string{"database"}, rec.stopped)
+	})
+
+	t.Run("failed shutdown is reported", func(t *testing.T) {
+		s := New(DefaultConfig())
+		s.Add(Stage{Name: "database", Shutdown: func(ctx context.Context) error {
+			return errors.New("connection reset")
+		}})
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		require.ErrorContains(t, s.Run(ctx), "failed to stop database: connection reset")
+	})
+}
+
+func TestStopper(t *testing.T) {
+	t.Run("stop waits for the loop", func(t *testing.T) {
+		var s Stopper
+		go func() {
+			<-s.Stopping()
+			time.Sleep(10 * time.Millisecond)
+			s.Stopped()
+		}()
+		require.NoError(t, s.Stop(t.Context()))
+		// Stopping twice is fine
+		require.NoError(t, s.Stop(t.Context()))
+	})
+
+	t.Run("stop gives up at the deadline", func(t *testing.T) {
+		var s Stopper
+		ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, s.Stop(ctx), context.DeadlineExceeded)
+	})
+}
@@ -0,0 +1,47 @@
+package lifecycle
+
+import (
+	"context"
+	"sync"
+)
+
+// Stopper lets a long running loop stop gracefully: Stop asks the loop to
+// finish its work and waits until it called Stopped. The zero value is ready to use.
+type Stopper struct {
+	once     sync.Once
+	stopping chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func (s *Stopper) init() {
+	s.once.Do(func() {
+		s.stopping = make(chan struct{})
+		s.done = make(chan struct{})
+	})
+}
+
+// Stopping is closed once Stop was called
+func (s *Stopper) Stopping() <-chan struct{} {
+	s.init()
+	return s.stopping
+}
+
+// Stopped reports that the loop returned
+func (s *Stopper) Stopped() {
+	s.init()
+	s.doneOnce.Do(func() { close(s.done) })
+}
+
+// Stop asks the loop to stop and waits until it did or ctx is done
+func (s *Stopper) Stop(ctx context.Context) error {
+	s.init()
+	s.stopOnce.Do(func() { close(s.stopping) })
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,273 @@
+package limits
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+	"transaction-management-system/auth"
+)
+
+const (
+	// Longest self-exclusion in days
+	maxExclusionDays = 3650
+	// Entries returned by the history endpoints
+	historySize = 100
+)
+
+// setLimitRequest is the body of a limit update
+type setLimitRequest struct {
+	Amount *float64 `json:"amount"`
+}
+
+// excludeRequest is the body of a self-exclusion request
+type excludeRequest struct {
+	Days   int    `json:"days"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Change is a recorded limit change as served by the api
+type Change struct {
+	Kind        Kind      `json:"kind"`
+	Period      Period    `json:"period"`
+	OldAmount   *float64  `json:"old_amount"`
+	NewAmount   *float64  `json:"new_amount"`
+	RequestedBy string    `json:"requested_by"`
+	RequestedAt time.Time `json:"requested_at"`
+	EffectiveAt time.Time `json:"effective_at"`
+}
+
+// RecordedViolation is a recorded violation as served by the api
+type RecordedViolation struct {
+	Id            int64     `json:"id"`
+	TransactionId *int64    `json:"transaction_id,omitempty"`
+	Amount        float64   `json:"amount"`
+	Reason        Reason    `json:"reason"`
+	Period        Period    `json:"period,omitempty"`
+	Detail        string    `json:"detail"`
+	Action        Action    `json:"action"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// GetLimits handles GET requests for a player's limits and self-exclusion
+func (e *Engine) GetLimits(w http.ResponseWriter, r *http.Request) {
+	userId, ok := parseUser(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := e.Status(r.Context(), userId)
+	if err != nil {
+		http.Error(w, "Failed to retrieve limits", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// SetLimit handles PUT requests setting one of a player's limits
+func (e *Engine) SetLimit(w http.ResponseWriter, r *http.Request) {
+	userId, kind, period, ok := parseLimitPath(w, r)
+	if !ok {
+		return
+	}
+
+	var req setLimitRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Amount == nil {
+		http.Error(w, "Missing amount", http.StatusBadRequest)
+		return
+	}
+	if *req.Amount < 0 || math.IsInf(*req.Amount, 0) {
+		http.Error(w, "Invalid amount", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := e.UpdateLimit(r.Context(), userId, kind, period, req.Amount, requestedBy(r), mayLoosen(r, userId))
+	if err != nil {
+		if errors.Is(err, ErrLoosenDenied) {
+			http.Error(w, "Raising a limit requires the player's own credential or an admin", http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Failed to set limit", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, limit)
+}
+
+// RemoveLimit handles DELETE requests removing one of a player's limits after the cooling-off period
+func (e *Engine) RemoveLimit(w http.ResponseWriter, r *http.Request) {
+	userId, kind, period, ok := parseLimitPath(w, r)
+	if !ok {
+		return
+	}
+
+	limit, err := e.UpdateLimit(r.Context(), userId, kind, period, nil, requestedBy(r), mayLoosen(r, userId))
+	if err != nil {
+		if errors.Is(err, ErrNoLimit) {
+			http.Error(w, "Limit not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, ErrLoosenDenied) {
+			http.Error(w, "Removing a limit requires the player's own credential or an admin", http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Failed to remove limit", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, limit)
+}
+
+// GetLimitChanges handles GET requests for the history of a player's limit changes
+func (e *Engine) GetLimitChanges(w http.ResponseWriter, r *http.Request) {
+	userId, ok := parseUser(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := e.Store.GetLimitChanges(r.Context(), userId, historySize)
+	if err != nil {
+		http.Error(w, "Failed to retrieve limit changes", http.StatusInternalServerError)
+		return
+	}
+	changes := make([]Change, len(rows))
+	for i, c := range rows {
+		changes[i] = Change{
+			Kind:        Kind(c.Kind),
+			Period:      Period(c.Period),
+			OldAmount:   c.OldAmount,
+			NewAmount:   c.NewAmount,
+			RequestedBy: c.RequestedBy,
+			RequestedAt: c.RequestedAt,
+			EffectiveAt: c.EffectiveAt,
+		}
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
+
+// GetViolations handles GET requests for a player's latest limit violations
+func (e *Engine) GetViolations(w http.ResponseWriter, r *http.Request) {
+	userId, ok := parseUser(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := e.Store.GetLimitViolations(r.Context(), userId, historySize)
+	if err != nil {
+		http.Error(w, "Failed to retrieve limit violations", http.StatusInternalServerError)
+		return
+	}
+	violations := make([]RecordedViolation, len(rows))
+	for i, v := range rows {
+		violations[i] = RecordedViolation{
+			Id:            v.Id,
+			TransactionId: v.TransactionId,
+			Amount:        v.Amount,
+			Reason:        Reason(v.Reason),
+			Period:        Period(v.Period),
+			Detail:        v.Detail,
+			Action:        Action(v.Action),
+			CreatedAt:     v.CreatedAt,
+		}
+	}
+	writeJSON(w, http.StatusOK, violations)
+}
+
+// CreateExclusion handles POST requests starting a self-exclusion
+func (e *Engine) CreateExclusion(w http.ResponseWriter, r *http.Request) {
+	userId, ok := parseUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req excludeRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Days < 1 || req.Days > maxExclusionDays {
+		http.Error(w, "Invalid days. Must be between 1 and "+strconv.Itoa(maxExclusionDays), http.StatusBadRequest)
+		return
+	}
+
+	ex, err := e.Exclude(r.Context(), userId, time.Duration(req.Days)*24*time.Hour, req.Reason, requestedBy(r))
+	if err != nil {
+		http.Error(w, "Failed to store self exclusion", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, ex)
+}
+
+// parseUser reads the user_id path value and checks the caller may access the user
+func parseUser(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userId, err := strconv.Atoi(r.PathValue("user_id"))
+	if err != nil || userId < 1 {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return 0, false
+	}
+	if principal, ok := auth.FromContext(r.Context()); ok && principal.Scoped() && !principal.CanAccessUser(userId) {
+		http.Error(w, "Access to user denied", http.StatusForbidden)
+		return 0, false
+	}
+	return userId, true
+}
+
+// parseLimitPath reads the user_id, kind and period path values
+func parseLimitPath(w http.ResponseWriter, r *http.Request) (int, Kind, Period, bool) {
+	kind, err := ParseKind(r.PathValue("kind"))
+	if err != nil {
+		http.Error(w, "Invalid kind. Must be 'loss' or 'wager'", http.StatusBadRequest)
+		return 0, "", "", false
+	}
+	period, err := ParsePeriod(r.PathValue("period"))
+	if err != nil {
+		http.Error(w, "Invalid period. Must be 'daily', 'weekly' or 'monthly'", http.StatusBadRequest)
+		return 0, "", "", false
+	}
+	userId, ok := parseUser(w, r)
+	return userId, kind, period, ok
+}
+
+// decodeBody reads a JSON request body, rejecting unknown fields
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return false
+		}
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// mayLoosen reports whether the caller may raise or remove the player's limits: a credential
+// scoped to the player alone or an admin one. Lowering a limit and self-exclusion only need
+// the ingest permission. Anyone may when auth is disabled.
+func mayLoosen(r *http.Request, userId int) bool {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		return true
+	}
+	return principal.Can(auth.PermAdmin) || slices.Equal(principal.UserIds, []int{userId})
+}
+
+// requestedBy names the caller in the limit history, "anonymous" when auth is disabled
+func requestedBy(r *http.Request) string {
+	if principal, ok := auth.FromContext(r.Context()); ok {
+		return principal.Subject
+	}
+	return "anonymous"
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,436 @@
+// Package limits enforces responsible gambling limits: a player's loss and wager limits
+// over rolling daily, weekly and monthly periods, and self-exclusion periods.
+// Lowering a limit applies at once, raising or removing it only after a cooling-off period.
+package limits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+	"transaction-management-system/database"
+)
+
+// Kind is what a limit caps
+type Kind string
+
+const (
+	// Loss caps bets minus wins
+	Loss Kind = "loss"
+	// Wager caps the sum of bets
+	Wager Kind = "wager"
+)
+
+// ParseKind validates a limit kind
+func ParseKind(s string) (Kind, error) {
+	switch k := Kind(s); k {
+	case Loss, Wager:
+		return k, nil
+	}
+	return "", fmt.Errorf("unknown limit kind %q", s)
+}
+
+// Period is the rolling window a limit applies to
+type Period string
+
+const (
+	Daily   Period = "daily"
+	Weekly  Period = "weekly"
+	Monthly Period = "monthly"
+)
+
+// ParsePeriod validates a limit period
+func ParsePeriod(s string) (Period, error) {
+	switch p := Period(s); p {
+	case Daily, Weekly, Monthly:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown limit period %q", s)
+}
+
+// Duration returns the length of the rolling window
+func (p Period) Duration() time.Duration {
+	switch p {
+	case Weekly:
+		return 7 * 24 * time.Hour
+	case Monthly:
+		return 30 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// Default delay before an increase or removal of a limit takes effect
+const DefaultCoolingOff = 24 * time.Hour
+
+// Config configures the engine
+type Config struct {
+	// CoolingOff delays increases and removals of limits
+	CoolingOff time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{CoolingOff: DefaultCoolingOff}
+}
+
+// ConfigFromEnv reads LIMITS_COOLING_OFF, e.g. 72h
+func ConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	if value := os.Getenv("LIMITS_COOLING_OFF"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return Config{}, fmt.Errorf("invalid LIMITS_COOLING_OFF %q, expected a duration like 24h", value)
+		}
+		cfg.CoolingOff = d
+	}
+	return cfg, nil
+}
+
+// Reader reads what Evaluate needs to know about a player
+type Reader interface {
+	GetPlayerLimits(ctx context.Context, userId int) ([]database.PlayerLimit, error)
+	GetSelfExclusions(ctx context.Context, userId int, endsAfter time.Time) ([]database.SelfExclusion, error)
+	GetUsage(ctx context.Context, userId int, window time.Duration) (database.Usage, error)
+}
+
+// Store keeps the limits, self-exclusions and violations, and sums the players' bets
+type Store interface {
+	Reader
+	UpdatePlayerLimit(ctx context.Context, userId int, kind, period string, update func(current database.PlayerLimit) (database.PlayerLimit, database.LimitChange, error)) (database.PlayerLimit, error)
+	GetLimitChanges(ctx context.Context, userId int, limit int) ([]database.LimitChange, error)
+	InsertSelfExclusion(ctx context.Context, e database.SelfExclusion) (int64, error)
+	InsertLimitViolations(ctx context.Context, violations []database.LimitViolation) error
+	GetLimitViolations(ctx context.Context, userId int, limit int) ([]database.LimitViolation, error)
+}
+
+var (
+	// ErrNoLimit is returned when removing a limit the player doesn't have
+	ErrNoLimit = errors.New("no such limit")
+	// ErrLoosenDenied is returned when raising or removing a limit without being allowed to
+	ErrLoosenDenied = errors.New("raising or removing a limit is not allowed")
+)
+
+// Engine evaluates bets against the players' limits and manages them
+type Engine struct {
+	Store  Store
+	Config Config
+
+	now func() time.Time
+}
+
+func NewEngine(store Store, cfg Config) *Engine {
+	return &Engine{
+		Store:  store,
+		Config: cfg,
+		now:    time.Now,
+	}
+}
+
+// Bet is a bet to evaluate
+type Bet struct {
+	UserId int
+	Amount float64
+}
+
+// Reason is why a bet violates the limits
+type Reason string
+
+const (
+	ReasonSelfExclusion Reason = "self_exclusion"
+	ReasonLossLimit     Reason = "loss_limit"
+	ReasonWagerLimit    Reason = "wager_limit"
+)
+
+// Violation is a limit or self-exclusion a bet breaks
+type Violation struct {
+	Reason Reason
+	// Period, Limit and Used describe a broken limit, Used is what the player
+	// lost or wagered in the period before the bet
+	Period Period
+	Limit  float64
+	Used   float64
+	// Until is the end of a self-exclusion
+	Until time.Time
+}
+
+func (v Violation) String() string {
+	switch v.Reason {
+	case ReasonSelfExclusion:
+		return "self-excluded until " + v.Until.UTC().Format(time.RFC3339)
+	case ReasonLossLimit:
+		return fmt.Sprintf("%s loss limit of %.2f exceeded, %.2f lost", v.Period, v.Limit, v.Used)
+	}
+	return fmt.Sprintf("%s wager limit of %.2f exceeded, %.2f wagered", v.Period, v.Limit, v.Used)
+}
+
+// Evaluate checks the bets in order, each one counting toward the limits of the later ones,
+// and returns the violations of each bet, none for a bet within the limits.
+// Usage is summed over the periods ending now, from the transactions stored in them. Bets of
+// one player evaluated concurrently don't see each other, so both may pass. Bets to reject
+// are evaluated with EvaluateIn instead.
+func (e *Engine) Evaluate(ctx context.Context, bets []Bet) ([][]Violation, error) {
+	return e.EvaluateIn(ctx, e.Store, bets)
+}
+
+// EvaluateIn evaluates the bets like Evaluate, reading the players from r. With the
+// *database.LockedPlayers of CreateTransactions, the bets are evaluated against the usage
+// of the players' submissions stored before and stored only if they pass.
+func (e *Engine) EvaluateIn(ctx context.Context, r Reader, bets []Bet) ([][]Violation, error) {
+	now := e.now()
+	players := make(map[int]*player)
+	violations := make([][]Violation, len(bets))
+	for i, bet := range bets {
+		p, ok := players[bet.UserId]
+		if !ok {
+			var err error
+			if p, err = e.loadPlayer(ctx, r, bet.UserId, now); err != nil {
+				return nil, err
+			}
+			players[bet.UserId] = p
+		}
+
+		if p.excludedUntil.After(now) {
+			violations[i] = append(violations[i], Violation{Reason: ReasonSelfExclusion, Until: p.excludedUntil})
+		}
+		for _, l := range p.limits {
+			usage, err := p.usage(ctx, r, Period(l.Period))
+			if err != nil {
+				return nil, err
+			}
+			reason, used := ReasonWagerLimit, usage.Wagered
+			if Kind(l.Kind) == Loss {
+				reason, used = ReasonLossLimit, math.Max(usage.Wagered-usage.Won, 0)
+			}
+			used += p.pending
+			if cents(used+bet.Amount) > cents(*l.Amount) {
+				violations[i] = append(violations[i], Violation{Reason: reason, Period: Period(l.Period), Limit: *l.Amount, Used: used})
+			}
+		}
+		p.pending += bet.Amount
+	}
+	return violations, nil
+}
+
+// player holds what Evaluate knows about a player
+type player struct {
+	userId        int
+	limits        []database.PlayerLimit // in force, with an amount
+	excludedUntil time.Time
+	usages        map[Period]database.Usage
+	pending       float64 // bets evaluated before
+}
+
+func (e *Engine) loadPlayer(ctx context.Context, r Reader, userId int, now time.Time) (*player, error) {
+	p := &player{userId: userId, usages: make(map[Period]database.Usage)}
+
+	limits, err := r.GetPlayerLimits(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range limits {
+		if l = effective(l, now); l.Amount != nil {
+			p.limits = append(p.limits, l)
+		}
+	}
+
+	exclusions, err := r.GetSelfExclusions(ctx, userId, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, ex := range exclusions {
+		if !ex.StartsAt.After(now) && ex.EndsAt.After(p.excludedUntil) {
+			p.excludedUntil = ex.EndsAt
+		}
+	}
+	return p, nil
+}
+
+// usage returns the player's bets and wins stored in the period ending now, read once per period
+func (p *player) usage(ctx context.Context, r Reader, period Period) (database.Usage, error) {
+	if u, ok := p.usages[period]; ok {
+		return u, nil
+	}
+	u, err := r.GetUsage(ctx, p.userId, period.Duration())
+	if err != nil {
+		return database.Usage{}, err
+	}
+	p.usages[period] = u
+	return u, nil
+}
+
+// Action is what was done with a violating bet
+type Action string
+
+const (
+	// ActionRejected bets were refused at submission
+	ActionRejected Action = "rejected"
+	// ActionFlagged bets were stored, they already took place
+	ActionFlagged Action = "flagged"
+)
+
+// Record stores the violations of a bet. transactionId is the stored bet, 0 for a rejected one.
+func (e *Engine) Record(ctx context.Context, bet Bet, transactionId int64, action Action, violations []Violation) error {
+	rows := make([]database.LimitViolation, len(violations))
+	for i, v := range violations {
+		rows[i] = database.LimitViolation{
+			UserId: bet.UserId,
+			Amount: bet.Amount,
+			Reason: string(v.Reason),
+			Period: string(v.Period),
+			Detail: v.String(),
+			Action: string(action),
+		}
+		if transactionId != 0 {
+			rows[i].TransactionId = &transactionId
+		}
+	}
+	return e.Store.InsertLimitViolations(ctx, rows)
+}
+
+// Limit is a player's limit in force, with its pending increase or removal
+type Limit struct {
+	Kind    Kind           `json:"kind"`
+	Period  Period         `json:"period"`
+	Amount  float64        `json:"amount"`
+	Pending *PendingChange `json:"pending,omitempty"`
+}
+
+// PendingChange is an increase or removal waiting for the cooling-off period
+type PendingChange struct {
+	// Amount is the new limit, null for a removal
+	Amount      *float64  `json:"amount"`
+	EffectiveAt time.Time `json:"effective_at"`
+}
+
+// Exclusion is a self-exclusion period
+type Exclusion struct {
+	Id       int64     `json:"id"`
+	StartsAt time.Time `json:"starts_at"`
+	EndsAt   time.Time `json:"ends_at"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// Status is a player's limits in force and current self-exclusion
+type Status struct {
+	UserId    int        `json:"user_id"`
+	Limits    []Limit    `json:"limits"`
+	Exclusion *Exclusion `json:"exclusion"`
+}
+
+// Status returns the player's limits and the self-exclusion ending last, if one is in force
+func (e *Engine) Status(ctx context.Context, userId int) (Status, error) {
+	now := e.now()
+	status := Status{UserId: userId, Limits: []Limit{}}
+
+	limits, err := e.Store.GetPlayerLimits(ctx, userId)
+	if err != nil {
+		return Status{}, err
+	}
+	for _, l := range limits {
+		if limit, ok := newLimit(effective(l, now)); ok {
+			status.Limits = append(status.Limits, limit)
+		}
+	}
+
+	exclusions, err := e.Store.GetSelfExclusions(ctx, userId, now)
+	if err != nil {
+		return Status{}, err
+	}
+	for _, ex := range exclusions {
+		if !ex.StartsAt.After(now) {
+			status.Exclusion = newExclusion(ex)
+			break
+		}
+	}
+	return status, nil
+}
+
+// UpdateLimit sets the player's limit, or removes it when amount is nil. Lowering or adding
+// a limit applies at once and cancels a pending change, raising or removing it applies after
+// the cooling-off period, and only when mayLoosen. Every request is recorded with the subject requesting it.
+func (e *Engine) UpdateLimit(ctx context.Context, userId int, kind Kind, period Period, amount *float64, requestedBy string, mayLoosen bool) (Limit, error) {
+	now := e.now()
+	stored, err := e.Store.UpdatePlayerLimit(ctx, userId, string(kind), string(period), func(current database.PlayerLimit) (database.PlayerLimit, database.LimitChange, error) {
+		current = effective(current, now)
+		if current.Amount == nil && amount == nil {
+			return database.PlayerLimit{}, database.LimitChange{}, ErrNoLimit
+		}
+
+		next := current
+		effectiveAt := now
+		loosens := current.Amount != nil && (amount == nil || cents(*amount) > cents(*current.Amount))
+		if loosens && !mayLoosen {
+			return database.PlayerLimit{}, database.LimitChange{}, ErrLoosenDenied
+		}
+		if loosens {
+			effectiveAt = now.Add(e.Config.CoolingOff)
+			next.PendingAmount, next.PendingFrom = amount, &effectiveAt
+		} else {
+			next.Amount, next.PendingAmount, next.PendingFrom = amount, nil, nil
+		}
+		return next, database.LimitChange{
+			UserId:      userId,
+			Kind:        string(kind),
+			Period:      string(period),
+			OldAmount:   current.Amount,
+			NewAmount:   amount,
+			RequestedBy: requestedBy,
+			RequestedAt: now,
+			EffectiveAt: effectiveAt,
+		}, nil
+	})
+	if err != nil {
+		return Limit{}, err
+	}
+	// Without cooling-off the change is already due
+	limit, _ := newLimit(effective(stored, now))
+	return limit, nil
+}
+
+// Exclude starts a self-exclusion for the duration. It can't be shortened, only extended by another.
+func (e *Engine) Exclude(ctx context.Context, userId int, duration time.Duration, reason, requestedBy string) (Exclusion, error) {
+	now := e.now().UTC().Truncate(time.Second)
+	ex := database.SelfExclusion{
+		UserId:      userId,
+		StartsAt:    now,
+		EndsAt:      now.Add(duration),
+		Reason:      reason,
+		RequestedBy: requestedBy,
+	}
+	id, err := e.Store.InsertSelfExclusion(ctx, ex)
+	if err != nil {
+		return Exclusion{}, err
+	}
+	ex.Id = id
+	return *newExclusion(ex), nil
+}
+
+// effective returns the limit in force at now, applying a pending change that is due
+func effective(l database.PlayerLimit, now time.Time) database.PlayerLimit {
+	if l.PendingFrom != nil && !now.Before(*l.PendingFrom) {
+		l.Amount, l.PendingAmount, l.PendingFrom = l.PendingAmount, nil, nil
+	}
+	return l
+}
+
+// newLimit returns the limit as served by the api, false when no limit is in force
+func newLimit(l database.PlayerLimit) (Limit, bool) {
+	if l.Amount == nil {
+		return Limit{}, false
+	}
+	limit := Limit{Kind: Kind(l.Kind), Period: Period(l.Period), Amount: *l.Amount}
+	if l.PendingFrom != nil {
+		limit.Pending = &PendingChange{Amount: l.PendingAmount, EffectiveAt: *l.PendingFrom}
+	}
+	return limit, true
+}
+
+func newExclusion(ex database.SelfExclusion) *Exclusion {
+	return &Exclusion{Id: ex.Id, StartsAt: ex.StartsAt, EndsAt: ex.EndsAt, Reason: ex.Reason}
+}
+
+// cents rounds an amount to whole cents, so comparisons ignore float rounding errors
+func cents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
@@ -0,0 +1,389 @@
+package limits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"transaction-management-system/auth"
+	"transaction-management-system/database"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memoryStore is an in-memory Store, usage is set by the tests
+type memoryStore struct {
+	limits     map[string]database.PlayerLimit
+	changes    []database.LimitChange
+	exclusions []database.SelfExclusion
+	usage      database.Usage
+	violations []database.LimitViolation
+	err        error
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{limits: make(map[string]database.PlayerLimit)}
+}
+
+func limitKey(userId int, kind, period string) string {
+	return fmt.Sprintf("%d/%s/%s", userId, kind, period)
+}
+
+func (m *memoryStore) GetPlayerLimits(ctx context.Context, userId int) ([]database.PlayerLimit, error) {
+	var limits []database.PlayerLimit
+	for _, l := range m.limits {
+		if l.UserId == userId {
+			limits = append(limits, l)
+		}
+	}
+	return limits, m.err
+}
+
+func (m *memoryStore) UpdatePlayerLimit(ctx context.Context, userId int, kind, period string, update func(current database.PlayerLimit) (database.PlayerLimit, database.LimitChange, error)) (database.PlayerLimit, error) {
+	current, ok := m.limits[limitKey(userId, kind, period)]
+	if !ok {
+		current = database.PlayerLimit{UserId: userId, Kind: kind, Period: period}
+	}
+	limit, change, err := update(current)
+	if err != nil {
+		return database.PlayerLimit{}, err
+	}
+	m.limits[limitKey(userId, kind, period)] = limit
+	m.changes = append(m.changes, change)
+	return limit, nil
+}
+
+func (m *memoryStore) GetLimitChanges(ctx context.Context, userId int, limit int) ([]database.LimitChange, error) {
+	return m.changes, m.err
+}
+
+func (m *memoryStore) InsertSelfExclusion(ctx context.Context, e database.SelfExclusion) (int64, error) {
+	e.Id = int64(len(m.exclusions) + 1)
+	m.exclusions = append(m.exclusions, e)
+	return e.Id, m.err
+}
+
+func (m *memoryStore) GetSelfExclusions(ctx context.Context, userId int, endsAfter time.Time) ([]database.SelfExclusion, error) {
+	var exclusions []database.SelfExclusion
+	for _, e := range m.exclusions {
+		if e.UserId == userId && e.EndsAt.After(endsAfter) {
+			exclusions = append(exclusions, e)
+		}
+	}
+	return exclusions, m.err
+}
+
+func (m *memoryStore) GetUsage(ctx context.Context, userId int, window time.Duration) (database.Usage, error) {
+	return m.usage, m.err
+}
+
+func (m *memoryStore) InsertLimitViolations(ctx context.Context, violations []database.LimitViolation) error {
+	m.violations = append(m.violations, violations...)
+	return m.err
+}
+
+func (m *memoryStore) GetLimitViolations(ctx context.Context, userId int, limit int) ([]database.LimitViolation, error) {
+	return m.violations, m.err
+}
+
+// newTestEngine returns an engine over a memory store with a clock moved by the tests
+func newTestEngine() (*Engine, *memoryStore, *time.Time) {
+	store := newMemoryStore()
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	e := NewEngine(store, DefaultConfig())
+	e.now = func() time.Time { return now }
+	return e, store, &now
+}
+
+func amount(f float64) *float64 {
+	return &f
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Run("successful default", func(t *testing.T) {
+		os.Unsetenv("LIMITS_COOLING_OFF")
+		cfg, err := ConfigFromEnv()
+		require.NoError(t, err)
+		require.Equal(t, DefaultCoolingOff, cfg.CoolingOff)
+	})
+
+	t.Run("successful cooling off", func(t *testing.T) {
+		t.Setenv("LIMITS_COOLING_OFF", "72h")
+		cfg, err := ConfigFromEnv()
+		require.NoError(t, err)
+		require.Equal(t, 72*time.Hour, cfg.CoolingOff)
+	})
+
+	t.Run("failed invalid cooling off", func(t *testing.T) {
+		t.Setenv("LIMITS_COOLING_OFF", "a day")
+		_, err := ConfigFromEnv()
+		require.ErrorContains(t, err, "invalid LIMITS_COOLING_OFF")
+	})
+}
+
+func TestUpdateLimit(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("successful new limit applies at once", func(t *testing.T) {
+		e, store, now := newTestEngine()
+		limit, err := e.UpdateLimit(ctx, 1, Loss, Daily, amount(100), "tester", true)
+		require.NoError(t, err)
+		require.Equal(t, Limit{Kind: Loss, Period: Daily, Amount: 100}, limit)
+
+		require.Len(t, store.changes, 1)
+		require.Nil(t, store.changes[0].OldAmount)
+		require.Equal(t, 100.0, *store.changes[0].NewAmount)
+		require.Equal(t, "tester", store.changes[0].RequestedBy)
+		require.Equal(t, *now, store.changes[0].EffectiveAt)
+	})
+
+	t.Run("successful increase waits for the cooling off", func(t *testing.T) {
+		e, store, now := newTestEngine()
+		_, err := e.UpdateLimit(ctx, 1, Wager, Weekly, amount(100), "tester", true)
+		require.NoError(t, err)
+
+		limit, err := e.UpdateLimit(ctx, 1, Wager, Weekly, amount(500), "tester", true)
+		require.NoError(t, err)
+		require.Equal(t, 100.0, limit.Amount)
+		require.NotNil(t, limit.Pending)
+		require.Equal(t, 500.0, *limit.Pending.Amount)
+		require.Equal(t, now.Add(DefaultCoolingOff), limit.Pending.EffectiveAt)
+		require.Equal(t, now.Add(DefaultCoolingOff), store.changes[1].EffectiveAt)
+
+		// Due after the cooling off
+		*now = now.Add(DefaultCoolingOff)
+		status, err := e.Status(ctx, 1)
+		require.NoError(t, err)
+		require.Equal(t, []Limit{{Kind: Wager, Period: Weekly, Amount: 500}}, status.Limits)
+	})
+
+	t.Run("successful decrease cancels a pending increase", func(t *testing.T) {
+		e, _, _ := newTestEngine()
+		_, err := e.UpdateLimit(ctx, 1, Loss, Monthly, amount(100), "tester", true)
+		require.NoError(t, err)
+		_, err = e.UpdateLimit(ctx, 1, Loss, Monthly, amount(500), "tester", true)
+		require.NoError(t, err)
+
+		limit, err := e.UpdateLimit(ctx, 1, Loss, Monthly, amount(50), "tester", true)
+		require.NoError(t, err)
+		require.Equal(t, Limit{Kind: Loss, Period: Monthly, Amount: 50}, limit)
+	})
+
+	t.Run("successful removal waits for the cooling off", func(t *testing.T) {
+		e, _, now := newTestEngine()
+		_, err := e.UpdateLimit(ctx, 1, Loss, Daily, amount(100), "tester", true)
+		require.NoError(t, err)
+
+		limit, err := e.UpdateLimit(ctx, 1, Loss, Daily, nil, "tester", true)
+		require.NoError(t, err)
+		require.Equal(t, 100.0, limit.Amount)
+		require.Nil(t, limit.Pending.Amount)
+
+		*now = now.Add(DefaultCoolingOff)
+		status, err := e.Status(ctx, 1)
+		require.NoError(t, err)
+		require.Empty(t, status.Limits)
+	})
+
+	t.Run("failed loosening not allowed", func(t *testing.T) {
+		e, store, _ := newTestEngine()
+		_, err := e.UpdateLimit(ctx, 1, Loss, Daily, amount(100), "tester", false)
+		require.NoError(t, err)
+		_, err = e.UpdateLimit(ctx, 1, Loss, Daily, amount(50), "tester", false)
+		require.NoError(t, err)
+
+		_, err = e.UpdateLimit(ctx, 1, Loss, Daily, amount(500), "tester", false)
+		require.ErrorIs(t, err, ErrLoosenDenied)
+		_, err = e.UpdateLimit(ctx, 1, Loss, Daily, nil, "tester", false)
+		require.ErrorIs(t, err, ErrLoosenDenied)
+		require.Len(t, store.changes, 2)
+	})
+
+	t.Run("failed removing a missing limit", func(t *testing.T) {
+		e, store, _ := newTestEngine()
+		_, err := e.UpdateLimit(ctx, 1, Loss, Daily, nil, "tester", true)
+		require.ErrorIs(t, err, ErrNoLimit)
+		require.Empty(t, store.changes)
+	})
+}
+
+func TestEvaluate(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("successful within limits", func(t *testing.T) {
+		e, store, _ := newTestEngine()
+		store.usage = database.Usage{Wagered: 90, Won: 20}
+		_, err := e.UpdateLimit(ctx, 1, Loss, Daily, amount(100), "tester", true)
+		require.NoError(t, err)
+
+		violations, err := e.Evaluate(ctx, []Bet{{UserId: 1, Amount: 30}})
+		require.NoError(t, err)
+		require.Empty(t, violations[0])
+	})
+
+	t.Run("failed loss limit", func(t *testing.T) {
+		e, store, _ := newTestEngine()
+		store.usage = database.Usage{Wagered: 90, Won: 20}
+		_, err := e.UpdateLimit(ctx, 1, Loss, Daily, amount(100), "tester", true)
+		require.NoError(t, err)
+
+		violations, err := e.Evaluate(ctx, []Bet{{UserId: 1, Amount: 30.01}})
+		require.NoError(t, err)
+		require.Equal(t, []Violation{{Reason: ReasonLossLimit, Period: Daily, Limit: 100, Used: 70}}, violations[0])
+		require.Equal(t, "daily loss limit of 100.00 exceeded, 70.00 lost", violations[0][0].String())
+	})
+
+	t.Run("failed wager limit counting the earlier bets of the batch", func(t *testing.T) {
+		e, store, _ := newTestEngine()
+		store.usage = database.Usage{Wagered: 40}
+		_, err := e.UpdateLimit(ctx, 1, Wager, Weekly, amount(100), "tester", true)
+		require.NoError(t, err)
+
+		violations, err := e.Evaluate(ctx, []Bet{{UserId: 1, Amount: 30}, {UserId: 2, Amount: 500}, {UserId: 1, Amount: 30.5}})
+		require.NoError(t, err)
+		require.Empty(t, violations[0])
+		require.Empty(t, violations[1])
+		require.Equal(t, []Violation{{Reason: ReasonWagerLimit, Period: Weekly, Limit: 100, Used: 70}}, violations[2])
+	})
+
+	t.Run("successful pending increase not applied yet", func(t *testing.T) {
+		e, _, _ := newTestEngine()
+		_, err := e.UpdateLimit(ctx, 1, Wager, Daily, amount(10), "tester", true)
+		require.NoError(t, err)
+		_, err = e.UpdateLimit(ctx, 1, Wager, Daily, amount(1000), "tester", true)
+		require.NoError(t, err)
+
+		violations, err := e.Evaluate(ctx, []Bet{{UserId: 1, Amount: 20}})
+		require.NoError(t, err)
+		require.Len(t, violations[0], 1)
+	})
+
+	t.Run("failed self exclusion", func(t *testing.T) {
+		e, _, now := newTestEngine()
+		ex, err := e.Exclude(ctx, 1, 7*24*time.Hour, "break", "tester")
+		require.NoError(t, err)
+		require.Equal(t, now.Add(7*24*time.Hour), ex.EndsAt)
+
+		violations, err := e.Evaluate(ctx, []Bet{{UserId: 1, Amount: 1}})
+		require.NoError(t, err)
+		require.Equal(t, []Violation{{Reason: ReasonSelfExclusion, Until: ex.EndsAt}}, violations[0])
+
+		// Over once it ends
+		*now = ex.EndsAt
+		violations, err = e.Evaluate(ctx, []Bet{{UserId: 1, Amount: 1}})
+		require.NoError(t, err)
+		require.Empty(t, violations[0])
+	})
+
+	t.Run("failed store error", func(t *testing.T) {
+		e, store, _ := newTestEngine()
+		store.err = errors.New("connection refused")
+		_, err := e.Evaluate(ctx, []Bet{{UserId: 1, Amount: 1}})
+		require.Error(t, err)
+	})
+}
+
+func TestRecord(t *testing.T) {
+	e, store, _ := newTestEngine()
+	violations := []Violation{{Reason: ReasonWagerLimit, Period: Daily, Limit: 10, Used: 5}}
+
+	require.NoError(t, e.Record(context.Background(), Bet{UserId: 1, Amount: 6}, 0, ActionRejected, violations))
+	require.NoError(t, e.Record(context.Background(), Bet{UserId: 1, Amount: 6}, 42, ActionFlagged, violations))
+
+	require.Len(t, store.violations, 2)
+	require.Nil(t, store.violations[0].TransactionId)
+	require.Equal(t, "rejected", store.violations[0].Action)
+	require.Equal(t, "daily wager limit of 10.00 exceeded, 5.00 wagered", store.violations[0].Detail)
+	require.Equal(t, int64(42), *store.violations[1].TransactionId)
+	require.Equal(t, "flagged", store.violations[1].Action)
+}
+
+func TestLimitsApi(t *testing.T) {
+	e, store, _ := newTestEngine()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{user_id}/limits", e.GetLimits)
+	mux.HandleFunc("PUT /users/{user_id}/limits/{kind}/{period}", e.SetLimit)
+	mux.HandleFunc("DELETE /users/{user_id}/limits/{kind}/{period}", e.RemoveLimit)
+	mux.HandleFunc("GET /users/{user_id}/limits/changes", e.GetLimitChanges)
+	mux.HandleFunc("POST /users/{user_id}/exclusions", e.CreateExclusion)
+
+	serve := func(method, target, body string, principal *auth.Principal) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if principal != nil {
+			req = req.WithContext(auth.NewContext(req.Context(), principal))
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("successful set limit", func(t *testing.T) {
+		rr := serve("PUT", "/users/1/limits/loss/daily", `{"amount": 100}`, &auth.Principal{Subject: "key:1", Role: auth.RoleWriter})
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.JSONEq(t, `{"kind": "loss", "period": "daily", "amount": 100}`, rr.Body.String())
+		require.Equal(t, "key:1", store.changes[0].RequestedBy)
+	})
+
+	t.Run("successful get limits", func(t *testing.T) {
+		rr := serve("GET", "/users/1/limits", "", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.JSONEq(t, `{"user_id": 1, "limits": [{"kind": "loss", "period": "daily", "amount": 100}], "exclusion": null}`, rr.Body.String())
+	})
+
+	t.Run("successful create exclusion", func(t *testing.T) {
+		rr := serve("POST", "/users/1/exclusions", `{"days": 30}`, nil)
+		require.Equal(t, http.StatusCreated, rr.Code)
+		require.Equal(t, "anonymous", store.exclusions[0].RequestedBy)
+	})
+
+	t.Run("failed remove missing limit", func(t *testing.T) {
+		rr := serve("DELETE", "/users/1/limits/wager/daily", "", nil)
+		require.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("failed raise by a writer", func(t *testing.T) {
+		writer := &auth.Principal{Subject: "key:1", Role: auth.RoleWriter}
+		rr := serve("PUT", "/users/1/limits/loss/daily", `{"amount": 500}`, writer)
+		require.Equal(t, http.StatusForbidden, rr.Code)
+		rr = serve("DELETE", "/users/1/limits/loss/daily", "", writer)
+		require.Equal(t, http.StatusForbidden, rr.Code)
+
+		operator := &auth.Principal{Subject: "key:2", Role: auth.RoleWriter, UserIds: []int{1, 2}}
+		rr = serve("PUT", "/users/1/limits/loss/daily", `{"amount": 500}`, operator)
+		require.Equal(t, http.StatusForbidden, rr.Code)
+
+		rr = serve("PUT", "/users/1/limits/loss/daily", `{"amount": 80}`, writer)
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("successful raise by the player or an admin", func(t *testing.T) {
+		player := &auth.Principal{Subject: "key:3", Role: auth.RoleWriter, UserIds: []int{1}}
+		rr := serve("PUT", "/users/1/limits/loss/daily", `{"amount": 500}`, player)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Contains(t, rr.Body.String(), `"pending"`)
+
+		rr = serve("DELETE", "/users/1/limits/loss/daily", "", &auth.Principal{Subject: "key:4", Role: auth.RoleAdmin})
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("failed user outside scope", func(t *testing.T) {
+		rr := serve("GET", "/users/2/limits/changes", "", &auth.Principal{Role: auth.RoleReader, UserIds: []int{1}})
+		require.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("failed invalid path", func(t *testing.T) {
+		rr := serve("PUT", "/users/1/limits/deposit/daily", `{"amount": 100}`, nil)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "Invalid kind")
+	})
+
+	t.Run("failed exclusion too long", func(t *testing.T) {
+		rr := serve("POST", "/users/1/exclusions", `{"days": 3651}`, nil)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const (
+	// Header carries the correlation id of HTTP requests and responses
+	Header = "X-Correlation-ID"
+	// CorrelationKey is the attribute holding the correlation id in log records
+	CorrelationKey = "correlation_id"
+
+	// Longest accepted correlation id
+	maxCorrelationIDLength = 64
+)
+
+type correlationKey struct{}
+
+// WithCorrelationID returns a context carrying the correlation id
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the correlation id of ctx, or "" without one
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// NewCorrelationID returns a random 128 bit id in hex
+func NewCorrelationID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Ensure returns ctx with the given correlation id when it is valid, with
+// a new one otherwise, so every unit of work can be traced
+func Ensure(ctx context.Context, id string) context.Context {
+	if !valid(id) {
+		id = NewCorrelationID()
+	}
+	return WithCorrelationID(ctx, id)
+}
+
+// Middleware reads the correlation id of the X-Correlation-ID header, or
+// generates one, into the request context and echoes it in the response
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := Ensure(r.Context(), r.Header.Get(Header))
+		w.Header().Set(Header, CorrelationID(ctx))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// valid accepts ids of letters, digits and -_.: so client supplied ids can't forge log lines
+func valid(id string) bool {
+	if id == "" || len(id) > maxCorrelationIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	var seen string
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = CorrelationID(r.Context())
+	}))
+
+	t.Run("client id is kept", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/transactions", nil)
+		req.Header.Set(Header, "client-42")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, "client-42", seen)
+		require.Equal(t, "client-42", rr.Header().Get(Header))
+	})
+
+	t.Run("missing id is generated", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/transactions", nil))
+
+		require.Len(t, seen, 32)
+		require.Equal(t, seen, rr.Header().Get(Header))
+	})
+
+	t.Run("unsafe id is replaced", func(t *testing.T) {
+		for _, id := range []string{"a b", "line\nbreak", strings.Repeat("x", 65)} {
+			req := httptest.NewRequest("GET", "/transactions", nil)
+			req.Header.Set(Header, id)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			require.NotEqual(t, id, seen)
+			require.Len(t, seen, 32)
+		}
+	})
+}
+
+func TestEnsure(t *testing.T) {
+	require.Equal(t, "msg-1", CorrelationID(Ensure(t.Context(), "msg-1")))
+	require.NotEmpty(t, CorrelationID(Ensure(t.Context(), "")))
+	require.Empty(t, CorrelationID(t.Context()))
+	require.NotEqual(t, NewCorrelationID(), NewCorrelationID())
+}
@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Config selects the minimum level and the output format
+type Config struct {
+	Level  slog.Level
+	Format string
+}
+
+// DefaultConfig logs info and above as text
+func DefaultConfig() Config {
+	return Config{Level: slog.LevelInfo, Format: FormatText}
+}
+
+// ConfigFromEnv reads LOG_LEVEL (debug, info, warn or error) and LOG_FORMAT (text or json)
+func ConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		if err := cfg.Level.UnmarshalText([]byte(level)); err != nil {
+			return Config{}, fmt.Errorf("invalid LOG_LEVEL %q, expected debug, info, warn or error", level)
+		}
+	}
+	if format := os.Getenv("LOG_FORMAT"); format != "" {
+		if format != FormatText && format != FormatJSON {
+			return Config{}, fmt.Errorf("invalid LOG_FORMAT %q, expected text or json", format)
+		}
+		cfg.Format = format
+	}
+	return cfg, nil
+}
+
+// New returns a logger writing to w that adds the correlation id of the
+// context to every record logged with one
+func New(cfg Config, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+	var h slog.Handler
+	if cfg.Format == FormatJSON {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(&contextHandler{h})
+}
+
+// Setup makes a logger writing to stderr the default of slog and of the log package
+func Setup(cfg Config) {
+	slog.SetDefault(New(cfg, os.Stderr))
+}
+
+// contextHandler adds the correlation id of the record's context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := CorrelationID(ctx); id != "" {
+		r.AddAttrs(slog.String(CorrelationKey, id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
+}
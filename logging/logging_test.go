@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		t.Setenv("LOG_LEVEL", "")
+		t.Setenv("LOG_FORMAT", "")
+		cfg, err := ConfigFromEnv()
+		require.NoError(t, err)
+		require.Equal(t, DefaultConfig(), cfg)
+	})
+
+	t.Run("successful level and format", func(t *testing.T) {
+		t.Setenv("LOG_LEVEL", "debug")
+		t.Setenv("LOG_FORMAT", "json")
+		cfg, err := ConfigFromEnv()
+		require.NoError(t, err)
+		require.Equal(t, Config{Level: slog.LevelDebug, Format: FormatJSON}, cfg)
+	})
+
+	t.Run("failed invalid values", func(t *testing.T) {
+		t.Setenv("LOG_LEVEL", "loud")
+		_, err := ConfigFromEnv()
+		require.ErrorContains(t, err, "invalid LOG_LEVEL")
+
+		t.Setenv("LOG_LEVEL", "")
+		t.Setenv("LOG_FORMAT", "xml")
+		_, err = ConfigFromEnv()
+		require.ErrorContains(t, err, "invalid LOG_FORMAT")
+	})
+}
+
+func TestNew(t *testing.T) {
+	t.Run("json records carry the correlation id", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := New(Config{Level: slog.LevelInfo, Format: FormatJSON}, &buf)
+
+		ctx := WithCorrelationID(t.Context(), "abc-123")
+		logger.With("component", "test").InfoContext(ctx, "Inserted transaction", "id", 7)
+
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+		require.Equal(t, "Inserted transaction", record["msg"])
+		require.Equal(t, "abc-123", record[CorrelationKey])
+		require.Equal(t, "test", record["component"])
+		require.Equal(t, float64(7), record["id"])
+	})
+
+	t.Run("level filters records", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := New(Config{Level: slog.LevelWarn, Format: FormatText}, &buf)
+
+		logger.Info("hidden")
+		logger.Warn("shown")
+		require.NotContains(t, buf.String(), "hidden")
+		require.Contains(t, buf.String(), "msg=shown")
+		require.NotContains(t, buf.String(), CorrelationKey)
+	})
+}
@@ -0,0 +1,102 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"transaction-management-system/transaction"
+)
+
+// Content types of the wire formats, sent as the AMQP content type
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// Names of the wire formats, e.g. in MESSAGE_FORMAT
+const (
+	FormatLegacy   = "legacy"
+	FormatJSON     = "json"
+	FormatProtobuf = "protobuf"
+)
+
+// Codec encodes transactions in one wire format and decodes them strictly
+type Codec interface {
+	ContentType() string
+	Encode(t transaction.Transaction) ([]byte, error)
+	Decode(body []byte) (transaction.Transaction, error)
+}
+
+// JSON is the versioned JSON envelope, see Encode and Decode
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return ContentTypeJSON }
+
+func (jsonCodec) Encode(t transaction.Transaction) ([]byte, error) { return Encode(t) }
+
+func (jsonCodec) Decode(body []byte) (transaction.Transaction, error) { return Decode(body) }
+
+// Legacy is the bare JSON transaction published before envelopes, which consumers
+// that weren't upgraded still read. Decoding reads envelopes too, like JSON.
+var Legacy Codec = legacyCodec{}
+
+type legacyCodec struct{}
+
+func (legacyCodec) ContentType() string { return ContentTypeJSON }
+
+func (legacyCodec) Encode(t transaction.Transaction) ([]byte, error) {
+	body, err := json.Marshal(t)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal transaction: %w", err)
+	}
+	return body, nil
+}
+
+func (legacyCodec) Decode(body []byte) (transaction.Transaction, error) { return Decode(body) }
+
+var codecs = map[string]Codec{
+	ContentTypeJSON:     JSON,
+	ContentTypeProtobuf: Protobuf,
+}
+
+// ForFormat returns the codec of a format name. Envelopes are opt-in until every
+// consumer reads them, so an empty name is Legacy.
+func ForFormat(format string) (Codec, error) {
+	switch format {
+	case "", FormatLegacy:
+		return Legacy, nil
+	case FormatJSON:
+		return JSON, nil
+	case FormatProtobuf:
+		return Protobuf, nil
+	}
+	return nil, fmt.Errorf("unknown message format %q, expected %s, %s or %s", format, FormatLegacy, FormatJSON, FormatProtobuf)
+}
+
+// ForContentType returns the codec of a message's content type. Messages without
+// one are JSON, like everything published before the content type was read.
+func ForContentType(contentType string) (Codec, error) {
+	if contentType == "" {
+		return JSON, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, invalid("invalid content type %q: %v", contentType, err)
+	}
+	codec, ok := codecs[mediaType]
+	if !ok {
+		return nil, invalid("unsupported content type %q", contentType)
+	}
+	return codec, nil
+}
+
+// DecodeContentType decodes the body with the codec of its content type
+func DecodeContentType(contentType string, body []byte) (transaction.Transaction, error) {
+	codec, err := ForContentType(contentType)
+	if err != nil {
+		return transaction.Transaction{}, err
+	}
+	return codec.Decode(body)
+}
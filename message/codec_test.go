@@ -0,0 +1,205 @@
+package message
+
+import (
+	"testing"
+	"time"
+	"transaction-management-system/transaction"
+	"transaction-management-system/transactionpb"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestCodecs(t *testing.T) {
+	for name, codec := range map[string]Codec{FormatLegacy: Legacy, FormatJSON: JSON, FormatProtobuf: Protobuf} {
+		t.Run(name, func(t *testing.T) {
+			tr := newTransaction()
+			tr.Timestamp = tr.Timestamp.Add(123 * time.Millisecond)
+			body, err := codec.Encode(tr)
+			require.NoError(t, err)
+
+			decoded, err := DecodeContentType(codec.ContentType(), body)
+			require.NoError(t, err)
+			require.Equal(t, tr, decoded)
+
+			// Not stored yet
+			tr.Id = 0
+			body, err = codec.Encode(tr)
+			require.NoError(t, err)
+			decoded, err = codec.Decode(body)
+			require.NoError(t, err)
+			require.Equal(t, tr, decoded)
+		})
+	}
+}
+
+func TestForContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		codec       Codec
+	}{
+		{"", JSON},
+		{"application/json", JSON},
+		{"application/json; charset=utf-8", JSON},
+		{"application/x-protobuf", Protobuf},
+	}
+	for _, tt := range tests {
+		codec, err := ForContentType(tt.contentType)
+		require.NoError(t, err)
+		require.Equal(t, tt.codec, codec)
+	}
+
+	_, err := ForContentType("text/plain")
+	require.ErrorIs(t, err, ErrInvalid)
+	require.ErrorContains(t, err, `unsupported content type "text/plain"`)
+
+	_, err = DecodeContentType("application/json;", []byte(`{}`))
+	require.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestForFormat(t *testing.T) {
+	codec, err := ForFormat("")
+	require.NoError(t, err)
+	require.Equal(t, Legacy, codec)
+
+	codec, err = ForFormat("json")
+	require.NoError(t, err)
+	require.Equal(t, JSON, codec)
+
+	codec, err = ForFormat("protobuf")
+	require.NoError(t, err)
+	require.Equal(t, Protobuf, codec)
+
+	_, err = ForFormat("avro")
+	require.ErrorContains(t, err, `unknown message format "avro"`)
+}
+
+// protobufBody marshals an envelope around the transaction, extra is appended to the transaction's fields
+func protobufBody(t *testing.T, version uint32, tx *transactionpb.Transaction, extra ...byte) []byte {
+	t.Helper()
+	payload, err := proto.Marshal(tx)
+	require.NoError(t, err)
+	payload = append(payload, extra...)
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(version))
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, TypeTransaction)
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	return protowire.AppendBytes(b, payload)
+}
+
+func TestProtobufDecode(t *testing.T) {
+	valid, err := Protobuf.Encode(newTransaction())
+	require.NoError(t, err)
+
+	// tx returns a valid transaction message, changed by edit
+	tx := func(edit func(tx *transactionpb.Transaction)) *transactionpb.Transaction {
+		m := &transactionpb.Transaction{
+			UserId:          proto.Int64(3),
+			TransactionType: transactionpb.TransactionType_TRANSACTION_TYPE_BET,
+			Amount:          proto.Float64(2.5),
+			Timestamp:       timestamppb.New(time.Now().Truncate(time.Second)),
+		}
+		if edit != nil {
+			edit(m)
+		}
+		return m
+	}
+
+	tr, err := Protobuf.Decode(protobufBody(t, Version, tx(nil)))
+	require.NoError(t, err)
+	require.Equal(t, transaction.BET, tr.TransactionType)
+	require.Equal(t, 2.5, tr.Amount)
+
+	unknownField := protowire.AppendVarint(protowire.AppendTag(nil, 9, protowire.VarintType), 1)
+	tests := []struct {
+		name string
+		body []byte
+		err  string
+	}{
+		{"truncated", valid[:len(valid)-3], "invalid envelope"},
+		{"unknown envelope field", append(unknownField, valid...), "unknown field or wrong wire type in the envelope"},
+		{"wrong wire type", protowire.AppendVarint(protowire.AppendTag(nil, 2, protowire.VarintType), 1), "unknown field or wrong wire type in the envelope"},
+		{"missing version", valid[2:], "schema_version is required"},
+		{"older version", protobufBody(t, VersionLegacy, tx(nil)), "unsupported schema version 1"},
+		{"unknown payload field", protobufBody(t, Version, tx(nil), unknownField...), "unknown field or wrong wire type in the payload"},
+		{"missing user id", protobufBody(t, Version, tx(func(tx *transactionpb.Transaction) { tx.UserId = nil })), "user_id is required"},
+		{"unspecified type", protobufBody(t, Version, tx(func(tx *transactionpb.Transaction) { tx.TransactionType = 0 })), "transaction_type is required"},
+		{"unknown type", protobufBody(t, Version, tx(func(tx *transactionpb.Transaction) { tx.TransactionType = 7 })), "unknown transaction_type 7"},
+		{"missing amount", protobufBody(t, Version, tx(func(tx *transactionpb.Transaction) { tx.Amount = nil })), "amount is required"},
+		{"missing timestamp", protobufBody(t, Version, tx(func(tx *transactionpb.Transaction) { tx.Timestamp = nil })), "timestamp is required"},
+		{"invalid timestamp", protobufBody(t, Version, tx(func(tx *transactionpb.Transaction) { tx.Timestamp.Nanos = -1 })), "timestamp"},
+		{"invalid amount", protobufBody(t, Version, tx(func(tx *transactionpb.Transaction) { tx.Amount = proto.Float64(1.005) })), "more than 2 decimals"},
+	}
+	for _, tt := range tests {
+		t.Run("failed "+tt.name, func(t *testing.T) {
+			_, err := Protobuf.Decode(tt.body)
+			require.ErrorIs(t, err, ErrInvalid)
+			require.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+// Messages must read the same with the generated types as with the codec
+func TestProtobufSchema(t *testing.T) {
+	tr := newTransaction()
+	tr.Timestamp = tr.Timestamp.Add(5 * time.Microsecond)
+
+	body, err := Protobuf.Encode(tr)
+	require.NoError(t, err)
+	var env transactionpb.Envelope
+	require.NoError(t, proto.Unmarshal(body, &env))
+	require.EqualValues(t, Version, env.GetSchemaVersion())
+	require.Equal(t, TypeTransaction, env.GetType())
+
+	payload := env.GetPayload()
+	require.Equal(t, tr.Id, payload.GetId())
+	require.EqualValues(t, tr.UserId, payload.GetUserId())
+	require.Equal(t, transactionpb.TransactionType_TRANSACTION_TYPE_WIN, payload.GetTransactionType())
+	require.Equal(t, tr.Amount, payload.GetAmount())
+	require.Equal(t, tr.Timestamp, payload.GetTimestamp().AsTime())
+
+	// The envelope is registered under the package of transaction.proto
+	require.Equal(t, "transactions.v1.Envelope", string(env.ProtoReflect().Descriptor().FullName()))
+}
+
+func BenchmarkEncode(b *testing.B) {
+	tr := newTransaction()
+	for _, codec := range []Codec{JSON, Protobuf} {
+		b.Run(codec.ContentType(), func(b *testing.B) {
+			var size int
+			b.ReportAllocs()
+			for b.Loop() {
+				body, err := codec.Encode(tr)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(body)
+			}
+			b.ReportMetric(float64(size), "bytes/msg")
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	tr := newTransaction()
+	for _, codec := range []Codec{JSON, Protobuf} {
+		b.Run(codec.ContentType(), func(b *testing.B) {
+			body, err := codec.Encode(tr)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			for b.Loop() {
+				if _, err := codec.Decode(body); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package message
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"transaction-management-system/transaction"
+)
+
+// Schema versions of the transaction payload
+const (
+	// VersionLegacy is a bare transaction body, published before envelopes
+	VersionLegacy = 1
+	// Version is written by Encode
+	Version = 2
+)
+
+// TypeTransaction is the type of a message carrying a transaction
+const TypeTransaction = "transaction"
+
+// ErrInvalid is returned for messages that can't be decoded or fail validation,
+// redelivering them can't succeed
+var ErrInvalid = errors.New("invalid message")
+
+// Envelope wraps a payload with its schema version and type
+type Envelope struct {
+	SchemaVersion int             `json:"schema_version"`
+	Type          string          `json:"type"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// Upcaster rewrites a payload of one schema version as the next version
+type Upcaster func(payload json.RawMessage) (json.RawMessage, error)
+
+// upcasters by the version they upgrade from, one for every version below Version
+var upcasters = map[int]Upcaster{
+	VersionLegacy: upcastLegacy,
+}
+
+// payload is the current transaction schema. Pointers tell missing fields from zero values.
+type payload struct {
+	Id              int64      `json:"id,omitempty"`
+	UserId          *int       `json:"user_id"`
+	TransactionType *string    `json:"transaction_type"`
+	Amount          *float64   `json:"amount"`
+	Timestamp       *time.Time `json:"timestamp"`
+}
+
+// Encode wraps the transaction in an envelope of the current version
+func Encode(t transaction.Transaction) ([]byte, error) {
+	body, err := json.Marshal(payload{
+		Id:              t.Id,
+		UserId:          &t.UserId,
+		TransactionType: &t.TransactionType,
+		Amount:          &t.Amount,
+		Timestamp:       &t.Timestamp,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal transaction: %w", err)
+	}
+	return json.Marshal(Envelope{SchemaVersion: Version, Type: TypeTransaction, Payload: body})
+}
+
+// Decode reads an envelope, or a legacy bare transaction, upcasts its payload
+// to the current version and validates the transaction. Unknown fields, missing
+// fields and invalid values are rejected with ErrInvalid.
+func Decode(body []byte) (transaction.Transaction, error) {
+	env, err := decodeEnvelope(body)
+	if err != nil {
+		return transaction.Transaction{}, err
+	}
+	if env.Type != TypeTransaction {
+		return transaction.Transaction{}, invalid("unknown message type %q", env.Type)
+	}
+	if env.SchemaVersion < VersionLegacy || env.SchemaVersion > Version {
+		return transaction.Transaction{}, invalid("unsupported schema version %d, expected %d to %d", env.SchemaVersion, VersionLegacy, Version)
+	}
+
+	data := env.Payload
+	for v := env.SchemaVersion; v < Version; v++ {
+		data, err = upcasters[v](data)
+		if err != nil {
+			return transaction.Transaction{}, invalid("failed to upcast schema version %d: %v", v, err)
+		}
+	}
+
+	var p payload
+	if err := strict(data, &p); err != nil {
+		return transaction.Transaction{}, invalid("invalid payload: %v", err)
+	}
+	t, err := p.transaction()
+	if err != nil {
+		return transaction.Transaction{}, invalid("%v", err)
+	}
+	if err := t.Validate(); err != nil {
+		return transaction.Transaction{}, invalid("%v", err)
+	}
+	return t, nil
+}
+
+// decodeEnvelope reads the envelope. Bodies without a schema version are legacy transactions.
+func decodeEnvelope(body []byte) (Envelope, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return Envelope{}, invalid("%v", err)
+	}
+	if _, ok := fields["schema_version"]; !ok {
+		return Envelope{SchemaVersion: VersionLegacy, Type: TypeTransaction, Payload: body}, nil
+	}
+
+	var env Envelope
+	if err := strict(body, &env); err != nil {
+		return Envelope{}, invalid("invalid envelope: %v", err)
+	}
+	if len(env.Payload) == 0 || bytes.Equal(env.Payload, []byte("null")) {
+		return Envelope{}, invalid("payload is required")
+	}
+	return env, nil
+}
+
+// transaction checks that every required field is present
+func (p payload) transaction() (transaction.Transaction, error) {
+	switch {
+	case p.UserId == nil:
+		return transaction.Transaction{}, errors.New("user_id is required")
+	case p.TransactionType == nil:
+		return transaction.Transaction{}, errors.New("transaction_type is required")
+	case p.Amount == nil:
+		return transaction.Transaction{}, errors.New("amount is required")
+	case p.Timestamp == nil:
+		return transaction.Transaction{}, errors.New("timestamp is required")
+	}
+	return transaction.Transaction{
+		Id:              p.Id,
+		UserId:          *p.UserId,
+		TransactionType: *p.TransactionType,
+		Amount:          *p.Amount,
+		Timestamp:       *p.Timestamp,
+	}, nil
+}
+
+// upcastLegacy keeps the fields of a bare transaction and drops the rest,
+// which legacy consumers ignored
+func upcastLegacy(data json.RawMessage) (json.RawMessage, error) {
+	var p payload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	return json.Marshal(p)
+}
+
+// strict decodes a single JSON value rejecting unknown fields
+func strict(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("unexpected data after the JSON value")
+	}
+	return nil
+}
+
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalid, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,92 @@
+package message
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+	"transaction-management-system/transaction"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTransaction() transaction.Transaction {
+	return transaction.Transaction{
+		Id:              7,
+		UserId:          3,
+		TransactionType: transaction.WIN,
+		Amount:          12.5,
+		Timestamp:       time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestEncode(t *testing.T) {
+	body, err := Encode(newTransaction())
+	require.NoError(t, err)
+
+	var env Envelope
+	require.NoError(t, json.Unmarshal(body, &env))
+	require.Equal(t, Version, env.SchemaVersion)
+	require.Equal(t, TypeTransaction, env.Type)
+	require.JSONEq(t, `{"id":7,"user_id":3,"transaction_type":"win","amount":12.5,"timestamp":"2025-06-01T12:00:00Z"}`, string(env.Payload))
+
+	tr, err := Decode(body)
+	require.NoError(t, err)
+	require.Equal(t, newTransaction(), tr)
+}
+
+func TestDecode(t *testing.T) {
+	t.Run("successful legacy transaction", func(t *testing.T) {
+		// Published before envelopes, fields legacy consumers ignored are dropped
+		tr, err := Decode([]byte(`{"user_id":3,"transaction_type":"win","amount":12.5,"timestamp":"2025-06-01T12:00:00Z","source":"old"}`))
+		require.NoError(t, err)
+		expected := newTransaction()
+		expected.Id = 0
+		require.Equal(t, expected, tr)
+	})
+
+	t.Run("successful legacy version in an envelope", func(t *testing.T) {
+		tr, err := Decode([]byte(`{"schema_version":1,"type":"transaction","payload":{"id":7,"user_id":3,"transaction_type":"win","amount":12.5,"timestamp":"2025-06-01T12:00:00Z","source":"old"}}`))
+		require.NoError(t, err)
+		require.Equal(t, newTransaction(), tr)
+	})
+
+	payload := `{"user_id":3,"transaction_type":"win","amount":12.5,"timestamp":"2025-06-01T12:00:00Z"}`
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	tests := []struct {
+		name string
+		body string
+		err  string
+	}{
+		{"not json", `not json`, "invalid character"},
+		{"unknown envelope field", `{"schema_version":2,"type":"transaction","payload":` + payload + `,"extra":1}`, `unknown field "extra"`},
+		{"missing payload", `{"schema_version":2,"type":"transaction"}`, "payload is required"},
+		{"unknown type", `{"schema_version":2,"type":"refund","payload":` + payload + `}`, `unknown message type "refund"`},
+		{"newer version", `{"schema_version":3,"type":"transaction","payload":` + payload + `}`, "unsupported schema version 3"},
+		{"older version", `{"schema_version":0,"type":"transaction","payload":` + payload + `}`, "unsupported schema version 0"},
+		{"unknown payload field", `{"schema_version":2,"type":"transaction","payload":{"user_id":3,"transaction_type":"win","amount":12.5,"timestamp":"2025-06-01T12:00:00Z","source":"new"}}`, `unknown field "source"`},
+		{"trailing data", `{"schema_version":2,"type":"transaction","payload":` + payload + `} {}`, "after top-level value"},
+		{"missing user id", `{"transaction_type":"win","amount":12.5,"timestamp":"2025-06-01T12:00:00Z"}`, "user_id is required"},
+		{"missing amount", `{"schema_version":2,"type":"transaction","payload":{"user_id":3,"transaction_type":"win","timestamp":"2025-06-01T12:00:00Z"}}`, "amount is required"},
+		{"missing timestamp", `{"user_id":3,"transaction_type":"win","amount":12.5}`, "timestamp is required"},
+		{"zero user id", `{"user_id":0,"transaction_type":"win","amount":12.5,"timestamp":"2025-06-01T12:00:00Z"}`, "user_id must be positive"},
+		{"unknown transaction type", `{"user_id":3,"transaction_type":"refund","amount":12.5,"timestamp":"2025-06-01T12:00:00Z"}`, "unknown transaction_type"},
+		{"negative amount", `{"user_id":3,"transaction_type":"win","amount":-1,"timestamp":"2025-06-01T12:00:00Z"}`, "amount can't be negative"},
+		{"three decimals", `{"user_id":3,"transaction_type":"win","amount":1.005,"timestamp":"2025-06-01T12:00:00Z"}`, "more than 2 decimals"},
+		{"ancient timestamp", `{"user_id":3,"transaction_type":"win","amount":1,"timestamp":"1970-01-01T00:00:00Z"}`, "timestamp can't be before"},
+		{"future timestamp", `{"user_id":3,"transaction_type":"win","amount":1,"timestamp":"` + future + `"}`, "timestamp can't be in the future"},
+	}
+	for _, tt := range tests {
+		t.Run("failed "+tt.name, func(t *testing.T) {
+			_, err := Decode([]byte(tt.body))
+			require.ErrorIs(t, err, ErrInvalid)
+			require.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestUpcasters(t *testing.T) {
+	// Every older version needs a path to the current one
+	for v := VersionLegacy; v < Version; v++ {
+		require.Contains(t, upcasters, v, "missing upcaster from version %d", v)
+	}
+}
@@ -0,0 +1,115 @@
+package message
+
+import (
+	"fmt"
+	"transaction-management-system/transaction"
+	"transaction-management-system/transactionpb"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Protobuf is the Envelope of transactionpb/transaction.proto
+var Protobuf Codec = protobufCodec{}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string { return ContentTypeProtobuf }
+
+// Encode writes an Envelope of the current version, a zero id is left out
+func (protobufCodec) Encode(t transaction.Transaction) ([]byte, error) {
+	if _, ok := transaction.ProtoType(t.TransactionType); !ok {
+		return nil, fmt.Errorf("failed to marshal transaction: unknown transaction_type %q", t.TransactionType)
+	}
+
+	body, err := proto.Marshal(&transactionpb.Envelope{
+		SchemaVersion: Version,
+		Type:          TypeTransaction,
+		Payload:       t.Proto(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal transaction: %w", err)
+	}
+	return body, nil
+}
+
+// Decode reads an Envelope and validates its transaction like the JSON codec.
+// Unknown fields are rejected, protobuf messages have no older versions to upcast.
+func (protobufCodec) Decode(body []byte) (transaction.Transaction, error) {
+	var env transactionpb.Envelope
+	if err := proto.Unmarshal(body, &env); err != nil {
+		return transaction.Transaction{}, invalid("invalid envelope: %v", err)
+	}
+	if err := rejectUnknown(&env); err != nil {
+		return transaction.Transaction{}, invalid("invalid envelope: %v", err)
+	}
+
+	switch {
+	case env.SchemaVersion == 0:
+		return transaction.Transaction{}, invalid("schema_version is required")
+	case env.Type != TypeTransaction:
+		return transaction.Transaction{}, invalid("unknown message type %q", env.Type)
+	case env.SchemaVersion != Version:
+		return transaction.Transaction{}, invalid("unsupported schema version %d, expected %d", env.SchemaVersion, Version)
+	case env.Payload == nil:
+		return transaction.Transaction{}, invalid("payload is required")
+	}
+
+	p, err := protoPayload(env.Payload)
+	if err != nil {
+		return transaction.Transaction{}, invalid("invalid payload: %v", err)
+	}
+	t, err := p.transaction()
+	if err != nil {
+		return transaction.Transaction{}, invalid("%v", err)
+	}
+	if err := t.Validate(); err != nil {
+		return transaction.Transaction{}, invalid("%v", err)
+	}
+	return t, nil
+}
+
+// rejectUnknown fails when the envelope or one of its messages holds unknown fields,
+// which the protobuf runtime keeps without complaint
+func rejectUnknown(env *transactionpb.Envelope) error {
+	messages := []struct {
+		name string
+		m    proto.Message
+	}{
+		{"envelope", env},
+		{"payload", env.GetPayload()},
+		{"timestamp", env.GetPayload().GetTimestamp()},
+	}
+	for _, msg := range messages {
+		m := msg.m.ProtoReflect()
+		if m.IsValid() && len(m.GetUnknown()) > 0 {
+			// Fields of a known number but the wrong wire type end up here too
+			return fmt.Errorf("unknown field or wrong wire type in the %s", msg.name)
+		}
+	}
+	return nil
+}
+
+// protoPayload reads a Transaction into the payload, leaving missing fields nil
+func protoPayload(tx *transactionpb.Transaction) (payload, error) {
+	p := payload{Id: tx.Id, Amount: tx.Amount}
+	if tx.UserId != nil {
+		userId := int(*tx.UserId)
+		p.UserId = &userId
+	}
+	name, err := transaction.TypeName(tx.TransactionType)
+	if err != nil {
+		return payload{}, err
+	}
+	// TRANSACTION_TYPE_UNSPECIFIED is the missing type
+	if name != "" {
+		p.TransactionType = &name
+	}
+	if tx.Timestamp != nil {
+		if err := tx.Timestamp.CheckValid(); err != nil {
+			return payload{}, fmt.Errorf("timestamp: %w", err)
+		}
+		timestamp := tx.Timestamp.AsTime()
+		p.Timestamp = &timestamp
+	}
+	return p, nil
+}
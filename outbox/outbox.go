@@ -0,0 +1,206 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+	"transaction-management-system/database"
+	"transaction-management-system/lifecycle"
+	"transaction-management-system/logging"
+	"transaction-management-system/message"
+	"transaction-management-system/rabbitmq"
+	"transaction-management-system/tracing"
+	"transaction-management-system/transaction"
+)
+
+const (
+	defaultPollInterval    = 500 * time.Millisecond
+	defaultBatchSize       = 100
+	defaultRetention       = 24 * time.Hour
+	defaultCleanupInterval = time.Hour
+)
+
+// MessageIdPrefix starts the message id of every relayed event, followed by the outbox row id.
+// It tells consumers the transaction is already stored.
+const MessageIdPrefix = "outbox-"
+
+// Relayed reports whether a message was published by the relay
+func Relayed(messageId string) bool {
+	return strings.HasPrefix(messageId, MessageIdPrefix)
+}
+
+// Store reads and updates outbox rows
+type Store interface {
+	GetPendingOutbox(ctx context.Context, limit int) ([]database.OutboxMessage, error)
+	MarkOutboxSent(ctx context.Context, id int64) error
+	DeleteSentOutbox(ctx context.Context, sentBefore time.Time) (int64, error)
+	DeleteOutboxReceipts(ctx context.Context, receivedBefore time.Time) (int64, error)
+}
+
+// ConfirmPublisher publishes a transaction and waits for the broker's confirm
+type ConfirmPublisher interface {
+	PublishWithConfirm(ctx context.Context, queueName, messageId string, transaction transaction.Transaction) error
+}
+
+// Relay publishes outbox rows to the queue and marks them sent.
+// Rows of the same user are published in insertion order: when one fails,
+// the user's later rows wait for the next poll. Only one relay may run per
+// database, otherwise per-user ordering is lost.
+type Relay struct {
+	RabbitMQ        ConfirmPublisher
+	Db              Store
+	PollInterval    time.Duration
+	BatchSize       int
+	Retention       time.Duration // sent rows older than this are deleted
+	CleanupInterval time.Duration
+
+	stopper lifecycle.Stopper
+}
+
+// NewRelay connects to RabbitMQ in confirm mode and relays rows of db, which stays owned by the caller,
+// as messages of the codec
+func NewRelay(amqpURI string, topology rabbitmq.Topology, db *database.Database, codec message.Codec) (*Relay, error) {
+	rmq, err := rabbitmq.Connect(amqpURI, topology)
+	if err != nil {
+		return nil, err
+	}
+	rmq.Codec = codec
+	if err := rmq.EnableConfirms(); err != nil {
+		rmq.Close()
+		return nil, err
+	}
+
+	return &Relay{
+		RabbitMQ:        rmq,
+		Db:              db,
+		PollInterval:    defaultPollInterval,
+		BatchSize:       defaultBatchSize,
+		Retention:       defaultRetention,
+		CleanupInterval: defaultCleanupInterval,
+	}, nil
+}
+
+// Run polls the outbox until Shutdown or until ctx is cancelled
+func (r *Relay) Run(ctx context.Context, queueName string) {
+	defer r.stopper.Stopped()
+	defer r.Close()
+
+	poll := time.NewTicker(r.PollInterval)
+	defer poll.Stop()
+	cleanup := time.NewTicker(r.CleanupInterval)
+	defer cleanup.Stop()
+
+	slog.InfoContext(ctx, "Outbox relay started, polling for messages")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.stopper.Stopping():
+			// Flush what was written since the last poll
+			r.drain(ctx, queueName)
+			return
+		case <-poll.C:
+			r.drain(ctx, queueName)
+		case <-cleanup.C:
+			if err := r.Cleanup(ctx); err != nil {
+				slog.ErrorContext(ctx, "Failed to clean up outbox", "error", err)
+			}
+		}
+	}
+}
+
+// Shutdown relays the pending rows a last time and waits until Run returned, or until ctx is done
+func (r *Relay) Shutdown(ctx context.Context) error {
+	return r.stopper.Stop(ctx)
+}
+
+// drain relays batches while they come back full
+func (r *Relay) drain(ctx context.Context, queueName string) {
+	for {
+		sent, err := r.RelayBatch(ctx, queueName)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to relay outbox", "error", err)
+			return
+		}
+		if sent < r.BatchSize || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// RelayBatch publishes one batch of pending rows and returns how many were sent
+func (r *Relay) RelayBatch(ctx context.Context, queueName string) (int, error) {
+	messages, err := r.Db.GetPendingOutbox(ctx, r.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	blocked := make(map[int]struct{})
+	for _, m := range messages {
+		// Keep the user's order: nothing after a failed row goes out before it
+		if _, ok := blocked[m.UserId]; ok {
+			continue
+		}
+
+		// Publish and log with the correlation id and in the trace of the request that created the row
+		msgCtx := tracing.WithTraceParent(logging.Ensure(ctx, m.CorrelationId), m.TraceParent)
+		if err := r.publish(msgCtx, queueName, m); err != nil {
+			slog.WarnContext(msgCtx, "Failed to relay outbox message", "outbox_id", m.Id, "error", err)
+			blocked[m.UserId] = struct{}{}
+			continue
+		}
+
+		// A crash before this update republishes the row, consumers skip transactions they
+		// already have a receipt of
+		if err := r.Db.MarkOutboxSent(msgCtx, m.Id); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+func (r *Relay) publish(ctx context.Context, queueName string, m database.OutboxMessage) error {
+	var tr transaction.Transaction
+	if err := json.Unmarshal(m.Payload, &tr); err != nil {
+		return fmt.Errorf("failed to decode payload: %w", err)
+	}
+	return r.RabbitMQ.PublishWithConfirm(ctx, queueName, MessageIdPrefix+strconv.FormatInt(m.Id, 10), tr)
+}
+
+// Cleanup deletes rows sent, and consumer receipts taken, longer ago than the retention period
+func (r *Relay) Cleanup(ctx context.Context) error {
+	before := time.Now().Add(-r.Retention)
+	deleted, err := r.Db.DeleteSentOutbox(ctx, before)
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		slog.InfoContext(ctx, "Deleted sent outbox messages", "deleted", deleted)
+	}
+	deleted, err = r.Db.DeleteOutboxReceipts(ctx, before)
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		slog.InfoContext(ctx, "Deleted outbox receipts", "deleted", deleted)
+	}
+	return nil
+}
+
+// Close closes the relay's RabbitMQ connection, the database is closed by its owner
+func (r *Relay) Close() error {
+	if closer, ok := r.RabbitMQ.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	slog.Info("Outbox relay closed")
+	return nil
+}
@@ -0,0 +1,209 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+	"transaction-management-system/database"
+	"transaction-management-system/logging"
+	"transaction-management-system/tracing"
+	"transaction-management-system/tracing/tracingtest"
+	"transaction-management-system/transaction"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type memoryStore struct {
+	messages []database.OutboxMessage
+	sent     map[int64]time.Time
+	receipts map[int64]time.Time
+}
+
+func (s *memoryStore) GetPendingOutbox(ctx context.Context, limit int) ([]database.OutboxMessage, error) {
+	var pending []database.OutboxMessage
+	for _, m := range s.messages {
+		if _, ok := s.sent[m.Id]; !ok && len(pending) < limit {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+func (s *memoryStore) MarkOutboxSent(ctx context.Context, id int64) error {
+	s.sent[id] = time.Now()
+	return nil
+}
+
+func (s *memoryStore) DeleteSentOutbox(ctx context.Context, sentBefore time.Time) (int64, error) {
+	var deleted int64
+	kept := s.messages[:0]
+	for _, m := range s.messages {
+		if at, ok := s.sent[m.Id]; ok && at.Before(sentBefore) {
+			deleted++
+			continue
+		}
+		kept = append(kept, m)
+	}
+	s.messages = kept
+	return deleted, nil
+}
+
+func (s *memoryStore) DeleteOutboxReceipts(ctx context.Context, receivedBefore time.Time) (int64, error) {
+	var deleted int64
+	for id, at := range s.receipts {
+		if at.Before(receivedBefore) {
+			delete(s.receipts, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+type fakePublisher struct {
+	published      []string
+	correlationIds []string
+	traceIds       []trace.TraceID
+	failIds        map[string]bool
+}
+
+func (p *fakePublisher) PublishWithConfirm(ctx context.Context, queueName, messageId string, tr transaction.Transaction) error {
+	if p.failIds[messageId] {
+		return errors.New("failed to confirm a message: nacked by the broker")
+	}
+	p.published = append(p.published, messageId)
+	p.correlationIds = append(p.correlationIds, logging.CorrelationID(ctx))
+	p.traceIds = append(p.traceIds, trace.SpanContextFromContext(ctx).TraceID())
+	return nil
+}
+
+func newMessage(t *testing.T, id int64, userId int) database.OutboxMessage {
+	payload, err := json.Marshal(transaction.Transaction{Id: id, UserId: userId, TransactionType: transaction.BET, Amount: 1, Timestamp: time.Now()})
+	require.NoError(t, err)
+	return database.OutboxMessage{Id: id, UserId: userId, Payload: payload}
+}
+
+func TestRelayBatch(t *testing.T) {
+	t.Run("successful relay in order", func(t *testing.T) {
+		store := &memoryStore{sent: map[int64]time.Time{}}
+		store.messages = []database.OutboxMessage{newMessage(t, 1, 1), newMessage(t, 2, 2), newMessage(t, 3, 1)}
+		pub := &fakePublisher{}
+		relay := &Relay{RabbitMQ: pub, Db: store, BatchSize: 10}
+
+		sent, err := relay.RelayBatch(t.Context(), "casino")
+		require.NoError(t, err)
+		require.Equal(t, 3, sent)
+		require.Equal(t, []string{"outbox-1", "outbox-2", "outbox-3"}, pub.published)
+
+		// Nothing left to send
+		sent, err = relay.RelayBatch(t.Context(), "casino")
+		require.NoError(t, err)
+		require.Equal(t, 0, sent)
+	})
+
+	t.Run("failed publish holds back the user's later messages", func(t *testing.T) {
+		store := &memoryStore{sent: map[int64]time.Time{}}
+		store.messages = []database.OutboxMessage{newMessage(t, 1, 1), newMessage(t, 2, 2), newMessage(t, 3, 1)}
+		pub := &fakePublisher{failIds: map[string]bool{"outbox-1": true}}
+		relay := &Relay{RabbitMQ: pub, Db: store, BatchSize: 10}
+
+		sent, err := relay.RelayBatch(t.Context(), "casino")
+		require.NoError(t, err)
+		require.Equal(t, 1, sent)
+		require.Equal(t, []string{"outbox-2"}, pub.published)
+
+		// Once the broker recovers, the user's messages go out in order
+		pub.failIds = nil
+		_, err = relay.RelayBatch(t.Context(), "casino")
+		require.NoError(t, err)
+		require.Equal(t, []string{"outbox-2", "outbox-1", "outbox-3"}, pub.published)
+	})
+
+	t.Run("stored correlation id is published", func(t *testing.T) {
+		store := &memoryStore{sent: map[int64]time.Time{}}
+		traced := newMessage(t, 1, 1)
+		traced.CorrelationId = "request-1"
+		store.messages = []database.OutboxMessage{traced, newMessage(t, 2, 2)}
+		pub := &fakePublisher{}
+		relay := &Relay{RabbitMQ: pub, Db: store, BatchSize: 10}
+
+		_, err := relay.RelayBatch(t.Context(), "casino")
+		require.NoError(t, err)
+		require.Equal(t, "request-1", pub.correlationIds[0])
+		// Rows written before correlation ids existed get a new one
+		require.NotEmpty(t, pub.correlationIds[1])
+	})
+
+	t.Run("stored trace parent is continued", func(t *testing.T) {
+		_, restore := tracingtest.Setup()
+		defer restore()
+		ctx, request := tracing.Start(t.Context(), "POST /transactions")
+		request.End()
+
+		store := &memoryStore{sent: map[int64]time.Time{}}
+		traced := newMessage(t, 1, 1)
+		traced.TraceParent = tracing.TraceParent(ctx)
+		store.messages = []database.OutboxMessage{traced, newMessage(t, 2, 2)}
+		pub := &fakePublisher{}
+		relay := &Relay{RabbitMQ: pub, Db: store, BatchSize: 10}
+
+		_, err := relay.RelayBatch(t.Context(), "casino")
+		require.NoError(t, err)
+		require.Equal(t, request.SpanContext().TraceID(), pub.traceIds[0])
+		require.False(t, pub.traceIds[1].IsValid())
+	})
+
+	t.Run("undecodable payload blocks the user", func(t *testing.T) {
+		store := &memoryStore{sent: map[int64]time.Time{}}
+		store.messages = []database.OutboxMessage{{Id: 1, UserId: 1, Payload: []byte("{")}, newMessage(t, 2, 1)}
+		pub := &fakePublisher{}
+		relay := &Relay{RabbitMQ: pub, Db: store, BatchSize: 10}
+
+		sent, err := relay.RelayBatch(t.Context(), "casino")
+		require.NoError(t, err)
+		require.Equal(t, 0, sent)
+	})
+}
+
+func TestCleanup(t *testing.T) {
+	store := &memoryStore{sent: map[int64]time.Time{}}
+	store.messages = []database.OutboxMessage{newMessage(t, 1, 1), newMessage(t, 2, 1)}
+	store.sent[1] = time.Now().Add(-48 * time.Hour)
+	store.receipts = map[int64]time.Time{1: time.Now().Add(-48 * time.Hour), 2: time.Now()}
+	relay := &Relay{RabbitMQ: &fakePublisher{}, Db: store, Retention: 24 * time.Hour}
+
+	require.NoError(t, relay.Cleanup(t.Context()))
+	require.Len(t, store.messages, 1)
+	require.Equal(t, int64(2), store.messages[0].Id)
+	require.Len(t, store.receipts, 1)
+	require.Contains(t, store.receipts, int64(2))
+}
+
+func TestRelayed(t *testing.T) {
+	require.True(t, Relayed("outbox-42"))
+	require.False(t, Relayed(""))
+	require.False(t, Relayed("replay-42"))
+}
+
+func TestShutdown(t *testing.T) {
+	store := &memoryStore{sent: map[int64]time.Time{}}
+	pub := &fakePublisher{}
+	relay := &Relay{RabbitMQ: pub, Db: store, BatchSize: 2, PollInterval: time.Hour, CleanupInterval: time.Hour}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		relay.Run(t.Context(), "casino")
+	}()
+
+	// Written after the last poll, flushed by the shutdown in full batches
+	store.messages = []database.OutboxMessage{newMessage(t, 1, 1), newMessage(t, 2, 2), newMessage(t, 3, 3)}
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+	defer cancel()
+	require.NoError(t, relay.Shutdown(ctx))
+	<-done
+	require.Equal(t, []string{"outbox-1", "outbox-2", "outbox-3"}, pub.published)
+}
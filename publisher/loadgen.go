@@ -0,0 +1,203 @@
+package publisher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+	"transaction-management-system/config"
+	"transaction-management-system/transaction"
+)
+
+// Phase is a stage of a load profile.
+// The target rate (msgs/sec) moves linearly from From to To over the phase,
+// so a ramp-up has From < To, steady load From == To and a spike a short high rate.
+type Phase struct {
+	Name     string          `json:"name"`
+	Duration config.Duration `json:"duration"`
+	From     float64         `json:"from"`
+	To       float64         `json:"to"`
+	// Unthrottled publishes as fast as possible, ignoring the rates
+	Unthrottled bool `json:"unthrottled"`
+}
+
+// maxAmount is the largest amount the transactions table stores, DECIMAL(15, 2)
+const maxAmount = 9999999999999.99
+
+// AmountDist describes how transaction amounts are drawn
+type AmountDist struct {
+	Kind  string  `json:"kind"` // "uniform", "lognormal" or "fixed"
+	Min   float64 `json:"min"`  // uniform lower bound
+	Max   float64 `json:"max"`  // uniform upper bound, lognormal cap
+	Mu    float64 `json:"mu"`   // lognormal mean of the underlying normal
+	Sigma float64 `json:"sigma"`
+	Value float64 `json:"value"` // fixed amount
+}
+
+// Profile configures the load generator
+type Profile struct {
+	Phases []Phase `json:"phases"`
+	// Users is the size of the user population, user ids are 1..Users
+	Users int `json:"users"`
+	// ZipfS skews activity towards low user ids (must be > 1), 0 picks users uniformly
+	ZipfS float64 `json:"zipf_s"`
+	// BetRatio is the probability of a transaction being a bet
+	BetRatio  float64    `json:"bet_ratio"`
+	BetAmount AmountDist `json:"bet_amount"`
+	WinAmount AmountDist `json:"win_amount"`
+	// Seed makes runs reproducible, 0 seeds from the clock
+	Seed int64 `json:"seed"`
+}
+
+// DefaultProfile returns the historical behavior: a 1ms unthrottled burst over 5 users
+func DefaultProfile() Profile {
+	amount := AmountDist{Kind: "uniform", Min: 0, Max: 100}
+	return Profile{
+		Phases:    []Phase{{Name: "burst", Duration: config.Duration(time.Millisecond), Unthrottled: true}},
+		Users:     5,
+		BetRatio:  0.5,
+		BetAmount: amount,
+		WinAmount: amount,
+	}
+}
+
+// LoadProfile reads a profile from a JSON file, using the defaults for missing fields
+func LoadProfile(path string) (Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Profile{}, fmt.Errorf("failed to read load profile: %w", err)
+	}
+	p := DefaultProfile()
+	if err := json.Unmarshal(data, &p); err != nil {
+		return Profile{}, fmt.Errorf("failed to parse load profile: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return Profile{}, fmt.Errorf("invalid load profile: %w", err)
+	}
+	return p, nil
+}
+
+// Validate checks the profile for values the generator can't use
+func (p Profile) Validate() error {
+	if len(p.Phases) == 0 {
+		return errors.New("at least one phase is required")
+	}
+	for _, phase := range p.Phases {
+		if phase.Duration <= 0 {
+			return fmt.Errorf("phase %q: duration must be positive", phase.Name)
+		}
+		if phase.From < 0 || phase.To < 0 {
+			return fmt.Errorf("phase %q: rates can't be negative", phase.Name)
+		}
+	}
+	if p.Users < 1 {
+		return errors.New("users must be at least 1")
+	}
+	if p.ZipfS != 0 && p.ZipfS <= 1 {
+		return errors.New("zipf_s must be greater than 1")
+	}
+	if p.BetRatio < 0 || p.BetRatio > 1 {
+		return errors.New("bet_ratio must be between 0 and 1")
+	}
+	for name, dist := range map[string]AmountDist{"bet_amount": p.BetAmount, "win_amount": p.WinAmount} {
+		if err := dist.validate(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (d AmountDist) validate() error {
+	switch d.Kind {
+	case "uniform":
+		if d.Min < 0 || d.Max < d.Min || d.Max > maxAmount {
+			return fmt.Errorf("uniform requires 0 <= min <= max <= %.2f", maxAmount)
+		}
+	case "lognormal":
+		// Unbounded draws would overflow the amount column and be dead-lettered
+		if d.Sigma < 0 || d.Max <= 0 || d.Max > maxAmount {
+			return fmt.Errorf("lognormal requires non-negative sigma and 0 < max <= %.2f", maxAmount)
+		}
+	case "fixed":
+		if d.Value < 0 || d.Value > maxAmount {
+			return fmt.Errorf("fixed value must be between 0 and %.2f", maxAmount)
+		}
+	default:
+		return fmt.Errorf("unknown amount distribution %q", d.Kind)
+	}
+	return nil
+}
+
+// Due returns how many messages should have been sent after elapsed time in the phase,
+// the integral of the linearly interpolated rate
+func (phase Phase) Due(elapsed time.Duration) float64 {
+	d := time.Duration(phase.Duration).Seconds()
+	t := math.Min(elapsed.Seconds(), d)
+	return phase.From*t + (phase.To-phase.From)*t*t/(2*d)
+}
+
+// Generator produces transactions following a profile
+type Generator struct {
+	seed    int64
+	profile Profile
+	rng     *rand.Rand
+	zipf    *rand.Zipf
+}
+
+func NewGenerator(p Profile) *Generator {
+	seed := p.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	g := &Generator{seed: seed, profile: p, rng: rng}
+	if p.ZipfS > 1 {
+		g.zipf = rand.NewZipf(rng, p.ZipfS, 1, uint64(p.Users-1))
+	}
+	return g
+}
+
+// Seed returns the seed to reuse for reproducing the run
+func (g *Generator) Seed() int64 {
+	return g.seed
+}
+
+// Next returns a new transaction stamped with now
+func (g *Generator) Next(now time.Time) transaction.Transaction {
+	var userId int
+	if g.zipf != nil {
+		userId = int(g.zipf.Uint64()) + 1
+	} else {
+		userId = g.rng.Intn(g.profile.Users) + 1
+	}
+
+	transactionType, dist := transaction.WIN, g.profile.WinAmount
+	if g.rng.Float64() < g.profile.BetRatio {
+		transactionType, dist = transaction.BET, g.profile.BetAmount
+	}
+
+	return transaction.Transaction{
+		UserId:          userId,
+		TransactionType: transactionType,
+		Amount:          g.amount(dist),
+		Timestamp:       now,
+	}
+}
+
+// amount draws from the distribution and rounds to cents
+func (g *Generator) amount(d AmountDist) float64 {
+	var a float64
+	switch d.Kind {
+	case "lognormal":
+		a = math.Min(math.Exp(d.Mu+d.Sigma*g.rng.NormFloat64()), d.Max)
+	case "fixed":
+		a = d.Value
+	default:
+		a = d.Min + g.rng.Float64()*(d.Max-d.Min)
+	}
+	return math.Round(a*100) / 100
+}
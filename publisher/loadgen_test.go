@@ -0,0 +1,136 @@
+package publisher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"transaction-management-system/config"
+	"transaction-management-system/transaction"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPhaseDue(t *testing.T) {
+	t.Run("steady phase", func(t *testing.T) {
+		phase := Phase{Duration: config.Duration(10 * time.Second), From: 100, To: 100}
+		require.InDelta(t, 500, phase.Due(5*time.Second), 1e-9)
+	})
+	t.Run("ramp up phase", func(t *testing.T) {
+		phase := Phase{Duration: config.Duration(10 * time.Second), From: 0, To: 100}
+		require.InDelta(t, 125, phase.Due(5*time.Second), 1e-9)
+		require.InDelta(t, 500, phase.Due(10*time.Second), 1e-9)
+	})
+	t.Run("elapsed past the phase end", func(t *testing.T) {
+		phase := Phase{Duration: config.Duration(time.Second), From: 10, To: 10}
+		require.InDelta(t, 10, phase.Due(time.Minute), 1e-9)
+	})
+}
+
+func TestGenerator(t *testing.T) {
+	t.Run("same seed reproduces the run", func(t *testing.T) {
+		p := DefaultProfile()
+		p.Seed = 42
+		now := time.Now()
+
+		g1, g2 := NewGenerator(p), NewGenerator(p)
+		for range 100 {
+			require.Equal(t, g1.Next(now), g2.Next(now))
+		}
+	})
+
+	t.Run("zipf favors low user ids", func(t *testing.T) {
+		p := DefaultProfile()
+		p.Seed = 1
+		p.Users = 1000
+		p.ZipfS = 1.5
+
+		g := NewGenerator(p)
+		counts := map[int]int{}
+		for range 10000 {
+			tr := g.Next(time.Now())
+			require.GreaterOrEqual(t, tr.UserId, 1)
+			require.LessOrEqual(t, tr.UserId, 1000)
+			counts[tr.UserId]++
+		}
+		require.Greater(t, counts[1], counts[2])
+		require.Greater(t, counts[1], 10000/1000*10)
+	})
+
+	t.Run("bet ratio and amount distributions", func(t *testing.T) {
+		p := DefaultProfile()
+		p.Seed = 1
+		p.BetRatio = 1
+		p.BetAmount = AmountDist{Kind: "fixed", Value: 2.5}
+
+		g := NewGenerator(p)
+		for range 100 {
+			tr := g.Next(time.Now())
+			require.Equal(t, transaction.BET, tr.TransactionType)
+			require.Equal(t, 2.5, tr.Amount)
+		}
+	})
+
+	t.Run("lognormal amounts are capped", func(t *testing.T) {
+		p := DefaultProfile()
+		p.Seed = 1
+		p.BetRatio = 0
+		p.WinAmount = AmountDist{Kind: "lognormal", Mu: 3, Sigma: 2, Max: 50}
+
+		g := NewGenerator(p)
+		for range 1000 {
+			tr := g.Next(time.Now())
+			require.Equal(t, transaction.WIN, tr.TransactionType)
+			require.LessOrEqual(t, tr.Amount, 50.0)
+			require.GreaterOrEqual(t, tr.Amount, 0.0)
+		}
+	})
+}
+
+func TestLoadProfile(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "profile.json")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	t.Run("successful load", func(t *testing.T) {
+		p, err := LoadProfile(write(t, `{
+			"phases": [
+				{"name": "ramp-up", "duration": "30s", "from": 0, "to": 500},
+				{"name": "steady", "duration": "5m", "from": 500, "to": 500},
+				{"name": "spike", "duration": "10s", "from": 5000, "to": 5000}
+			],
+			"users": 10000,
+			"zipf_s": 1.2,
+			"bet_ratio": 0.6,
+			"seed": 7
+		}`))
+		require.NoError(t, err)
+		require.Len(t, p.Phases, 3)
+		require.Equal(t, config.Duration(30*time.Second), p.Phases[0].Duration)
+		require.Equal(t, "uniform", p.BetAmount.Kind)
+	})
+
+	t.Run("failed invalid profile", func(t *testing.T) {
+		_, err := LoadProfile(write(t, `{"zipf_s": 0.5}`))
+		require.ErrorContains(t, err, "zipf_s must be greater than 1")
+
+		_, err = LoadProfile(write(t, `{"phases": [{"name": "x", "duration": "0s"}]}`))
+		require.ErrorContains(t, err, "duration must be positive")
+
+		_, err = LoadProfile(write(t, `{"bet_amount": {"kind": "pareto"}}`))
+		require.ErrorContains(t, err, "unknown amount distribution")
+
+		_, err = LoadProfile(write(t, `{"bet_amount": {"kind": "lognormal", "mu": 2, "sigma": 1, "max": 0}}`))
+		require.ErrorContains(t, err, "lognormal requires non-negative sigma and 0 < max")
+
+		_, err = LoadProfile(write(t, `{"win_amount": {"kind": "fixed", "value": 1e14}}`))
+		require.ErrorContains(t, err, "fixed value must be between 0 and 9999999999999.99")
+	})
+
+	t.Run("failed missing file", func(t *testing.T) {
+		_, err := LoadProfile(filepath.Join(t.TempDir(), "missing.json"))
+		require.ErrorContains(t, err, "failed to read load profile")
+	})
+}
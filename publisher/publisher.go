@@ -2,50 +2,85 @@ package publisher
 
 import (
 	"context"
-	"fmt"
-	"log"
-	"sync"
+	"log/slog"
 	"time"
+	"transaction-management-system/logging"
 	"transaction-management-system/rabbitmq"
-	"transaction-management-system/transaction"
 )
 
+// Sleep between pacing checks when a phase is ahead of its target rate
+const pacingInterval = time.Millisecond
+
 type Publisher struct {
 	RabbitMQ *rabbitmq.RabbitMQ
+	// Profile shapes the generated load
+	Profile Profile
 }
 
-func NewPublisher(amqpURI, queueName string) (*Publisher, error) {
-	rmq, err := rabbitmq.GetInstance(amqpURI, queueName)
+func NewPublisher(amqpURI string, topology rabbitmq.Topology) (*Publisher, error) {
+	rmq, err := rabbitmq.Connect(amqpURI, topology)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Publisher{
 		RabbitMQ: rmq,
+		Profile:  DefaultProfile(),
 	}, nil
 }
 
-func (p *Publisher) StartPublish(ctx context.Context, wg *sync.WaitGroup, queueName string) {
-	defer wg.Done()
+// StartPublish publishes generated transactions through every phase of the profile
+func (p *Publisher) StartPublish(ctx context.Context, queueName string) {
 	defer p.Close()
 
-	publishingCtx, cancel := context.WithTimeout(ctx, 1*time.Millisecond)
+	gen := NewGenerator(p.Profile)
+	slog.InfoContext(ctx, "Load generator started", "seed", gen.Seed())
+
+	for _, phase := range p.Profile.Phases {
+		if !p.runPhase(ctx, gen, phase, queueName) {
+			return
+		}
+	}
+}
+
+// runPhase publishes at the phase's target rate until the phase ends.
+// It returns false when ctx is cancelled.
+func (p *Publisher) runPhase(ctx context.Context, gen *Generator, phase Phase, queueName string) bool {
+	start := time.Now()
+	phaseCtx, cancel := context.WithDeadline(ctx, start.Add(time.Duration(phase.Duration)))
 	defer cancel()
+
+	sent, failed := 0, 0
+	defer func() {
+		slog.InfoContext(ctx, "Load phase finished", "phase", phase.Name, "sent", sent, "failed", failed)
+	}()
+
 	for {
 		select {
 		case <-ctx.Done():
-			return
-		case <-publishingCtx.Done():
-			return
+			return false
+		case <-phaseCtx.Done():
+			return true
 		default:
-			transaction := transaction.NewTransaction()
-			err := p.RabbitMQ.Publish(queueName, transaction)
-			if err != nil {
-				log.Printf("Failed to publish message: %v", err)
-				continue
-			}
-			fmt.Printf(" [x] Sent: %s\n", transaction)
 		}
+
+		// Wait while ahead of the target rate
+		if !phase.Unthrottled && float64(sent+failed) >= phase.Due(time.Since(start)) {
+			time.Sleep(pacingInterval)
+			continue
+		}
+
+		// Each generated transaction starts its own trace
+		transaction := gen.Next(time.Now())
+		msgCtx := logging.WithCorrelationID(ctx, logging.NewCorrelationID())
+		err := p.RabbitMQ.Publish(msgCtx, queueName, transaction)
+		if err != nil {
+			slog.WarnContext(msgCtx, "Failed to publish message", "error", err)
+			failed++
+			continue
+		}
+		sent++
+		slog.DebugContext(msgCtx, "Sent transaction", "transaction", transaction)
 	}
 }
 
@@ -53,6 +88,6 @@ func (p *Publisher) Close() error {
 	if err := p.RabbitMQ.Close(); err != nil {
 		return err
 	}
-	log.Println("Publisher closed succesfully")
+	slog.Info("Publisher closed")
 	return nil
 }
@@ -1,30 +1,30 @@
 package publisher
 
 import (
-	"encoding/json"
 	"sync"
 	"testing"
 	test "transaction-management-system/config"
-	"transaction-management-system/transaction"
+	"transaction-management-system/message"
+	"transaction-management-system/rabbitmq"
 
 	"github.com/stretchr/testify/require"
 )
 
 func TestNewPublisher(t *testing.T) {
 	t.Run("failed new publisher - wrong amqp uri", func(t *testing.T) {
-		_, err := NewPublisher(test.WRONG_AMQP_URI, test.QUEUE_NAME)
+		_, err := NewPublisher(test.WRONG_AMQP_URI, rabbitmq.DefaultTopology(test.QUEUE_NAME))
 
 		require.Error(t, err)
 		require.ErrorContains(t, err, "failed to connect to RabbitMQ")
 	})
 	t.Run("failed new publisher - wrong queue name", func(t *testing.T) {
-		_, err := NewPublisher(test.AMQP_URI, test.WRONG_QUEUE_NAME)
+		_, err := NewPublisher(test.AMQP_URI, rabbitmq.DefaultTopology(test.WRONG_QUEUE_NAME))
 
 		require.Error(t, err)
 		require.ErrorContains(t, err, "failed to declare a queue")
 	})
 	t.Run("succesfully created new publisher", func(t *testing.T) {
-		p, err := NewPublisher(test.AMQP_URI, test.QUEUE_NAME)
+		p, err := NewPublisher(test.AMQP_URI, rabbitmq.DefaultTopology(test.QUEUE_NAME))
 
 		require.Nil(t, err)
 		require.NotNil(t, p)
@@ -34,17 +34,19 @@ func TestNewPublisher(t *testing.T) {
 func TestStartPublishing(t *testing.T) {
 	var wg sync.WaitGroup
 	t.Run("successfully published", func(t *testing.T) {
-		p, _ := NewPublisher(test.AMQP_URI, test.QUEUE_NAME)
+		p, _ := NewPublisher(test.AMQP_URI, rabbitmq.DefaultTopology(test.QUEUE_NAME))
 
 		wg.Add(1)
-		go p.StartPublish(t.Context(), &wg, test.QUEUE_NAME)
+		go func() {
+			defer wg.Done()
+			p.StartPublish(t.Context(), test.QUEUE_NAME)
+		}()
 
 		msgs, err := p.RabbitMQ.Consume(test.QUEUE_NAME)
 		require.Nil(t, err)
 
 		for msg := range msgs {
-			var tr transaction.Transaction
-			err = json.Unmarshal(msg.Body, &tr)
+			tr, err := message.Decode(msg.Body)
 
 			require.Nil(t, err)
 			require.NotEmpty(t, tr)
@@ -56,7 +58,7 @@ func TestStartPublishing(t *testing.T) {
 
 func TestClose(t *testing.T) {
 	t.Run("failed closing", func(t *testing.T) {
-		p, _ := NewPublisher(test.AMQP_URI, test.QUEUE_NAME)
+		p, _ := NewPublisher(test.AMQP_URI, rabbitmq.DefaultTopology(test.QUEUE_NAME))
 		p.Close()
 		err := p.Close()
 
@@ -64,7 +66,7 @@ func TestClose(t *testing.T) {
 		require.ErrorContains(t, err, "channel/connection is not open")
 	})
 	t.Run("succesfully closed", func(t *testing.T) {
-		p, _ := NewPublisher(test.AMQP_URI, test.QUEUE_NAME)
+		p, _ := NewPublisher(test.AMQP_URI, rabbitmq.DefaultTopology(test.QUEUE_NAME))
 		err := p.Close()
 
 		require.Nil(t, err)
@@ -0,0 +1,263 @@
+package publisher
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"transaction-management-system/logging"
+	"transaction-management-system/transaction"
+)
+
+// Maximum length of a single JSONL record
+const maxReplayLineSize = 1 << 20
+
+// Sender publishes a transaction to a queue, with the correlation id of ctx
+type Sender interface {
+	Publish(ctx context.Context, queueName string, transaction transaction.Transaction) error
+}
+
+// ReplayOptions configures a replay run
+type ReplayOptions struct {
+	Path string
+	// Format is "csv" or "jsonl", detected from the file extension when empty
+	Format string
+	// Paced replays with the original timestamp deltas instead of as fast as possible
+	Paced bool
+	// Speed divides the original deltas when paced, 2 replays twice as fast
+	Speed float64
+	// Offset skips that many records, to resume an interrupted replay
+	Offset int
+}
+
+// ReplayReport counts what happened to the records
+type ReplayReport struct {
+	Skipped   int
+	Published int
+	Rejected  int
+	// Offset is the number of records consumed, the offset to resume from
+	Offset int
+}
+
+func (r ReplayReport) String() string {
+	return fmt.Sprintf("{published: %d, rejected: %d, skipped: %d, offset: %d}", r.Published, r.Rejected, r.Skipped, r.Offset)
+}
+
+// errInvalidRecord marks records that can't be parsed, they are rejected but don't stop the replay
+var errInvalidRecord = errors.New("invalid record")
+
+// recordReader returns records in file order, io.EOF at the end
+type recordReader interface {
+	Next() (transaction.Transaction, error)
+}
+
+// Replay publishes transactions from a CSV or JSONL file in order.
+// Invalid records are rejected and logged, a publishing failure stops the
+// replay and the report's Offset tells where to resume.
+func Replay(ctx context.Context, sender Sender, queueName string, opts ReplayOptions) (ReplayReport, error) {
+	report := ReplayReport{}
+
+	if opts.Paced && opts.Speed <= 0 {
+		return report, errors.New("speed must be positive for paced replay")
+	}
+
+	f, err := os.Open(opts.Path)
+	if err != nil {
+		return report, fmt.Errorf("failed to open replay file: %w", err)
+	}
+	defer f.Close()
+
+	reader, err := newRecordReader(f, opts)
+	if err != nil {
+		return report, err
+	}
+
+	var firstTimestamp, start time.Time
+	for {
+		tr, err := reader.Next()
+		if err == io.EOF {
+			return report, nil
+		}
+		if err != nil && !errors.Is(err, errInvalidRecord) {
+			return report, fmt.Errorf("failed to read replay file: %w", err)
+		}
+
+		// Resume after the records already replayed
+		if report.Offset < opts.Offset {
+			report.Offset++
+			report.Skipped++
+			continue
+		}
+
+		if err == nil {
+			err = tr.Validate()
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Rejected replay record", "record", report.Offset+1, "error", err)
+			report.Offset++
+			report.Rejected++
+			continue
+		}
+
+		if opts.Paced {
+			if start.IsZero() {
+				firstTimestamp, start = tr.Timestamp, time.Now()
+			}
+			delay := time.Duration(float64(tr.Timestamp.Sub(firstTimestamp)) / opts.Speed)
+			if err := sleepUntil(ctx, start.Add(delay)); err != nil {
+				return report, err
+			}
+		} else if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		// Ids belong to the source database, the consumer assigns new ones
+		tr.Id = 0
+		recordCtx := logging.WithCorrelationID(ctx, logging.NewCorrelationID())
+		if err := sender.Publish(recordCtx, queueName, tr); err != nil {
+			return report, fmt.Errorf("failed to publish record %d: %w", report.Offset+1, err)
+		}
+		report.Offset++
+		report.Published++
+	}
+}
+
+// Replay publishes the file's transactions with the publisher's connection
+func (p *Publisher) Replay(ctx context.Context, queueName string, opts ReplayOptions) (ReplayReport, error) {
+	return Replay(ctx, p.RabbitMQ, queueName, opts)
+}
+
+// StartReplay runs a replay in place of load generation and logs its report
+func (p *Publisher) StartReplay(ctx context.Context, queueName string, opts ReplayOptions) {
+	defer p.Close()
+
+	report, err := p.Replay(ctx, queueName, opts)
+	if err != nil {
+		slog.ErrorContext(ctx, "Replay stopped", "path", opts.Path, "error", err)
+	}
+	slog.InfoContext(ctx, "Replay finished", "path", opts.Path,
+		"published", report.Published, "rejected", report.Rejected, "skipped", report.Skipped, "offset", report.Offset)
+}
+
+func sleepUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func newRecordReader(r io.Reader, opts ReplayOptions) (recordReader, error) {
+	format := opts.Format
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(opts.Path)), ".")
+	}
+
+	switch format {
+	case "csv":
+		return newCsvRecordReader(r)
+	case "jsonl", "ndjson":
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxReplayLineSize)
+		return &jsonlRecordReader{scanner: scanner}, nil
+	default:
+		return nil, fmt.Errorf("unsupported replay format %q. Must be 'csv' or 'jsonl'", format)
+	}
+}
+
+// jsonlRecordReader reads one JSON transaction per line, skipping blank lines
+type jsonlRecordReader struct {
+	scanner *bufio.Scanner
+}
+
+func (r *jsonlRecordReader) Next() (transaction.Transaction, error) {
+	var tr transaction.Transaction
+	for r.scanner.Scan() {
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(line), &tr); err != nil {
+			return tr, fmt.Errorf("%w: %v", errInvalidRecord, err)
+		}
+		return tr, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return tr, err
+	}
+	return tr, io.EOF
+}
+
+// csvRecordReader reads transactions from CSV rows whose columns are named by the header row,
+// the format written by the export endpoint
+type csvRecordReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCsvRecordReader(r io.Reader) (*csvRecordReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"user_id", "transaction_type", "amount", "timestamp"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing csv column %q", name)
+		}
+	}
+
+	return &csvRecordReader{reader: reader, columns: columns}, nil
+}
+
+func (r *csvRecordReader) Next() (transaction.Transaction, error) {
+	var tr transaction.Transaction
+	row, err := r.reader.Read()
+	if err == io.EOF {
+		return tr, io.EOF
+	}
+	if err != nil {
+		return tr, fmt.Errorf("%w: %v", errInvalidRecord, err)
+	}
+
+	field := func(name string) string {
+		if i := r.columns[name]; i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	if tr.UserId, err = strconv.Atoi(field("user_id")); err != nil {
+		return tr, fmt.Errorf("%w: user_id: %v", errInvalidRecord, err)
+	}
+	tr.TransactionType = field("transaction_type")
+	if tr.Amount, err = strconv.ParseFloat(field("amount"), 64); err != nil {
+		return tr, fmt.Errorf("%w: amount: %v", errInvalidRecord, err)
+	}
+	if tr.Timestamp, err = time.Parse(time.RFC3339Nano, field("timestamp")); err != nil {
+		return tr, fmt.Errorf("%w: timestamp: %v", errInvalidRecord, err)
+	}
+	return tr, nil
+}
@@ -0,0 +1,125 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"transaction-management-system/logging"
+	"transaction-management-system/transaction"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingSender collects published transactions and their correlation ids,
+// failing after failAfter publishes when set
+type recordingSender struct {
+	published      []transaction.Transaction
+	correlationIds []string
+	failAfter      int
+}
+
+func (s *recordingSender) Publish(ctx context.Context, queueName string, tr transaction.Transaction) error {
+	if s.failAfter > 0 && len(s.published) == s.failAfter {
+		return errors.New("channel/connection is not open")
+	}
+	s.published = append(s.published, tr)
+	s.correlationIds = append(s.correlationIds, logging.CorrelationID(ctx))
+	return nil
+}
+
+func writeReplayFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+const replayJSONL = `{"id": 10, "user_id": 1, "transaction_type": "bet", "amount": 5, "timestamp": "2025-01-01T00:00:00Z"}
+{"user_id": 0, "transaction_type": "bet", "amount": 5, "timestamp": "2025-01-01T00:00:01Z"}
+not json
+
+{"user_id": 2, "transaction_type": "win", "amount": 7.5, "timestamp": "2025-01-01T00:00:02Z"}
+`
+
+func TestReplay(t *testing.T) {
+	t.Run("successful jsonl replay", func(t *testing.T) {
+		sender := &recordingSender{}
+		report, err := Replay(t.Context(), sender, "casino", ReplayOptions{Path: writeReplayFile(t, "tx.jsonl", replayJSONL)})
+		require.NoError(t, err)
+
+		require.Equal(t, ReplayReport{Published: 2, Rejected: 2, Offset: 4}, report)
+		require.Equal(t, 1, sender.published[0].UserId)
+		require.Equal(t, int64(0), sender.published[0].Id)
+		require.Equal(t, transaction.WIN, sender.published[1].TransactionType)
+
+		// Every record is traced on its own
+		require.NotEmpty(t, sender.correlationIds[0])
+		require.NotEqual(t, sender.correlationIds[0], sender.correlationIds[1])
+	})
+
+	t.Run("successful csv replay", func(t *testing.T) {
+		csv := "id,user_id,transaction_type,amount,timestamp\n" +
+			"1,3,bet,1.50,2025-01-02T03:04:05Z\n" +
+			"2,3,refund,1.50,2025-01-02T03:04:06Z\n" +
+			"3,abc,win,1.50,2025-01-02T03:04:07Z\n" +
+			"4,3,win,2.00,2025-01-02T03:04:08Z\n"
+
+		sender := &recordingSender{}
+		report, err := Replay(t.Context(), sender, "casino", ReplayOptions{Path: writeReplayFile(t, "tx.csv", csv)})
+		require.NoError(t, err)
+
+		require.Equal(t, ReplayReport{Published: 2, Rejected: 2, Offset: 4}, report)
+		require.Equal(t, 2.0, sender.published[1].Amount)
+	})
+
+	t.Run("successful resume from offset", func(t *testing.T) {
+		sender := &recordingSender{}
+		report, err := Replay(t.Context(), sender, "casino", ReplayOptions{Path: writeReplayFile(t, "tx.jsonl", replayJSONL), Offset: 3})
+		require.NoError(t, err)
+
+		require.Equal(t, ReplayReport{Skipped: 3, Published: 1, Offset: 4}, report)
+		require.Equal(t, 2, sender.published[0].UserId)
+	})
+
+	t.Run("failed publish reports resume offset", func(t *testing.T) {
+		sender := &recordingSender{failAfter: 1}
+		report, err := Replay(t.Context(), sender, "casino", ReplayOptions{Path: writeReplayFile(t, "tx.jsonl", replayJSONL)})
+		require.ErrorContains(t, err, "failed to publish record 4")
+
+		require.Equal(t, 3, report.Offset)
+		require.Equal(t, 1, report.Published)
+	})
+
+	t.Run("successful paced replay with speed multiplier", func(t *testing.T) {
+		sender := &recordingSender{}
+		start := time.Now()
+		_, err := Replay(t.Context(), sender, "casino", ReplayOptions{Path: writeReplayFile(t, "tx.jsonl", replayJSONL), Paced: true, Speed: 20})
+		require.NoError(t, err)
+
+		// Two seconds between the valid records, replayed twenty times faster
+		require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+		require.Len(t, sender.published, 2)
+	})
+
+	t.Run("failed paced replay cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+		defer cancel()
+
+		sender := &recordingSender{}
+		report, err := Replay(ctx, sender, "casino", ReplayOptions{Path: writeReplayFile(t, "tx.jsonl", replayJSONL), Paced: true, Speed: 1})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Equal(t, 1, report.Published)
+	})
+
+	t.Run("failed unsupported format", func(t *testing.T) {
+		_, err := Replay(t.Context(), &recordingSender{}, "casino", ReplayOptions{Path: writeReplayFile(t, "tx.xml", "")})
+		require.ErrorContains(t, err, "unsupported replay format")
+	})
+
+	t.Run("failed csv without required columns", func(t *testing.T) {
+		_, err := Replay(t.Context(), &recordingSender{}, "casino", ReplayOptions{Path: writeReplayFile(t, "tx.csv", "user_id,amount\n")})
+		require.ErrorContains(t, err, `missing csv column "transaction_type"`)
+	})
+}
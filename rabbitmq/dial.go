@@ -0,0 +1,49 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"transaction-management-system/secrets"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// dialConfig builds the connection settings for the URI. AMQP_TLS_* files
+// configure TLS for amqps:// URIs, and AMQP_USERNAME / AMQP_PASSWORD (or their
+// _FILE variants) override the URI's credentials. Both are read for every
+// connection, so rotated secrets apply once RabbitMQ reconnects, see watch.
+func dialConfig(amqpURI string) (amqp.Config, error) {
+	uri, err := amqp.ParseURI(amqpURI)
+	if err != nil {
+		return amqp.Config{}, err
+	}
+	config := amqp.Config{Locale: "en_US"}
+
+	tlsFiles := secrets.TLSFilesFromEnv("AMQP")
+	if tlsFiles.Enabled() {
+		if uri.Scheme != "amqps" {
+			return amqp.Config{}, errors.New("TLS settings require an amqps:// URI")
+		}
+		config.TLSClientConfig, err = secrets.LoadTLSConfig(tlsFiles)
+		if err != nil {
+			return amqp.Config{}, err
+		}
+	}
+
+	user, password := secrets.FromEnv("AMQP_USERNAME"), secrets.FromEnv("AMQP_PASSWORD")
+	if user != nil || password != nil {
+		auth := uri.PlainAuth()
+		if user != nil {
+			if auth.Username, err = user.Get(); err != nil {
+				return amqp.Config{}, fmt.Errorf("amqp username: %w", err)
+			}
+		}
+		if password != nil {
+			if auth.Password, err = password.Get(); err != nil {
+				return amqp.Config{}, fmt.Errorf("amqp password: %w", err)
+			}
+		}
+		config.SASL = []amqp.Authentication{auth}
+	}
+	return config, nil
+}
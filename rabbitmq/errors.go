@@ -0,0 +1,85 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	// ErrAuthFailed is returned when the broker refuses the credentials or the vhost
+	ErrAuthFailed = errors.New("rabbitmq: authentication failed")
+	// ErrUnreachable is returned when the broker can't be reached over the network
+	ErrUnreachable = errors.New("rabbitmq: broker unreachable")
+	// ErrPreconditionFailed is returned when a declaration conflicts with the broker's state,
+	// e.g. a queue that already exists with other arguments
+	ErrPreconditionFailed = errors.New("rabbitmq: precondition failed")
+)
+
+// Steps reported by Error.Op
+const (
+	OpConnect         = "connect to RabbitMQ"
+	OpChannel         = "open a channel"
+	OpDeclareExchange = "declare an exchange"
+	OpDeclareQueue    = "declare a queue"
+	OpBind            = "bind a queue"
+	OpConfirm         = "enable publisher confirms"
+	OpPublish         = "publish a message"
+	OpWaitConfirm     = "confirm a message"
+	OpQos             = "set Qos"
+	OpConsume         = "consume"
+	OpCancel          = "cancel a consumer"
+)
+
+// Error is a failed RabbitMQ step. It wraps the cause and, when the cause
+// can be classified, one of ErrAuthFailed, ErrUnreachable or ErrPreconditionFailed.
+type Error struct {
+	Op       string // failing step, one of the Op constants
+	Resource string // exchange or queue name, empty when not relevant
+	Code     int    // AMQP reply code, 0 when the cause isn't an AMQP error
+	Err      error
+	kind     error
+}
+
+func newError(op, resource string, err error) *Error {
+	e := &Error{Op: op, Resource: resource, Err: err}
+
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) {
+		e.Code = amqpErr.Code
+	}
+
+	var netErr net.Error
+	switch {
+	case e.Code == amqp.AccessRefused && op == OpConnect:
+		e.kind = ErrAuthFailed
+	case e.Code == amqp.PreconditionFailed:
+		e.kind = ErrPreconditionFailed
+	case op == OpConnect && errors.As(err, &netErr):
+		e.kind = ErrUnreachable
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	msg := "failed to " + e.Op
+	if e.Resource != "" {
+		msg += fmt.Sprintf(" %q", e.Resource)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if e.Code != 0 {
+		msg += fmt.Sprintf(" (reply code %d)", e.Code)
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() []error {
+	if e.kind != nil {
+		return []error{e.kind, e.Err}
+	}
+	return []error{e.Err}
+}
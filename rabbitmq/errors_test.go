@@ -0,0 +1,59 @@
+package rabbitmq
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewError(t *testing.T) {
+	t.Run("refused credentials", func(t *testing.T) {
+		err := newError(OpConnect, "", amqp.ErrCredentials)
+
+		require.ErrorIs(t, err, ErrAuthFailed)
+		require.ErrorIs(t, err, amqp.ErrCredentials)
+		require.Equal(t, amqp.AccessRefused, err.Code)
+		require.Equal(t, `failed to connect to RabbitMQ: Exception (403) Reason: "username or password not allowed" (reply code 403)`, err.Error())
+	})
+
+	t.Run("unreachable broker", func(t *testing.T) {
+		cause := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+		err := newError(OpConnect, "", cause)
+
+		require.ErrorIs(t, err, ErrUnreachable)
+		require.NotErrorIs(t, err, ErrAuthFailed)
+		require.Zero(t, err.Code)
+	})
+
+	t.Run("precondition failed", func(t *testing.T) {
+		cause := &amqp.Error{Code: amqp.PreconditionFailed, Reason: "PRECONDITION_FAILED - inequivalent arg 'x-queue-type'"}
+		err := newError(OpDeclareQueue, "casino", cause)
+
+		require.ErrorIs(t, err, ErrPreconditionFailed)
+		require.ErrorContains(t, err, `failed to declare a queue "casino"`)
+		require.ErrorContains(t, err, "(reply code 406)")
+
+		var amqpErr *amqp.Error
+		require.ErrorAs(t, err, &amqpErr)
+		require.Same(t, cause, amqpErr)
+	})
+
+	t.Run("access refused after connecting", func(t *testing.T) {
+		cause := &amqp.Error{Code: amqp.AccessRefused, Reason: "ACCESS_REFUSED - queue name 'amq.queueName' contains reserved prefix 'amq.*'"}
+		err := newError(OpDeclareQueue, "amq.queueName", cause)
+
+		require.NotErrorIs(t, err, ErrAuthFailed)
+		require.Equal(t, amqp.AccessRefused, err.Code)
+	})
+
+	t.Run("closed channel", func(t *testing.T) {
+		err := newError(OpPublish, "", amqp.ErrClosed)
+
+		require.ErrorIs(t, err, amqp.ErrClosed)
+		require.ErrorContains(t, err, "failed to publish a message: ")
+		require.ErrorContains(t, err, "channel/connection is not open")
+	})
+}
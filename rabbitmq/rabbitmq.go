@@ -1,111 +1,434 @@
 package rabbitmq
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync"
+	"time"
+	"transaction-management-system/logging"
+	"transaction-management-system/message"
+	"transaction-management-system/tracing"
 	"transaction-management-system/transaction"
 
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
 )
 
-// RabbitMQ represents a wrapper for RabbitMQ connection and channel
+const (
+	// Wait before the first reconnect attempt, doubled after each failed one
+	reconnectBackoff    = time.Second
+	maxReconnectBackoff = 30 * time.Second
+	// How often Wait checks whether the channel is open again
+	waitInterval = 100 * time.Millisecond
+)
+
+// RabbitMQ represents a wrapper for RabbitMQ connection and channel. When the broker
+// or the network closes them it connects again, see watch.
 type RabbitMQ struct {
+	mu      sync.Mutex
 	conn    *amqp.Connection
 	channel *amqp.Channel
+	// uri and topology are dialed again on reconnect, confirms is set by EnableConfirms
+	uri      string
+	topology Topology
+	confirms bool
+	closed   bool
+	done     chan struct{}
+	// exchange and shards route published transactions, see Topology
+	exchange string
+	shards   int
+	// Codec encodes published transactions, message.Legacy when nil
+	Codec message.Codec
 }
 
-// GetInstance returns a instance of RabbitMQ
+// GetInstance returns a instance of RabbitMQ with the default topology for the queue
 func GetInstance(amqpURI, queueName string) (*RabbitMQ, error) {
-	conn, err := amqp.Dial(amqpURI)
+	return Connect(amqpURI, DefaultTopology(queueName))
+}
+
+// Connect returns a instance of RabbitMQ after declaring the topology
+func Connect(amqpURI string, topology Topology) (*RabbitMQ, error) {
+	conn, channel, err := dial(amqpURI, topology)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to RabbitMQ")
+		return nil, err
 	}
 
-	channel, err := conn.Channel()
+	r := &RabbitMQ{
+		conn:     conn,
+		channel:  channel,
+		uri:      amqpURI,
+		topology: topology,
+		done:     make(chan struct{}),
+		exchange: topology.PublishExchange,
+		shards:   topology.Shards,
+	}
+	go r.watch(conn, channel)
+	return r, nil
+}
+
+// dial opens a connection and a channel with the current dial settings and declares the topology
+func dial(amqpURI string, topology Topology) (*amqp.Connection, *amqp.Channel, error) {
+	config, err := dialConfig(amqpURI)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open a channel")
+		return nil, nil, newError(OpConnect, "", err)
 	}
 
-	_, err = channel.QueueDeclare(
-		queueName, // name
-		true,      // durable
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // arguments
-	)
+	conn, err := amqp.DialConfig(amqpURI, config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to declare a queue")
+		return nil, nil, newError(OpConnect, "", err)
 	}
 
-	return &RabbitMQ{
-		conn:    conn,
-		channel: channel,
-	}, nil
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, nil, newError(OpChannel, "", err)
+	}
+
+	if err := declare(channel, topology); err != nil {
+		// A failed declaration closes the channel, the connection is still open
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, channel, nil
+}
+
+// watch waits until the connection or the channel closes with an error, then dials
+// again with backoff until it succeeds or Close is called. Dialing reads the
+// credentials and TLS files again, so rotated secrets apply. A close without error
+// was asked for by the client, e.g. by Close, and ends the watch.
+func (r *RabbitMQ) watch(conn *amqp.Connection, channel *amqp.Channel) {
+	for {
+		connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		channelClosed := channel.NotifyClose(make(chan *amqp.Error, 1))
+
+		var cause *amqp.Error
+		select {
+		case cause = <-connClosed:
+		case cause = <-channelClosed:
+		case <-r.done:
+			return
+		}
+		if cause == nil {
+			return
+		}
+		slog.Warn("RabbitMQ connection lost, reconnecting", "error", cause)
+		// The connection may still be open when only the channel failed
+		conn.Close()
+
+		var err error
+		conn, channel, err = r.reconnect()
+		if err != nil {
+			return
+		}
+		slog.Info("RabbitMQ reconnected")
+	}
+}
+
+// reconnect dials until it succeeds and swaps in the new connection and channel.
+// It fails only when Close is called meanwhile.
+func (r *RabbitMQ) reconnect() (*amqp.Connection, *amqp.Channel, error) {
+	backoff := reconnectBackoff
+	for {
+		select {
+		case <-r.done:
+			return nil, nil, amqp.ErrClosed
+		case <-time.After(backoff):
+		}
+
+		conn, channel, err := dial(r.uri, r.topology)
+		if err == nil {
+			err = r.swap(conn, channel)
+			if err == nil {
+				return conn, channel, nil
+			}
+			conn.Close()
+			if errors.Is(err, amqp.ErrClosed) {
+				return nil, nil, err
+			}
+		}
+		slog.Warn("Failed to reconnect to RabbitMQ", "backoff", backoff, "error", err)
+		backoff = min(2*backoff, maxReconnectBackoff)
+	}
+}
+
+// swap replaces the connection and channel, putting the channel in confirm mode when
+// EnableConfirms was called. It fails with amqp.ErrClosed after Close.
+func (r *RabbitMQ) swap(conn *amqp.Connection, channel *amqp.Channel) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return amqp.ErrClosed
+	}
+	if r.confirms {
+		if err := channel.Confirm(false); err != nil {
+			return newError(OpConfirm, "", err)
+		}
+	}
+	r.conn, r.channel = conn, channel
+	return nil
+}
+
+// current returns the channel of the current connection
+func (r *RabbitMQ) current() *amqp.Channel {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.channel
+}
+
+// Wait blocks until the channel is open, e.g. after a reconnect, or until ctx is done.
+// It returns amqp.ErrClosed after Close.
+func (r *RabbitMQ) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(waitInterval)
+	defer ticker.Stop()
+	for {
+		r.mu.Lock()
+		closed, open := r.closed, !r.channel.IsClosed()
+		r.mu.Unlock()
+		switch {
+		case closed:
+			return amqp.ErrClosed
+		case open:
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// declare declares the topology's exchanges, then its queues, then its bindings
+func declare(channel *amqp.Channel, topology Topology) error {
+	for _, e := range topology.Exchanges {
+		err := channel.ExchangeDeclare(
+			e.Name,    // name
+			e.Kind,    // kind
+			e.Durable, // durable
+			false,     // auto-deleted
+			false,     // internal
+			false,     // no-wait
+			nil,       // arguments
+		)
+		if err != nil {
+			return newError(OpDeclareExchange, e.Name, err)
+		}
+	}
+
+	for _, q := range topology.Queues {
+		_, err := channel.QueueDeclare(
+			q.Name,        // name
+			q.Durable,     // durable
+			false,         // delete when unused
+			false,         // exclusive
+			false,         // no-wait
+			q.Arguments(), // arguments
+		)
+		if err != nil {
+			qerr := newError(OpDeclareQueue, q.Name, err)
+			if errors.Is(qerr, ErrPreconditionFailed) {
+				// The queue exists with other arguments, redeclaring can't change them
+				qerr.Err = fmt.Errorf("it already exists with different settings, "+
+					"delete the queue or align the topology with it: %w", err)
+			}
+			return qerr
+		}
+	}
+
+	for _, b := range topology.Bindings {
+		err := channel.QueueBind(
+			b.Queue,      // queue
+			b.RoutingKey, // routing key
+			b.Exchange,   // exchange
+			false,        // no-wait
+			nil,          // arguments
+		)
+		if err != nil {
+			return newError(OpBind, b.Queue, fmt.Errorf("exchange %q: %w", b.Exchange, err))
+		}
+	}
+	return nil
 }
 
-// Close closes the RabbitMQ connection and channel
+// Close closes the RabbitMQ channel and connection, the connection is closed even when the channel fails to.
+// It stops reconnecting.
 func (r *RabbitMQ) Close() error {
-	if r.channel != nil {
-		if closeErr := r.channel.Close(); closeErr != nil {
-			return closeErr
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.done)
+	}
+	conn, channel := r.conn, r.channel
+	r.mu.Unlock()
+
+	var errs []error
+	if channel != nil {
+		if closeErr := channel.Close(); closeErr != nil {
+			errs = append(errs, closeErr)
 		}
 	}
-	if r.conn != nil {
-		if closeErr := r.conn.Close(); closeErr != nil {
-			return closeErr
+	if conn != nil {
+		if closeErr := conn.Close(); closeErr != nil {
+			errs = append(errs, closeErr)
 		}
 	}
-	log.Println("RabbitMQ closed succesfully")
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	slog.Info("RabbitMQ closed")
 	return nil
 }
 
-func (r *RabbitMQ) Publish(queueName string, transaction transaction.Transaction) error {
-	// Marshal to JSON
-	body, err := json.Marshal(transaction)
+// Publish sends the transaction to the publish exchange, or straight to the queue when there is none.
+// The correlation id of ctx is sent as the message's correlation id and its trace context in the headers.
+func (r *RabbitMQ) Publish(ctx context.Context, queueName string, transaction transaction.Transaction) (err error) {
+	exchange, routingKey := r.route(queueName, transaction)
+	ctx, span := startPublish(ctx, exchange, routingKey)
+	defer func() { tracing.End(span, err) }()
+
+	msg, err := newPublishing(ctx, r.codec(), transaction)
+	if err != nil {
+		return err
+	}
+
+	err = r.current().PublishWithContext(
+		ctx,
+		exchange,   // exchange
+		routingKey, // routing key
+		false,      // mandatory
+		false,      // immediate
+		msg)
 	if err != nil {
-		return fmt.Errorf("failed to marshal transaction: %v", err)
-	}
-
-	err = r.channel.Publish(
-		"",        // exchange
-		queueName, // routing key
-		false,     // mandatory
-		false,     // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "application/json",
-			Body:         []byte(body),
-		})
+		return newError(OpPublish, exchange, err)
+	}
+
+	return nil
+}
+
+// EnableConfirms puts the channel in confirm mode, required by PublishWithConfirm.
+// Channels opened on reconnect are put in confirm mode too.
+func (r *RabbitMQ) EnableConfirms() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.channel.Confirm(false); err != nil {
+		return newError(OpConfirm, "", err)
+	}
+	r.confirms = true
+	return nil
+}
+
+// PublishWithConfirm publishes the transaction and waits until the broker confirms it
+func (r *RabbitMQ) PublishWithConfirm(ctx context.Context, queueName, messageId string, transaction transaction.Transaction) (err error) {
+	exchange, routingKey := r.route(queueName, transaction)
+	ctx, span := startPublish(ctx, exchange, routingKey)
+	defer func() { tracing.End(span, err) }()
+
+	msg, err := newPublishing(ctx, r.codec(), transaction)
+	if err != nil {
+		return err
+	}
+	msg.MessageId = messageId
+
+	confirmation, err := r.current().PublishWithDeferredConfirmWithContext(
+		ctx,
+		exchange,   // exchange
+		routingKey, // routing key
+		false,      // mandatory
+		false,      // immediate
+		msg)
 	if err != nil {
-		return fmt.Errorf("failed to publish a message: %v", err)
+		return newError(OpPublish, exchange, err)
+	}
+	if confirmation == nil {
+		return newError(OpPublish, exchange, errors.New("publisher confirms are not enabled"))
 	}
 
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return newError(OpWaitConfirm, messageId, err)
+	}
+	if !acked {
+		return newError(OpWaitConfirm, messageId, errors.New("nacked by the broker"))
+	}
 	return nil
 }
 
+// route returns the exchange and routing key of a transaction
+func (r *RabbitMQ) route(queueName string, transaction transaction.Transaction) (string, string) {
+	if r.exchange == "" {
+		return "", queueName
+	}
+	return r.exchange, RoutingKey(transaction, r.shards)
+}
+
+func (r *RabbitMQ) codec() message.Codec {
+	if r.Codec == nil {
+		return message.Legacy
+	}
+	return r.Codec
+}
+
+// newPublishing encodes the transaction as a persistent message of the codec's content type,
+// carrying the correlation id and the W3C trace context of ctx
+func newPublishing(ctx context.Context, codec message.Codec, transaction transaction.Transaction) (amqp.Publishing, error) {
+	body, err := codec.Encode(transaction)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	headers := amqp.Table{}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+
+	return amqp.Publishing{
+		Headers:       headers,
+		DeliveryMode:  amqp.Persistent,
+		ContentType:   codec.ContentType(),
+		CorrelationId: logging.CorrelationID(ctx),
+		Body:          body,
+	}, nil
+}
+
+// Consume delivers the queue's messages one at a time, each must be acked or nacked.
+// The delivery channel closes when the connection is lost, see Wait.
 func (r *RabbitMQ) Consume(queueName string) (<-chan amqp.Delivery, error) {
-	if err := r.channel.Qos(
+	channel := r.current()
+	if err := channel.Qos(
 		1,     // prefetch count
 		0,     // prefetch size
 		false, // global
 	); err != nil {
-		return nil, err
+		return nil, newError(OpQos, "", err)
 	}
 
-	msgs, err := r.channel.Consume(
-		queueName, // queue
-		"",        // consumer
-		true,      // auto-ack
-		false,     // exclusive
-		false,     // no-local
-		false,     // no-wait
-		nil,       // args
+	msgs, err := channel.Consume(
+		queueName,              // queue
+		consumerTag(queueName), // consumer
+		false,                  // auto-ack, the consumer settles each message
+		false,                  // exclusive
+		false,                  // no-local
+		false,                  // no-wait
+		nil,                    // args
 	)
 	if err != nil {
-		return nil, err
+		return nil, newError(OpConsume, queueName, err)
 	}
 
 	return msgs, nil
 }
+
+// Cancel stops the consumer started by Consume on the queue. Its delivery channel is
+// closed, unacked messages stay with the caller until they are acked or nacked.
+func (r *RabbitMQ) Cancel(queueName string) error {
+	if err := r.current().Cancel(consumerTag(queueName), false); err != nil {
+		return newError(OpCancel, queueName, err)
+	}
+	return nil
+}
+
+// consumerTag identifies the channel's consumer of the queue, so it can be cancelled
+func consumerTag(queueName string) string {
+	return "consumer-" + queueName
+}
@@ -0,0 +1,221 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"transaction-management-system/transaction"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// Exchange transactions are published to by default
+	DefaultExchange = "transactions"
+	// Number of user shards encoded in routing keys by default
+	DefaultShards = 16
+)
+
+// ExchangeSpec declares an exchange
+type ExchangeSpec struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"` // "direct", "fanout", "topic" or "headers"
+	Durable bool   `json:"durable"`
+}
+
+// QueueSpec declares a queue. Zero values leave the broker defaults:
+// a classic queue without TTL, length limit or dead lettering.
+type QueueSpec struct {
+	Name    string `json:"name"`
+	Durable bool   `json:"durable"`
+	// Type is "classic" or "quorum"
+	Type string `json:"type,omitempty"`
+	// DeliveryLimit dead letters a message after that many redeliveries (quorum only)
+	DeliveryLimit int `json:"delivery_limit,omitempty"`
+	// MessageTTL expires messages after that many milliseconds
+	MessageTTL int64 `json:"message_ttl,omitempty"`
+	// MaxLength caps the number of ready messages, Overflow decides what happens beyond it
+	MaxLength int64 `json:"max_length,omitempty"`
+	// Overflow is "drop-head", "reject-publish" or "reject-publish-dlx"
+	Overflow string `json:"overflow,omitempty"`
+	// DeadLetterExchange receives expired, dropped and rejected messages
+	DeadLetterExchange   string `json:"dead_letter_exchange,omitempty"`
+	DeadLetterRoutingKey string `json:"dead_letter_routing_key,omitempty"`
+}
+
+// Arguments returns the queue's x-arguments, nil for a plain classic queue
+func (q QueueSpec) Arguments() amqp.Table {
+	args := amqp.Table{}
+	if q.Type != "" {
+		args["x-queue-type"] = q.Type
+	}
+	if q.DeliveryLimit > 0 {
+		args["x-delivery-limit"] = int64(q.DeliveryLimit)
+	}
+	if q.MessageTTL > 0 {
+		args["x-message-ttl"] = q.MessageTTL
+	}
+	if q.MaxLength > 0 {
+		args["x-max-length"] = q.MaxLength
+	}
+	if q.Overflow != "" {
+		args["x-overflow"] = q.Overflow
+	}
+	if q.DeadLetterExchange != "" {
+		args["x-dead-letter-exchange"] = q.DeadLetterExchange
+	}
+	if q.DeadLetterRoutingKey != "" {
+		args["x-dead-letter-routing-key"] = q.DeadLetterRoutingKey
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return args
+}
+
+func (q QueueSpec) validate(exchanges map[string]bool) error {
+	switch q.Type {
+	case "", "classic":
+		if q.DeliveryLimit != 0 {
+			return errors.New("delivery_limit requires a quorum queue")
+		}
+	case "quorum":
+		if !q.Durable {
+			return errors.New("quorum queues must be durable")
+		}
+		if q.Overflow == "reject-publish-dlx" {
+			return errors.New("quorum queues don't support overflow reject-publish-dlx")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", q.Type)
+	}
+
+	if q.DeliveryLimit < 0 || q.MessageTTL < 0 || q.MaxLength < 0 {
+		return errors.New("delivery_limit, message_ttl and max_length can't be negative")
+	}
+
+	switch q.Overflow {
+	case "":
+	case "drop-head", "reject-publish", "reject-publish-dlx":
+		if q.MaxLength == 0 {
+			return errors.New("overflow requires max_length")
+		}
+	default:
+		return fmt.Errorf("unknown overflow %q", q.Overflow)
+	}
+
+	if q.DeadLetterExchange != "" && !exchanges[q.DeadLetterExchange] && !strings.HasPrefix(q.DeadLetterExchange, "amq.") {
+		return fmt.Errorf("dead letter exchange %q is not declared", q.DeadLetterExchange)
+	}
+	if q.DeadLetterRoutingKey != "" && q.DeadLetterExchange == "" {
+		return errors.New("dead_letter_routing_key requires dead_letter_exchange")
+	}
+	return nil
+}
+
+// BindingSpec binds a queue to an exchange
+type BindingSpec struct {
+	Queue      string `json:"queue"`
+	Exchange   string `json:"exchange"`
+	RoutingKey string `json:"routing_key"`
+}
+
+// Topology describes the exchanges, queues and bindings to declare,
+// and where transactions are published
+type Topology struct {
+	Exchanges []ExchangeSpec `json:"exchanges"`
+	Queues    []QueueSpec    `json:"queues"`
+	Bindings  []BindingSpec  `json:"bindings"`
+	// PublishExchange receives published transactions with routing keys
+	// "tx.<type>.<shard>", empty publishes straight to the queue
+	PublishExchange string `json:"publish_exchange"`
+	// Shards is the number of user shards, a user's shard is user_id % Shards
+	Shards int `json:"shards"`
+}
+
+// DefaultTopology returns a durable topic exchange routing every transaction to the queue
+func DefaultTopology(queueName string) Topology {
+	return Topology{
+		Exchanges: []ExchangeSpec{{Name: DefaultExchange, Kind: "topic", Durable: true}},
+		Queues:    []QueueSpec{{Name: queueName, Durable: true}},
+		Bindings:  []BindingSpec{{Queue: queueName, Exchange: DefaultExchange, RoutingKey: "tx.#"}},
+
+		PublishExchange: DefaultExchange,
+		Shards:          DefaultShards,
+	}
+}
+
+// LoadTopology reads a topology from a JSON file
+func LoadTopology(path string) (Topology, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Topology{}, fmt.Errorf("failed to read topology: %w", err)
+	}
+	var t Topology
+	if err := json.Unmarshal(data, &t); err != nil {
+		return Topology{}, fmt.Errorf("failed to parse topology: %w", err)
+	}
+	if t.PublishExchange != "" && t.Shards == 0 {
+		t.Shards = DefaultShards
+	}
+	if err := t.Validate(); err != nil {
+		return Topology{}, fmt.Errorf("invalid topology: %w", err)
+	}
+	return t, nil
+}
+
+// Validate checks that bindings refer to declared (or predeclared) exchanges and queues
+func (t Topology) Validate() error {
+	exchanges := make(map[string]bool)
+	for _, e := range t.Exchanges {
+		switch e.Kind {
+		case "direct", "fanout", "topic", "headers":
+		default:
+			return fmt.Errorf("exchange %q: unknown kind %q", e.Name, e.Kind)
+		}
+		exchanges[e.Name] = true
+	}
+	queues := make(map[string]bool)
+	for _, q := range t.Queues {
+		if q.Name == "" {
+			return errors.New("queue name is required")
+		}
+		queues[q.Name] = true
+	}
+	for _, q := range t.Queues {
+		if err := q.validate(exchanges); err != nil {
+			return fmt.Errorf("queue %q: %w", q.Name, err)
+		}
+	}
+
+	for _, b := range t.Bindings {
+		if !queues[b.Queue] {
+			return fmt.Errorf("binding refers to undeclared queue %q", b.Queue)
+		}
+		if !exchanges[b.Exchange] && !strings.HasPrefix(b.Exchange, "amq.") {
+			return fmt.Errorf("binding refers to undeclared exchange %q", b.Exchange)
+		}
+	}
+
+	if t.PublishExchange != "" {
+		if !exchanges[t.PublishExchange] && !strings.HasPrefix(t.PublishExchange, "amq.") {
+			return fmt.Errorf("publish exchange %q is not declared", t.PublishExchange)
+		}
+		if t.Shards < 1 {
+			return errors.New("shards must be at least 1")
+		}
+	}
+	return nil
+}
+
+// RoutingKey returns "tx.<type>.<shard>" for the transaction,
+// so consumers can bind to e.g. "tx.win.*" or "tx.*.3"
+func RoutingKey(tr transaction.Transaction, shards int) string {
+	shard := tr.UserId % shards
+	if shard < 0 {
+		shard += shards
+	}
+	return fmt.Sprintf("tx.%s.%d", tr.TransactionType, shard)
+}
@@ -0,0 +1,159 @@
+package rabbitmq
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"transaction-management-system/transaction"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutingKey(t *testing.T) {
+	tr := transaction.Transaction{UserId: 35, TransactionType: transaction.WIN}
+	require.Equal(t, "tx.win.3", RoutingKey(tr, 16))
+
+	tr = transaction.Transaction{UserId: 7, TransactionType: transaction.BET}
+	require.Equal(t, "tx.bet.0", RoutingKey(tr, 1))
+}
+
+func TestTopologyValidate(t *testing.T) {
+	t.Run("default topology", func(t *testing.T) {
+		require.NoError(t, DefaultTopology("casino").Validate())
+	})
+
+	t.Run("unknown exchange kind", func(t *testing.T) {
+		topology := DefaultTopology("casino")
+		topology.Exchanges[0].Kind = "round-robin"
+		require.ErrorContains(t, topology.Validate(), "unknown kind")
+	})
+
+	t.Run("binding to undeclared queue", func(t *testing.T) {
+		topology := DefaultTopology("casino")
+		topology.Bindings = append(topology.Bindings, BindingSpec{Queue: "wins", Exchange: DefaultExchange, RoutingKey: "tx.win.*"})
+		require.ErrorContains(t, topology.Validate(), `undeclared queue "wins"`)
+	})
+
+	t.Run("binding to undeclared exchange", func(t *testing.T) {
+		topology := DefaultTopology("casino")
+		topology.Bindings[0].Exchange = "missing"
+		require.ErrorContains(t, topology.Validate(), `undeclared exchange "missing"`)
+	})
+
+	t.Run("predeclared exchange", func(t *testing.T) {
+		topology := DefaultTopology("casino")
+		topology.Bindings[0].Exchange = "amq.topic"
+		require.NoError(t, topology.Validate())
+	})
+
+	t.Run("undeclared publish exchange", func(t *testing.T) {
+		topology := DefaultTopology("casino")
+		topology.PublishExchange = "missing"
+		require.ErrorContains(t, topology.Validate(), "is not declared")
+	})
+
+	t.Run("no shards", func(t *testing.T) {
+		topology := DefaultTopology("casino")
+		topology.Shards = 0
+		require.ErrorContains(t, topology.Validate(), "shards must be at least 1")
+	})
+
+	t.Run("direct publishing needs no shards", func(t *testing.T) {
+		topology := Topology{Queues: []QueueSpec{{Name: "casino", Durable: true}}}
+		require.NoError(t, topology.Validate())
+	})
+}
+
+func TestQueueArguments(t *testing.T) {
+	require.Nil(t, QueueSpec{Name: "casino", Durable: true}.Arguments())
+
+	q := QueueSpec{
+		Name:                 "casino",
+		Durable:              true,
+		Type:                 "quorum",
+		DeliveryLimit:        5,
+		MessageTTL:           60000,
+		MaxLength:            100000,
+		Overflow:             "reject-publish",
+		DeadLetterExchange:   "transactions.dlx",
+		DeadLetterRoutingKey: "casino.dead",
+	}
+	require.Equal(t, amqp.Table{
+		"x-queue-type":              "quorum",
+		"x-delivery-limit":          int64(5),
+		"x-message-ttl":             int64(60000),
+		"x-max-length":              int64(100000),
+		"x-overflow":                "reject-publish",
+		"x-dead-letter-exchange":    "transactions.dlx",
+		"x-dead-letter-routing-key": "casino.dead",
+	}, q.Arguments())
+}
+
+func TestQueueValidate(t *testing.T) {
+	exchanges := map[string]bool{"transactions.dlx": true}
+	tests := []struct {
+		name  string
+		queue QueueSpec
+		err   string
+	}{
+		{"quorum with limits", QueueSpec{Name: "q", Durable: true, Type: "quorum", DeliveryLimit: 5, MaxLength: 10, Overflow: "drop-head", DeadLetterExchange: "transactions.dlx"}, ""},
+		{"unknown type", QueueSpec{Name: "q", Type: "stream-ish"}, "unknown type"},
+		{"transient quorum", QueueSpec{Name: "q", Type: "quorum"}, "must be durable"},
+		{"classic delivery limit", QueueSpec{Name: "q", DeliveryLimit: 5}, "requires a quorum queue"},
+		{"quorum reject-publish-dlx", QueueSpec{Name: "q", Durable: true, Type: "quorum", MaxLength: 10, Overflow: "reject-publish-dlx"}, "don't support"},
+		{"negative ttl", QueueSpec{Name: "q", MessageTTL: -1}, "can't be negative"},
+		{"overflow without max length", QueueSpec{Name: "q", Overflow: "drop-head"}, "requires max_length"},
+		{"unknown overflow", QueueSpec{Name: "q", MaxLength: 10, Overflow: "block"}, "unknown overflow"},
+		{"undeclared dlx", QueueSpec{Name: "q", DeadLetterExchange: "missing"}, "is not declared"},
+		{"routing key without dlx", QueueSpec{Name: "q", DeadLetterRoutingKey: "dead"}, "requires dead_letter_exchange"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.queue.validate(exchanges)
+			if tt.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestLoadTopology(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := LoadTopology(filepath.Join(dir, "missing.json"))
+		require.ErrorContains(t, err, "failed to read topology")
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		path := filepath.Join(dir, "invalid.json")
+		require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+
+		_, err := LoadTopology(path)
+		require.ErrorContains(t, err, "failed to parse topology")
+	})
+
+	t.Run("consumer groups with default shards", func(t *testing.T) {
+		path := filepath.Join(dir, "topology.json")
+		data := `{
+			"exchanges": [{"name": "transactions", "kind": "topic", "durable": true}],
+			"queues": [{"name": "casino", "durable": true}, {"name": "wins", "durable": true}],
+			"bindings": [
+				{"queue": "casino", "exchange": "transactions", "routing_key": "tx.#"},
+				{"queue": "wins", "exchange": "transactions", "routing_key": "tx.win.*"}
+			],
+			"publish_exchange": "transactions"
+		}`
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+
+		topology, err := LoadTopology(path)
+		require.NoError(t, err)
+		require.Len(t, topology.Bindings, 2)
+		require.Equal(t, "tx.win.*", topology.Bindings[1].RoutingKey)
+		require.Equal(t, DefaultShards, topology.Shards)
+	})
+}
@@ -0,0 +1,74 @@
+package rabbitmq
+
+import (
+	"context"
+	"transaction-management-system/tracing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// headerCarrier reads and writes trace context in AMQP message headers
+type headerCarrier amqp.Table
+
+func (c headerCarrier) Get(key string) string {
+	value, _ := c[key].(string)
+	return value
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Extract returns ctx with the trace context the publisher put in the delivery's headers
+func Extract(ctx context.Context, msg amqp.Delivery) context.Context {
+	if msg.Headers == nil {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, headerCarrier(msg.Headers))
+}
+
+// StartProcess starts the consumer span of a delivery, as a child of the publisher's span
+func StartProcess(ctx context.Context, msg amqp.Delivery) (context.Context, trace.Span) {
+	destination := msg.Exchange
+	if destination == "" {
+		// The default exchange routes by queue name
+		destination = msg.RoutingKey
+	}
+	return tracing.Start(Extract(ctx, msg), "process "+destination,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemRabbitMQ,
+			semconv.MessagingOperationTypeProcess,
+			semconv.MessagingDestinationName(destination),
+			semconv.MessagingRabbitMQDestinationRoutingKey(msg.RoutingKey),
+		),
+	)
+}
+
+// startPublish starts the producer span of a message sent to the exchange
+func startPublish(ctx context.Context, exchange, routingKey string) (context.Context, trace.Span) {
+	destination := exchange
+	if destination == "" {
+		destination = routingKey
+	}
+	return tracing.Start(ctx, "publish "+destination,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemRabbitMQ,
+			semconv.MessagingOperationTypeSend,
+			semconv.MessagingDestinationName(destination),
+			semconv.MessagingRabbitMQDestinationRoutingKey(routingKey),
+		),
+	)
+}
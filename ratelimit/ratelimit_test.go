@@ -0,0 +1,124 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLimiter(cfg Config) (*Limiter, *time.Time) {
+	now := time.Now()
+	l := NewLimiter(cfg)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestAllow(t *testing.T) {
+	cfg := Config{
+		Default: Limit{Rate: 1, Burst: 2},
+		Routes:  map[string]Limit{"/slow": {Rate: 0.5, Burst: 1}},
+	}
+
+	t.Run("successful burst then reject", func(t *testing.T) {
+		l, _ := newTestLimiter(cfg)
+
+		require.True(t, l.Allow("/transactions", "a").Allowed)
+		res := l.Allow("/transactions", "a")
+		require.True(t, res.Allowed)
+		require.Equal(t, 0, res.Remaining)
+
+		res = l.Allow("/transactions", "a")
+		require.False(t, res.Allowed)
+		require.Equal(t, time.Second, res.RetryAfter)
+	})
+
+	t.Run("successful refill over time", func(t *testing.T) {
+		l, now := newTestLimiter(cfg)
+
+		l.Allow("/transactions", "a")
+		l.Allow("/transactions", "a")
+		*now = now.Add(time.Second)
+
+		require.True(t, l.Allow("/transactions", "a").Allowed)
+	})
+
+	t.Run("clients and routes are independent", func(t *testing.T) {
+		l, _ := newTestLimiter(cfg)
+
+		require.True(t, l.Allow("/slow", "a").Allowed)
+		require.False(t, l.Allow("/slow", "a").Allowed)
+		require.True(t, l.Allow("/slow", "b").Allowed)
+		require.True(t, l.Allow("/transactions", "a").Allowed)
+	})
+
+	t.Run("idle buckets are swept", func(t *testing.T) {
+		l, now := newTestLimiter(cfg)
+
+		l.Allow("/transactions", "a")
+		*now = now.Add(2 * idleBucketTTL)
+		l.Allow("/transactions", "b")
+
+		require.Len(t, l.buckets, 1)
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	l, _ := newTestLimiter(Config{Default: Limit{Rate: 0.5, Burst: 1}})
+	handler := l.Middleware("/transactions", ClientIP, func(w http.ResponseWriter, r *http.Request) {})
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/transactions", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "1", rr.Header().Get("X-RateLimit-Limit"))
+	require.Equal(t, "0", rr.Header().Get("X-RateLimit-Remaining"))
+	require.Equal(t, "2", rr.Header().Get("X-RateLimit-Reset"))
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/transactions", nil))
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.Equal(t, "2", rr.Header().Get("Retry-After"))
+}
+
+func TestMaxBytes(t *testing.T) {
+	handler := MaxBytes(4, func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 16)
+		if _, err := r.Body.Read(buf); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		}
+	})
+
+	t.Run("failed declared length too large", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest("POST", "/", strings.NewReader("too long")))
+		require.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	})
+
+	t.Run("successful small body", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest("POST", "/", strings.NewReader("ok")))
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("failed missing file", func(t *testing.T) {
+		_, err := LoadConfig(filepath.Join(t.TempDir(), "missing.json"))
+		require.ErrorContains(t, err, "failed to read rate limit config")
+	})
+
+	t.Run("successful override", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "limits.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"default": {"rate": 5, "burst": 5}}`), 0o600))
+
+		cfg, err := LoadConfig(path)
+		require.NoError(t, err)
+		require.Equal(t, Limit{Rate: 5, Burst: 5}, cfg.Default)
+		require.Contains(t, cfg.Routes, "/transactions/export")
+	})
+}
@@ -0,0 +1,73 @@
+// Package secrets loads credentials and TLS material from the environment or
+// from mounted files. File based secrets are re-read when the file changes,
+// so rotated credentials apply to new connections without a restart.
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Secret returns the current value of a credential
+type Secret interface {
+	Get() (string, error)
+}
+
+// Static is a secret that never changes, e.g. a plain environment variable
+type Static string
+
+func (s Static) Get() (string, error) {
+	return string(s), nil
+}
+
+// File is a secret read from a file, re-read whenever the file's size or
+// modification time changes
+type File struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	value   string
+	loaded  bool
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Get returns the file's contents without the trailing newline
+func (f *File) Get() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret: %w", err)
+	}
+	if f.loaded && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.value, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret: %w", err)
+	}
+	f.value = strings.TrimRight(string(data), "\r\n")
+	f.modTime, f.size, f.loaded = info.ModTime(), info.Size(), true
+	return f.value, nil
+}
+
+// FromEnv returns the secret configured by the environment: the file named by
+// <name>_FILE when set, otherwise the value of <name>. It returns nil when neither is set.
+func FromEnv(name string) Secret {
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		return NewFile(path)
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return Static(value)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(path, []byte("first\n"), 0o600))
+
+	f := NewFile(path)
+	value, err := f.Get()
+	require.NoError(t, err)
+	require.Equal(t, "first", value)
+
+	t.Run("rotated secret is re-read", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("second"), 0o600))
+		later := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(path, later, later))
+
+		value, err := f.Get()
+		require.NoError(t, err)
+		require.Equal(t, "second", value)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := NewFile(filepath.Join(t.TempDir(), "missing")).Get()
+		require.ErrorContains(t, err, "failed to read secret")
+	})
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Run("unset", func(t *testing.T) {
+		require.Nil(t, FromEnv("SECRETS_TEST_UNSET"))
+	})
+
+	t.Run("plain variable", func(t *testing.T) {
+		t.Setenv("SECRETS_TEST_PASSWORD", "plain")
+
+		value, err := FromEnv("SECRETS_TEST_PASSWORD").Get()
+		require.NoError(t, err)
+		require.Equal(t, "plain", value)
+	})
+
+	t.Run("file takes precedence", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "password")
+		require.NoError(t, os.WriteFile(path, []byte("from-file\n"), 0o600))
+		t.Setenv("SECRETS_TEST_PASSWORD", "plain")
+		t.Setenv("SECRETS_TEST_PASSWORD_FILE", path)
+
+		value, err := FromEnv("SECRETS_TEST_PASSWORD").Get()
+		require.NoError(t, err)
+		require.Equal(t, "from-file", value)
+	})
+}
@@ -0,0 +1,109 @@
+package secrets
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSFiles names the PEM files of a TLS client configuration
+type TLSFiles struct {
+	CAFile     string // CA bundle verifying the server, system roots when empty
+	CertFile   string // client certificate, optional
+	KeyFile    string // client private key, required with CertFile
+	ServerName string // overrides the host name verified in the server certificate
+}
+
+// TLSFilesFromEnv reads <prefix>_TLS_CA, <prefix>_TLS_CERT, <prefix>_TLS_KEY and <prefix>_TLS_SERVER_NAME
+func TLSFilesFromEnv(prefix string) TLSFiles {
+	return TLSFiles{
+		CAFile:     os.Getenv(prefix + "_TLS_CA"),
+		CertFile:   os.Getenv(prefix + "_TLS_CERT"),
+		KeyFile:    os.Getenv(prefix + "_TLS_KEY"),
+		ServerName: os.Getenv(prefix + "_TLS_SERVER_NAME"),
+	}
+}
+
+// Enabled reports whether any TLS setting is present
+func (f TLSFiles) Enabled() bool {
+	return f != TLSFiles{}
+}
+
+// LoadTLSConfig builds a client tls.Config. The client certificate is re-read
+// when its files change, so a rotated certificate is used by the next handshake.
+func LoadTLSConfig(files TLSFiles) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: files.ServerName,
+	}
+
+	if files.CAFile != "" {
+		pem, err := os.ReadFile(files.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", files.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if (files.CertFile == "") != (files.KeyFile == "") {
+		return nil, errors.New("client certificate and key must be set together")
+	}
+	if files.CertFile != "" {
+		pair := &keyPair{certFile: files.CertFile, keyFile: files.KeyFile}
+		if _, err := pair.get(); err != nil {
+			return nil, err
+		}
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return pair.get()
+		}
+	}
+	return config, nil
+}
+
+// keyPair caches a client certificate and reloads it when the files change
+type keyPair struct {
+	certFile, keyFile string
+
+	mu              sync.Mutex
+	certMod, keyMod time.Time
+	cert            *tls.Certificate
+}
+
+func (p *keyPair) get() (*tls.Certificate, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	certInfo, certErr := os.Stat(p.certFile)
+	keyInfo, keyErr := os.Stat(p.keyFile)
+	if err := errors.Join(certErr, keyErr); err != nil {
+		if p.cert != nil {
+			slog.Warn("Keeping the current client certificate", "error", err)
+			return p.cert, nil
+		}
+		return nil, fmt.Errorf("failed to read client certificate: %w", err)
+	}
+	if p.cert != nil && certInfo.ModTime().Equal(p.certMod) && keyInfo.ModTime().Equal(p.keyMod) {
+		return p.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+	if err != nil {
+		// A rotation may be half written, keep using the previous pair until both files match
+		if p.cert != nil {
+			slog.Warn("Keeping the current client certificate", "error", err)
+			return p.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	p.cert, p.certMod, p.keyMod = &cert, certInfo.ModTime(), keyInfo.ModTime()
+	return p.cert, nil
+}
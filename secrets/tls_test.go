@@ -0,0 +1,109 @@
+package secrets
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes a self-signed certificate and its key, returning their paths
+func writeCert(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+	return certPath, keyPath
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return parsed.Subject.CommonName
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeCert(t, dir, "first")
+
+	t.Run("CA bundle and client certificate", func(t *testing.T) {
+		config, err := LoadTLSConfig(TLSFiles{CAFile: certPath, CertFile: certPath, KeyFile: keyPath, ServerName: "mysql.internal"})
+		require.NoError(t, err)
+		require.NotNil(t, config.RootCAs)
+		require.Equal(t, "mysql.internal", config.ServerName)
+
+		cert, err := config.GetClientCertificate(nil)
+		require.NoError(t, err)
+		require.Equal(t, "first", commonName(t, cert))
+
+		t.Run("rotated certificate is reloaded", func(t *testing.T) {
+			writeCert(t, dir, "second")
+			later := time.Now().Add(time.Minute)
+			require.NoError(t, os.Chtimes(certPath, later, later))
+			require.NoError(t, os.Chtimes(keyPath, later, later))
+
+			cert, err := config.GetClientCertificate(nil)
+			require.NoError(t, err)
+			require.Equal(t, "second", commonName(t, cert))
+		})
+
+		t.Run("broken rotation keeps the current certificate", func(t *testing.T) {
+			require.NoError(t, os.WriteFile(keyPath, []byte("partial"), 0o600))
+			later := time.Now().Add(2 * time.Minute)
+			require.NoError(t, os.Chtimes(keyPath, later, later))
+
+			cert, err := config.GetClientCertificate(nil)
+			require.NoError(t, err)
+			require.Equal(t, "second", commonName(t, cert))
+		})
+	})
+
+	t.Run("invalid CA bundle", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "ca.pem")
+		require.NoError(t, os.WriteFile(path, []byte("not a certificate"), 0o600))
+
+		_, err := LoadTLSConfig(TLSFiles{CAFile: path})
+		require.ErrorContains(t, err, "no certificates found")
+	})
+
+	t.Run("certificate without key", func(t *testing.T) {
+		_, err := LoadTLSConfig(TLSFiles{CertFile: certPath})
+		require.ErrorContains(t, err, "must be set together")
+	})
+}
+
+func TestTLSFilesFromEnv(t *testing.T) {
+	require.False(t, TLSFilesFromEnv("SECRETS_TEST").Enabled())
+
+	t.Setenv("SECRETS_TEST_TLS_CA", "/run/secrets/ca.pem")
+	files := TLSFilesFromEnv("SECRETS_TEST")
+	require.True(t, files.Enabled())
+	require.Equal(t, "/run/secrets/ca.pem", files.CAFile)
+}
@@ -0,0 +1,29 @@
+package tracing
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// Middleware starts a server span per request, continuing the trace of the
+// caller's traceparent header. Spans are named after the matched route, so
+// the name doesn't grow with path parameters.
+func Middleware(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http.server",
+		otelhttp.WithSpanNameFormatter(spanName),
+	)
+}
+
+// spanName returns "METHOD route", or only the method before routing
+func spanName(_ string, r *http.Request) string {
+	if r.Pattern == "" {
+		return r.Method
+	}
+	if strings.Contains(r.Pattern, " ") {
+		// The route includes its method
+		return r.Pattern
+	}
+	return r.Method + " " + r.Pattern
+}
@@ -0,0 +1,116 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters
+const (
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+)
+
+// instrumentation names the tracer of every span started by this module
+const instrumentation = "transaction-management-system"
+
+// Config selects where spans are exported
+type Config struct {
+	// Exporter is ExporterOTLP or ExporterNone. The OTLP endpoint, headers and
+	// TLS are read from the standard OTEL_EXPORTER_OTLP_* variables.
+	Exporter    string
+	ServiceName string
+	// SampleRatio is the fraction of new traces recorded, remote parents keep their decision
+	SampleRatio float64
+}
+
+// DefaultConfig exports nothing, trace context is still propagated
+func DefaultConfig() Config {
+	return Config{Exporter: ExporterNone, ServiceName: instrumentation, SampleRatio: 1}
+}
+
+// ConfigFromEnv reads TRACING_EXPORTER (otlp or none), OTEL_SERVICE_NAME and TRACING_SAMPLE_RATIO (0 to 1)
+func ConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	if exporter := os.Getenv("TRACING_EXPORTER"); exporter != "" {
+		if exporter != ExporterOTLP && exporter != ExporterNone {
+			return Config{}, fmt.Errorf("invalid TRACING_EXPORTER %q, expected otlp or none", exporter)
+		}
+		cfg.Exporter = exporter
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		cfg.ServiceName = name
+	}
+	if ratio := os.Getenv("TRACING_SAMPLE_RATIO"); ratio != "" {
+		r, err := strconv.ParseFloat(ratio, 64)
+		if err != nil || r < 0 || r > 1 {
+			return Config{}, fmt.Errorf("invalid TRACING_SAMPLE_RATIO %q, expected a number from 0 to 1", ratio)
+		}
+		cfg.SampleRatio = r
+	}
+	return cfg, nil
+}
+
+// Setup installs the W3C trace context propagator and, unless the exporter is
+// none, a global tracer provider exporting to it. The returned shutdown flushes
+// the spans still buffered and must be called before exiting.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if cfg.Exporter != ExporterOTLP {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span with the module's tracer of the global provider
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, opts...)
+}
+
+// End records err, if any, on the span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceParent returns the W3C traceparent of the span in ctx, or "" without a valid span.
+// It lets the trace continue after a hop through storage, see WithTraceParent.
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// WithTraceParent returns ctx with the remote span of a traceparent from TraceParent.
+// An empty or malformed traceparent leaves ctx unchanged.
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": traceParent})
+}
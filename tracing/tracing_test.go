@@ -0,0 +1,143 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"transaction-management-system/tracing/tracingtest"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		t.Setenv("TRACING_EXPORTER", "")
+		t.Setenv("OTEL_SERVICE_NAME", "")
+		t.Setenv("TRACING_SAMPLE_RATIO", "")
+		cfg, err := ConfigFromEnv()
+		require.NoError(t, err)
+		require.Equal(t, DefaultConfig(), cfg)
+	})
+
+	t.Run("successful otlp exporter", func(t *testing.T) {
+		t.Setenv("TRACING_EXPORTER", "otlp")
+		t.Setenv("OTEL_SERVICE_NAME", "casino-api")
+		t.Setenv("TRACING_SAMPLE_RATIO", "0.25")
+		cfg, err := ConfigFromEnv()
+		require.NoError(t, err)
+		require.Equal(t, Config{Exporter: ExporterOTLP, ServiceName: "casino-api", SampleRatio: 0.25}, cfg)
+	})
+
+	t.Run("failed invalid values", func(t *testing.T) {
+		t.Setenv("TRACING_EXPORTER", "jaeger")
+		_, err := ConfigFromEnv()
+		require.ErrorContains(t, err, "invalid TRACING_EXPORTER")
+
+		t.Setenv("TRACING_EXPORTER", "")
+		for _, ratio := range []string{"half", "-0.1", "2"} {
+			t.Setenv("TRACING_SAMPLE_RATIO", ratio)
+			_, err = ConfigFromEnv()
+			require.ErrorContains(t, err, "invalid TRACING_SAMPLE_RATIO")
+		}
+	})
+}
+
+func TestSetup(t *testing.T) {
+	_, restore := tracingtest.Setup()
+	defer restore()
+
+	shutdown, err := Setup(t.Context(), DefaultConfig())
+	require.NoError(t, err)
+	require.NoError(t, shutdown(t.Context()))
+}
+
+func TestEnd(t *testing.T) {
+	exporter, restore := tracingtest.Setup()
+	defer restore()
+
+	_, span := Start(t.Context(), "ok")
+	End(span, nil)
+	_, span = Start(t.Context(), "failed")
+	End(span, http.ErrServerClosed)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	require.Equal(t, codes.Unset, spans[0].Status.Code)
+	require.Equal(t, codes.Error, spans[1].Status.Code)
+	require.Len(t, spans[1].Events, 1)
+}
+
+func TestTraceParent(t *testing.T) {
+	_, restore := tracingtest.Setup()
+	defer restore()
+
+	t.Run("stored trace continues", func(t *testing.T) {
+		ctx, span := Start(t.Context(), "request")
+		defer span.End()
+
+		traceParent := TraceParent(ctx)
+		require.Len(t, traceParent, 55)
+
+		restored := trace.SpanContextFromContext(WithTraceParent(t.Context(), traceParent))
+		require.True(t, restored.IsRemote())
+		require.Equal(t, span.SpanContext().TraceID(), restored.TraceID())
+		require.Equal(t, span.SpanContext().SpanID(), restored.SpanID())
+	})
+
+	t.Run("no span", func(t *testing.T) {
+		require.Empty(t, TraceParent(t.Context()))
+		require.False(t, trace.SpanContextFromContext(WithTraceParent(t.Context(), "")).IsValid())
+		require.False(t, trace.SpanContextFromContext(WithTraceParent(t.Context(), "garbage")).IsValid())
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	exporter, restore := tracingtest.Setup()
+	defer restore()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/transactions", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("DELETE /admin/api-keys/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	handler := Middleware(mux)
+
+	t.Run("span named after the route continues the caller's trace", func(t *testing.T) {
+		exporter.Reset()
+		ctx, parent := Start(t.Context(), "client")
+		parent.End()
+
+		req := httptest.NewRequest("GET", "/transactions?limit=1", nil).WithContext(t.Context())
+		req.Header.Set("traceparent", TraceParent(ctx))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 2)
+		server := spans[1]
+		require.Equal(t, "GET /transactions", server.Name)
+		require.Equal(t, trace.SpanKindServer, server.SpanKind)
+		require.Equal(t, parent.SpanContext().TraceID(), server.SpanContext.TraceID())
+		require.Equal(t, parent.SpanContext().SpanID(), server.Parent.SpanID())
+	})
+
+	t.Run("path parameters don't name the span", func(t *testing.T) {
+		exporter.Reset()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/admin/api-keys/42", nil))
+
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 1)
+		require.Equal(t, "DELETE /admin/api-keys/{id}", spans[0].Name)
+		require.Equal(t, codes.Error, spans[0].Status.Code)
+	})
+
+	t.Run("unmatched route", func(t *testing.T) {
+		exporter.Reset()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/unknown", nil))
+
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 1)
+		require.Equal(t, "GET", spans[0].Name)
+	})
+}
@@ -2,33 +2,68 @@ package transaction
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
-	"log"
-	"math"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
-	"os"
-	"os/signal"
+	"net/url"
 	"strconv"
 	"sync"
-	"syscall"
 	"time"
-	"transaction-management-system/config"
+	"transaction-management-system/auth"
+	"transaction-management-system/breaker"
 	"transaction-management-system/database"
+	"transaction-management-system/fraud"
+	"transaction-management-system/limits"
+	"transaction-management-system/logging"
+	"transaction-management-system/ratelimit"
+	"transaction-management-system/tracing"
 )
 
 type TransactionApi struct {
 	Database *database.Database
+	Broker   *Broker
+	// Auth guards the routes by role, a nil Auth leaves them open
+	Auth *auth.Authenticator
+	// Limiter throttles each client per route, a nil Limiter disables throttling
+	Limiter *ratelimit.Limiter
+	// Breaker is the consumer's database breaker reported by /health and /metrics (optional)
+	Breaker *breaker.Breaker
+	// Limits rejects bets breaking the players' limits and serves their management routes,
+	// a nil Limits disables them
+	Limits *limits.Engine
+	// Fraud serves the alerts raised by the consumer's detector, a nil Fraud leaves them out
+	Fraud *fraud.Detector
+
+	// Server state, see ListenAndServe and Shutdown
+	mu           sync.Mutex
+	server       *http.Server
+	closing      context.Context // cancelled when the server shuts down
+	closeStreams context.CancelFunc
 }
 
-func NewTransactionApi() (*TransactionApi, error) {
-	db, err := database.GetDB(config.DB_SCHEMA)
-	if err != nil {
-		return nil, err
-	}
+const (
+	// Maximum accepted request body size
+	maxBodyBytes = 1 << 20
+
+	// Transactions returned without a limit parameter, and at most. Exports aren't bounded.
+	defaultPageSize = 100
+	maxPageSize     = 1000
 
+	// Server timeouts, streaming handlers lift the write deadline themselves
+	readHeaderTimeout = 5 * time.Second
+	readTimeout       = 15 * time.Second
+	writeTimeout      = 30 * time.Second
+	idleTimeout       = 120 * time.Second
+)
+
+// NewTransactionApi serves transactions from db, which stays owned by the caller
+func NewTransactionApi(db *database.Database) *TransactionApi {
 	return &TransactionApi{
 		Database: db,
-	}, nil
+	}
 }
 
 // GetTransactions handles GET requests for transaction data
@@ -36,37 +71,22 @@ func (tapi *TransactionApi) GetTransactions(w http.ResponseWriter, r *http.Reque
 	// Parse query parameters
 	query := r.URL.Query()
 
-	// Get optional user_id filter
-	var userId *int
-	if uid := query.Get("user_id"); uid != "" {
-		user_id, err := strconv.Atoi(uid)
-		if err != nil {
-			http.Error(w, "Invalid user id conversion", http.StatusBadRequest)
-			return
-		}
-		userId = &user_id
+	// Get optional user_id and transaction_type filters
+	userId, transactionType, err := parseFilters(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Get optional transaction_type filter ("bet", "win", or empty for all)
-	var transactionType *string
-	if tt := query.Get("transaction_type"); tt != "" {
-		if tt == "bet" || tt == "win" {
-			transactionType = &tt
-		} else {
-			http.Error(w, "Invalid transaction_type. Must be 'bet' or 'win'", http.StatusBadRequest)
-			return
-		}
+	if !checkScope(w, r, userId) {
+		return
 	}
 
-	// Get optional limit parameter
-	limit := math.MaxInt
-	if l := query.Get("limit"); l != "" {
-		lInt, err := strconv.Atoi(l)
-		if err != nil || lInt < 1 {
-			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
-			return
-		}
-		limit = lInt
+	// Get optional limit parameter, pages are bounded so a request can't scan the whole table
+	limit, err := parseLimit(query, defaultPageSize, maxPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	// Get transactions from database
@@ -77,11 +97,17 @@ func (tapi *TransactionApi) GetTransactions(w http.ResponseWriter, r *http.Reque
 	}
 	defer rows.Close()
 
+	// Stream CSV or NDJSON without buffering the whole result
+	if format := negotiateFormat(r.Header.Get("Accept")); format != "" {
+		streamRows(r.Context(), w, rows, format)
+		return
+	}
+
 	// Scan all transactions
 	var transactions []Transaction
 	for rows.Next() {
-		var t Transaction
-		if err := rows.Scan(&t.UserId, &t.TransactionType, &t.Amount, &t.Timestamp); err != nil {
+		t, err := scanTransaction(rows)
+		if err != nil {
 			http.Error(w, "Failed to scan transaction: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -99,42 +125,266 @@ func (tapi *TransactionApi) GetTransactions(w http.ResponseWriter, r *http.Reque
 	}
 }
 
-// RegisterRoutes sets up the HTTP routes for the Transaction API
+// parseFilters reads the optional user_id and transaction_type query parameters
+func parseFilters(query url.Values) (*int, *string, error) {
+	var userId *int
+	if uid := query.Get("user_id"); uid != "" {
+		user_id, err := strconv.Atoi(uid)
+		if err != nil {
+			return nil, nil, errors.New("Invalid user id conversion")
+		}
+		userId = &user_id
+	}
+
+	transactionType, err := parseTransactionType(query.Get("transaction_type"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return userId, transactionType, nil
+}
+
+// parseTransactionType reads a transaction type filter, "bet", "win", or empty for all
+func parseTransactionType(tt string) (*string, error) {
+	if tt == "" {
+		return nil, nil
+	}
+	if tt != BET && tt != WIN {
+		return nil, errors.New("Invalid transaction_type. Must be 'bet' or 'win'")
+	}
+	return &tt, nil
+}
+
+// Errors of authorizeUser
+var (
+	errUserRequired = errors.New("user_id is required for user scoped credentials")
+	errAccessDenied = errors.New("Access to user denied")
+)
+
+// checkScope rejects requests for users outside the caller's scope
+func checkScope(w http.ResponseWriter, r *http.Request, userId *int) bool {
+	if err := authorizeUser(r.Context(), userId); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// authorizeUser checks the user is in the scope of the caller in ctx.
+// Scoped credentials must always filter by one of their user ids.
+func authorizeUser(ctx context.Context, userId *int) error {
+	principal, ok := auth.FromContext(ctx)
+	if !ok || !principal.Scoped() {
+		return nil
+	}
+	if userId == nil {
+		return errUserRequired
+	}
+	if !principal.CanAccessUser(*userId) {
+		return errAccessDenied
+	}
+	return nil
+}
+
+// parseLimit reads the optional limit query parameter, def when it is left out and at most maxLimit
+func parseLimit(query url.Values, def, maxLimit int) (int, error) {
+	limit := def
+	if l := query.Get("limit"); l != "" {
+		lInt, err := strconv.Atoi(l)
+		if err != nil || lInt < 1 || lInt > maxLimit {
+			return 0, errors.New("Invalid limit parameter")
+		}
+		limit = lInt
+	}
+	return limit, nil
+}
+
+// scanTransaction reads the current row into a transaction
+func scanTransaction(rows *sql.Rows) (Transaction, error) {
+	var t Transaction
+	err := rows.Scan(&t.Id, &t.UserId, &t.TransactionType, &t.Amount, &t.Timestamp)
+	return t, err
+}
+
+// route is an endpoint of the api, each one is described in openapi.json
+type route struct {
+	method  string
+	path    string          // ServeMux path and OpenAPI path
+	limit   string          // rate limit route
+	perm    auth.Permission // required permission, empty for public routes
+	handler http.HandlerFunc
+}
+
+// routes lists the endpoints served by RegisterRoutes
+func (tapi *TransactionApi) routes() []route {
+	routes := []route{
+		{http.MethodGet, "/transactions", "/transactions", auth.PermRead, tapi.GetTransactions},
+		{http.MethodPost, "/transactions", "/transactions", auth.PermIngest, tapi.CreateTransaction},
+		{http.MethodGet, "/transactions/export", "/transactions/export", auth.PermRead, tapi.ExportTransactions},
+		{http.MethodGet, "/transactions/stream", "/transactions/stream", auth.PermRead, tapi.StreamTransactions},
+		{http.MethodGet, "/transactions/ws", "/transactions/ws", auth.PermRead, tapi.WatchTransactions},
+
+		// Probes, scrapers and api clients don't authenticate
+		{http.MethodGet, "/health", "", "", tapi.GetHealth},
+		{http.MethodGet, "/metrics", "", "", tapi.GetMetrics},
+		{http.MethodGet, "/openapi.json", "", "", tapi.GetOpenAPI},
+	}
+	if tapi.Limits != nil {
+		routes = append(routes,
+			route{http.MethodGet, "/users/{user_id}/limits", "/users/limits", auth.PermRead, tapi.Limits.GetLimits},
+			route{http.MethodPut, "/users/{user_id}/limits/{kind}/{period}", "/users/limits", auth.PermIngest, tapi.Limits.SetLimit},
+			route{http.MethodDelete, "/users/{user_id}/limits/{kind}/{period}", "/users/limits", auth.PermIngest, tapi.Limits.RemoveLimit},
+			route{http.MethodGet, "/users/{user_id}/limits/changes", "/users/limits", auth.PermRead, tapi.Limits.GetLimitChanges},
+			route{http.MethodGet, "/users/{user_id}/limits/violations", "/users/limits", auth.PermRead, tapi.Limits.GetViolations},
+			route{http.MethodPost, "/users/{user_id}/exclusions", "/users/limits", auth.PermIngest, tapi.Limits.CreateExclusion},
+		)
+	}
+	if tapi.Fraud != nil {
+		routes = append(routes,
+			route{http.MethodGet, "/alerts", "/alerts", auth.PermAdmin, tapi.Fraud.GetAlerts},
+			route{http.MethodPost, "/alerts/{id}/acknowledge", "/alerts", auth.PermAdmin, tapi.Fraud.AcknowledgeAlert},
+		)
+	}
+	if tapi.Auth != nil {
+		routes = append(routes,
+			route{http.MethodPost, "/admin/api-keys", "/admin/api-keys", auth.PermAdmin, tapi.Auth.CreateApiKey},
+			route{http.MethodDelete, "/admin/api-keys/{id}", "/admin/api-keys", auth.PermAdmin, tapi.Auth.RevokeApiKey},
+		)
+	}
+	return routes
+}
+
+// RegisterRoutes sets up the HTTP routes for the Transaction API.
+// Requests are validated against openapi.json before reaching the handlers.
 func (tapi *TransactionApi) RegisterRoutes(mux *http.ServeMux) {
-	mux.HandleFunc("/transactions", tapi.GetTransactions)
+	for _, rt := range tapi.routes() {
+		h := validateRequest(rt.method, rt.path, rt.handler)
+		if rt.perm != "" {
+			h = tapi.handle(rt.limit, rt.perm, h)
+		}
+		mux.HandleFunc(rt.method+" "+rt.path, h)
+	}
 }
 
-func (tapi *TransactionApi) ListenAndServe(wg *sync.WaitGroup) {
-	defer wg.Done()
+// handle wraps the handler with authentication, per-client rate limiting and a body size cap.
+// Authenticated clients are limited by credential rather than IP, after an IP limit that
+// throttles attempts with bad credentials.
+func (tapi *TransactionApi) handle(route string, perm auth.Permission, handler http.HandlerFunc) http.HandlerFunc {
+	h := ratelimit.MaxBytes(maxBodyBytes, handler)
+	if tapi.Limiter != nil {
+		h = tapi.Limiter.Middleware(route, clientKey, h)
+	}
+	if tapi.Auth != nil {
+		h = tapi.Auth.Require(perm, h)
+		if route == "/transactions/ws" {
+			// Browsers can't set headers on WebSocket requests
+			h = auth.QueryToken(h)
+		}
+		if tapi.Limiter != nil {
+			h = tapi.Limiter.Middleware(ratelimit.RouteAuth, ipKey, h)
+		}
+	}
+	return h
+}
+
+// clientKey identifies the caller for rate limiting
+func clientKey(r *http.Request) string {
+	if principal, ok := auth.FromContext(r.Context()); ok {
+		return principal.Subject
+	}
+	return ipKey(r)
+}
 
+func ipKey(r *http.Request) string {
+	return "ip:" + ratelimit.ClientIP(r)
+}
+
+// ListenAndServe serves the api on addr until Shutdown, or until ctx is done
+// which closes open connections right away
+func (tapi *TransactionApi) ListenAndServe(ctx context.Context, addr string) error {
 	// Set up router
 	mux := http.NewServeMux()
 	tapi.RegisterRoutes(mux)
 
 	// Configure server
 	server := &http.Server{
-		Addr:    ":8080",
-		Handler: mux,
+		Addr:              addr,
+		Handler:           logging.Middleware(tracing.Middleware(mux)),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
 	}
 
-	// Start server in a goroutine
-	go func() {
-		log.Println("Starting server on :8080")
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
-		}
-	}()
+	tapi.mu.Lock()
+	closing, closeStreams := tapi.closingContext()
+	if closing.Err() != nil {
+		// Shut down before it started
+		tapi.mu.Unlock()
+		return nil
+	}
+	tapi.server = server
+	tapi.mu.Unlock()
 
-	// Graceful shutdown
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	log.Println("Shutting down server...")
+	stop := context.AfterFunc(ctx, func() {
+		closeStreams()
+		server.Close()
+	})
+	defer stop()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	slog.InfoContext(ctx, "Starting server", "addr", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections, ends live streams and waits for the
+// open requests to finish, or until ctx is done
+func (tapi *TransactionApi) Shutdown(ctx context.Context) error {
+	tapi.mu.Lock()
+	server := tapi.server
+	tapi.mu.Unlock()
+
+	slog.InfoContext(ctx, "Shutting down server")
+	tapi.endStreams()
+	if server == nil {
+		return nil
+	}
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server shutdown error: %v", err)
+		return err
+	}
+	slog.InfoContext(ctx, "Server stopped")
+	return nil
+}
+
+// closingContext returns the context cancelled when the server shuts down, tapi.mu must be held
+func (tapi *TransactionApi) closingContext() (context.Context, context.CancelFunc) {
+	if tapi.closing == nil {
+		tapi.closing, tapi.closeStreams = context.WithCancel(context.Background())
+	}
+	return tapi.closing, tapi.closeStreams
+}
+
+// endStreams ends the live streams of the HTTP and gRPC servers
+func (tapi *TransactionApi) endStreams() {
+	tapi.mu.Lock()
+	_, closeStreams := tapi.closingContext()
+	tapi.mu.Unlock()
+	closeStreams()
+}
+
+// streamContext returns ctx, also cancelled when the server shuts down,
+// so live streams don't hold up Shutdown
+func (tapi *TransactionApi) streamContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	tapi.mu.Lock()
+	closing, _ := tapi.closingContext()
+	tapi.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(closing, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
 	}
-	log.Println("Server stopped")
 }
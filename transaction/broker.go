@@ -0,0 +1,115 @@
+package transaction
+
+import (
+	"slices"
+	"sync"
+)
+
+// Filter selects which transactions a subscription receives
+type Filter struct {
+	UserIds         []int
+	TransactionType *string
+}
+
+// Match reports whether the transaction passes the filter
+func (f Filter) Match(t Transaction) bool {
+	if len(f.UserIds) > 0 && !slices.Contains(f.UserIds, t.UserId) {
+		return false
+	}
+	if f.TransactionType != nil && *f.TransactionType != t.TransactionType {
+		return false
+	}
+	return true
+}
+
+// Subscription is a live feed of transactions accepted by the broker
+type Subscription struct {
+	ch     chan Transaction
+	done   chan struct{}
+	filter Filter
+}
+
+// Transactions returns the channel delivering matching transactions
+func (s *Subscription) Transactions() <-chan Transaction {
+	return s.ch
+}
+
+// Done is closed when the subscription is dropped by the broker
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Broker fans out stored transactions to live subscribers.
+// Publishing never blocks: a subscriber whose buffer is full is dropped.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe registers a new subscription holding at most buffer pending transactions
+func (b *Broker) Subscribe(filter Filter, buffer int) *Subscription {
+	s := &Subscription{
+		ch:     make(chan Transaction, buffer),
+		done:   make(chan struct{}),
+		filter: filter,
+	}
+
+	b.mu.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mu.Unlock()
+
+	return s
+}
+
+// Unsubscribe removes the subscription from the broker
+func (b *Broker) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drop(s)
+}
+
+// SetFilter replaces the filter of an active subscription
+func (b *Broker) SetFilter(s *Subscription, filter Filter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s.filter = filter
+}
+
+// Publish delivers the transaction to every matching subscriber
+func (b *Broker) Publish(t Transaction) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subscribers {
+		if !s.filter.Match(t) {
+			continue
+		}
+		select {
+		case s.ch <- t:
+		default:
+			// Slow subscriber, disconnect it so it can't stall the publisher
+			b.drop(s)
+		}
+	}
+}
+
+// Subscribers returns the number of active subscriptions
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+func (b *Broker) drop(s *Subscription) {
+	if _, ok := b.subscribers[s]; !ok {
+		return
+	}
+	delete(b.subscribers, s)
+	close(s.done)
+}
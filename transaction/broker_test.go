@@ -0,0 +1,52 @@
+package transaction
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterMatch(t *testing.T) {
+	bet := BET
+	tr := Transaction{UserId: 1, TransactionType: BET}
+
+	t.Run("empty filter matches everything", func(t *testing.T) {
+		require.True(t, Filter{}.Match(tr))
+	})
+	t.Run("user id filter", func(t *testing.T) {
+		require.True(t, Filter{UserIds: []int{1, 2}}.Match(tr))
+		require.False(t, Filter{UserIds: []int{2}}.Match(tr))
+	})
+	t.Run("transaction type filter", func(t *testing.T) {
+		require.True(t, Filter{TransactionType: &bet}.Match(tr))
+		require.False(t, Filter{TransactionType: &bet}.Match(Transaction{UserId: 1, TransactionType: WIN}))
+	})
+}
+
+func TestBroker(t *testing.T) {
+	t.Run("successful publish to matching subscriber", func(t *testing.T) {
+		b := NewBroker()
+		sub := b.Subscribe(Filter{UserIds: []int{1}}, 1)
+		defer b.Unsubscribe(sub)
+
+		b.Publish(Transaction{Id: 1, UserId: 2})
+		b.Publish(Transaction{Id: 2, UserId: 1})
+
+		tr := <-sub.Transactions()
+		require.Equal(t, int64(2), tr.Id)
+	})
+
+	t.Run("slow subscriber is dropped", func(t *testing.T) {
+		b := NewBroker()
+		sub := b.Subscribe(Filter{}, 1)
+
+		b.Publish(Transaction{Id: 1})
+		b.Publish(Transaction{Id: 2})
+
+		<-sub.Done()
+		require.Equal(t, 0, b.Subscribers())
+
+		// Unsubscribing a dropped subscription is a no-op
+		b.Unsubscribe(sub)
+	})
+}
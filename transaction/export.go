@@ -0,0 +1,187 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	contentTypeJSON   = "application/json"
+	contentTypeCSV    = "text/csv"
+	contentTypeNDJSON = "application/x-ndjson"
+
+	// Number of rows written between flushes of a streamed response
+	exportFlushEvery = 100
+)
+
+// rowEncoder writes transactions one by one to a streamed response
+type rowEncoder interface {
+	Begin() error
+	Encode(t Transaction) error
+	Flush() error
+}
+
+// negotiateFormat returns the streaming content type requested by the Accept header, or "" for JSON
+func negotiateFormat(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case contentTypeCSV, contentTypeNDJSON:
+			return mediaType
+		case contentTypeJSON:
+			return ""
+		}
+	}
+	return ""
+}
+
+// ExportTransactions handles GET requests streaming transactions as NDJSON or CSV.
+// The format is chosen by the format query parameter ("ndjson" or "csv") or the Accept header.
+func (tapi *TransactionApi) ExportTransactions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	format := negotiateFormat(r.Header.Get("Accept"))
+	switch query.Get("format") {
+	case "":
+		if format == "" {
+			format = contentTypeNDJSON
+		}
+	case "ndjson":
+		format = contentTypeNDJSON
+	case "csv":
+		format = contentTypeCSV
+	default:
+		http.Error(w, "Invalid format. Must be 'ndjson' or 'csv'", http.StatusBadRequest)
+		return
+	}
+
+	userId, transactionType, err := parseFilters(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !checkScope(w, r, userId) {
+		return
+	}
+	limit, err := parseLimit(query, math.MaxInt, math.MaxInt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The request context cancels the query when the client disconnects
+	rows, err := tapi.Database.GetTransactions(r.Context(), userId, transactionType, limit)
+	if err != nil {
+		http.Error(w, "Failed to retrieve transactions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	// Large exports outlive the server write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	if format == contentTypeCSV {
+		w.Header().Set("Content-Disposition", `attachment; filename="transactions.csv"`)
+	}
+	streamRows(r.Context(), w, rows, format)
+}
+
+// streamRows encodes rows straight to the response, flushing periodically
+func streamRows(ctx context.Context, w http.ResponseWriter, rows *sql.Rows, format string) {
+	w.Header().Set("Content-Type", format)
+
+	var enc rowEncoder
+	switch format {
+	case contentTypeCSV:
+		enc = newCsvEncoder(w)
+	default:
+		enc = newNdjsonEncoder(w)
+	}
+
+	flusher, _ := w.(http.Flusher)
+	if err := enc.Begin(); err != nil {
+		return
+	}
+
+	count := 0
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			// Headers are already sent, the truncated body is all we can report
+			slog.ErrorContext(ctx, "Failed to scan transaction", "error", err)
+			return
+		}
+		if err := enc.Encode(t); err != nil {
+			return
+		}
+		count++
+		if flusher != nil && count%exportFlushEvery == 0 {
+			if err := enc.Flush(); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "Export stopped", "rows", count, "error", err)
+		return
+	}
+	enc.Flush()
+}
+
+// ndjsonEncoder writes one JSON object per line
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func newNdjsonEncoder(w io.Writer) *ndjsonEncoder {
+	return &ndjsonEncoder{enc: json.NewEncoder(w)}
+}
+
+func (e *ndjsonEncoder) Begin() error { return nil }
+
+func (e *ndjsonEncoder) Encode(t Transaction) error { return e.enc.Encode(t) }
+
+func (e *ndjsonEncoder) Flush() error { return nil }
+
+// csvEncoder writes a header row followed by one row per transaction
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func newCsvEncoder(w io.Writer) *csvEncoder {
+	return &csvEncoder{w: csv.NewWriter(w)}
+}
+
+func (e *csvEncoder) Begin() error {
+	return e.w.Write([]string{"id", "user_id", "transaction_type", "amount", "timestamp"})
+}
+
+func (e *csvEncoder) Encode(t Transaction) error {
+	return e.w.Write([]string{
+		strconv.FormatInt(t.Id, 10),
+		strconv.Itoa(t.UserId),
+		t.TransactionType,
+		strconv.FormatFloat(t.Amount, 'f', 2, 64),
+		t.Timestamp.Format(time.RFC3339),
+	})
+}
+
+// Flush writes buffered rows to the response
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
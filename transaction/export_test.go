@@ -0,0 +1,91 @@
+package transaction
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"transaction-management-system/auth"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	t.Run("successful csv", func(t *testing.T) {
+		require.Equal(t, contentTypeCSV, negotiateFormat("text/csv; charset=utf-8"))
+	})
+	t.Run("successful ndjson", func(t *testing.T) {
+		require.Equal(t, contentTypeNDJSON, negotiateFormat("text/html, application/x-ndjson"))
+	})
+	t.Run("json or unknown falls back to json", func(t *testing.T) {
+		require.Equal(t, "", negotiateFormat("application/json, text/csv"))
+		require.Equal(t, "", negotiateFormat("*/*"))
+		require.Equal(t, "", negotiateFormat(""))
+	})
+}
+
+func TestRowEncoders(t *testing.T) {
+	tr := Transaction{Id: 1, UserId: 2, TransactionType: BET, Amount: 1.5, Timestamp: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)}
+
+	t.Run("successful csv encoding", func(t *testing.T) {
+		var buf bytes.Buffer
+		enc := newCsvEncoder(&buf)
+		require.NoError(t, enc.Begin())
+		require.NoError(t, enc.Encode(tr))
+		require.NoError(t, enc.Flush())
+
+		require.Equal(t, "id,user_id,transaction_type,amount,timestamp\n1,2,bet,1.50,2025-01-02T03:04:05Z\n", buf.String())
+	})
+
+	t.Run("successful ndjson encoding", func(t *testing.T) {
+		var buf bytes.Buffer
+		enc := newNdjsonEncoder(&buf)
+		require.NoError(t, enc.Begin())
+		require.NoError(t, enc.Encode(tr))
+		require.NoError(t, enc.Encode(tr))
+		require.NoError(t, enc.Flush())
+
+		line := `{"id":1,"user_id":2,"transaction_type":"bet","amount":1.5,"timestamp":"2025-01-02T03:04:05Z"}` + "\n"
+		require.Equal(t, line+line, buf.String())
+	})
+}
+
+func TestExportTransactions(t *testing.T) {
+	tapi := &TransactionApi{}
+
+	t.Run("failed: invalid format", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		tapi.ExportTransactions(rr, httptest.NewRequest("GET", "/transactions/export?format=xml", nil))
+
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "Invalid format")
+	})
+
+	t.Run("failed: invalid limit", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		tapi.ExportTransactions(rr, httptest.NewRequest("GET", "/transactions/export?limit=0", nil))
+
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "Invalid limit parameter")
+	})
+
+	t.Run("failed: scoped credentials without user filter", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/transactions/export", nil)
+		req = req.WithContext(auth.NewContext(req.Context(), &auth.Principal{Role: auth.RoleReader, UserIds: []int{1}}))
+		rr := httptest.NewRecorder()
+		tapi.ExportTransactions(rr, req)
+
+		require.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("failed: scoped credentials for another user", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/transactions/export?user_id=2", nil)
+		req = req.WithContext(auth.NewContext(req.Context(), &auth.Principal{Role: auth.RoleReader, UserIds: []int{1}}))
+		rr := httptest.NewRecorder()
+		tapi.ExportTransactions(rr, req)
+
+		require.Equal(t, http.StatusForbidden, rr.Code)
+		require.Contains(t, rr.Body.String(), "Access to user denied")
+	})
+}
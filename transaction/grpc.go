@@ -0,0 +1,445 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"transaction-management-system/auth"
+	"transaction-management-system/logging"
+	"transaction-management-system/ratelimit"
+	"transaction-management-system/tracing"
+	"transaction-management-system/transactionpb"
+
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// Permissions of the gRPC methods, like those of the matching routes
+var grpcPermissions = map[string]auth.Permission{
+	transactionpb.TransactionService_SubmitTransaction_FullMethodName: auth.PermIngest,
+	transactionpb.TransactionService_SubmitBatch_FullMethodName:       auth.PermIngest,
+	transactionpb.TransactionService_ListTransactions_FullMethodName:  auth.PermRead,
+	transactionpb.TransactionService_GetBalance_FullMethodName:        auth.PermRead,
+	transactionpb.TransactionService_WatchTransactions_FullMethodName: auth.PermRead,
+}
+
+// GrpcServer serves the api over gRPC for internal game servers, see transactionpb/transaction_service.proto.
+// It shares validation, storage, authentication and rate limits with the REST api.
+type GrpcServer struct {
+	transactionpb.UnimplementedTransactionServiceServer
+	Api *TransactionApi
+
+	// Server state, see Serve and Shutdown
+	mu      sync.Mutex
+	server  *grpc.Server
+	health  *health.Server
+	stopped bool
+}
+
+func NewGrpcServer(tapi *TransactionApi) *GrpcServer {
+	return &GrpcServer{Api: tapi}
+}
+
+// ListenAndServe serves on addr until Shutdown, or until ctx is done
+func (s *GrpcServer) ListenAndServe(ctx context.Context, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	return s.Serve(ctx, lis)
+}
+
+// Serve serves on lis until Shutdown, or until ctx is done which cancels open calls right away
+func (s *GrpcServer) Serve(ctx context.Context, lis net.Listener) error {
+	server := grpc.NewServer(
+		grpc.MaxRecvMsgSize(maxBodyBytes),
+		grpc.ChainUnaryInterceptor(s.unaryInterceptor),
+		grpc.ChainStreamInterceptor(s.streamInterceptor),
+	)
+	transactionpb.RegisterTransactionServiceServer(server, s)
+
+	// Standard health checking for probes and reflection for tools like grpcurl
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(transactionpb.TransactionService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+	reflection.Register(server)
+
+	s.mu.Lock()
+	if s.stopped {
+		// Shut down before it started
+		s.mu.Unlock()
+		lis.Close()
+		return nil
+	}
+	s.server, s.health = server, healthServer
+	s.mu.Unlock()
+
+	stop := context.AfterFunc(ctx, server.Stop)
+	defer stop()
+
+	slog.InfoContext(ctx, "Starting gRPC server", "addr", lis.Addr().String())
+	if err := server.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return fmt.Errorf("failed to serve gRPC: %w", err)
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections, ends live streams and waits for the
+// open calls to finish, or until ctx is done when the remaining calls are cancelled
+func (s *GrpcServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopped = true
+	server, healthServer := s.server, s.health
+	s.mu.Unlock()
+
+	slog.InfoContext(ctx, "Shutting down gRPC server")
+	s.Api.endStreams()
+	if server == nil {
+		return nil
+	}
+	// Probes see the server going away while the open calls finish
+	healthServer.Shutdown()
+
+	done := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		slog.InfoContext(ctx, "gRPC server stopped")
+		return nil
+	case <-ctx.Done():
+		server.Stop()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (s *GrpcServer) SubmitTransaction(ctx context.Context, req *transactionpb.SubmitTransactionRequest) (*transactionpb.Transaction, error) {
+	transactions, err := fromProto([]*transactionpb.Transaction{req.GetTransaction()})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if err := s.Api.submit(ctx, transactions); err != nil {
+		return nil, grpcError(err)
+	}
+	return transactions[0].Proto(), nil
+}
+
+func (s *GrpcServer) SubmitBatch(ctx context.Context, req *transactionpb.SubmitBatchRequest) (*transactionpb.SubmitBatchResponse, error) {
+	if len(req.GetTransactions()) == 0 || len(req.GetTransactions()) > maxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "A batch holds 1 to %d transactions", maxBatchSize)
+	}
+	transactions, err := fromProto(req.GetTransactions())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if err := s.Api.submit(ctx, transactions); err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &transactionpb.SubmitBatchResponse{Transactions: make([]*transactionpb.Transaction, len(transactions))}
+	for i, t := range transactions {
+		resp.Transactions[i] = t.Proto()
+	}
+	return resp, nil
+}
+
+func (s *GrpcServer) ListTransactions(req *transactionpb.ListTransactionsRequest, stream grpc.ServerStreamingServer[transactionpb.Transaction]) error {
+	ctx := stream.Context()
+	transactionType, err := protoTypeFilter(req.GetTransactionType())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.GetLimit() < 0 || req.GetLimit() > maxPageSize {
+		return status.Error(codes.InvalidArgument, "Invalid limit parameter")
+	}
+	limit := defaultPageSize
+	if req.GetLimit() > 0 {
+		limit = int(req.GetLimit())
+	}
+	var userId *int
+	if req.UserId != nil {
+		id := int(req.GetUserId())
+		userId = &id
+	}
+	if err := authorizeUser(ctx, userId); err != nil {
+		return grpcError(err)
+	}
+
+	rows, err := s.Api.Database.GetTransactions(ctx, userId, transactionType, limit)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to retrieve transactions", "error", err)
+		return status.Error(codes.Internal, "Failed to retrieve transactions")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			return status.Error(codes.Internal, "Failed to scan transaction")
+		}
+		if err := stream.Send(t.Proto()); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return status.Error(codes.Internal, "Error processing transaction data")
+	}
+	return nil
+}
+
+func (s *GrpcServer) GetBalance(ctx context.Context, req *transactionpb.GetBalanceRequest) (*transactionpb.Balance, error) {
+	userId := int(req.GetUserId())
+	if userId < 1 {
+		return nil, status.Error(codes.InvalidArgument, "user_id must be positive")
+	}
+	if err := authorizeUser(ctx, &userId); err != nil {
+		return nil, grpcError(err)
+	}
+	amount, _, err := s.Api.Database.GetBalance(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to retrieve balance", "error", err)
+		return nil, status.Error(codes.Internal, "Failed to retrieve balance")
+	}
+	return &transactionpb.Balance{UserId: int64(userId), Balance: amount}, nil
+}
+
+func (s *GrpcServer) WatchTransactions(req *transactionpb.WatchTransactionsRequest, stream grpc.ServerStreamingServer[transactionpb.Transaction]) error {
+	if s.Api.Broker == nil {
+		return status.Error(codes.Unavailable, "Transaction stream is not available")
+	}
+	transactionType, err := protoTypeFilter(req.GetTransactionType())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if len(req.GetUserIds()) > wsMaxUserIds {
+		return status.Errorf(codes.InvalidArgument, "At most %d user ids can be watched", wsMaxUserIds)
+	}
+	userIds := make([]int, len(req.GetUserIds()))
+	for i, id := range req.GetUserIds() {
+		userIds[i] = int(id)
+	}
+	if len(userIds) == 0 {
+		if err := authorizeUser(stream.Context(), nil); err != nil {
+			return grpcError(err)
+		}
+	}
+	for _, id := range userIds {
+		if err := authorizeUser(stream.Context(), &id); err != nil {
+			return grpcError(err)
+		}
+	}
+
+	sub := s.Api.Broker.Subscribe(Filter{UserIds: userIds, TransactionType: transactionType}, streamBufferSize)
+	defer s.Api.Broker.Unsubscribe(sub)
+
+	// Ends with the call or when the server shuts down
+	ctx, cancel := s.Api.streamContext(stream.Context())
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := stream.Context().Err(); err != nil {
+				return status.FromContextError(err).Err()
+			}
+			// Clients reconnect to another instance
+			return status.Error(codes.Unavailable, "Server is shutting down")
+		case <-sub.Done():
+			return status.Error(codes.ResourceExhausted, "Client could not keep up with the stream")
+		case t := <-sub.Transactions():
+			if err := stream.Send(t.Proto()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// fromProto converts submitted messages, the error names the index of an invalid one like submit
+func fromProto(messages []*transactionpb.Transaction) ([]Transaction, error) {
+	transactions := make([]Transaction, len(messages))
+	for i, m := range messages {
+		t, err := FromProto(m)
+		if err != nil {
+			if len(messages) == 1 {
+				return nil, fmt.Errorf("%w: %v", errInvalidTransaction, err)
+			}
+			return nil, fmt.Errorf("%w %d: %v", errInvalidTransaction, i, err)
+		}
+		transactions[i] = t
+	}
+	return transactions, nil
+}
+
+// protoTypeFilter returns the transaction type to filter by, nil for all types
+func protoTypeFilter(t transactionpb.TransactionType) (*string, error) {
+	name, err := TypeName(t)
+	if err != nil || name == "" {
+		return nil, err
+	}
+	return &name, nil
+}
+
+// grpcError maps the errors of submit and authorizeUser to a status, like the REST api to a status code
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, errInvalidTransaction):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, errUserRequired), errors.Is(err, errAccessDenied):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, errLimitExceeded):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func (s *GrpcServer) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	ctx, done, err := s.begin(ctx, info.FullMethod)
+	defer func() { done(err) }()
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *GrpcServer) streamInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	ctx, done, err := s.begin(stream.Context(), info.FullMethod)
+	defer func() { done(err) }()
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+}
+
+// begin prepares the context of a call like the HTTP middlewares do for a request: it carries
+// the correlation id, continues the caller's trace and holds the authenticated principal.
+// Unauthenticated, forbidden and throttled calls are rejected. done ends the call's span.
+func (s *GrpcServer) begin(ctx context.Context, method string) (context.Context, func(err error), error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	ctx = logging.Ensure(ctx, firstValue(md, logging.Header))
+	grpc.SetHeader(ctx, metadata.Pairs(logging.Header, logging.CorrelationID(ctx)))
+
+	service, name, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+	ctx, span := tracing.Start(ctx, strings.TrimPrefix(method, "/"),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(semconv.RPCSystemGRPC, semconv.RPCService(service), semconv.RPCMethod(name)),
+	)
+	done := func(err error) {
+		code := status.Code(err)
+		span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(code)))
+		tracing.End(span, err)
+		slog.DebugContext(ctx, "gRPC call finished", "method", method, "code", code.String())
+	}
+
+	// Health checks and reflection don't authenticate, like /health and /openapi.json
+	perm, authenticated := grpcPermissions[method]
+	if s.Api.Auth != nil && authenticated {
+		// Attempts are throttled by IP before the credentials are looked up
+		if err := s.allow(ctx, ratelimit.RouteAuth, grpcPeerKey(ctx)); err != nil {
+			return ctx, done, err
+		}
+		principal, err := s.Api.Auth.AuthenticateCredentials(ctx, firstValue(md, "x-api-key"), firstValue(md, "authorization"))
+		if err != nil && !errors.Is(err, auth.ErrUnauthenticated) {
+			slog.ErrorContext(ctx, "Failed to authenticate", "error", err)
+			return ctx, done, status.Error(codes.Internal, "Failed to authenticate")
+		}
+		if err != nil {
+			return ctx, done, status.Error(codes.Unauthenticated, "Unauthorized")
+		}
+		if !principal.Can(perm) {
+			slog.WarnContext(ctx, "Access denied", "subject", principal.Subject, "role", principal.Role, "method", method)
+			return ctx, done, status.Error(codes.PermissionDenied, "Forbidden")
+		}
+		ctx = auth.NewContext(ctx, principal)
+	}
+
+	if err := s.allow(ctx, method, grpcClientKey(ctx)); err != nil {
+		return ctx, done, err
+	}
+	return ctx, done, nil
+}
+
+// allow takes a token from the client's bucket for the route, a ResourceExhausted error when there is none
+func (s *GrpcServer) allow(ctx context.Context, route, client string) error {
+	if s.Api.Limiter == nil {
+		return nil
+	}
+	res := s.Api.Limiter.Allow(route, client)
+	if !res.Allowed {
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(ratelimit.CeilSeconds(res.RetryAfter))))
+		return status.Error(codes.ResourceExhausted, "Too many requests")
+	}
+	return nil
+}
+
+// grpcClientKey identifies the caller for rate limiting, like clientKey
+func grpcClientKey(ctx context.Context) string {
+	if principal, ok := auth.FromContext(ctx); ok {
+		return principal.Subject
+	}
+	return grpcPeerKey(ctx)
+}
+
+// grpcPeerKey identifies the caller by IP, like ipKey
+func grpcPeerKey(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return "ip:" + host
+		}
+		return "ip:" + p.Addr.String()
+	}
+	return "ip:"
+}
+
+// contextStream is a server stream with the context prepared by begin
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// metadataCarrier reads and writes trace context in gRPC metadata
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	return firstValue(metadata.MD(c), key)
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,323 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+	"transaction-management-system/auth"
+	"transaction-management-system/ratelimit"
+	"transaction-management-system/transactionpb"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// startGrpc serves tapi over an in-memory listener and returns a client
+func startGrpc(t *testing.T, tapi *TransactionApi) (*GrpcServer, transactionpb.TransactionServiceClient) {
+	s, conn := serveGrpc(t, tapi)
+	return s, transactionpb.NewTransactionServiceClient(conn)
+}
+
+// serveGrpc serves tapi over an in-memory listener and returns a client connection
+func serveGrpc(t *testing.T, tapi *TransactionApi) (*GrpcServer, *grpc.ClientConn) {
+	lis := bufconn.Listen(1 << 20)
+	s := NewGrpcServer(tapi)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(context.Background(), lis) }()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+		require.NoError(t, s.Shutdown(context.Background()))
+		require.NoError(t, <-served)
+	})
+	return s, conn
+}
+
+// submission returns a submitted transaction message
+func submission(userId int64, transactionType transactionpb.TransactionType, amount float64) *transactionpb.Transaction {
+	return &transactionpb.Transaction{UserId: &userId, TransactionType: transactionType, Amount: &amount}
+}
+
+const (
+	protoBet = transactionpb.TransactionType_TRANSACTION_TYPE_BET
+	protoWin = transactionpb.TransactionType_TRANSACTION_TYPE_WIN
+)
+
+func TestGrpcSubmit(t *testing.T) {
+	_, client := startGrpc(t, &TransactionApi{})
+
+	t.Run("failed invalid transaction", func(t *testing.T) {
+		_, err := client.SubmitTransaction(t.Context(), &transactionpb.SubmitTransactionRequest{Transaction: submission(1, 7, 1)})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+		require.ErrorContains(t, err, "unknown transaction_type 7")
+
+		_, err = client.SubmitTransaction(t.Context(), &transactionpb.SubmitTransactionRequest{Transaction: submission(1, 0, 1)})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+		require.ErrorContains(t, err, "unknown transaction_type")
+	})
+
+	t.Run("failed invalid transaction in a batch", func(t *testing.T) {
+		_, err := client.SubmitBatch(t.Context(), &transactionpb.SubmitBatchRequest{Transactions: []*transactionpb.Transaction{
+			submission(1, protoBet, 1),
+			submission(1, protoWin, -1),
+		}})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+		require.ErrorContains(t, err, "Invalid transaction 1: amount can't be negative")
+	})
+
+	t.Run("failed empty batch", func(t *testing.T) {
+		_, err := client.SubmitBatch(t.Context(), &transactionpb.SubmitBatchRequest{})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("correlation id is echoed", func(t *testing.T) {
+		var header metadata.MD
+		ctx := metadata.AppendToOutgoingContext(t.Context(), "x-correlation-id", "game-42")
+		client.SubmitTransaction(ctx, &transactionpb.SubmitTransactionRequest{}, grpc.Header(&header))
+		require.Equal(t, []string{"game-42"}, header.Get("x-correlation-id"))
+	})
+
+	t.Run("unknown fields are ignored", func(t *testing.T) {
+		// A field added to the schema after this server was built
+		m := submission(1, protoBet, -1)
+		m.ProtoReflect().SetUnknown(protowire.AppendVarint(protowire.AppendTag(nil, 99, protowire.VarintType), 1))
+
+		// Decoded and validated like any other transaction
+		_, err := client.SubmitTransaction(t.Context(), &transactionpb.SubmitTransactionRequest{Transaction: m})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+		require.ErrorContains(t, err, "amount can't be negative")
+	})
+}
+
+func TestGrpcAuth(t *testing.T) {
+	secret := []byte("grpc-test-secret")
+	keys := auth.NewKeySet()
+	keys.AddHMAC("", secret)
+	token := func(role auth.Role, userIds ...int) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{
+			Role:    string(role),
+			UserIds: userIds,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   "game-server",
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		}).SignedString(secret)
+		require.NoError(t, err)
+		return "Bearer " + signed
+	}
+	_, client := startGrpc(t, &TransactionApi{Auth: auth.NewAuthenticator(keys, nil), Broker: NewBroker()})
+	submit := &transactionpb.SubmitTransactionRequest{Transaction: submission(2, protoBet, 1)}
+
+	t.Run("failed without credentials", func(t *testing.T) {
+		_, err := client.SubmitTransaction(t.Context(), submit)
+		require.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("failed reader submitting", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(t.Context(), "authorization", token(auth.RoleReader))
+		_, err := client.SubmitTransaction(ctx, submit)
+		require.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("failed user outside scope", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(t.Context(), "authorization", token(auth.RoleWriter, 1))
+		_, err := client.SubmitTransaction(ctx, submit)
+		require.Equal(t, codes.PermissionDenied, status.Code(err))
+		require.ErrorContains(t, err, "Access to user denied")
+	})
+
+	t.Run("failed scoped watch of every user", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(t.Context(), "authorization", token(auth.RoleReader, 1))
+		stream, err := client.WatchTransactions(ctx, &transactionpb.WatchTransactionsRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+}
+
+func TestGrpcRateLimit(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.Config{
+		Default: ratelimit.Limit{Rate: 100, Burst: 100},
+		Routes:  map[string]ratelimit.Limit{transactionpb.TransactionService_GetBalance_FullMethodName: {Rate: 0.01, Burst: 1}},
+	})
+	_, client := startGrpc(t, &TransactionApi{Limiter: limiter})
+
+	// Rejected before reaching the database
+	_, err := client.GetBalance(t.Context(), &transactionpb.GetBalanceRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	var header metadata.MD
+	_, err = client.GetBalance(t.Context(), &transactionpb.GetBalanceRequest{}, grpc.Header(&header))
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.NotEmpty(t, header.Get("retry-after"))
+
+	t.Run("failed credentials throttled by ip", func(t *testing.T) {
+		limiter := ratelimit.NewLimiter(ratelimit.Config{
+			Default: ratelimit.Limit{Rate: 100, Burst: 100},
+			Routes:  map[string]ratelimit.Limit{ratelimit.RouteAuth: {Rate: 0.01, Burst: 1}},
+		})
+		_, client := startGrpc(t, &TransactionApi{Auth: auth.NewAuthenticator(auth.NewKeySet(), nil), Limiter: limiter})
+		ctx := metadata.AppendToOutgoingContext(t.Context(), "x-api-key", "guess")
+
+		_, err := client.GetBalance(ctx, &transactionpb.GetBalanceRequest{})
+		require.Equal(t, codes.Unauthenticated, status.Code(err))
+		_, err = client.GetBalance(ctx, &transactionpb.GetBalanceRequest{})
+		require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	})
+}
+
+func TestGrpcWatchTransactions(t *testing.T) {
+	t.Run("successful filtered stream", func(t *testing.T) {
+		broker := NewBroker()
+		_, client := startGrpc(t, &TransactionApi{Broker: broker})
+		stream, err := client.WatchTransactions(t.Context(), &transactionpb.WatchTransactionsRequest{UserIds: []int64{7}, TransactionType: protoWin})
+		require.NoError(t, err)
+
+		// Wait for the subscription before publishing
+		require.Eventually(t, func() bool {
+			broker.mu.Lock()
+			defer broker.mu.Unlock()
+			return len(broker.subscribers) == 1
+		}, time.Second, time.Millisecond)
+		broker.Publish(Transaction{Id: 1, UserId: 8, TransactionType: WIN, Amount: 1})
+		broker.Publish(Transaction{Id: 2, UserId: 7, TransactionType: BET, Amount: 1})
+		broker.Publish(Transaction{Id: 3, UserId: 7, TransactionType: WIN, Amount: 2.5})
+
+		got, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, int64(3), got.GetId())
+		require.Equal(t, 2.5, got.GetAmount())
+		require.Equal(t, protoWin, got.GetTransactionType())
+	})
+
+	t.Run("failed invalid transaction type", func(t *testing.T) {
+		_, client := startGrpc(t, &TransactionApi{Broker: NewBroker()})
+		stream, err := client.WatchTransactions(t.Context(), &transactionpb.WatchTransactionsRequest{TransactionType: 7})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("shutdown ends the stream", func(t *testing.T) {
+		broker := NewBroker()
+		s, client := startGrpc(t, &TransactionApi{Broker: broker})
+		stream, err := client.WatchTransactions(t.Context(), &transactionpb.WatchTransactionsRequest{})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			broker.mu.Lock()
+			defer broker.mu.Unlock()
+			return len(broker.subscribers) == 1
+		}, time.Second, time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+		defer cancel()
+		require.NoError(t, s.Shutdown(ctx))
+
+		_, err = stream.Recv()
+		require.False(t, errors.Is(err, io.EOF))
+		require.Equal(t, codes.Unavailable, status.Code(err))
+	})
+}
+
+func TestGrpcHealth(t *testing.T) {
+	_, conn := serveGrpc(t, &TransactionApi{Auth: auth.NewAuthenticator(auth.NewKeySet(), nil)})
+
+	t.Run("successful health check without credentials", func(t *testing.T) {
+		resp, err := healthpb.NewHealthClient(conn).Check(t.Context(), &healthpb.HealthCheckRequest{
+			Service: transactionpb.TransactionService_ServiceDesc.ServiceName,
+		})
+		require.NoError(t, err)
+		require.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+	})
+
+	t.Run("successful reflection lists the service", func(t *testing.T) {
+		stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(t.Context())
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+		}))
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		var services []string
+		for _, service := range resp.GetListServicesResponse().GetService() {
+			services = append(services, service.GetName())
+		}
+		require.Contains(t, services, transactionpb.TransactionService_ServiceDesc.ServiceName)
+	})
+}
+
+func TestGrpcShutdown(t *testing.T) {
+	t.Run("shutdown before serving", func(t *testing.T) {
+		s := NewGrpcServer(&TransactionApi{})
+		require.NoError(t, s.Shutdown(t.Context()))
+		require.NoError(t, s.Serve(t.Context(), bufconn.Listen(1024)))
+	})
+
+	t.Run("cancelled context stops serving", func(t *testing.T) {
+		s := NewGrpcServer(&TransactionApi{})
+		ctx, cancel := context.WithCancel(t.Context())
+		served := make(chan error, 1)
+		go func() { served <- s.Serve(ctx, bufconn.Listen(1024)) }()
+		cancel()
+		select {
+		case err := <-served:
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("server still running")
+		}
+	})
+}
+
+func TestGrpcDatabase(t *testing.T) {
+	tapi := NewTransactionApi(openDB(t))
+	_, client := startGrpc(t, tapi)
+
+	batch, err := client.SubmitBatch(t.Context(), &transactionpb.SubmitBatchRequest{Transactions: []*transactionpb.Transaction{
+		submission(9001, protoWin, 10),
+		submission(9001, protoBet, 2.5),
+	}})
+	require.NoError(t, err)
+	require.Len(t, batch.GetTransactions(), 2)
+	require.Less(t, batch.GetTransactions()[0].GetId(), batch.GetTransactions()[1].GetId())
+
+	submitted, err := client.SubmitTransaction(t.Context(), &transactionpb.SubmitTransactionRequest{Transaction: submission(9001, protoBet, 1)})
+	require.NoError(t, err)
+	require.Greater(t, submitted.GetId(), batch.GetTransactions()[1].GetId())
+	require.NotNil(t, submitted.GetTimestamp())
+
+	balance, err := client.GetBalance(t.Context(), &transactionpb.GetBalanceRequest{UserId: 9001})
+	require.NoError(t, err)
+	require.Equal(t, int64(9001), balance.GetUserId())
+
+	stream, err := client.ListTransactions(t.Context(), &transactionpb.ListTransactionsRequest{UserId: proto.Int64(9001), Limit: 2})
+	require.NoError(t, err)
+	var listed []*transactionpb.Transaction
+	for {
+		tr, err := stream.Recv()
+		if err != nil {
+			require.ErrorIs(t, err, io.EOF)
+			break
+		}
+		listed = append(listed, tr)
+	}
+	require.Len(t, listed, 2)
+	require.Equal(t, int64(9001), listed[0].GetUserId())
+}
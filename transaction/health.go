@@ -0,0 +1,73 @@
+package transaction
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"transaction-management-system/breaker"
+)
+
+// Health reports whether the service can store and serve transactions
+type Health struct {
+	// "ok", or "degraded" while the consumer can't store transactions
+	Status string `json:"status"`
+	// State of the consumer's database breaker, empty without one
+	ConsumerBreaker string `json:"consumer_breaker,omitempty"`
+	// Read replicas currently serving reads
+	HealthyReplicas int `json:"healthy_replicas"`
+}
+
+// health returns the current health
+func (tapi *TransactionApi) health() Health {
+	h := Health{Status: "ok", HealthyReplicas: tapi.Database.HealthyReplicas()}
+	if tapi.Breaker != nil {
+		state := tapi.Breaker.State()
+		h.ConsumerBreaker = state.String()
+		if state != breaker.Closed {
+			h.Status = "degraded"
+		}
+	}
+	return h
+}
+
+// GetHealth answers 200 when healthy and 503 while the consumer's breaker isn't closed
+func (tapi *TransactionApi) GetHealth(w http.ResponseWriter, r *http.Request) {
+	h := tapi.health()
+
+	w.Header().Set("Content-Type", "application/json")
+	if h.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(h)
+}
+
+// GetMetrics writes the health gauges in the Prometheus text format
+func (tapi *TransactionApi) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	if tapi.Breaker != nil {
+		stats := tapi.Breaker.Stats()
+		fmt.Fprintln(w, "# HELP consumer_breaker_state State of the consumer's database circuit breaker.")
+		fmt.Fprintln(w, "# TYPE consumer_breaker_state gauge")
+		for _, state := range []breaker.State{breaker.Closed, breaker.Open, breaker.HalfOpen} {
+			fmt.Fprintf(w, "consumer_breaker_state{state=%q} %d\n", state, boolToInt(stats.State == state))
+		}
+		fmt.Fprintln(w, "# HELP consumer_breaker_consecutive_failures Database failures since the last success.")
+		fmt.Fprintln(w, "# TYPE consumer_breaker_consecutive_failures gauge")
+		fmt.Fprintf(w, "consumer_breaker_consecutive_failures %d\n", stats.ConsecutiveFailures)
+		fmt.Fprintln(w, "# HELP consumer_breaker_opens_total Times the breaker opened.")
+		fmt.Fprintln(w, "# TYPE consumer_breaker_opens_total counter")
+		fmt.Fprintf(w, "consumer_breaker_opens_total %d\n", stats.Opens)
+	}
+
+	fmt.Fprintln(w, "# HELP database_healthy_replicas Read replicas currently serving reads.")
+	fmt.Fprintln(w, "# TYPE database_healthy_replicas gauge")
+	fmt.Fprintf(w, "database_healthy_replicas %d\n", tapi.Database.HealthyReplicas())
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,64 @@
+package transaction
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"transaction-management-system/breaker"
+	"transaction-management-system/database"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetHealth(t *testing.T) {
+	t.Run("healthy", func(t *testing.T) {
+		tapi := NewTransactionApi(&database.Database{})
+		tapi.Breaker = breaker.New("database", breaker.DefaultConfig())
+
+		rr := httptest.NewRecorder()
+		tapi.GetHealth(rr, httptest.NewRequest("GET", "/health", nil))
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		var h Health
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&h))
+		require.Equal(t, Health{Status: "ok", ConsumerBreaker: "closed"}, h)
+	})
+
+	t.Run("degraded while the breaker is open", func(t *testing.T) {
+		tapi := NewTransactionApi(&database.Database{})
+		tapi.Breaker = breaker.New("database", breaker.Config{FailureThreshold: 1, OpenTimeout: time.Minute})
+		tapi.Breaker.Failure()
+
+		rr := httptest.NewRecorder()
+		tapi.GetHealth(rr, httptest.NewRequest("GET", "/health", nil))
+
+		require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		require.Contains(t, rr.Body.String(), `"consumer_breaker":"open"`)
+	})
+}
+
+func TestGetMetrics(t *testing.T) {
+	tapi := NewTransactionApi(&database.Database{})
+	tapi.Breaker = breaker.New("database", breaker.Config{FailureThreshold: 1, OpenTimeout: time.Minute})
+	tapi.Breaker.Failure()
+
+	mux := http.NewServeMux()
+	tapi.RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, string(body), `consumer_breaker_state{state="open"} 1`)
+	require.Contains(t, string(body), `consumer_breaker_state{state="closed"} 0`)
+	require.Contains(t, string(body), "consumer_breaker_opens_total 1")
+	require.Contains(t, string(body), "database_healthy_replicas 0")
+}
@@ -0,0 +1,178 @@
+package transaction
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+	"transaction-management-system/database"
+	"transaction-management-system/limits"
+)
+
+// Maximum number of transactions submitted at once
+const maxBatchSize = 500
+
+// Errors of submit, besides those of authorizeUser
+var (
+	errInvalidTransaction = errors.New("Invalid transaction")
+	errStoreTransaction   = errors.New("Failed to store transaction")
+	errLimitExceeded      = errors.New("Limit exceeded")
+	errCheckLimits        = errors.New("Failed to check limits")
+)
+
+// CreateTransaction handles POST requests submitting a transaction.
+// The transaction and its outbox event are stored atomically, the outbox relay
+// then publishes the event to the queue.
+func (tapi *TransactionApi) CreateTransaction(w http.ResponseWriter, r *http.Request) {
+	var t Transaction
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&t); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	transactions := []Transaction{t}
+	if err := tapi.submit(r.Context(), transactions); err != nil {
+		switch {
+		case errors.Is(err, errInvalidTransaction):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, errUserRequired), errors.Is(err, errAccessDenied):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, errLimitExceeded):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(transactions[0])
+}
+
+// submit validates the transactions and stores them with their outbox events, all or none.
+// Ids are assigned by the database, timestamps default to the time of submission.
+// Bets breaking the player's limits reject the whole submission, they are checked in
+// the SQL transaction storing it so concurrent submissions can't both pass.
+// The HTTP and gRPC handlers share it, so both accept the same transactions.
+func (tapi *TransactionApi) submit(ctx context.Context, transactions []Transaction) error {
+	now := time.Now()
+	rows := make([]database.NewTransaction, len(transactions))
+	for i := range transactions {
+		t := &transactions[i]
+		t.Id = 0
+		if t.Timestamp.IsZero() {
+			t.Timestamp = now
+		}
+		if err := t.Validate(); err != nil {
+			if len(transactions) == 1 {
+				return fmt.Errorf("%w: %v", errInvalidTransaction, err)
+			}
+			return fmt.Errorf("%w %d: %v", errInvalidTransaction, i, err)
+		}
+		if err := authorizeUser(ctx, &t.UserId); err != nil {
+			return err
+		}
+		rows[i] = database.NewTransaction{
+			UserId:          t.UserId,
+			TransactionType: t.TransactionType,
+			Amount:          t.Amount,
+			Timestamp:       t.Timestamp,
+		}
+	}
+	check := tapi.checkLimits(transactions)
+	var evaluate func(ctx context.Context, players *database.LockedPlayers) error
+	if check != nil {
+		evaluate = check.evaluate
+	}
+
+	ids, err := tapi.Database.CreateTransactions(ctx, rows, evaluate, func(i int, id int64) ([]byte, error) {
+		event := transactions[i]
+		event.Id = id
+		return json.Marshal(event)
+	})
+	switch {
+	case errors.Is(err, errLimitExceeded):
+		check.record(ctx)
+		return err
+	case errors.Is(err, errCheckLimits):
+		slog.ErrorContext(ctx, "Failed to check limits", "error", err)
+		return errCheckLimits
+	case err != nil:
+		slog.ErrorContext(ctx, "Failed to create transaction", "error", err, "count", len(transactions))
+		return errStoreTransaction
+	}
+	for i, id := range ids {
+		transactions[i].Id = id
+	}
+	return nil
+}
+
+// limitCheck evaluates the bets of a submission against the players' limits
+type limitCheck struct {
+	engine     *limits.Engine
+	single     bool // a submission of one transaction
+	bets       []limits.Bet
+	index      []int // of the bets in the submission
+	violations [][]limits.Violation
+}
+
+// checkLimits returns the check of the submission's bets, nil without bets or limits
+func (tapi *TransactionApi) checkLimits(transactions []Transaction) *limitCheck {
+	if tapi.Limits == nil {
+		return nil
+	}
+	check := &limitCheck{engine: tapi.Limits, single: len(transactions) == 1}
+	for i, t := range transactions {
+		if t.TransactionType == BET {
+			check.bets = append(check.bets, limits.Bet{UserId: t.UserId, Amount: t.Amount})
+			check.index = append(check.index, i)
+		}
+	}
+	if len(check.bets) == 0 {
+		return nil
+	}
+	return check
+}
+
+// evaluate runs in the SQL transaction storing the submission, with the players locked.
+// It fails with the first violation, so the submission is rolled back.
+func (c *limitCheck) evaluate(ctx context.Context, players *database.LockedPlayers) error {
+	violations, err := c.engine.EvaluateIn(ctx, players, c.bets)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errCheckLimits, err)
+	}
+	c.violations = violations
+	for i, vs := range violations {
+		if len(vs) == 0 {
+			continue
+		}
+		if c.single {
+			return fmt.Errorf("%w: %s", errLimitExceeded, vs[0])
+		}
+		return fmt.Errorf("%w by transaction %d: %s", errLimitExceeded, c.index[i], vs[0])
+	}
+	return nil
+}
+
+// record stores the violations of the rejected bets, once the submission is rolled back
+func (c *limitCheck) record(ctx context.Context) {
+	for i, vs := range c.violations {
+		if len(vs) == 0 {
+			continue
+		}
+		if err := c.engine.Record(ctx, c.bets[i], 0, limits.ActionRejected, vs); err != nil {
+			slog.ErrorContext(ctx, "Failed to record limit violations", "error", err, "user_id", c.bets[i].UserId)
+		}
+	}
+}
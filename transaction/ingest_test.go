@@ -0,0 +1,104 @@
+package transaction
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"transaction-management-system/auth"
+	"transaction-management-system/limits"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateTransaction(t *testing.T) {
+	tapi := &TransactionApi{}
+
+	post := func(body string, principal *auth.Principal) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/transactions", strings.NewReader(body))
+		if principal != nil {
+			req = req.WithContext(auth.NewContext(req.Context(), principal))
+		}
+		rr := httptest.NewRecorder()
+		tapi.CreateTransaction(rr, req)
+		return rr
+	}
+
+	t.Run("failed: invalid body", func(t *testing.T) {
+		rr := post(`{"user_id": "abc"}`, nil)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "Invalid request body")
+	})
+
+	t.Run("failed: unknown field", func(t *testing.T) {
+		rr := post(`{"user_id": 1, "transaction_type": "bet", "amount": 1, "currency": "EUR"}`, nil)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("failed: invalid transaction", func(t *testing.T) {
+		rr := post(`{"user_id": 1, "transaction_type": "refund", "amount": 1}`, nil)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "unknown transaction_type")
+	})
+
+	t.Run("failed: user outside scope", func(t *testing.T) {
+		rr := post(`{"user_id": 2, "transaction_type": "bet", "amount": 1}`, &auth.Principal{Role: auth.RoleWriter, UserIds: []int{1}})
+		require.Equal(t, http.StatusForbidden, rr.Code)
+	})
+}
+
+func TestCreateTransactionLimits(t *testing.T) {
+	db := openDB(t)
+	tapi := NewTransactionApi(db)
+	// Without cooling-off, so every run can raise the limit to the edge of the usage
+	tapi.Limits = limits.NewEngine(db, limits.Config{})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/transactions", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		tapi.CreateTransaction(rr, req)
+		return rr
+	}
+
+	t.Run("successful one of two concurrent bets at the limit edge", func(t *testing.T) {
+		usage, err := db.GetUsage(t.Context(), 9002, limits.Daily.Duration())
+		require.NoError(t, err)
+		limit := usage.Wagered + 10
+		_, err = tapi.Limits.UpdateLimit(t.Context(), 9002, limits.Wager, limits.Daily, &limit, "tester", true)
+		require.NoError(t, err)
+
+		codes := make(chan int, 2)
+		var wg sync.WaitGroup
+		for range 2 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				codes <- post(`{"user_id": 9002, "transaction_type": "bet", "amount": 10}`).Code
+			}()
+		}
+		wg.Wait()
+		close(codes)
+
+		var got []int
+		for code := range codes {
+			got = append(got, code)
+		}
+		require.ElementsMatch(t, []int{http.StatusCreated, http.StatusUnprocessableEntity}, got)
+	})
+
+	t.Run("failed self-excluded player", func(t *testing.T) {
+		_, err := tapi.Limits.Exclude(t.Context(), 9003, 24*time.Hour, "break", "tester")
+		require.NoError(t, err)
+
+		rr := post(`{"user_id": 9003, "transaction_type": "bet", "amount": 1}`)
+		require.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+		require.Contains(t, rr.Body.String(), "self-excluded until")
+
+		violations, err := db.GetLimitViolations(t.Context(), 9003, 1)
+		require.NoError(t, err)
+		require.Len(t, violations, 1)
+		require.Equal(t, "rejected", violations[0].Action)
+	})
+}
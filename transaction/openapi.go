@@ -0,0 +1,409 @@
+package transaction
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openAPIDocument describes the routes of RegisterRoutes, see GetOpenAPI
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// apiSpec is openAPIDocument parsed for request validation
+var apiSpec = mustParseSpec(openAPIDocument)
+
+// openAPISpec is the part of an OpenAPI 3 document used for validation.
+// Path items only hold operations, and schemas only the keywords of schema.
+type openAPISpec struct {
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components struct {
+		Parameters map[string]*parameter `json:"parameters"`
+		Schemas    map[string]*schema    `json:"schemas"`
+		Responses  map[string]*response  `json:"responses"`
+	} `json:"components"`
+}
+
+type operation struct {
+	Parameters  []*parameter         `json:"parameters"`
+	RequestBody *requestBody         `json:"requestBody"`
+	Responses   map[string]*response `json:"responses"`
+}
+
+type parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"` // "query", "header" or "path"
+	Required bool    `json:"required"`
+	Schema   *schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*mediaType `json:"content"`
+}
+
+type response struct {
+	Ref     string                `json:"$ref"`
+	Content map[string]*mediaType `json:"content"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Enum                 []any              `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinLength            int                `json:"minLength"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	Nullable             bool               `json:"nullable"`
+}
+
+// mustParseSpec parses the embedded document, which is part of the build
+func mustParseSpec(doc []byte) *openAPISpec {
+	spec, err := parseSpec(doc)
+	if err != nil {
+		panic("invalid openapi.json: " + err.Error())
+	}
+	return spec
+}
+
+// parseSpec parses an OpenAPI document and replaces its references by their targets
+func parseSpec(doc []byte) (*openAPISpec, error) {
+	var spec openAPISpec
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+	if err := decoder.Decode(&spec); err != nil {
+		return nil, err
+	}
+
+	r := refResolver{spec: &spec}
+	for _, s := range spec.Components.Schemas {
+		r.schema(&s)
+	}
+	for _, p := range spec.Components.Parameters {
+		r.schema(&p.Schema)
+	}
+	for _, resp := range spec.Components.Responses {
+		r.content(resp.Content)
+	}
+	for path, item := range spec.Paths {
+		for method, op := range item {
+			if op == nil {
+				return nil, fmt.Errorf("%s %s: empty operation", method, path)
+			}
+			for i := range op.Parameters {
+				r.parameter(&op.Parameters[i])
+			}
+			if op.RequestBody != nil {
+				r.content(op.RequestBody.Content)
+			}
+			for status := range op.Responses {
+				resp := op.Responses[status]
+				r.response(&resp)
+				op.Responses[status] = resp
+			}
+		}
+	}
+	return &spec, r.err
+}
+
+// refResolver resolves local references, keeping the first unknown one
+type refResolver struct {
+	spec *openAPISpec
+	err  error
+}
+
+func (r *refResolver) target(ref, kind string) string {
+	name, ok := strings.CutPrefix(ref, "#/components/"+kind+"/")
+	if !ok && r.err == nil {
+		r.err = fmt.Errorf("unsupported reference %q", ref)
+	}
+	return name
+}
+
+func (r *refResolver) missing(ref string) {
+	if r.err == nil {
+		r.err = fmt.Errorf("unknown reference %q", ref)
+	}
+}
+
+func (r *refResolver) schema(s **schema) {
+	if *s == nil {
+		return
+	}
+	if ref := (*s).Ref; ref != "" {
+		target, ok := r.spec.Components.Schemas[r.target(ref, "schemas")]
+		if !ok {
+			r.missing(ref)
+			return
+		}
+		*s = target
+		return
+	}
+	for name := range (*s).Properties {
+		prop := (*s).Properties[name]
+		r.schema(&prop)
+		(*s).Properties[name] = prop
+	}
+	r.schema(&(*s).Items)
+}
+
+func (r *refResolver) parameter(p **parameter) {
+	if ref := (*p).Ref; ref != "" {
+		target, ok := r.spec.Components.Parameters[r.target(ref, "parameters")]
+		if !ok {
+			r.missing(ref)
+			return
+		}
+		*p = target
+		return
+	}
+	r.schema(&(*p).Schema)
+}
+
+func (r *refResolver) response(resp **response) {
+	if ref := (*resp).Ref; ref != "" {
+		target, ok := r.spec.Components.Responses[r.target(ref, "responses")]
+		if !ok {
+			r.missing(ref)
+			return
+		}
+		*resp = target
+		return
+	}
+	r.content((*resp).Content)
+}
+
+func (r *refResolver) content(content map[string]*mediaType) {
+	for _, m := range content {
+		r.schema(&m.Schema)
+	}
+}
+
+// operation returns the operation of method and path, nil when the document lacks it
+func (spec *openAPISpec) operation(method, path string) *operation {
+	return spec.Paths[path][strings.ToLower(method)]
+}
+
+// GetOpenAPI serves the OpenAPI document of the api
+func (tapi *TransactionApi) GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Write(openAPIDocument)
+}
+
+// validateRequest wraps the handler so it only runs for requests matching the operation
+// of method and path in openapi.json. Handlers still check what the document can't express.
+func validateRequest(method, path string, next http.HandlerFunc) http.HandlerFunc {
+	op := apiSpec.operation(method, path)
+	if op == nil {
+		// Caught by the drift test
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := op.validate(r); err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// validate checks the parameters and body of r, leaving the body readable for the handler
+func (op *operation) validate(r *http.Request) error {
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		var value string
+		switch p.In {
+		case "query":
+			value = query.Get(p.Name)
+		case "header":
+			value = r.Header.Get(p.Name)
+		case "path":
+			value = r.PathValue(p.Name)
+		}
+		// Handlers treat empty values as left out
+		if value == "" {
+			if p.Required {
+				return fmt.Errorf("Missing %s parameter %s", p.In, p.Name)
+			}
+			continue
+		}
+		if err := p.Schema.validateParameter(value); err != nil {
+			return fmt.Errorf("Invalid %s parameter %s: %v", p.In, p.Name, err)
+		}
+	}
+
+	if op.RequestBody == nil {
+		return nil
+	}
+	media, ok := op.RequestBody.Content[contentTypeJSON]
+	if !ok {
+		return nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			return errors.New("Missing request body")
+		}
+		return nil
+	}
+
+	var v any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return errors.New("Invalid request body")
+	}
+	if err := media.Schema.validate(v, ""); err != nil {
+		return fmt.Errorf("Invalid request body: %v", err)
+	}
+	return nil
+}
+
+// validateParameter checks a parameter value, typed by the schema
+func (s *schema) validateParameter(value string) error {
+	switch s.Type {
+	case "integer", "number":
+		return s.validate(json.Number(value), "")
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("must be a boolean")
+		}
+		return s.validate(b, "")
+	}
+	return s.validate(value, "")
+}
+
+// validate checks a value decoded with json.Decoder.UseNumber, at is the path to it for errors
+func (s *schema) validate(v any, at string) error {
+	fail := func(format string, args ...any) error {
+		msg := fmt.Sprintf(format, args...)
+		if at == "" {
+			return errors.New(msg)
+		}
+		return fmt.Errorf("%s %s", at, msg)
+	}
+
+	if v == nil && s.Nullable {
+		return nil
+	}
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fail("must be an object")
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s is required", joinPath(at, name))
+			}
+		}
+		for name, value := range obj {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s is not allowed", joinPath(at, name))
+				}
+				continue
+			}
+			if err := prop.validate(value, joinPath(at, name)); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return fail("must be an array")
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fail("must be a string")
+		}
+		if len(str) < s.MinLength {
+			return fail("must be at least %d characters", s.MinLength)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return fail("must be an RFC 3339 date-time")
+			}
+		}
+	case "integer", "number":
+		n, _ := v.(json.Number)
+		f, err := strconv.ParseFloat(string(n), 64)
+		if s.Type == "integer" {
+			_, err = strconv.ParseInt(string(n), 10, 64)
+		}
+		if err != nil {
+			if s.Type == "integer" {
+				return fail("must be an integer")
+			}
+			return fail("must be a number")
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			return fail("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			return fail("must be at most %v", *s.Maximum)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fail("must be a boolean")
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		for _, e := range s.Enum {
+			if e == v {
+				return nil
+			}
+		}
+		values := make([]string, len(s.Enum))
+		for i, e := range s.Enum {
+			values[i] = fmt.Sprint(e)
+		}
+		return fail("must be one of %s", strings.Join(values, ", "))
+	}
+	return nil
+}
+
+func joinPath(at, name string) string {
+	if at == "" {
+		return name
+	}
+	return at + "." + name
+}
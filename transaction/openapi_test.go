@@ -0,0 +1,348 @@
+package transaction
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"transaction-management-system/auth"
+	"transaction-management-system/database"
+	"transaction-management-system/fraud"
+	"transaction-management-system/limits"
+	"transaction-management-system/ratelimit"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The drift tests below keep openapi.json and the handlers in agreement
+
+func TestOpenAPIRoutes(t *testing.T) {
+	tapi := &TransactionApi{
+		Auth:   auth.NewAuthenticator(nil, nil),
+		Limits: limits.NewEngine(nil, limits.DefaultConfig()),
+		Fraud:  fraud.NewDetector(nil, fraud.DefaultConfig()),
+	}
+
+	var routes []string
+	for _, rt := range tapi.routes() {
+		routes = append(routes, rt.method+" "+rt.path)
+	}
+	var operations []string
+	for path, item := range apiSpec.Paths {
+		for method := range item {
+			operations = append(operations, strings.ToUpper(method)+" "+path)
+		}
+	}
+	require.ElementsMatch(t, operations, routes, "routes and openapi.json operations differ")
+}
+
+// invalidValues returns parameter values the schema rejects
+func invalidValues(s *schema) []string {
+	var values []string
+	switch s.Type {
+	case "integer":
+		values = append(values, "abc", "1.5")
+	case "number":
+		values = append(values, "abc")
+	case "boolean":
+		values = append(values, "maybe")
+	}
+	if s.Minimum != nil {
+		values = append(values, fmt.Sprint(*s.Minimum-1))
+	}
+	if s.Maximum != nil {
+		values = append(values, fmt.Sprint(*s.Maximum+1))
+	}
+	if len(s.Enum) > 0 {
+		values = append(values, "other")
+	}
+	return values
+}
+
+func TestOpenAPIParameters(t *testing.T) {
+	// Handlers parse their parameters before using the database, which stays unset
+	tapi := &TransactionApi{
+		Broker: NewBroker(),
+		Auth:   auth.NewAuthenticator(nil, nil),
+		Limits: limits.NewEngine(nil, limits.DefaultConfig()),
+		Fraud:  fraud.NewDetector(nil, fraud.DefaultConfig()),
+	}
+
+	for _, rt := range tapi.routes() {
+		op := apiSpec.operation(rt.method, rt.path)
+		require.NotNil(t, op, "%s %s", rt.method, rt.path)
+
+		for _, p := range op.Parameters {
+			values := invalidValues(p.Schema)
+			require.NotEmpty(t, values, "%s of %s %s accepts any value", p.Name, rt.method, rt.path)
+
+			for _, value := range values {
+				name := fmt.Sprintf("%s %s %s=%s", rt.method, rt.path, p.Name, value)
+				req := func() *http.Request {
+					req := httptest.NewRequest(rt.method, rt.path, nil)
+					switch p.In {
+					case "query":
+						req.URL.RawQuery = url.Values{p.Name: {value}}.Encode()
+					case "header":
+						req.Header.Set(p.Name, value)
+					case "path":
+						req.SetPathValue(p.Name, value)
+					}
+					return req
+				}
+
+				// The validator rejects the value
+				rr := httptest.NewRecorder()
+				validateRequest(rt.method, rt.path, func(http.ResponseWriter, *http.Request) {
+					t.Errorf("%s: passed validation", name)
+				})(rr, req())
+				require.Equal(t, http.StatusBadRequest, rr.Code, name)
+
+				// And so does the handler, so it reads the parameter the spec describes
+				require.Equal(t, http.StatusBadRequest, serveUnvalidated(t, rt.handler, req()), name)
+			}
+		}
+	}
+}
+
+// serveUnvalidated runs the handler and returns its status, failing when it goes on to the database
+func serveUnvalidated(t *testing.T, handler http.HandlerFunc, req *http.Request) (code int) {
+	defer func() {
+		if recover() != nil {
+			t.Errorf("%s %s: handler accepted the request", req.Method, req.URL)
+			code = http.StatusOK
+		}
+	}()
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr.Code
+}
+
+// validValue returns a parameter value the schema accepts
+func validValue(s *schema) string {
+	if len(s.Enum) > 0 {
+		return fmt.Sprint(s.Enum[0])
+	}
+	return "1"
+}
+
+func TestOpenAPIRequestBodies(t *testing.T) {
+	tapi := &TransactionApi{Auth: auth.NewAuthenticator(nil, nil), Limits: limits.NewEngine(nil, limits.DefaultConfig())}
+	handlers := make(map[string]http.HandlerFunc)
+	for _, rt := range tapi.routes() {
+		handlers[rt.method+" "+rt.path] = rt.handler
+	}
+
+	// Bodies rejected by the spec and by the handler
+	invalid := map[string][]string{
+		"POST /transactions": {
+			`[]`,
+			`{"transaction_type": "bet", "amount": 1}`,
+			`{"user_id": "1", "transaction_type": "bet", "amount": 1}`,
+			`{"user_id": 1.5, "transaction_type": "bet", "amount": 1}`,
+			`{"user_id": 0, "transaction_type": "bet", "amount": 1}`,
+			`{"user_id": 1, "transaction_type": "refund", "amount": 1}`,
+			`{"user_id": 1, "transaction_type": "bet", "amount": -1}`,
+			`{"user_id": 1, "transaction_type": "bet", "amount": 1, "timestamp": "yesterday"}`,
+			`{"user_id": 1, "transaction_type": "bet", "amount": 1, "currency": "EUR"}`,
+		},
+		"POST /admin/api-keys": {
+			`{"role": "reader"}`,
+			`{"name": "", "role": "reader"}`,
+			`{"name": "dashboard", "role": "owner"}`,
+			`{"name": "dashboard", "role": "reader", "user_ids": [0]}`,
+			`{"name": "dashboard", "role": "reader", "user_ids": 1}`,
+		},
+		"PUT /users/{user_id}/limits/{kind}/{period}": {
+			`{}`,
+			`{"amount": null}`,
+			`{"amount": "100"}`,
+			`{"amount": -1}`,
+			`{"amount": 100, "currency": "EUR"}`,
+		},
+		"POST /users/{user_id}/exclusions": {
+			`{"reason": "break"}`,
+			`{"days": 0}`,
+			`{"days": 3651}`,
+			`{"days": 1.5}`,
+			`{"days": 7, "reason": 1}`,
+			`{"days": 7, "until": "2030-01-01T00:00:00Z"}`,
+		},
+	}
+	for route, bodies := range invalid {
+		method, path, _ := strings.Cut(route, " ")
+		op := apiSpec.operation(method, path)
+		require.NotNil(t, op.RequestBody, route)
+		// Path parameters are valid, so only the body is rejected
+		req := func(body string) *http.Request {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			for _, p := range op.Parameters {
+				if p.In == "path" {
+					req.SetPathValue(p.Name, validValue(p.Schema))
+				}
+			}
+			return req
+		}
+		for _, body := range bodies {
+			rr := httptest.NewRecorder()
+			validateRequest(method, path, func(http.ResponseWriter, *http.Request) {
+				t.Errorf("%s %s: passed validation", route, body)
+			})(rr, req(body))
+			require.Equal(t, http.StatusBadRequest, rr.Code, "%s %s", route, body)
+
+			code := serveUnvalidated(t, handlers[route], req(body))
+			require.Equal(t, http.StatusBadRequest, code, "%s %s", route, body)
+		}
+	}
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	// Schemas list exactly the JSON fields of the types they describe
+	types := map[string]any{
+		"Transaction":    Transaction{},
+		"Health":         Health{},
+		"Limit":          limits.Limit{},
+		"PendingChange":  limits.PendingChange{},
+		"LimitStatus":    limits.Status{},
+		"LimitChange":    limits.Change{},
+		"LimitViolation": limits.RecordedViolation{},
+		"Exclusion":      limits.Exclusion{},
+		"Alert":          fraud.Alert{},
+	}
+	for name, v := range types {
+		s := apiSpec.Components.Schemas[name]
+		require.NotNil(t, s, name)
+
+		var fields []string
+		typ := reflect.TypeOf(v)
+		for i := range typ.NumField() {
+			tag, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+			fields = append(fields, tag)
+		}
+		var properties []string
+		for prop := range s.Properties {
+			properties = append(properties, prop)
+		}
+		require.ElementsMatch(t, fields, properties, name)
+	}
+
+	t.Run("responses match their schema", func(t *testing.T) {
+		tapi := NewTransactionApi(&database.Database{})
+		mux := http.NewServeMux()
+		tapi.RegisterRoutes(mux)
+
+		for _, path := range []string{"/health", "/openapi.json"} {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+			require.Equal(t, http.StatusOK, rr.Code, path)
+
+			media := apiSpec.operation("GET", path).Responses["200"].Content[rr.Header().Get("Content-Type")]
+			require.NotNil(t, media, path)
+			decoder := json.NewDecoder(rr.Body)
+			decoder.UseNumber()
+			var v any
+			require.NoError(t, decoder.Decode(&v))
+			require.NoError(t, media.Schema.validate(v, ""), path)
+		}
+	})
+}
+
+func TestParseSpec(t *testing.T) {
+	t.Run("failed unknown reference", func(t *testing.T) {
+		_, err := parseSpec([]byte(`{"paths": {"/x": {"get": {"parameters": [{"$ref": "#/components/parameters/Missing"}]}}}}`))
+		require.ErrorContains(t, err, "unknown reference")
+	})
+
+	t.Run("failed unsupported reference", func(t *testing.T) {
+		_, err := parseSpec([]byte(`{"components": {"schemas": {"A": {"items": {"$ref": "other.json#/A"}}}}}`))
+		require.ErrorContains(t, err, "unsupported reference")
+	})
+}
+
+func TestValidateRequest(t *testing.T) {
+	var got string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var t Transaction
+		json.NewDecoder(r.Body).Decode(&t)
+		got = t.TransactionType
+	}
+
+	t.Run("successful valid request", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/transactions", strings.NewReader(`{"user_id": 1, "transaction_type": "win", "amount": 2.5, "timestamp": "2025-03-01T12:00:00Z"}`))
+		validateRequest("POST", "/transactions", handler)(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		// The body is still readable
+		require.Equal(t, WIN, got)
+	})
+
+	t.Run("successful empty parameters", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		validateRequest("GET", "/transactions", func(http.ResponseWriter, *http.Request) {})(rr, httptest.NewRequest("GET", "/transactions?user_id=&limit=", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	tests := map[string]struct {
+		method, target, body string
+		want                 string
+	}{
+		"query parameter":   {"GET", "/transactions?limit=0", "", "Invalid query parameter limit: must be at least 1"},
+		"enum parameter":    {"GET", "/transactions/export?format=xml", "", "Invalid query parameter format: must be one of ndjson, csv"},
+		"missing body":      {"POST", "/transactions", "", "Missing request body"},
+		"malformed body":    {"POST", "/transactions", `{"user_id": `, "Invalid request body"},
+		"missing field":     {"POST", "/transactions", `{"user_id": 1, "amount": 1}`, "Invalid request body: transaction_type is required"},
+		"unknown field":     {"POST", "/transactions", `{"user_id": 1, "transaction_type": "bet", "amount": 1, "currency": "EUR"}`, "Invalid request body: currency is not allowed"},
+		"nested field":      {"POST", "/admin/api-keys", `{"name": "x", "role": "reader", "user_ids": [1, "2"]}`, "Invalid request body: user_ids[1] must be an integer"},
+		"wrong body type":   {"POST", "/transactions", `"bet"`, "Invalid request body: must be an object"},
+		"date-time field":   {"POST", "/transactions", `{"user_id": 1, "transaction_type": "bet", "amount": 1, "timestamp": "2025-03-01"}`, "Invalid request body: timestamp must be an RFC 3339 date-time"},
+		"integer parameter": {"GET", "/transactions?user_id=1e3", "", "Invalid query parameter user_id: must be an integer"},
+	}
+	for name, test := range tests {
+		t.Run("failed "+name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			path, _, _ := strings.Cut(test.target, "?")
+			validateRequest(test.method, path, func(http.ResponseWriter, *http.Request) {
+				t.Error("passed validation")
+			})(rr, httptest.NewRequest(test.method, test.target, strings.NewReader(test.body)))
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+			require.Equal(t, test.want, strings.TrimSpace(rr.Body.String()))
+		})
+	}
+
+	t.Run("failed body too large", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		body := `{"user_id": 1, "transaction_type": "` + strings.Repeat("x", 64) + `"}`
+		h := ratelimit.MaxBytes(16, validateRequest("POST", "/transactions", handler))
+		h(rr, httptest.NewRequest("POST", "/transactions", strings.NewReader(body)))
+		require.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	})
+}
+
+func TestGetOpenAPI(t *testing.T) {
+	tapi := &TransactionApi{}
+	mux := http.NewServeMux()
+	tapi.RegisterRoutes(mux)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var doc struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&doc))
+	require.True(t, strings.HasPrefix(doc.OpenAPI, "3."))
+	require.Contains(t, doc.Paths, "/transactions")
+
+	t.Run("undocumented methods are not allowed", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("PUT", "/transactions", nil))
+		require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	})
+}
@@ -0,0 +1,74 @@
+package transaction
+
+import (
+	"fmt"
+	"transaction-management-system/transactionpb"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TransactionType values of transactionpb/transaction.proto
+var (
+	protoTypes = map[string]transactionpb.TransactionType{
+		BET: transactionpb.TransactionType_TRANSACTION_TYPE_BET,
+		WIN: transactionpb.TransactionType_TRANSACTION_TYPE_WIN,
+	}
+	typeNames = map[transactionpb.TransactionType]string{
+		transactionpb.TransactionType_TRANSACTION_TYPE_BET: BET,
+		transactionpb.TransactionType_TRANSACTION_TYPE_WIN: WIN,
+	}
+)
+
+// ProtoType returns the TransactionType of a transaction type, false when it is unknown
+func ProtoType(transactionType string) (transactionpb.TransactionType, bool) {
+	t, ok := protoTypes[transactionType]
+	return t, ok
+}
+
+// TypeName returns the transaction type of a TransactionType, empty for TRANSACTION_TYPE_UNSPECIFIED
+func TypeName(t transactionpb.TransactionType) (string, error) {
+	if t == transactionpb.TransactionType_TRANSACTION_TYPE_UNSPECIFIED {
+		return "", nil
+	}
+	name, ok := typeNames[t]
+	if !ok {
+		return "", fmt.Errorf("unknown transaction_type %d", t)
+	}
+	return name, nil
+}
+
+// Proto returns the transaction as a message of transaction.proto, a zero timestamp is left out
+func (t Transaction) Proto() *transactionpb.Transaction {
+	userId := int64(t.UserId)
+	m := &transactionpb.Transaction{
+		Id:              t.Id,
+		UserId:          &userId,
+		TransactionType: protoTypes[t.TransactionType],
+		Amount:          &t.Amount,
+	}
+	if !t.Timestamp.IsZero() {
+		m.Timestamp = timestamppb.New(t.Timestamp)
+	}
+	return m
+}
+
+// FromProto returns the transaction of a message, missing fields are left zero for Validate to reject
+func FromProto(m *transactionpb.Transaction) (Transaction, error) {
+	transactionType, err := TypeName(m.GetTransactionType())
+	if err != nil {
+		return Transaction{}, err
+	}
+	t := Transaction{
+		Id:              m.GetId(),
+		UserId:          int(m.GetUserId()),
+		TransactionType: transactionType,
+		Amount:          m.GetAmount(),
+	}
+	if m.GetTimestamp() != nil {
+		if err := m.GetTimestamp().CheckValid(); err != nil {
+			return Transaction{}, fmt.Errorf("timestamp: %w", err)
+		}
+		t.Timestamp = m.GetTimestamp().AsTime()
+	}
+	return t, nil
+}
@@ -0,0 +1,36 @@
+package transaction
+
+import (
+	"testing"
+	"time"
+	"transaction-management-system/transactionpb"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestProto(t *testing.T) {
+	t.Run("successful round trip", func(t *testing.T) {
+		tr := Transaction{Id: 4, UserId: 7, TransactionType: WIN, Amount: 12.5, Timestamp: time.Date(2025, 3, 1, 12, 0, 0, 500, time.UTC)}
+		got, err := FromProto(tr.Proto())
+		require.NoError(t, err)
+		require.Equal(t, tr, got)
+	})
+
+	t.Run("successful missing fields stay zero", func(t *testing.T) {
+		got, err := FromProto(&transactionpb.Transaction{})
+		require.NoError(t, err)
+		require.Equal(t, Transaction{}, got)
+		require.Nil(t, Transaction{UserId: 1}.Proto().GetTimestamp())
+	})
+
+	t.Run("failed unknown transaction type", func(t *testing.T) {
+		_, err := FromProto(&transactionpb.Transaction{TransactionType: 7})
+		require.ErrorContains(t, err, "unknown transaction_type 7")
+	})
+
+	t.Run("failed invalid timestamp", func(t *testing.T) {
+		_, err := FromProto(&transactionpb.Transaction{Timestamp: &timestamppb.Timestamp{Nanos: -1}})
+		require.ErrorContains(t, err, "timestamp")
+	})
+}
@@ -0,0 +1,153 @@
+package transaction
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// Maximum number of pending events per client before it is disconnected
+	streamBufferSize = 256
+	// Maximum number of missed transactions replayed on Last-Event-ID resumption
+	streamReplayLimit = 1000
+	// How long before the last received event the replay starts, transactions relayed by
+	// the outbox or stored by other consumers may be published after higher ids
+	streamReplayOverlap = 30 * time.Second
+	// Interval between keep-alive comments
+	streamHeartbeat = 15 * time.Second
+)
+
+// StreamTransactions handles GET requests for a server-sent events stream of newly stored transactions
+func (tapi *TransactionApi) StreamTransactions(w http.ResponseWriter, r *http.Request) {
+	if tapi.Broker == nil {
+		http.Error(w, "Transaction stream is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Get optional user_id and transaction_type filters
+	userId, transactionType, err := parseFilters(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !checkScope(w, r, userId) {
+		return
+	}
+
+	// Get optional resumption point
+	var lastEventId int64
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		lastEventId, err = strconv.ParseInt(id, 10, 64)
+		if err != nil || lastEventId < 0 {
+			http.Error(w, "Invalid Last-Event-ID header", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Subscribe before replaying so no transaction falls between replay and live events
+	filter := Filter{TransactionType: transactionType}
+	if userId != nil {
+		filter.UserIds = []int{*userId}
+	}
+	sub := tapi.Broker.Subscribe(filter, streamBufferSize)
+	defer tapi.Broker.Unsubscribe(sub)
+
+	// Ends with the request or when the server shuts down
+	ctx, cancel := tapi.streamContext(r.Context())
+	defer cancel()
+
+	// The stream outlives the server write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Replay transactions missed since the last received event
+	var replayed map[int64]bool
+	if lastEventId > 0 {
+		replayed, err = tapi.replay(ctx, w, lastEventId, userId, transactionType)
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.Done():
+			// Client could not keep up with the stream
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case t := <-sub.Transactions():
+			// Skip transactions already delivered by the replay
+			if replayed[t.Id] {
+				continue
+			}
+			if err := writeEvent(w, t); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// replay writes the transactions stored since lastEventId in storage order and returns
+// their ids. Past streamReplayLimit it writes a truncated event, the client reloads
+// the rest with GET /transactions.
+func (tapi *TransactionApi) replay(ctx context.Context, w http.ResponseWriter, lastEventId int64, userId *int, transactionType *string) (map[int64]bool, error) {
+	rows, err := tapi.Database.GetTransactionsSince(ctx, lastEventId, streamReplayOverlap, userId, transactionType, streamReplayLimit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	replayed := make(map[int64]bool)
+	for rows.Next() {
+		if len(replayed) == streamReplayLimit {
+			_, err := fmt.Fprintf(w, "event: truncated\ndata: {\"limit\":%d}\n\n", streamReplayLimit)
+			return replayed, err
+		}
+		t, err := scanTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeEvent(w, t); err != nil {
+			return nil, err
+		}
+		replayed[t.Id] = true
+	}
+	return replayed, rows.Err()
+}
+
+// writeEvent writes the transaction as a single server-sent event
+func writeEvent(w http.ResponseWriter, t Transaction) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: transaction\ndata: %s\n\n", t.Id, data)
+	return err
+}
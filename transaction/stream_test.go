@@ -0,0 +1,117 @@
+package transaction
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamTransactions(t *testing.T) {
+	t.Run("failed: broker not configured", func(t *testing.T) {
+		tapi := &TransactionApi{}
+		rr := httptest.NewRecorder()
+		tapi.StreamTransactions(rr, httptest.NewRequest("GET", "/transactions/stream", nil))
+
+		require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	})
+
+	t.Run("failed: invalid Last-Event-ID", func(t *testing.T) {
+		tapi := &TransactionApi{Broker: NewBroker()}
+		req := httptest.NewRequest("GET", "/transactions/stream", nil)
+		req.Header.Set("Last-Event-ID", "abc")
+		rr := httptest.NewRecorder()
+		tapi.StreamTransactions(rr, req)
+
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "Invalid Last-Event-ID header")
+	})
+
+	t.Run("successful live event", func(t *testing.T) {
+		tapi := &TransactionApi{Broker: NewBroker()}
+		srv := httptest.NewServer(http.HandlerFunc(tapi.StreamTransactions))
+		defer srv.Close()
+
+		ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/transactions/stream?user_id=7", nil)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		// Headers are flushed after subscribing, so the publish can't be missed
+		tapi.Broker.Publish(Transaction{Id: 41, UserId: 8, TransactionType: BET})
+		tapi.Broker.Publish(Transaction{Id: 42, UserId: 7, TransactionType: WIN})
+
+		reader := bufio.NewReader(resp.Body)
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, "id: 42\n", line)
+
+		line, _ = reader.ReadString('\n')
+		require.Equal(t, "event: transaction\n", line)
+		line, _ = reader.ReadString('\n')
+		require.True(t, strings.HasPrefix(line, `data: {"id":42,"user_id":7`))
+	})
+
+	t.Run("shutdown ends the stream", func(t *testing.T) {
+		tapi := &TransactionApi{Broker: NewBroker()}
+		srv := httptest.NewServer(http.HandlerFunc(tapi.StreamTransactions))
+		defer srv.Close()
+
+		ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/transactions/stream", nil)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		require.NoError(t, tapi.Shutdown(ctx))
+
+		// The handler returned, so the body ends instead of waiting for events
+		_, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Zero(t, tapi.Broker.Subscribers())
+	})
+}
+
+func TestStreamReplay(t *testing.T) {
+	db := openDB(t)
+	tapi := NewTransactionApi(db)
+	tapi.Broker = NewBroker()
+	srv := httptest.NewServer(http.HandlerFunc(tapi.StreamTransactions))
+	defer srv.Close()
+
+	// The first reaches the broker after the second, as when the outbox relays it
+	first, err := db.InsertTransaction(t.Context(), 9003, BET, 1, time.Now())
+	require.NoError(t, err)
+	second, err := db.InsertTransaction(t.Context(), 9003, BET, 1, time.Now())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/transactions/stream?user_id=9003", nil)
+	req.Header.Set("Last-Event-ID", fmt.Sprint(second))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// Earlier runs may have stored more within the overlap
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		require.NotEqual(t, fmt.Sprintf("id: %d\n", second), line)
+		if line == fmt.Sprintf("id: %d\n", first) {
+			return
+		}
+	}
+}
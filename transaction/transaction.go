@@ -1,7 +1,9 @@
 package transaction
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"math"
 	"math/rand"
 	"time"
@@ -17,7 +19,14 @@ var TransactionTypes = []string{
 	WIN,
 }
 
+// Timestamp bounds of a valid transaction
+var MinTimestamp = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// MaxClockSkew is how far in the future a valid timestamp may be
+const MaxClockSkew = 5 * time.Minute
+
 type Transaction struct {
+	Id              int64     `json:"id,omitempty"`
 	UserId          int       `json:"user_id"`
 	TransactionType string    `json:"transaction_type"`
 	Amount          float64   `json:"amount"`
@@ -52,3 +61,46 @@ func getTimestamp() time.Time {
 func (t Transaction) String() string {
 	return fmt.Sprintf("{user_id: %d, transaction_type: %s, amount: %.2f, timestamp: %s}", t.UserId, t.TransactionType, t.Amount, t.Timestamp.Format(time.RFC1123))
 }
+
+// LogValue logs the transaction as a group of its fields
+func (t Transaction) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.Int("user_id", t.UserId),
+		slog.String("transaction_type", t.TransactionType),
+		slog.Float64("amount", t.Amount),
+		slog.Time("timestamp", t.Timestamp),
+	}
+	if t.Id != 0 {
+		attrs = append([]slog.Attr{slog.Int64("id", t.Id)}, attrs...)
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// Validate checks that the transaction can be stored
+func (t Transaction) Validate() error {
+	if t.UserId < 1 {
+		return errors.New("user_id must be positive")
+	}
+	if t.TransactionType != BET && t.TransactionType != WIN {
+		return fmt.Errorf("unknown transaction_type %q", t.TransactionType)
+	}
+	if math.IsNaN(t.Amount) || math.IsInf(t.Amount, 0) {
+		return errors.New("amount must be a number")
+	}
+	if t.Amount < 0 {
+		return errors.New("amount can't be negative")
+	}
+	if cents := t.Amount * 100; math.Abs(cents-math.Round(cents)) > 1e-6 {
+		return errors.New("amount can't have more than 2 decimals")
+	}
+	if t.Timestamp.IsZero() {
+		return errors.New("timestamp is required")
+	}
+	if t.Timestamp.Before(MinTimestamp) {
+		return fmt.Errorf("timestamp can't be before %s", MinTimestamp.Format(time.DateOnly))
+	}
+	if t.Timestamp.After(time.Now().Add(MaxClockSkew)) {
+		return errors.New("timestamp can't be in the future")
+	}
+	return nil
+}
@@ -1,15 +1,18 @@
 package transaction
 
 import (
+	"context"
 	"io"
+	"math"
 	"net/http"
 	"net/http/httptest"
-	"os"
-	"sync"
-	"syscall"
+	"net/url"
 	"testing"
 	"time"
+	"transaction-management-system/auth"
+	test "transaction-management-system/config"
 	"transaction-management-system/database"
+	"transaction-management-system/ratelimit"
 
 	"github.com/stretchr/testify/require"
 )
@@ -21,6 +24,52 @@ func TestNewTransaction(t *testing.T) {
 	})
 }
 
+func TestValidate(t *testing.T) {
+	t.Run("successful validation", func(t *testing.T) {
+		require.NoError(t, NewTransaction().Validate())
+	})
+	t.Run("failed validation", func(t *testing.T) {
+		tr := NewTransaction()
+		tr.UserId = 0
+		require.ErrorContains(t, tr.Validate(), "user_id must be positive")
+
+		tr = NewTransaction()
+		tr.TransactionType = "refund"
+		require.ErrorContains(t, tr.Validate(), "unknown transaction_type")
+
+		tr = NewTransaction()
+		tr.Amount = -1
+		require.ErrorContains(t, tr.Validate(), "amount can't be negative")
+
+		tr = NewTransaction()
+		tr.Amount = 1.234
+		require.ErrorContains(t, tr.Validate(), "amount can't have more than 2 decimals")
+
+		tr = NewTransaction()
+		tr.Amount = math.Inf(1)
+		require.ErrorContains(t, tr.Validate(), "amount must be a number")
+
+		tr = NewTransaction()
+		tr.Timestamp = time.Time{}
+		require.ErrorContains(t, tr.Validate(), "timestamp is required")
+
+		tr = NewTransaction()
+		tr.Timestamp = time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC)
+		require.ErrorContains(t, tr.Validate(), "timestamp can't be before 2000-01-01")
+
+		tr = NewTransaction()
+		tr.Timestamp = time.Now().Add(time.Hour)
+		require.ErrorContains(t, tr.Validate(), "timestamp can't be in the future")
+	})
+	t.Run("successful amount edge cases", func(t *testing.T) {
+		for _, amount := range []float64{0, 0.1, 0.29, 19.99, 1234567.89} {
+			tr := NewTransaction()
+			tr.Amount = amount
+			require.NoError(t, tr.Validate(), amount)
+		}
+	})
+}
+
 func TestGetUserId(t *testing.T) {
 	t.Run("successful get user id", func(t *testing.T) {
 		id := getUserId()
@@ -57,28 +106,31 @@ func TestGetTimestamp(t *testing.T) {
 	})
 }
 
-func TestNewTransactionApi(t *testing.T) {
-	t.Run("failed new transaction api - db", func(t *testing.T) {
-		os.Setenv("ENV_PATH", "")
-
-		_, err := NewTransactionApi()
-		require.Error(t, err)
-		require.ErrorContains(t, err, "failed to load .env file")
+// openDB opens a handle on the test schema, closed when the test ends
+func openDB(t *testing.T) *database.Database {
+	t.Helper()
+	test.LoadEnv()
+	cfg, err := database.ConfigFromEnv(test.DB_SCHEMA)
+	require.NoError(t, err)
+	db, err := database.Open(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
 
-		os.Setenv("ENV_PATH", "../.env")
-		database.ResetInstance()
-	})
+func TestNewTransactionApi(t *testing.T) {
 	t.Run("successful new transaction api", func(t *testing.T) {
-		tapi, err := NewTransactionApi()
+		db := openDB(t)
+		tapi := NewTransactionApi(db)
 
-		require.Nil(t, err)
 		require.NotNil(t, tapi)
+		require.Same(t, db, tapi.Database)
 	})
 }
 
 func TestGetTransactions(t *testing.T) {
 
-	tapi, _ := NewTransactionApi()
+	tapi := NewTransactionApi(openDB(t))
 
 	// Create a test HTTP server
 	srv := httptest.NewServer(http.HandlerFunc(tapi.GetTransactions))
@@ -126,7 +178,6 @@ func TestGetTransactions(t *testing.T) {
 	t.Run("failed: db get transactions", func(t *testing.T) {
 		// Close DB to produce an error
 		tapi.Database.Close()
-		defer database.ResetInstance()
 
 		resp, err := http.Get(srv.URL + "/transactions?limit=1")
 		require.NoError(t, err)
@@ -140,7 +191,7 @@ func TestGetTransactions(t *testing.T) {
 		require.Contains(t, bodyStr, "Failed to retrieve transactions")
 	})
 
-	tapi, _ = NewTransactionApi()
+	tapi = NewTransactionApi(openDB(t))
 	srv = httptest.NewServer(http.HandlerFunc(tapi.GetTransactions))
 	t.Run("succesful request", func(t *testing.T) {
 		resp, err := http.Get(srv.URL + "/transactions?user_id=123&transaction_type=bet")
@@ -151,9 +202,24 @@ func TestGetTransactions(t *testing.T) {
 	})
 }
 
+func TestParseLimit(t *testing.T) {
+	limit, err := parseLimit(url.Values{}, defaultPageSize, maxPageSize)
+	require.NoError(t, err)
+	require.Equal(t, defaultPageSize, limit)
+
+	limit, err = parseLimit(url.Values{"limit": {"1000"}}, defaultPageSize, maxPageSize)
+	require.NoError(t, err)
+	require.Equal(t, maxPageSize, limit)
+
+	for _, l := range []string{"0", "1001", "ten"} {
+		_, err := parseLimit(url.Values{"limit": {l}}, defaultPageSize, maxPageSize)
+		require.Error(t, err, l)
+	}
+}
+
 func TestRegisterRoutes(t *testing.T) {
 	// Create mux for tapi
-	tapi, _ := NewTransactionApi()
+	tapi := NewTransactionApi(openDB(t))
 	mux := http.NewServeMux()
 	tapi.RegisterRoutes(mux)
 
@@ -168,18 +234,51 @@ func TestRegisterRoutes(t *testing.T) {
 		"Expected handler to be registered for /transactions")
 }
 
-func TestListenAndServe(t *testing.T) {
-	var wg sync.WaitGroup
-	tapi, _ := NewTransactionApi()
+func TestHandle(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.Config{
+		Default: ratelimit.Limit{Rate: 100, Burst: 100},
+		Routes:  map[string]ratelimit.Limit{ratelimit.RouteAuth: {Rate: 0.01, Burst: 2}},
+	})
+	tapi := &TransactionApi{Auth: auth.NewAuthenticator(auth.NewKeySet(), nil), Limiter: limiter}
+	handler := tapi.handle("/transactions", auth.PermRead, func(w http.ResponseWriter, r *http.Request) {})
+
+	// Bad credentials are throttled by IP before they are checked
+	codes := make([]int, 3)
+	for i := range codes {
+		req := httptest.NewRequest("GET", "/transactions", nil)
+		req.Header.Set("X-API-Key", "guess")
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		codes[i] = rr.Code
+	}
+	require.Equal(t, []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}, codes)
+}
 
-	wg.Add(1)
-	go tapi.ListenAndServe(&wg)
+func TestListenAndServe(t *testing.T) {
+	t.Run("successful graceful shutdown", func(t *testing.T) {
+		tapi := NewTransactionApi(openDB(t))
+		served := make(chan error, 1)
+		go func() { served <- tapi.ListenAndServe(t.Context(), "127.0.0.1:0") }()
+
+		ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, tapi.Shutdown(ctx))
+		require.NoError(t, <-served)
+	})
 
-	// Find the process and kill it sub-goroutine
-	process, err := os.FindProcess(os.Getpid())
-	require.NoError(t, err)
+	t.Run("cancelled context closes the server", func(t *testing.T) {
+		tapi := NewTransactionApi(nil)
+		ctx, cancel := context.WithCancel(t.Context())
+		served := make(chan error, 1)
+		go func() { served <- tapi.ListenAndServe(ctx, "127.0.0.1:0") }()
 
-	go process.Signal(syscall.SIGTERM)
+		cancel()
+		require.NoError(t, <-served)
+	})
 
-	wg.Wait()
+	t.Run("failed listening", func(t *testing.T) {
+		tapi := NewTransactionApi(nil)
+		err := tapi.ListenAndServe(t.Context(), "127.0.0.1:-1")
+		require.ErrorContains(t, err, "failed to serve")
+	})
 }
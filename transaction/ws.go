@@ -0,0 +1,301 @@
+package transaction
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+	"transaction-management-system/auth"
+	"transaction-management-system/database"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Time allowed to write a message to the client
+	wsWriteWait = 10 * time.Second
+	// Time allowed to read the next pong message from the client
+	wsPongWait = 60 * time.Second
+	// Send pings with this period, must be less than wsPongWait
+	wsPingPeriod = (wsPongWait * 9) / 10
+	// Maximum size of a client message
+	wsMaxMessageSize = 4096
+	// Maximum number of user ids a single connection can watch
+	wsMaxUserIds = 100
+	// Maximum number of pending transactions before a slow client is disconnected
+	wsBufferSize = 256
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// wsCommand is a message sent by the client
+type wsCommand struct {
+	Action  string `json:"action"` // "subscribe" or "unsubscribe"
+	UserIds []int  `json:"user_ids"`
+}
+
+// wsMessage is a message sent to the client
+type wsMessage struct {
+	Type    string `json:"type"` // "subscribed", "transaction", "balance" or "error"
+	Data    any    `json:"data,omitempty"`
+	UserIds []int  `json:"user_ids,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Balance is the user's sum of wins minus bets
+type Balance struct {
+	UserId  int     `json:"user_id"`
+	Balance float64 `json:"balance"`
+}
+
+// balanceState tracks a user's balance and the last transaction it includes
+type balanceState struct {
+	amount float64
+	lastId int64
+}
+
+// WatchTransactions upgrades the request to a WebSocket that delivers
+// transactions and balance changes for the user ids the client subscribes to
+func (tapi *TransactionApi) WatchTransactions(w http.ResponseWriter, r *http.Request) {
+	if tapi.Broker == nil {
+		http.Error(w, "Transaction feed is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client
+		slog.WarnContext(r.Context(), "WebSocket upgrade failed", "error", err)
+		return
+	}
+
+	// Credentials scoped to users can only subscribe to those users
+	principal, _ := auth.FromContext(r.Context())
+
+	client := &wsClient{
+		tapi:      tapi,
+		principal: principal,
+		conn:      conn,
+		commands:  make(chan wsCommand),
+		done:      make(chan struct{}),
+		userIds:   make(map[int]struct{}),
+		balances:  make(map[int]*balanceState),
+	}
+	// Closed with the request or when the server shuts down
+	ctx, cancel := tapi.streamContext(r.Context())
+	defer cancel()
+
+	go client.readPump()
+	client.writePump(ctx)
+}
+
+// wsClient is a single WebSocket connection.
+// The write pump owns the connection writes and all subscription state.
+type wsClient struct {
+	tapi      *TransactionApi
+	principal *auth.Principal
+	conn      *websocket.Conn
+	commands  chan wsCommand
+	done      chan struct{}
+	sub       *Subscription
+	userIds   map[int]struct{}
+	balances  map[int]*balanceState
+}
+
+// readPump reads client commands and forwards them to the write pump
+func (c *wsClient) readPump() {
+	defer close(c.commands)
+
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var cmd wsCommand
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			cmd = wsCommand{Action: "invalid"}
+		}
+		select {
+		case c.commands <- cmd:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// writePump delivers transactions and replies to commands until the connection ends
+func (c *wsClient) writePump(ctx context.Context) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		close(c.done)
+		ticker.Stop()
+		if c.sub != nil {
+			c.tapi.Broker.Unsubscribe(c.sub)
+		}
+		c.conn.Close()
+	}()
+
+	for {
+		var transactions <-chan Transaction
+		var dropped <-chan struct{}
+		if c.sub != nil {
+			transactions = c.sub.Transactions()
+			dropped = c.sub.Done()
+		}
+
+		select {
+		case <-ctx.Done():
+			c.close(websocket.CloseGoingAway, "server shutting down")
+			return
+		case <-dropped:
+			// Client could not keep up with its feed
+			c.close(websocket.CloseTryAgainLater, "client too slow")
+			return
+		case cmd, ok := <-c.commands:
+			if !ok {
+				return
+			}
+			if err := c.handleCommand(ctx, cmd); err != nil {
+				return
+			}
+		case t := <-transactions:
+			if err := c.handleTransaction(t); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *wsClient) handleCommand(ctx context.Context, cmd wsCommand) error {
+	switch cmd.Action {
+	case "subscribe":
+		if len(c.userIds)+len(cmd.UserIds) > wsMaxUserIds {
+			return c.write(wsMessage{Type: "error", Error: "Too many user ids"})
+		}
+		for _, id := range cmd.UserIds {
+			if id < 1 {
+				return c.write(wsMessage{Type: "error", Error: "Invalid user id"})
+			}
+			if c.principal != nil && !c.principal.CanAccessUser(id) {
+				return c.write(wsMessage{Type: "error", Error: "Access to user denied"})
+			}
+		}
+		var added []int
+		for _, id := range cmd.UserIds {
+			if _, ok := c.userIds[id]; !ok {
+				c.userIds[id] = struct{}{}
+				added = append(added, id)
+			}
+		}
+		// Subscribe before the balance snapshot so no transaction is missed
+		c.resubscribe()
+		for _, id := range added {
+			if err := c.snapshotBalance(ctx, id); err != nil {
+				return err
+			}
+		}
+	case "invalid":
+		return c.write(wsMessage{Type: "error", Error: "Invalid command"})
+	case "unsubscribe":
+		for _, id := range cmd.UserIds {
+			delete(c.userIds, id)
+			delete(c.balances, id)
+		}
+		c.resubscribe()
+	default:
+		return c.write(wsMessage{Type: "error", Error: "Unknown action. Must be 'subscribe' or 'unsubscribe'"})
+	}
+
+	return c.write(wsMessage{Type: "subscribed", UserIds: c.subscribedIds()})
+}
+
+// resubscribe updates the broker subscription to the current user ids
+func (c *wsClient) resubscribe() {
+	if len(c.userIds) == 0 {
+		// An empty filter matches everything, so drop the subscription instead
+		if c.sub != nil {
+			c.tapi.Broker.Unsubscribe(c.sub)
+			c.sub = nil
+		}
+		return
+	}
+
+	filter := Filter{UserIds: c.subscribedIds()}
+	if c.sub == nil {
+		c.sub = c.tapi.Broker.Subscribe(filter, wsBufferSize)
+		return
+	}
+	c.tapi.Broker.SetFilter(c.sub, filter)
+}
+
+// snapshotBalance loads the user's current balance and sends it to the client
+func (c *wsClient) snapshotBalance(ctx context.Context, userId int) error {
+	if c.tapi.Database == nil {
+		return nil
+	}
+	// Live events are deduplicated by id against the snapshot, a lagging replica would lose some
+	amount, lastId, err := c.tapi.Database.GetBalance(database.WithPrimary(ctx), userId)
+	if err != nil {
+		return c.write(wsMessage{Type: "error", Error: "Failed to retrieve balance"})
+	}
+	c.balances[userId] = &balanceState{amount: amount, lastId: lastId}
+	return c.write(wsMessage{Type: "balance", Data: Balance{UserId: userId, Balance: amount}})
+}
+
+func (c *wsClient) handleTransaction(t Transaction) error {
+	if _, ok := c.userIds[t.UserId]; !ok {
+		// Delivered before an unsubscribe took effect
+		return nil
+	}
+	if err := c.write(wsMessage{Type: "transaction", Data: t}); err != nil {
+		return err
+	}
+
+	// Transactions already included in the snapshot don't change the balance
+	state, ok := c.balances[t.UserId]
+	if !ok || t.Id <= state.lastId {
+		return nil
+	}
+	switch t.TransactionType {
+	case WIN:
+		state.amount += t.Amount
+	case BET:
+		state.amount -= t.Amount
+	}
+	state.lastId = t.Id
+	return c.write(wsMessage{Type: "balance", Data: Balance{UserId: t.UserId, Balance: state.amount}})
+}
+
+func (c *wsClient) subscribedIds() []int {
+	ids := make([]int, 0, len(c.userIds))
+	for id := range c.userIds {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func (c *wsClient) write(msg wsMessage) error {
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.conn.WriteJSON(msg)
+}
+
+func (c *wsClient) close(code int, reason string) {
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteWait))
+}
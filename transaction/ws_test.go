@@ -0,0 +1,83 @@
+package transaction
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"transaction-management-system/auth"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchTransactions(t *testing.T) {
+	keys := auth.NewKeySet()
+	keys.AddHMAC("", []byte("secret"))
+	tapi := &TransactionApi{Broker: NewBroker(), Auth: auth.NewAuthenticator(keys, nil)}
+	srv := httptest.NewServer(tapi.handle("/transactions/ws", auth.PermRead, tapi.WatchTransactions))
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+	token := func(userIds ...int) string {
+		claims := auth.Claims{Role: "reader", UserIds: userIds}
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		require.NoError(t, err)
+		return signed
+	}
+
+	t.Run("failed: missing token", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		require.Error(t, err)
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("failed: invalid command", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?access_token="+token(), nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("not json")))
+
+		var msg wsMessage
+		require.NoError(t, conn.ReadJSON(&msg))
+		require.Equal(t, "error", msg.Type)
+		require.Equal(t, "Invalid command", msg.Error)
+	})
+
+	t.Run("successful subscription", func(t *testing.T) {
+		header := http.Header{"Authorization": []string{"Bearer " + token()}}
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, header)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		require.NoError(t, conn.WriteJSON(wsCommand{Action: "subscribe", UserIds: []int{3, 1}}))
+
+		var msg wsMessage
+		require.NoError(t, conn.ReadJSON(&msg))
+		require.Equal(t, "subscribed", msg.Type)
+		require.Equal(t, []int{1, 3}, msg.UserIds)
+
+		tapi.Broker.Publish(Transaction{Id: 1, UserId: 2, TransactionType: BET})
+		tapi.Broker.Publish(Transaction{Id: 2, UserId: 3, TransactionType: WIN})
+
+		require.NoError(t, conn.ReadJSON(&msg))
+		require.Equal(t, "transaction", msg.Type)
+		require.Equal(t, float64(3), msg.Data.(map[string]any)["user_id"])
+	})
+
+	t.Run("failed: subscription outside user scope", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?access_token="+token(5), nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		require.NoError(t, conn.WriteJSON(wsCommand{Action: "subscribe", UserIds: []int{6}}))
+
+		var msg wsMessage
+		require.NoError(t, conn.ReadJSON(&msg))
+		require.Equal(t, "error", msg.Type)
+		require.Equal(t, "Access to user denied", msg.Error)
+	})
+}
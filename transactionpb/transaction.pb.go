@@ -0,0 +1,297 @@
+// Transactions in protobuf, shared by queue messages sent with content type
+// application/x-protobuf and the gRPC api of transaction_service.proto. Envelope mirrors
+// the JSON envelope of schema version 2. Regenerate the Go code with make proto.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.8
+// 	protoc        (unknown)
+// source: transactionpb/transaction.proto
+
+package transactionpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type TransactionType int32
+
+const (
+	TransactionType_TRANSACTION_TYPE_UNSPECIFIED TransactionType = 0
+	TransactionType_TRANSACTION_TYPE_BET         TransactionType = 1
+	TransactionType_TRANSACTION_TYPE_WIN         TransactionType = 2
+)
+
+// Enum value maps for TransactionType.
+var (
+	TransactionType_name = map[int32]string{
+		0: "TRANSACTION_TYPE_UNSPECIFIED",
+		1: "TRANSACTION_TYPE_BET",
+		2: "TRANSACTION_TYPE_WIN",
+	}
+	TransactionType_value = map[string]int32{
+		"TRANSACTION_TYPE_UNSPECIFIED": 0,
+		"TRANSACTION_TYPE_BET":         1,
+		"TRANSACTION_TYPE_WIN":         2,
+	}
+)
+
+func (x TransactionType) Enum() *TransactionType {
+	p := new(TransactionType)
+	*p = x
+	return p
+}
+
+func (x TransactionType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (TransactionType) Descriptor() protoreflect.EnumDescriptor {
+	return file_transactionpb_transaction_proto_enumTypes[0].Descriptor()
+}
+
+func (TransactionType) Type() protoreflect.EnumType {
+	return &file_transactionpb_transaction_proto_enumTypes[0]
+}
+
+func (x TransactionType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use TransactionType.Descriptor instead.
+func (TransactionType) EnumDescriptor() ([]byte, []int) {
+	return file_transactionpb_transaction_proto_rawDescGZIP(), []int{0}
+}
+
+type Envelope struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SchemaVersion uint32                 `protobuf:"varint,1,opt,name=schema_version,json=schemaVersion,proto3" json:"schema_version,omitempty"`
+	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Payload       *Transaction           `protobuf:"bytes,3,opt,name=payload,proto3" json:"payload,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Envelope) Reset() {
+	*x = Envelope{}
+	mi := &file_transactionpb_transaction_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Envelope) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Envelope) ProtoMessage() {}
+
+func (x *Envelope) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionpb_transaction_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Envelope.ProtoReflect.Descriptor instead.
+func (*Envelope) Descriptor() ([]byte, []int) {
+	return file_transactionpb_transaction_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Envelope) GetSchemaVersion() uint32 {
+	if x != nil {
+		return x.SchemaVersion
+	}
+	return 0
+}
+
+func (x *Envelope) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Envelope) GetPayload() *Transaction {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+// Consumers of the queue reject unknown fields like they do in JSON, new fields need
+// a new schema version. The gRPC api ignores them.
+type Transaction struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Assigned when stored, set in queue messages of transactions submitted through the api.
+	// Ignored when submitting over gRPC.
+	Id              int64           `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	UserId          *int64          `protobuf:"varint,2,opt,name=user_id,json=userId,proto3,oneof" json:"user_id,omitempty"`
+	TransactionType TransactionType `protobuf:"varint,3,opt,name=transaction_type,json=transactionType,proto3,enum=transactions.v1.TransactionType" json:"transaction_type,omitempty"`
+	Amount          *float64        `protobuf:"fixed64,4,opt,name=amount,proto3,oneof" json:"amount,omitempty"`
+	// Time of submission when left out of a gRPC submission
+	Timestamp     *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Transaction) Reset() {
+	*x = Transaction{}
+	mi := &file_transactionpb_transaction_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Transaction) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Transaction) ProtoMessage() {}
+
+func (x *Transaction) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionpb_transaction_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Transaction.ProtoReflect.Descriptor instead.
+func (*Transaction) Descriptor() ([]byte, []int) {
+	return file_transactionpb_transaction_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Transaction) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Transaction) GetUserId() int64 {
+	if x != nil && x.UserId != nil {
+		return *x.UserId
+	}
+	return 0
+}
+
+func (x *Transaction) GetTransactionType() TransactionType {
+	if x != nil {
+		return x.TransactionType
+	}
+	return TransactionType_TRANSACTION_TYPE_UNSPECIFIED
+}
+
+func (x *Transaction) GetAmount() float64 {
+	if x != nil && x.Amount != nil {
+		return *x.Amount
+	}
+	return 0
+}
+
+func (x *Transaction) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+var File_transactionpb_transaction_proto protoreflect.FileDescriptor
+
+const file_transactionpb_transaction_proto_rawDesc = "" +
+	"\n" +
+	"\x1ftransactionpb/transaction.proto\x12\x0ftransactions.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"}\n" +
+	"\bEnvelope\x12%\n" +
+	"\x0eschema_version\x18\x01 \x01(\rR\rschemaVersion\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x126\n" +
+	"\apayload\x18\x03 \x01(\v2\x1c.transactions.v1.TransactionR\apayload\"\xf6\x01\n" +
+	"\vTransaction\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x1c\n" +
+	"\auser_id\x18\x02 \x01(\x03H\x00R\x06userId\x88\x01\x01\x12K\n" +
+	"\x10transaction_type\x18\x03 \x01(\x0e2 .transactions.v1.TransactionTypeR\x0ftransactionType\x12\x1b\n" +
+	"\x06amount\x18\x04 \x01(\x01H\x01R\x06amount\x88\x01\x01\x128\n" +
+	"\ttimestamp\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestampB\n" +
+	"\n" +
+	"\b_user_idB\t\n" +
+	"\a_amount*g\n" +
+	"\x0fTransactionType\x12 \n" +
+	"\x1cTRANSACTION_TYPE_UNSPECIFIED\x10\x00\x12\x18\n" +
+	"\x14TRANSACTION_TYPE_BET\x10\x01\x12\x18\n" +
+	"\x14TRANSACTION_TYPE_WIN\x10\x02B-Z+transaction-management-system/transactionpbb\x06proto3"
+
+var (
+	file_transactionpb_transaction_proto_rawDescOnce sync.Once
+	file_transactionpb_transaction_proto_rawDescData []byte
+)
+
+func file_transactionpb_transaction_proto_rawDescGZIP() []byte {
+	file_transactionpb_transaction_proto_rawDescOnce.Do(func() {
+		file_transactionpb_transaction_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_transactionpb_transaction_proto_rawDesc), len(file_transactionpb_transaction_proto_rawDesc)))
+	})
+	return file_transactionpb_transaction_proto_rawDescData
+}
+
+var file_transactionpb_transaction_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_transactionpb_transaction_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_transactionpb_transaction_proto_goTypes = []any{
+	(TransactionType)(0),          // 0: transactions.v1.TransactionType
+	(*Envelope)(nil),              // 1: transactions.v1.Envelope
+	(*Transaction)(nil),           // 2: transactions.v1.Transaction
+	(*timestamppb.Timestamp)(nil), // 3: google.protobuf.Timestamp
+}
+var file_transactionpb_transaction_proto_depIdxs = []int32{
+	2, // 0: transactions.v1.Envelope.payload:type_name -> transactions.v1.Transaction
+	0, // 1: transactions.v1.Transaction.transaction_type:type_name -> transactions.v1.TransactionType
+	3, // 2: transactions.v1.Transaction.timestamp:type_name -> google.protobuf.Timestamp
+	3, // [3:3] is the sub-list for method output_type
+	3, // [3:3] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_transactionpb_transaction_proto_init() }
+func file_transactionpb_transaction_proto_init() {
+	if File_transactionpb_transaction_proto != nil {
+		return
+	}
+	file_transactionpb_transaction_proto_msgTypes[1].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_transactionpb_transaction_proto_rawDesc), len(file_transactionpb_transaction_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_transactionpb_transaction_proto_goTypes,
+		DependencyIndexes: file_transactionpb_transaction_proto_depIdxs,
+		EnumInfos:         file_transactionpb_transaction_proto_enumTypes,
+		MessageInfos:      file_transactionpb_transaction_proto_msgTypes,
+	}.Build()
+	File_transactionpb_transaction_proto = out.File
+	file_transactionpb_transaction_proto_goTypes = nil
+	file_transactionpb_transaction_proto_depIdxs = nil
+}
@@ -0,0 +1,476 @@
+// gRPC api of the transaction service for internal game servers, next to the REST api.
+// Regenerate the Go code with make proto, clients generate stubs from it as usual.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.8
+// 	protoc        (unknown)
+// source: transactionpb/transaction_service.proto
+
+package transactionpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SubmitTransactionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Transaction   *Transaction           `protobuf:"bytes,1,opt,name=transaction,proto3" json:"transaction,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitTransactionRequest) Reset() {
+	*x = SubmitTransactionRequest{}
+	mi := &file_transactionpb_transaction_service_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitTransactionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitTransactionRequest) ProtoMessage() {}
+
+func (x *SubmitTransactionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionpb_transaction_service_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitTransactionRequest.ProtoReflect.Descriptor instead.
+func (*SubmitTransactionRequest) Descriptor() ([]byte, []int) {
+	return file_transactionpb_transaction_service_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SubmitTransactionRequest) GetTransaction() *Transaction {
+	if x != nil {
+		return x.Transaction
+	}
+	return nil
+}
+
+type SubmitBatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Transactions  []*Transaction         `protobuf:"bytes,1,rep,name=transactions,proto3" json:"transactions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitBatchRequest) Reset() {
+	*x = SubmitBatchRequest{}
+	mi := &file_transactionpb_transaction_service_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitBatchRequest) ProtoMessage() {}
+
+func (x *SubmitBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionpb_transaction_service_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitBatchRequest.ProtoReflect.Descriptor instead.
+func (*SubmitBatchRequest) Descriptor() ([]byte, []int) {
+	return file_transactionpb_transaction_service_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SubmitBatchRequest) GetTransactions() []*Transaction {
+	if x != nil {
+		return x.Transactions
+	}
+	return nil
+}
+
+type SubmitBatchResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Stored transactions in the order of the request
+	Transactions  []*Transaction `protobuf:"bytes,1,rep,name=transactions,proto3" json:"transactions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitBatchResponse) Reset() {
+	*x = SubmitBatchResponse{}
+	mi := &file_transactionpb_transaction_service_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitBatchResponse) ProtoMessage() {}
+
+func (x *SubmitBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionpb_transaction_service_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitBatchResponse.ProtoReflect.Descriptor instead.
+func (*SubmitBatchResponse) Descriptor() ([]byte, []int) {
+	return file_transactionpb_transaction_service_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SubmitBatchResponse) GetTransactions() []*Transaction {
+	if x != nil {
+		return x.Transactions
+	}
+	return nil
+}
+
+type ListTransactionsRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	UserId *int64                 `protobuf:"varint,1,opt,name=user_id,json=userId,proto3,oneof" json:"user_id,omitempty"`
+	// All types when unspecified
+	TransactionType TransactionType `protobuf:"varint,2,opt,name=transaction_type,json=transactionType,proto3,enum=transactions.v1.TransactionType" json:"transaction_type,omitempty"`
+	// 100 transactions when 0, at most 1000
+	Limit         int32 `protobuf:"varint,3,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTransactionsRequest) Reset() {
+	*x = ListTransactionsRequest{}
+	mi := &file_transactionpb_transaction_service_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTransactionsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTransactionsRequest) ProtoMessage() {}
+
+func (x *ListTransactionsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionpb_transaction_service_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTransactionsRequest.ProtoReflect.Descriptor instead.
+func (*ListTransactionsRequest) Descriptor() ([]byte, []int) {
+	return file_transactionpb_transaction_service_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListTransactionsRequest) GetUserId() int64 {
+	if x != nil && x.UserId != nil {
+		return *x.UserId
+	}
+	return 0
+}
+
+func (x *ListTransactionsRequest) GetTransactionType() TransactionType {
+	if x != nil {
+		return x.TransactionType
+	}
+	return TransactionType_TRANSACTION_TYPE_UNSPECIFIED
+}
+
+func (x *ListTransactionsRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type GetBalanceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        int64                  `protobuf:"varint,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetBalanceRequest) Reset() {
+	*x = GetBalanceRequest{}
+	mi := &file_transactionpb_transaction_service_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetBalanceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetBalanceRequest) ProtoMessage() {}
+
+func (x *GetBalanceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionpb_transaction_service_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetBalanceRequest.ProtoReflect.Descriptor instead.
+func (*GetBalanceRequest) Descriptor() ([]byte, []int) {
+	return file_transactionpb_transaction_service_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetBalanceRequest) GetUserId() int64 {
+	if x != nil {
+		return x.UserId
+	}
+	return 0
+}
+
+type Balance struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        int64                  `protobuf:"varint,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Balance       float64                `protobuf:"fixed64,2,opt,name=balance,proto3" json:"balance,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Balance) Reset() {
+	*x = Balance{}
+	mi := &file_transactionpb_transaction_service_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Balance) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Balance) ProtoMessage() {}
+
+func (x *Balance) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionpb_transaction_service_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Balance.ProtoReflect.Descriptor instead.
+func (*Balance) Descriptor() ([]byte, []int) {
+	return file_transactionpb_transaction_service_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Balance) GetUserId() int64 {
+	if x != nil {
+		return x.UserId
+	}
+	return 0
+}
+
+func (x *Balance) GetBalance() float64 {
+	if x != nil {
+		return x.Balance
+	}
+	return 0
+}
+
+type WatchTransactionsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Required for user scoped credentials
+	UserIds []int64 `protobuf:"varint,1,rep,packed,name=user_ids,json=userIds,proto3" json:"user_ids,omitempty"`
+	// All types when unspecified
+	TransactionType TransactionType `protobuf:"varint,2,opt,name=transaction_type,json=transactionType,proto3,enum=transactions.v1.TransactionType" json:"transaction_type,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *WatchTransactionsRequest) Reset() {
+	*x = WatchTransactionsRequest{}
+	mi := &file_transactionpb_transaction_service_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchTransactionsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchTransactionsRequest) ProtoMessage() {}
+
+func (x *WatchTransactionsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionpb_transaction_service_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchTransactionsRequest.ProtoReflect.Descriptor instead.
+func (*WatchTransactionsRequest) Descriptor() ([]byte, []int) {
+	return file_transactionpb_transaction_service_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *WatchTransactionsRequest) GetUserIds() []int64 {
+	if x != nil {
+		return x.UserIds
+	}
+	return nil
+}
+
+func (x *WatchTransactionsRequest) GetTransactionType() TransactionType {
+	if x != nil {
+		return x.TransactionType
+	}
+	return TransactionType_TRANSACTION_TYPE_UNSPECIFIED
+}
+
+var File_transactionpb_transaction_service_proto protoreflect.FileDescriptor
+
+const file_transactionpb_transaction_service_proto_rawDesc = "" +
+	"\n" +
+	"'transactionpb/transaction_service.proto\x12\x0ftransactions.v1\x1a\x1ftransactionpb/transaction.proto\"Z\n" +
+	"\x18SubmitTransactionRequest\x12>\n" +
+	"\vtransaction\x18\x01 \x01(\v2\x1c.transactions.v1.TransactionR\vtransaction\"V\n" +
+	"\x12SubmitBatchRequest\x12@\n" +
+	"\ftransactions\x18\x01 \x03(\v2\x1c.transactions.v1.TransactionR\ftransactions\"W\n" +
+	"\x13SubmitBatchResponse\x12@\n" +
+	"\ftransactions\x18\x01 \x03(\v2\x1c.transactions.v1.TransactionR\ftransactions\"\xa6\x01\n" +
+	"\x17ListTransactionsRequest\x12\x1c\n" +
+	"\auser_id\x18\x01 \x01(\x03H\x00R\x06userId\x88\x01\x01\x12K\n" +
+	"\x10transaction_type\x18\x02 \x01(\x0e2 .transactions.v1.TransactionTypeR\x0ftransactionType\x12\x14\n" +
+	"\x05limit\x18\x03 \x01(\x05R\x05limitB\n" +
+	"\n" +
+	"\b_user_id\",\n" +
+	"\x11GetBalanceRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\x03R\x06userId\"<\n" +
+	"\aBalance\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\x03R\x06userId\x12\x18\n" +
+	"\abalance\x18\x02 \x01(\x01R\abalance\"\x82\x01\n" +
+	"\x18WatchTransactionsRequest\x12\x19\n" +
+	"\buser_ids\x18\x01 \x03(\x03R\auserIds\x12K\n" +
+	"\x10transaction_type\x18\x02 \x01(\x0e2 .transactions.v1.TransactionTypeR\x0ftransactionType2\xd6\x03\n" +
+	"\x12TransactionService\x12\\\n" +
+	"\x11SubmitTransaction\x12).transactions.v1.SubmitTransactionRequest\x1a\x1c.transactions.v1.Transaction\x12X\n" +
+	"\vSubmitBatch\x12#.transactions.v1.SubmitBatchRequest\x1a$.transactions.v1.SubmitBatchResponse\x12\\\n" +
+	"\x10ListTransactions\x12(.transactions.v1.ListTransactionsRequest\x1a\x1c.transactions.v1.Transaction0\x01\x12J\n" +
+	"\n" +
+	"GetBalance\x12\".transactions.v1.GetBalanceRequest\x1a\x18.transactions.v1.Balance\x12^\n" +
+	"\x11WatchTransactions\x12).transactions.v1.WatchTransactionsRequest\x1a\x1c.transactions.v1.Transaction0\x01B-Z+transaction-management-system/transactionpbb\x06proto3"
+
+var (
+	file_transactionpb_transaction_service_proto_rawDescOnce sync.Once
+	file_transactionpb_transaction_service_proto_rawDescData []byte
+)
+
+func file_transactionpb_transaction_service_proto_rawDescGZIP() []byte {
+	file_transactionpb_transaction_service_proto_rawDescOnce.Do(func() {
+		file_transactionpb_transaction_service_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_transactionpb_transaction_service_proto_rawDesc), len(file_transactionpb_transaction_service_proto_rawDesc)))
+	})
+	return file_transactionpb_transaction_service_proto_rawDescData
+}
+
+var file_transactionpb_transaction_service_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_transactionpb_transaction_service_proto_goTypes = []any{
+	(*SubmitTransactionRequest)(nil), // 0: transactions.v1.SubmitTransactionRequest
+	(*SubmitBatchRequest)(nil),       // 1: transactions.v1.SubmitBatchRequest
+	(*SubmitBatchResponse)(nil),      // 2: transactions.v1.SubmitBatchResponse
+	(*ListTransactionsRequest)(nil),  // 3: transactions.v1.ListTransactionsRequest
+	(*GetBalanceRequest)(nil),        // 4: transactions.v1.GetBalanceRequest
+	(*Balance)(nil),                  // 5: transactions.v1.Balance
+	(*WatchTransactionsRequest)(nil), // 6: transactions.v1.WatchTransactionsRequest
+	(*Transaction)(nil),              // 7: transactions.v1.Transaction
+	(TransactionType)(0),             // 8: transactions.v1.TransactionType
+}
+var file_transactionpb_transaction_service_proto_depIdxs = []int32{
+	7,  // 0: transactions.v1.SubmitTransactionRequest.transaction:type_name -> transactions.v1.Transaction
+	7,  // 1: transactions.v1.SubmitBatchRequest.transactions:type_name -> transactions.v1.Transaction
+	7,  // 2: transactions.v1.SubmitBatchResponse.transactions:type_name -> transactions.v1.Transaction
+	8,  // 3: transactions.v1.ListTransactionsRequest.transaction_type:type_name -> transactions.v1.TransactionType
+	8,  // 4: transactions.v1.WatchTransactionsRequest.transaction_type:type_name -> transactions.v1.TransactionType
+	0,  // 5: transactions.v1.TransactionService.SubmitTransaction:input_type -> transactions.v1.SubmitTransactionRequest
+	1,  // 6: transactions.v1.TransactionService.SubmitBatch:input_type -> transactions.v1.SubmitBatchRequest
+	3,  // 7: transactions.v1.TransactionService.ListTransactions:input_type -> transactions.v1.ListTransactionsRequest
+	4,  // 8: transactions.v1.TransactionService.GetBalance:input_type -> transactions.v1.GetBalanceRequest
+	6,  // 9: transactions.v1.TransactionService.WatchTransactions:input_type -> transactions.v1.WatchTransactionsRequest
+	7,  // 10: transactions.v1.TransactionService.SubmitTransaction:output_type -> transactions.v1.Transaction
+	2,  // 11: transactions.v1.TransactionService.SubmitBatch:output_type -> transactions.v1.SubmitBatchResponse
+	7,  // 12: transactions.v1.TransactionService.ListTransactions:output_type -> transactions.v1.Transaction
+	5,  // 13: transactions.v1.TransactionService.GetBalance:output_type -> transactions.v1.Balance
+	7,  // 14: transactions.v1.TransactionService.WatchTransactions:output_type -> transactions.v1.Transaction
+	10, // [10:15] is the sub-list for method output_type
+	5,  // [5:10] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_transactionpb_transaction_service_proto_init() }
+func file_transactionpb_transaction_service_proto_init() {
+	if File_transactionpb_transaction_service_proto != nil {
+		return
+	}
+	file_transactionpb_transaction_proto_init()
+	file_transactionpb_transaction_service_proto_msgTypes[3].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_transactionpb_transaction_service_proto_rawDesc), len(file_transactionpb_transaction_service_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_transactionpb_transaction_service_proto_goTypes,
+		DependencyIndexes: file_transactionpb_transaction_service_proto_depIdxs,
+		MessageInfos:      file_transactionpb_transaction_service_proto_msgTypes,
+	}.Build()
+	File_transactionpb_transaction_service_proto = out.File
+	file_transactionpb_transaction_service_proto_goTypes = nil
+	file_transactionpb_transaction_service_proto_depIdxs = nil
+}